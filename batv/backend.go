@@ -0,0 +1,61 @@
+package batv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/server"
+)
+
+var (
+	// errMissing is returned for a bounce to an address without tag.
+	errMissing = smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Bounce address tag missing")
+	// errInvalid is returned for a bounce to an address with a forged tag.
+	errInvalid = smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Bounce address tag invalid")
+	// errExpired is returned for a bounce to an address with an expired tag.
+	errExpired = smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Bounce address tag expired")
+)
+
+// Backend returns a backend which validates the recipients of bounces (null sender) before
+// calling the sessions of backend. Bounces to addresses without a valid tag are rejected.
+// Tags of valid addresses are removed, so backend sees the address originally used as sender.
+func Backend(backend server.Backend, signer *Signer) server.Backend {
+	return server.BackendFunc(func(ctx context.Context, c *server.Conn) (context.Context, server.Session, error) {
+		ctx, s, err := backend.NewSession(ctx, c)
+		if err != nil {
+			return ctx, s, err
+		}
+		return ctx, &session{Session: s, signer: signer}, nil
+	})
+}
+
+// session validates the recipients of bounces, the other methods are passed through.
+type session struct {
+	server.Session
+	signer *Signer
+	bounce bool
+}
+
+// Mail implements server.Session.
+func (s *session) Mail(ctx context.Context, from string, opts *smtp.MailOptions) error {
+	s.bounce = from == ""
+	return s.Session.Mail(ctx, from, opts)
+}
+
+// Rcpt implements server.Session.
+func (s *session) Rcpt(ctx context.Context, to string, opts *smtp.RcptOptions) error {
+	addr, err := s.signer.Verify(to)
+	switch {
+	case err == nil:
+		return s.Session.Rcpt(ctx, addr, opts)
+	case !s.bounce:
+		return s.Session.Rcpt(ctx, to, opts)
+	case errors.Is(err, ErrMissing):
+		return errMissing
+	case errors.Is(err, ErrExpired):
+		return errExpired
+	default:
+		return errInvalid
+	}
+}
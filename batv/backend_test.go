@@ -0,0 +1,77 @@
+package batv_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/batv"
+	"github.com/uponusolutions/go-smtp/mailer"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+const message = "Subject: Hello\r\n\r\nBody\r\n"
+
+func TestBackend(t *testing.T) {
+	signer, err := batv.New(map[int]string{0: "tops3cr3t"})
+	require.NoError(t, err)
+
+	be := tester.NewBackend()
+	srv := tester.Standard(server.WithBackend(batv.Backend(be, signer)))
+	l, err := srv.Listen()
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	addr := l.Addr().String()
+
+	// the sender of outgoing mail is tagged
+	m := mailer.New(mailer.WithServerAddresses(addr), mailer.WithBATV(signer))
+	_, _, _, err = m.Send(context.Background(), "sender@example.org", []string{"user@example.com"},
+		strings.NewReader(message))
+	require.NoError(t, err)
+	_ = m.Disconnect()
+
+	var tagged string
+	be.Mails.Range(func(_, value any) bool {
+		tagged = value.(*tester.Mail).From
+		return false
+	})
+	require.Regexp(t, `^prvs=0[0-9]{3}[0-9a-f]{6}=sender@example.org$`, tagged)
+
+	// a bounce to the tagged sender is accepted, the session sees the original address
+	m = mailer.New(mailer.WithServerAddresses(addr))
+	_, _, _, err = m.Send(context.Background(), "", []string{tagged}, strings.NewReader(message))
+	require.NoError(t, err)
+	_, ok := be.Load("", []string{"sender@example.org"})
+	require.True(t, ok)
+
+	// bounces without a valid tag are rejected, the case of the prefix is ignored
+	for rcpt, msg := range map[string]string{
+		"sender@example.org":                         "Bounce address tag missing",
+		"prvs=0000000000=sender@example.org":         "Bounce address tag invalid",
+		tagged[:15] + "=other@example.org":           "Bounce address tag invalid",
+		"prvs=0123" + tagged[9:]:                     "Bounce address tag invalid",
+		strings.Replace(tagged, "prvs=", "PRVS=", 1): "",
+	} {
+		_, _, failures, err := m.Send(context.Background(), "", []string{rcpt}, strings.NewReader(message))
+		if msg == "" {
+			require.NoError(t, err, rcpt)
+			require.Empty(t, failures, rcpt)
+			continue
+		}
+		require.ErrorIs(t, err, mailer.ErrNoRecipientAccepted, rcpt)
+		require.Len(t, failures, 1, rcpt)
+		require.ErrorContains(t, failures[0].Error, msg, rcpt)
+	}
+
+	// other senders aren't validated
+	_, _, _, err = m.Send(context.Background(), "other@example.net", []string{"sender@example.org"},
+		strings.NewReader(message))
+	require.NoError(t, err)
+}
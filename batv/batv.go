@@ -0,0 +1,172 @@
+// Package batv implements Bounce Address Tag Validation (draft-levine-smtp-batv-01) with the prvs scheme.
+//
+// The envelope sender of outgoing mail is tagged with a key number, an expiry day and a keyed hash:
+//
+//	user@example.com -> prvs=KDDDSSSSSS=user@example.com
+//
+// Legitimate bounces are sent to the tagged address. Bounces (backscatter) of mail with a forged
+// sender don't carry a valid tag and can be rejected. Use mailer.WithBATV to tag the sender
+// and Backend to validate the recipients of bounces.
+package batv
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// day is the resolution of the expiry day.
+	day = 24 * time.Hour
+	// days is the number of different expiry days, the day number wraps around after it.
+	days = 1000
+	// maxSecrets is the number of secrets which can be addressed by the single digit key number.
+	maxSecrets = 10
+	// tagLength is the length of the tag value KDDDSSSSSS.
+	tagLength = 10
+)
+
+var (
+	// ErrMissing is returned by Verify if the address isn't tagged.
+	ErrMissing = errors.New("batv: missing tag")
+	// ErrInvalid is returned by Verify if the tag is malformed or the hash doesn't match.
+	ErrInvalid = errors.New("batv: invalid tag")
+	// ErrExpired is returned by Verify if the tag is expired.
+	ErrExpired = errors.New("batv: expired tag")
+)
+
+// Signer tags and verifies addresses. It is safe for concurrent use.
+type Signer struct {
+	secrets  [maxSecrets][]byte // indexed by key number, nil if unused
+	key      int
+	validity time.Duration
+	now      func() time.Time
+}
+
+// Option defines a signer option.
+type Option func(s *Signer)
+
+// WithKey sets the number of the secret used to tag addresses, the highest key number by default.
+func WithKey(key int) Option {
+	return func(s *Signer) {
+		s.key = key
+	}
+}
+
+// WithValidity sets how long tagged addresses are valid (default 7 days, at most one year).
+// The expiry has a resolution of one day.
+func WithValidity(validity time.Duration) Option {
+	return func(s *Signer) {
+		s.validity = validity
+	}
+}
+
+// New returns a signer with the secrets by their key number (0 to 9), which is part of every tag.
+// Tags of all secrets are verified, so a secret is rotated by adding a new one with another
+// key number and removing the old one after the validity. Key numbers of removed secrets can
+// be reused after the validity as well, e.g. key 0 after key 9 using WithKey(0).
+func New(secrets map[int]string, opts ...Option) (*Signer, error) {
+	s := &Signer{
+		key:      -1,
+		validity: 7 * day,
+		now:      time.Now,
+	}
+
+	if len(secrets) == 0 {
+		return nil, errors.New("batv: no secrets")
+	}
+	for key, secret := range secrets {
+		if key < 0 || key >= maxSecrets {
+			return nil, fmt.Errorf("batv: invalid key %d, 0 to %d are allowed", key, maxSecrets-1)
+		}
+		if secret == "" {
+			return nil, errors.New("batv: empty secret")
+		}
+		s.secrets[key] = []byte(secret)
+		s.key = max(s.key, key)
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if s.key < 0 || s.key >= maxSecrets || s.secrets[s.key] == nil {
+		return nil, fmt.Errorf("batv: invalid key %d", s.key)
+	}
+	if s.validity < day || s.validity > 366*day {
+		return nil, fmt.Errorf("batv: invalid validity %s", s.validity)
+	}
+
+	return s, nil
+}
+
+// Sign returns the tagged address. The null sender, addresses without domain and
+// addresses which are already tagged are returned unchanged.
+func (s *Signer) Sign(address string) string {
+	if !strings.Contains(address, "@") || strings.HasPrefix(address, "@") {
+		return address
+	}
+	if _, _, ok := split(address); ok {
+		return address
+	}
+
+	expiry := fmt.Sprintf("%d%03d", s.key, (s.day()+int(s.validity/day))%days)
+	return "prvs=" + expiry + hash(s.secrets[s.key], expiry, address) + "=" + address
+}
+
+// Verify returns the address without its tag. It returns ErrMissing if the address isn't tagged,
+// ErrInvalid if the tag is malformed or forged and ErrExpired if it is expired.
+func (s *Signer) Verify(address string) (string, error) {
+	tag, addr, ok := split(address)
+	if !ok {
+		return address, ErrMissing
+	}
+
+	if len(tag) != tagLength {
+		return "", ErrInvalid
+	}
+	key := int(tag[0] - '0')
+	expiry, err := strconv.Atoi(tag[1:4])
+	if key < 0 || key >= maxSecrets || s.secrets[key] == nil || err != nil ||
+		!hmac.Equal([]byte(strings.ToLower(tag[4:])), []byte(hash(s.secrets[key], tag[:4], addr))) {
+		return "", ErrInvalid
+	}
+
+	// the expiry day is at most validity days in the future
+	if (expiry-s.day()+days)%days > int(s.validity/day) {
+		return "", ErrExpired
+	}
+
+	return addr, nil
+}
+
+// day returns the days since the epoch modulo the number of expiry days.
+func (s *Signer) day() int {
+	return int(s.now().Unix()/int64(day/time.Second)) % days
+}
+
+// split splits a prvs tagged address into the tag and the address.
+func split(address string) (string, string, bool) {
+	if len(address) < 5 || !strings.EqualFold(address[:5], "prvs=") {
+		return "", "", false
+	}
+	tag, addr, ok := strings.Cut(address[5:], "=")
+	if !ok || !strings.Contains(addr, "@") {
+		return "", "", false
+	}
+	return tag, addr, true
+}
+
+// hash returns the first three bytes of the HMAC-SHA1 of the key number, the expiry day and the
+// lower case address as hex.
+func hash(secret []byte, expiry string, address string) string {
+	mac := hmac.New(sha1.New, secret)
+	_, _ = mac.Write([]byte(expiry))
+	_, _ = mac.Write([]byte(strings.ToLower(address)))
+	return hex.EncodeToString(mac.Sum(nil)[:3])
+}
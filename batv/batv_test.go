@@ -0,0 +1,131 @@
+package batv
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newSigner returns a signer with a fixed clock.
+func newSigner(t *testing.T, secrets map[int]string, opts ...Option) *Signer {
+	s, err := New(secrets, opts...)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestSign(t *testing.T) {
+	s := newSigner(t, map[int]string{0: "old", 1: "tops3cr3t"})
+
+	// key 1, expires on day 20097 (2025-01-09)
+	tagged := s.Sign("User@example.com")
+	require.Equal(t, "prvs=1097"+hash([]byte("tops3cr3t"), "1097", "user@example.com")+"=User@example.com", tagged)
+	require.Regexp(t, `^prvs=1097[0-9a-f]{6}=User@example.com$`, tagged)
+
+	// not tagged
+	for _, addr := range []string{"", "postmaster", tagged, "PRVS=0123abcdef=user@example.com"} {
+		require.Equal(t, addr, s.Sign(addr))
+	}
+
+	addr, err := s.Verify(tagged)
+	require.NoError(t, err)
+	require.Equal(t, "User@example.com", addr)
+
+	// case of the prefix, the hash and the domain is ignored
+	addr, err = s.Verify(strings.ToUpper(tagged[:15]) + "=User@EXAMPLE.com")
+	require.NoError(t, err)
+	require.Equal(t, "User@EXAMPLE.com", addr)
+}
+
+func TestVerify(t *testing.T) {
+	s := newSigner(t, map[int]string{0: "tops3cr3t"})
+	tagged := s.Sign("user@example.com")
+
+	addr, err := s.Verify("user@example.com")
+	require.ErrorIs(t, err, ErrMissing)
+	require.Equal(t, "user@example.com", addr)
+
+	for _, addr := range []string{
+		"prvs=0097abc=user@example.com",
+		"prvs=x097" + tagged[9:],
+		"prvs=1097" + tagged[9:],
+		"prvs=0098" + tagged[9:],
+		tagged[:15] + "0=user@example.com",
+		tagged[:15] + "=other@example.com",
+	} {
+		_, err := s.Verify(addr)
+		require.ErrorIs(t, err, ErrInvalid, addr)
+	}
+}
+
+func TestVerify_Expired(t *testing.T) {
+	s := newSigner(t, map[int]string{0: "tops3cr3t"}, WithValidity(2*24*time.Hour))
+	tagged := s.Sign("user@example.com")
+
+	s.now = func() time.Time { return now.Add(2 * 24 * time.Hour) }
+	_, err := s.Verify(tagged)
+	require.NoError(t, err)
+
+	s.now = func() time.Time { return now.Add(3 * 24 * time.Hour) }
+	_, err = s.Verify(tagged)
+	require.ErrorIs(t, err, ErrExpired)
+
+	// tags from the future aren't accepted either
+	s.now = func() time.Time { return now.Add(-24 * time.Hour) }
+	_, err = s.Verify(tagged)
+	require.ErrorIs(t, err, ErrExpired)
+}
+
+func TestRotation(t *testing.T) {
+	old := newSigner(t, map[int]string{0: "old"})
+	tagged := old.Sign("user@example.com")
+
+	s := newSigner(t, map[int]string{0: "old", 1: "new"})
+	_, err := s.Verify(tagged)
+	require.NoError(t, err)
+	rotated := s.Sign("user@example.com")
+	require.True(t, strings.HasPrefix(rotated, "prvs=1"))
+
+	s = newSigner(t, map[int]string{0: "old", 1: "new"}, WithKey(0))
+	require.Equal(t, tagged, s.Sign("user@example.com"))
+
+	_, err = old.Verify(rotated)
+	require.ErrorIs(t, err, ErrInvalid)
+
+	// the old secret is removed, the key number of the new one doesn't change
+	s = newSigner(t, map[int]string{1: "new"})
+	addr, err := s.Verify(rotated)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", addr)
+	require.Equal(t, rotated, s.Sign("user@example.com"))
+	_, err = s.Verify(tagged)
+	require.ErrorIs(t, err, ErrInvalid)
+
+	// key numbers wrap around
+	s = newSigner(t, map[int]string{9: "new", 0: "newer"}, WithKey(0))
+	require.True(t, strings.HasPrefix(s.Sign("user@example.com"), "prvs=0"))
+	_, err = s.Verify(newSigner(t, map[int]string{9: "new"}).Sign("user@example.com"))
+	require.NoError(t, err)
+}
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		secrets map[int]string
+		opts    []Option
+	}{
+		{nil, nil},
+		{map[int]string{0: ""}, nil},
+		{map[int]string{10: "secret"}, nil},
+		{map[int]string{-1: "secret"}, nil},
+		{map[int]string{0: "secret"}, []Option{WithKey(1)}},
+		{map[int]string{0: "secret"}, []Option{WithValidity(time.Hour)}},
+		{map[int]string{0: "secret"}, []Option{WithValidity(400 * 24 * time.Hour)}},
+	} {
+		_, err := New(tc.secrets, tc.opts...)
+		require.Error(t, err)
+	}
+}
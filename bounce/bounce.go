@@ -0,0 +1,183 @@
+// Package bounce parses inbound bounces and feedback loop complaints into structured results.
+//
+// Standard reports are multipart/report messages of the report type delivery-status (RFC 3464,
+// including the UTF-8 variant of RFC 6533) or feedback-report (ARF, RFC 5965). Bounces of mail
+// systems which don't send standard reports are recognized with heuristics:
+//
+//	func (s *session) Data(ctx context.Context, r func() io.Reader) (string, error) {
+//		report, err := bounce.Parse(io.LimitReader(r(), 10<<20))
+//		if err != nil {
+//			return "", err
+//		}
+//		for _, addr := range report.Suppress() {
+//			suppress(addr)
+//		}
+//		return "", nil
+//	}
+package bounce
+
+import (
+	"errors"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/dsn"
+)
+
+// ErrNoReport is returned if a message is neither a report nor looks like a bounce.
+var ErrNoReport = errors.New("bounce: message isn't a report")
+
+// Kind describes how a report was recognized.
+type Kind string
+
+const (
+	// KindDeliveryStatus is a delivery status notification (RFC 3464).
+	KindDeliveryStatus Kind = "delivery-status"
+	// KindFeedback is a feedback report, e.g. a spam complaint (RFC 5965).
+	KindFeedback Kind = "feedback-report"
+	// KindHeuristic is a non-standard bounce recognized by heuristics.
+	KindHeuristic Kind = "heuristic"
+)
+
+// Report is a parsed bounce or feedback report.
+type Report struct {
+	Kind Kind
+
+	// ReportingMTA is the server which created a delivery status notification.
+	ReportingMTA string
+	// EnvelopeID is the envelope id (ENVID) of the original message, if returned.
+	EnvelopeID string
+	// ArrivalDate is the time the original message was received, if known.
+	ArrivalDate time.Time
+
+	// Recipients are the per-recipient results of a delivery status notification
+	// or of a bounce recognized by heuristics.
+	Recipients []Recipient
+
+	// Feedback is set for feedback reports.
+	Feedback *Feedback
+
+	// Original is the header of the original message, nil if it wasn't returned.
+	// For example its Message-Id links the report to the sent message.
+	Original mail.Header
+}
+
+// Recipient is the result of a single recipient.
+type Recipient struct {
+	// Address is the final recipient.
+	Address string
+	// OriginalRecipient is the original recipient (ORCPT), if returned.
+	OriginalRecipient string
+	// Action performed for the recipient.
+	Action dsn.Action
+	// Status is the enhanced status code, smtp.EnhancedCodeNotSet if unknown.
+	Status smtp.EnhancedCode
+	// Diagnostic is the reply of the remote server, if any.
+	Diagnostic string
+	// RemoteMTA is the server which returned the diagnostic, if any.
+	RemoteMTA string
+}
+
+// Hard returns true if the delivery failed permanently, e.g. the mailbox doesn't exist.
+// A failure without a permanent status code (5.x.x) isn't hard.
+func (r *Recipient) Hard() bool {
+	return r.Action == dsn.ActionFailed && r.Status[0] == 5
+}
+
+// Parse reads a message and parses it into a report.
+// ErrNoReport is returned if the message is neither a report nor looks like a bounce.
+func Parse(r io.Reader) (*Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseEntity(textproto.MIMEHeader(msg.Header), msg.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if report := root.find("multipart/report"); report != nil {
+		switch strings.ToLower(report.params["report-type"]) {
+		case string(KindDeliveryStatus):
+			if status := report.find("message/delivery-status", "message/global-delivery-status"); status != nil {
+				return parseDeliveryStatus(report, status), nil
+			}
+		case string(KindFeedback):
+			if feedback := report.find("message/feedback-report"); feedback != nil {
+				return parseFeedback(report, feedback), nil
+			}
+		}
+	}
+
+	return heuristic(msg.Header, root)
+}
+
+// Suppress returns the addresses which shouldn't receive mail anymore: the recipients with a hard
+// bounce and the recipients of the original message of a complaint.
+func (r *Report) Suppress() []string {
+	addresses := []string{}
+
+	if r.Feedback != nil && r.Feedback.Complaint() {
+		addresses = append(addresses, r.Feedback.OriginalRcptTo...)
+		if len(addresses) == 0 && r.Original != nil {
+			if list, err := r.Original.AddressList("To"); err == nil {
+				for _, address := range list {
+					addresses = append(addresses, address.Address)
+				}
+			}
+		}
+		return addresses
+	}
+
+	for i := range r.Recipients {
+		if r.Recipients[i].Hard() {
+			addresses = append(addresses, r.Recipients[i].Address)
+		}
+	}
+	return addresses
+}
+
+// parseDeliveryStatus parses a delivery status notification.
+func parseDeliveryStatus(report *entity, status *entity) *Report {
+	res := &Report{Kind: KindDeliveryStatus, Original: report.original()}
+
+	blocks := readFields(status.body)
+	if len(blocks) == 0 {
+		return res
+	}
+
+	message := blocks[0]
+	res.ReportingMTA = value(message.Get("Reporting-MTA"))
+	res.EnvelopeID = strings.TrimSpace(message.Get("Original-Envelope-Id"))
+	res.ArrivalDate, _ = mail.ParseDate(message.Get("Arrival-Date"))
+
+	for _, block := range blocks[1:] {
+		rcpt := Recipient{
+			Address:           value(block.Get("Final-Recipient")),
+			OriginalRecipient: value(block.Get("Original-Recipient")),
+			Action:            dsn.Action(strings.ToLower(strings.TrimSpace(block.Get("Action")))),
+			Status:            parseCode(block.Get("Status")),
+			Diagnostic:        value(block.Get("Diagnostic-Code")),
+			RemoteMTA:         value(block.Get("Remote-MTA")),
+		}
+		if rcpt.Address == "" {
+			continue
+		}
+		res.Recipients = append(res.Recipients, rcpt)
+	}
+
+	return res
+}
+
+// value returns the value of a typed field (e.g. "rfc822; user@example.com") without its type.
+func value(field string) string {
+	if _, v, ok := strings.Cut(field, ";"); ok {
+		field = v
+	}
+	return strings.Join(strings.Fields(field), " ")
+}
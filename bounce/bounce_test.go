@@ -0,0 +1,281 @@
+package bounce_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/bounce"
+	"github.com/uponusolutions/go-smtp/dsn"
+)
+
+const original = "From: sender@example.org\r\nTo: user@example.com\r\nMessage-Id: <1@example.org>\r\n" +
+	"Subject: Hello\r\n\r\nBody\r\n"
+
+func TestParse_DeliveryStatus(t *testing.T) {
+	arrival := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	r, err := (&dsn.Report{
+		ReportingMTA: "mx.example.com",
+		To:           "sender@example.org",
+		EnvelopeID:   "QQ314159",
+		ArrivalDate:  arrival,
+		Recipients: []dsn.Recipient{
+			{
+				FinalRecipient:        "unknown@example.com",
+				OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+				OriginalRecipient:     "alias@example.com",
+				Action:                dsn.ActionFailed,
+				Status:                smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "No such user"),
+				RemoteMTA:             "mx.example.net",
+			},
+			{
+				FinalRecipient: "full@example.com",
+				Action:         dsn.ActionFailed,
+				Status:         smtp.NewStatus(452, smtp.EnhancedCode{4, 2, 2}, "Mailbox full"),
+			},
+			{
+				FinalRecipient: "later@example.com",
+				Action:         dsn.ActionDelayed,
+			},
+		},
+		Message: strings.NewReader(original),
+	}).Reader()
+	require.NoError(t, err)
+
+	report, err := bounce.Parse(r)
+	require.NoError(t, err)
+
+	require.Equal(t, bounce.KindDeliveryStatus, report.Kind)
+	require.Equal(t, "mx.example.com", report.ReportingMTA)
+	require.Equal(t, "QQ314159", report.EnvelopeID)
+	require.True(t, arrival.Equal(report.ArrivalDate))
+	require.Nil(t, report.Feedback)
+	require.Equal(t, "<1@example.org>", report.Original.Get("Message-Id"))
+
+	require.Equal(t, []bounce.Recipient{
+		{
+			Address:           "unknown@example.com",
+			OriginalRecipient: "alias@example.com",
+			Action:            dsn.ActionFailed,
+			Status:            smtp.EnhancedCode{5, 1, 1},
+			Diagnostic:        "550 5.1.1 No such user",
+			RemoteMTA:         "mx.example.net",
+		},
+		{
+			Address:    "full@example.com",
+			Action:     dsn.ActionFailed,
+			Status:     smtp.EnhancedCode{4, 2, 2},
+			Diagnostic: "452 4.2.2 Mailbox full",
+		},
+		{
+			Address: "later@example.com",
+			Action:  dsn.ActionDelayed,
+			Status:  smtp.EnhancedCode{4, 0, 0},
+		},
+	}, report.Recipients)
+
+	// only the permanent failure is suppressed
+	require.Equal(t, []string{"unknown@example.com"}, report.Suppress())
+}
+
+func TestParse_Feedback(t *testing.T) {
+	msg := "From: <abuse@example.net>\r\n" +
+		"To: <fbl@example.org>\r\n" +
+		"Subject: FW: Hello\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/report; report-type=feedback-report;\r\n" +
+		"  boundary=\"part1\"\r\n" +
+		"\r\n" +
+		"--part1\r\n" +
+		"Content-Type: text/plain; charset=\"US-ASCII\"\r\n" +
+		"\r\n" +
+		"This is an email abuse report for an email message received from IP\r\n" +
+		"192.0.2.1 on Thu, 8 Mar 2005 14:00:00 EDT.\r\n" +
+		"\r\n" +
+		"--part1\r\n" +
+		"Content-Type: message/feedback-report\r\n" +
+		"\r\n" +
+		"Feedback-Type: abuse\r\n" +
+		"User-Agent: SomeGenerator/1.0\r\n" +
+		"Version: 1\r\n" +
+		"Original-Mail-From: <sender@example.org>\r\n" +
+		"Original-Rcpt-To: <user@example.com>\r\n" +
+		"Arrival-Date: Thu, 8 Mar 2005 14:00:00 EDT\r\n" +
+		"Source-IP: 192.0.2.1\r\n" +
+		"Authentication-Results: mail.example.com;\r\n" +
+		"  spf=fail smtp.mailfrom=example.org\r\n" +
+		"Reported-Domain: example.org\r\n" +
+		"Reported-Uri: http://example.org/\r\n" +
+		"Incidents: 3\r\n" +
+		"\r\n" +
+		"--part1\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"Content-Disposition: inline\r\n" +
+		"\r\n" +
+		original +
+		"--part1--\r\n"
+
+	report, err := bounce.Parse(strings.NewReader(msg))
+	require.NoError(t, err)
+
+	require.Equal(t, bounce.KindFeedback, report.Kind)
+	require.Empty(t, report.Recipients)
+	require.Equal(t, "Hello", report.Original.Get("Subject"))
+
+	f := report.Feedback
+	require.NotNil(t, f)
+	require.Equal(t, "abuse", f.FeedbackType)
+	require.Equal(t, "SomeGenerator/1.0", f.UserAgent)
+	require.Equal(t, "1", f.Version)
+	require.Equal(t, "sender@example.org", f.OriginalMailFrom)
+	require.Equal(t, []string{"user@example.com"}, f.OriginalRcptTo)
+	require.Equal(t, 2005, f.ArrivalDate.Year())
+	require.Equal(t, "192.0.2.1", f.SourceIP)
+	require.Equal(t, "example.org", f.ReportedDomain)
+	require.Equal(t, []string{"http://example.org/"}, f.ReportedURI)
+	require.Equal(t, []string{"mail.example.com; spf=fail smtp.mailfrom=example.org"}, f.AuthenticationResults)
+	require.Equal(t, 3, f.Incidents)
+	require.True(t, f.Complaint())
+
+	require.Equal(t, []string{"user@example.com"}, report.Suppress())
+
+	// without Original-Rcpt-To the recipients of the original message are suppressed
+	msg = strings.Replace(msg, "Original-Rcpt-To: <user@example.com>\r\n", "", 1)
+	report, err = bounce.Parse(strings.NewReader(msg))
+	require.NoError(t, err)
+	require.Equal(t, []string{"user@example.com"}, report.Suppress())
+
+	// a not-spam report isn't a complaint
+	msg = strings.Replace(msg, "Feedback-Type: abuse", "Feedback-Type: not-spam", 1)
+	report, err = bounce.Parse(strings.NewReader(msg))
+	require.NoError(t, err)
+	require.False(t, report.Feedback.Complaint())
+	require.Empty(t, report.Suppress())
+}
+
+func TestParse_Heuristic(t *testing.T) {
+	for name, test := range map[string]struct {
+		msg        string
+		recipients []bounce.Recipient
+	}{
+		"qmail": {
+			msg: "From: MAILER-DAEMON@mail.example.net\r\n" +
+				"To: sender@example.org\r\n" +
+				"Subject: failure notice\r\n" +
+				"\r\n" +
+				"Hi. This is the qmail-send program at mail.example.net.\r\n" +
+				"I'm afraid I wasn't able to deliver your message to the following addresses.\r\n" +
+				"This is a permanent error; I've given up. Sorry it didn't work out.\r\n" +
+				"\r\n" +
+				"<unknown@example.com>:\r\n" +
+				"192.0.2.2 does not like recipient.\r\n" +
+				"Remote host said: 550 5.1.1 User unknown\r\n" +
+				"Giving up on 192.0.2.2.\r\n" +
+				"\r\n" +
+				"--- Below this line is a copy of the message.\r\n" +
+				"\r\n" +
+				original,
+			recipients: []bounce.Recipient{{
+				Address:    "unknown@example.com",
+				Action:     dsn.ActionFailed,
+				Status:     smtp.EnhancedCode{5, 1, 1},
+				Diagnostic: "Remote host said: 550 5.1.1 User unknown",
+			}},
+		},
+		"exim": {
+			msg: "From: Mail Delivery System <Mailer-Daemon@mail.example.net>\r\n" +
+				"To: sender@example.org\r\n" +
+				"Subject: Mail delivery failed: returning message to sender\r\n" +
+				"X-Failed-Recipients: unknown@example.com, full@example.com\r\n" +
+				"\r\n" +
+				"This message was created automatically by mail delivery software.\r\n" +
+				"\r\n" +
+				"A message that you sent could not be delivered to one or more of its\r\n" +
+				"recipients. This is a permanent error. The following address(es) failed:\r\n" +
+				"\r\n" +
+				"  unknown@example.com\r\n" +
+				"    host mx.example.com [192.0.2.3]\r\n" +
+				"    SMTP error from remote mail server after RCPT TO:<unknown@example.com>:\r\n" +
+				"    550-5.1.1 The email account that you tried to reach does not exist.\r\n" +
+				"  full@example.com\r\n" +
+				"    host mx.example.com [192.0.2.3]\r\n" +
+				"    SMTP error from remote mail server after RCPT TO:<full@example.com>:\r\n" +
+				"    452 mailbox full\r\n" +
+				"\r\n" +
+				"------ This is a copy of the message, including all the headers. ------\r\n" +
+				"\r\n" +
+				original,
+			recipients: []bounce.Recipient{
+				{
+					Address:    "unknown@example.com",
+					Action:     dsn.ActionFailed,
+					Status:     smtp.EnhancedCode{5, 1, 1},
+					Diagnostic: "550-5.1.1 The email account that you tried to reach does not exist.",
+				},
+				{
+					Address:    "full@example.com",
+					Action:     dsn.ActionFailed,
+					Status:     smtp.EnhancedCode{4, 0, 0},
+					Diagnostic: "452 mailbox full",
+				},
+			},
+		},
+		"without code": {
+			msg: "From: MAILER-DAEMON@mail.example.net\r\n" +
+				"Subject: Undelivered Mail Returned to Sender\r\n" +
+				"\r\n" +
+				"Your message to unknown@example.com couldn't be delivered.\r\n" +
+				"550 5.1.1 User unknown\r\n" +
+				"\r\n" +
+				"For help contact helpdesk@example.net.\r\n",
+			recipients: []bounce.Recipient{
+				{
+					Address:    "unknown@example.com",
+					Action:     dsn.ActionFailed,
+					Status:     smtp.EnhancedCode{5, 1, 1},
+					Diagnostic: "550 5.1.1 User unknown",
+				},
+				{
+					Address: "helpdesk@example.net",
+					Action:  dsn.ActionFailed,
+					Status:  smtp.EnhancedCodeNotSet,
+				},
+			},
+		},
+		"header only": {
+			msg: "From: postmaster@example.net\r\n" +
+				"Subject: Undeliverable: Hello\r\n" +
+				"X-Failed-Recipients: unknown@example.com\r\n" +
+				"\r\n" +
+				"Delivery has failed to these recipients or groups:\r\n" +
+				"unknown@example.com\r\n",
+			recipients: []bounce.Recipient{{
+				Address: "unknown@example.com",
+				Action:  dsn.ActionFailed,
+				Status:  smtp.EnhancedCode{5, 0, 0},
+			}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			report, err := bounce.Parse(strings.NewReader(test.msg))
+			require.NoError(t, err)
+			require.Equal(t, bounce.KindHeuristic, report.Kind)
+			require.Equal(t, test.recipients, report.Recipients)
+			if strings.Contains(test.msg, original) {
+				require.Equal(t, "<1@example.org>", report.Original.Get("Message-Id"))
+			}
+			require.Equal(t, []string{"unknown@example.com"}, report.Suppress())
+		})
+	}
+}
+
+func TestParse_NoReport(t *testing.T) {
+	_, err := bounce.Parse(strings.NewReader(original))
+	require.ErrorIs(t, err, bounce.ErrNoReport)
+
+	_, err = bounce.Parse(strings.NewReader("invalid"))
+	require.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package bounce
+
+import (
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feedback is the machine readable part of a feedback report (RFC 5965 3.1).
+type Feedback struct {
+	// FeedbackType is the type of the report, e.g. abuse, fraud or not-spam.
+	FeedbackType string
+	// UserAgent is the software which created the report.
+	UserAgent string
+	// Version of the report format.
+	Version string
+
+	// OriginalMailFrom is the envelope sender of the original message, if known.
+	OriginalMailFrom string
+	// OriginalRcptTo are the envelope recipients of the original message, if known.
+	OriginalRcptTo []string
+	// ArrivalDate is the time the original message was received, if known.
+	ArrivalDate time.Time
+	// SourceIP is the address of the client which sent the original message, if known.
+	SourceIP string
+	// ReportedDomain is the domain the report is about, if known.
+	ReportedDomain string
+	// ReportedURI are the URIs the report is about, if any.
+	ReportedURI []string
+	// AuthenticationResults are the results of the authentication checks of the original message.
+	AuthenticationResults []string
+	// Incidents is the number of incidents the report represents, 1 if not set.
+	Incidents int
+}
+
+// Complaint returns true if the report is a complaint about the message, e.g. marked as spam.
+func (f *Feedback) Complaint() bool {
+	switch f.FeedbackType {
+	case "abuse", "fraud", "virus", "other":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseFeedback parses a feedback report.
+func parseFeedback(report *entity, feedback *entity) *Report {
+	res := &Report{Kind: KindFeedback, Original: report.original(), Feedback: &Feedback{Incidents: 1}}
+
+	blocks := readFields(feedback.body)
+	if len(blocks) == 0 {
+		return res
+	}
+
+	fields := blocks[0]
+	f := res.Feedback
+	f.FeedbackType = strings.ToLower(strings.TrimSpace(fields.Get("Feedback-Type")))
+	f.UserAgent = strings.TrimSpace(fields.Get("User-Agent"))
+	f.Version = strings.TrimSpace(fields.Get("Version"))
+	f.OriginalMailFrom = address(fields.Get("Original-Mail-From"))
+	for _, rcpt := range fields.Values("Original-Rcpt-To") {
+		if addr := address(rcpt); addr != "" {
+			f.OriginalRcptTo = append(f.OriginalRcptTo, addr)
+		}
+	}
+	f.ArrivalDate, _ = mail.ParseDate(fields.Get("Arrival-Date"))
+	f.SourceIP = strings.TrimSpace(fields.Get("Source-IP"))
+	f.ReportedDomain = strings.TrimSpace(fields.Get("Reported-Domain"))
+	for _, uri := range fields.Values("Reported-URI") {
+		f.ReportedURI = append(f.ReportedURI, strings.TrimSpace(uri))
+	}
+	for _, result := range fields.Values("Authentication-Results") {
+		f.AuthenticationResults = append(f.AuthenticationResults, strings.Join(strings.Fields(result), " "))
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(fields.Get("Incidents"))); err == nil && n > 0 {
+		f.Incidents = n
+	}
+
+	res.ArrivalDate = f.ArrivalDate
+	return res
+}
+
+// address returns the address of an envelope field, e.g. "<user@example.com>".
+func address(field string) string {
+	return strings.Trim(strings.TrimSpace(field), "<>")
+}
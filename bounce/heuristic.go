@@ -0,0 +1,171 @@
+package bounce
+
+import (
+	"bufio"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/dsn"
+)
+
+// window is the number of lines after an address which are searched for a status code.
+const window = 3
+
+var (
+	// subjectBounce matches the subjects of common non-standard bounces.
+	subjectBounce = regexp.MustCompile(`(?i)undeliver|returned mail|failure notice|delivery (status notification|` +
+		`failure|has failed|failed)|mail delivery failed|could not be delivered|non[- ]?delivery`)
+	// subjectDelay matches the subjects of delay warnings.
+	subjectDelay = regexp.MustCompile(`(?i)delayed|delivery delay|warning: could not send`)
+	// addressPattern matches an address in a line of text.
+	addressPattern = regexp.MustCompile(`[A-Za-z0-9.!#$%&'*+/=?^_{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?` +
+		`(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)+`)
+	// enhancedPattern matches an enhanced status code (RFC 3463), not a part of an ip address.
+	enhancedPattern = regexp.MustCompile(`(?:^|[\s(\[#:;-])([245])\.(\d{1,3})\.(\d{1,3})\.?(?:$|[\s)\],;:])`)
+	// replyPattern matches a reply code, e.g. "550 " or "550-".
+	replyPattern = regexp.MustCompile(`(?:^|\s)([45])\d\d(?:[\s-]|$)`)
+	// copyPattern matches the line separating the explanation from the returned message,
+	// e.g. "--- Below this line is a copy of the message." (qmail) or
+	// "------ This is a copy of the message, including all the headers. ------" (Exim).
+	copyPattern = regexp.MustCompile(`(?i)^-+ .*(copy of the message|original message)`)
+)
+
+// heuristic recognizes a non-standard bounce by its header and extracts the failed recipients
+// and their status codes from the explanation. Addresses without a status code nearby have
+// no status unless they are listed in X-Failed-Recipients.
+func heuristic(header mail.Header, root *entity) (*Report, error) {
+	failed := header.Get("X-Failed-Recipients")
+	if failed == "" && !daemon(header.Get("From")) && !subjectBounce.MatchString(header.Get("Subject")) {
+		return nil, ErrNoReport
+	}
+
+	action := dsn.ActionFailed
+	if subjectDelay.MatchString(header.Get("Subject")) {
+		action = dsn.ActionDelayed
+	}
+
+	res := &Report{Kind: KindHeuristic, Original: root.original()}
+
+	// the addresses of the bounce itself are never failed recipients
+	ignore := []string{}
+	for _, key := range []string{"From", "To", "Return-Path", "Sender"} {
+		for _, addr := range addressPattern.FindAllString(header.Get(key), -1) {
+			ignore = append(ignore, strings.ToLower(addr))
+		}
+	}
+
+	for _, text := range root.texts() {
+		rcpts, original := scan(text, ignore, action)
+		for _, rcpt := range rcpts {
+			if !slices.ContainsFunc(res.Recipients, func(r Recipient) bool {
+				return strings.EqualFold(r.Address, rcpt.Address)
+			}) {
+				res.Recipients = append(res.Recipients, rcpt)
+			}
+		}
+		if res.Original == nil {
+			res.Original = original
+		}
+	}
+
+	// Exim lists the failed recipients in a header field
+	for _, addr := range addressPattern.FindAllString(failed, -1) {
+		i := slices.IndexFunc(res.Recipients, func(r Recipient) bool {
+			return strings.EqualFold(r.Address, addr)
+		})
+		switch {
+		case i == -1:
+			res.Recipients = append(res.Recipients, Recipient{Address: addr, Action: action, Status: status(action)})
+		case res.Recipients[i].Status == smtp.EnhancedCodeNotSet:
+			res.Recipients[i].Status = status(action)
+		}
+	}
+
+	return res, nil
+}
+
+// scan searches the lines of text for recipients followed by status codes.
+// The header of a message returned after the explanation is returned as well.
+func scan(text string, ignore []string, action dsn.Action) ([]Recipient, mail.Header) {
+	lines := []string{}
+	var original mail.Header
+
+	s := bufio.NewScanner(strings.NewReader(text))
+	for s.Scan() {
+		line := s.Text()
+		if copyPattern.MatchString(line) {
+			rest := text[strings.Index(text, line)+len(line):]
+			original = readHeader([]byte(strings.TrimLeft(rest, "\r\n")))
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	rcpts := []Recipient{}
+	for i, line := range lines {
+		for _, addr := range addressPattern.FindAllString(line, -1) {
+			addr = strings.TrimRight(addr, ".")
+			if daemon(addr) || slices.Contains(ignore, strings.ToLower(addr)) ||
+				slices.ContainsFunc(rcpts, func(r Recipient) bool { return strings.EqualFold(r.Address, addr) }) {
+				continue
+			}
+			// without a status code nearby the address could be any address of the text
+			code, diagnostic := find(lines[i:min(i+window+1, len(lines))], addr)
+			rcpts = append(rcpts, Recipient{Address: addr, Action: action, Status: code, Diagnostic: diagnostic})
+		}
+	}
+
+	return rcpts, original
+}
+
+// find returns the status code of addr and the line containing it from the given lines.
+// The search stops at a line containing another address.
+func find(lines []string, addr string) (smtp.EnhancedCode, string) {
+	for i, line := range lines {
+		if i > 0 && slices.ContainsFunc(addressPattern.FindAllString(line, -1), func(other string) bool {
+			return !strings.EqualFold(strings.TrimRight(other, "."), addr)
+		}) {
+			break
+		}
+		if code := parseCode(line); code != smtp.EnhancedCodeNotSet {
+			return code, strings.Join(strings.Fields(line), " ")
+		}
+		if m := replyPattern.FindStringSubmatch(line); m != nil {
+			class, _ := strconv.Atoi(m[1])
+			return smtp.EnhancedCode{class, 0, 0}, strings.Join(strings.Fields(line), " ")
+		}
+	}
+	return smtp.EnhancedCodeNotSet, ""
+}
+
+// status returns the default status code of an action.
+func status(action dsn.Action) smtp.EnhancedCode {
+	if action == dsn.ActionDelayed {
+		return smtp.EnhancedCode{4, 0, 0}
+	}
+	return smtp.EnhancedCode{5, 0, 0}
+}
+
+// parseCode returns the first enhanced status code of s, smtp.EnhancedCodeNotSet if there is none.
+func parseCode(s string) smtp.EnhancedCode {
+	m := enhancedPattern.FindStringSubmatch(" " + s)
+	if m == nil {
+		return smtp.EnhancedCodeNotSet
+	}
+	code := smtp.EnhancedCode{}
+	for i := range code {
+		code[i], _ = strconv.Atoi(m[i+1])
+	}
+	return code
+}
+
+// daemon returns true if s contains the address of a mailer daemon or postmaster.
+func daemon(s string) bool {
+	s = strings.ToLower(s)
+	return strings.Contains(s, "mailer-daemon@") || strings.Contains(s, "postmaster@") ||
+		strings.Contains(s, "<mailer-daemon>")
+}
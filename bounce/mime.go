@@ -0,0 +1,143 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// maxDepth limits the nesting of multipart entities.
+const maxDepth = 8
+
+// entity is a parsed MIME entity.
+type entity struct {
+	mediaType string
+	params    map[string]string
+	body      []byte
+	parts     []*entity
+}
+
+// parseEntity parses the entity with the given header and body.
+// Multipart bodies are split into parts, other bodies are decoded.
+func parseEntity(header textproto.MIMEHeader, body io.Reader, depth int) (*entity, error) {
+	e := &entity{mediaType: "text/plain", params: map[string]string{}}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err == nil {
+			e.mediaType = mediaType
+			e.params = params
+		}
+	}
+
+	if strings.HasPrefix(e.mediaType, "multipart/") && e.params["boundary"] != "" && depth < maxDepth {
+		mr := multipart.NewReader(body, e.params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return e, nil
+			}
+			if err != nil {
+				// keep the parts read so far of a truncated message
+				return e, nil // nolint: nilerr
+			}
+			child, err := parseEntity(p.Header, p, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			e.parts = append(e.parts, child)
+		}
+	}
+
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+	e.body = data
+	return e, nil
+}
+
+// find returns the first entity (depth-first) matching the given media types.
+func (e *entity) find(mediaTypes ...string) *entity {
+	for _, mediaType := range mediaTypes {
+		if e.mediaType == mediaType {
+			return e
+		}
+	}
+	for _, p := range e.parts {
+		if found := p.find(mediaTypes...); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// texts returns the bodies of all text/plain entities.
+func (e *entity) texts() []string {
+	if e.mediaType == "text/plain" {
+		return []string{string(e.body)}
+	}
+	texts := []string{}
+	for _, p := range e.parts {
+		texts = append(texts, p.texts()...)
+	}
+	return texts
+}
+
+// original returns the header of a returned message: message/rfc822, message/global,
+// text/rfc822-headers or message/global-headers.
+func (e *entity) original() mail.Header {
+	found := e.find("message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers")
+	if found == nil {
+		return nil
+	}
+	return readHeader(found.body)
+}
+
+// readHeader reads a message header, the body (if any) is ignored.
+func readHeader(data []byte) mail.Header {
+	r := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("\r\n\r\n"))))
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil
+	}
+	return mail.Header(header)
+}
+
+// readFields reads the blocks of fields of a status body separated by empty lines.
+func readFields(data []byte) []textproto.MIMEHeader {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	blocks := []textproto.MIMEHeader{}
+	for {
+		// skip empty lines between blocks
+		for {
+			b, err := r.R.Peek(1)
+			if err != nil {
+				return blocks
+			}
+			if b[0] != '\r' && b[0] != '\n' {
+				break
+			}
+			_, _ = r.R.ReadByte()
+		}
+
+		block, err := r.ReadMIMEHeader()
+		if len(block) > 0 {
+			blocks = append(blocks, block)
+		}
+		if err != nil {
+			return blocks
+		}
+	}
+}
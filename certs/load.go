@@ -0,0 +1,108 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// pair is a certificate file with its private key file.
+type pair struct {
+	name string
+	cert string
+	key  string
+}
+
+// scan returns the certificate pairs of dir sorted by name.
+// A pair is either <name>.crt with <name>.key or a directory <name> containing fullchain.pem
+// and privkey.pem, which is the layout of certbot (e.g. /etc/letsencrypt/live).
+func scan(dir string) ([]pair, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []pair{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		// follow symlinks
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if info.IsDir() {
+			p := pair{
+				name: entry.Name(),
+				cert: filepath.Join(path, "fullchain.pem"),
+				key:  filepath.Join(path, "privkey.pem"),
+			}
+			if _, err := os.Stat(p.cert); err == nil {
+				pairs = append(pairs, p)
+			}
+			continue
+		}
+
+		if name, ok := strings.CutSuffix(entry.Name(), ".crt"); ok {
+			pairs = append(pairs, pair{name: name, cert: path, key: filepath.Join(dir, name+".key")})
+		}
+	}
+
+	slices.SortFunc(pairs, func(a, b pair) int { return strings.Compare(a.name, b.name) })
+	return pairs, nil
+}
+
+// load loads all certificate pairs of dir, it fails if any pair is invalid.
+func load(dir string) ([]*certificate, error) {
+	pairs, err := scan(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("certs: no certificate found in %s", dir)
+	}
+
+	certs := make([]*certificate, 0, len(pairs))
+	for _, p := range pairs {
+		cert, err := tls.LoadX509KeyPair(p.cert, p.key)
+		if err != nil {
+			return nil, fmt.Errorf("certs: %s: %w", p.name, err)
+		}
+		if cert.Leaf == nil {
+			// the leaf isn't populated with GODEBUG x509keypairleaf=0
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("certs: %s: %w", p.name, err)
+			}
+		}
+		certs = append(certs, &certificate{name: p.name, cert: &cert})
+	}
+
+	return certs, nil
+}
+
+// stamp returns a value which changes if any certificate pair of dir is added, removed or modified.
+func stamp(dir string) string {
+	pairs, err := scan(dir)
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, p := range pairs {
+		for _, file := range []string{p.cert, p.key} {
+			b.WriteString(file)
+			if info, err := os.Stat(file); err == nil {
+				fmt.Fprintf(&b, ":%d:%d", info.Size(), info.ModTime().UnixNano())
+			}
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.String()
+}
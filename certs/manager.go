@@ -0,0 +1,212 @@
+// Package certs implements a certificate manager for the SMTP server.
+//
+// The manager loads certificate pairs from a directory, selects the certificate by the
+// server name (SNI) of the client and reloads the certificates without restarting the server.
+// Because the certificate is selected during every handshake, it is used for implicit TLS
+// as well as for STARTTLS:
+//
+//	m, err := certs.New("/etc/letsencrypt/live")
+//	if err != nil {
+//		return err
+//	}
+//	go m.Watch(ctx, time.Minute, syscall.SIGHUP)
+//
+//	s := server.New(server.WithTLSConfig(m.TLSConfig(nil)))
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Info describes a loaded certificate.
+type Info struct {
+	// Name is the name of the certificate pair, e.g. the file name without extension.
+	Name string
+	// DNSNames are the names the certificate is selected for.
+	DNSNames  []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Manager selects certificates by SNI and reloads them. It is safe for concurrent use.
+type Manager struct {
+	dir    string
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	stamp string
+	certs []*certificate
+	names map[string][]*certificate
+}
+
+// certificate is a loaded certificate pair.
+type certificate struct {
+	name string
+	cert *tls.Certificate
+}
+
+// Option defines a manager option.
+type Option func(m *Manager)
+
+// WithLogger sets the logger used to report failed reloads while watching.
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
+
+// New returns a manager with the certificate pairs of dir. A pair is either <name>.crt with <name>.key
+// or a directory <name> containing fullchain.pem and privkey.pem (the layout of certbot).
+func New(dir string, opts ...Option) (*Manager, error) {
+	m := &Manager{dir: dir, logger: slog.Default()}
+
+	for _, o := range opts {
+		o(m)
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Reload loads all certificate pairs again. If any pair can't be loaded,
+// the previous certificates are kept and an error is returned.
+func (m *Manager) Reload() error {
+	stamp := stamp(m.dir)
+
+	certs, err := load(m.dir)
+	if err != nil {
+		return err
+	}
+
+	names := map[string][]*certificate{}
+	for _, c := range certs {
+		dnsNames := c.cert.Leaf.DNSNames
+		if len(dnsNames) == 0 && c.cert.Leaf.Subject.CommonName != "" {
+			dnsNames = []string{c.cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range dnsNames {
+			name = strings.ToLower(name)
+			names[name] = append(names[name], c)
+		}
+	}
+
+	m.mu.Lock()
+	m.stamp = stamp
+	m.certs = certs
+	m.names = names
+	m.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate returns the certificate for the server name of the client, it can be used as
+// tls.Config.GetCertificate. Exact names take precedence over wildcards. If no certificate matches,
+// the first certificate in name order is used.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	candidates := m.names[name]
+	if len(candidates) == 0 {
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			candidates = m.names["*"+name[i:]]
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = m.certs
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("certs: no certificate available")
+	}
+
+	// prefer a certificate the client supports, e.g. ECDSA or RSA
+	for _, c := range candidates {
+		if hello.SupportsCertificate(c.cert) == nil {
+			return c.cert, nil
+		}
+	}
+
+	return candidates[0].cert, nil
+}
+
+// TLSConfig returns a copy of config (or a new config if nil) which selects the certificates by the manager.
+func (m *Manager) TLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		config = config.Clone()
+	}
+
+	config.Certificates = nil
+	config.GetCertificate = m.GetCertificate
+	return config
+}
+
+// Certificates returns information about the loaded certificates, e.g. to monitor their expiry.
+func (m *Manager) Certificates() []Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]Info, 0, len(m.certs))
+	for _, c := range m.certs {
+		res = append(res, Info{
+			Name:      c.name,
+			DNSNames:  c.cert.Leaf.DNSNames,
+			NotBefore: c.cert.Leaf.NotBefore,
+			NotAfter:  c.cert.Leaf.NotAfter,
+		})
+	}
+
+	return res
+}
+
+// Watch reloads the certificates if the files change (checked every interval) or if one of
+// the signals (e.g. SIGHUP) is received, until ctx is done. A non positive interval disables
+// checking the files. Failed reloads are logged and the previous certificates are kept.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration, signals ...os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var sig chan os.Signal
+	if len(signals) > 0 {
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, signals...)
+		defer signal.Stop(sig)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			m.mu.RLock()
+			current := m.stamp
+			m.mu.RUnlock()
+			if stamp(m.dir) == current {
+				continue
+			}
+		case <-sig:
+		}
+
+		if err := m.Reload(); err != nil {
+			m.logger.ErrorContext(ctx, "certificate reload failed", slog.String("dir", m.dir), slog.Any("err", err))
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// writeCert writes a self signed certificate for domain to certFile and its key to keyFile.
+func writeCert(t *testing.T, certFile string, keyFile string, domain string) {
+	cert, err := tester.GenX509KeyPair(domain)
+	require.NoError(t, err)
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(certFile), 0o700))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+	require.NoError(t, os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+}
+
+// serverName returns the first DNS name of the certificate selected for name.
+func serverName(t *testing.T, m *Manager, name string) string {
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	require.NoError(t, err)
+	return cert.Leaf.DNSNames[0]
+}
+
+func TestManager_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "mx.example.com")
+	writeCert(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "*.example.org")
+	writeCert(t, filepath.Join(dir, "c.crt"), filepath.Join(dir, "c.key"), "mx.example.org")
+	writeCert(t, filepath.Join(dir, "d", "fullchain.pem"), filepath.Join(dir, "d", "privkey.pem"), "mx.example.net")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0o600))
+
+	m, err := New(dir)
+	require.NoError(t, err)
+
+	require.Equal(t, "mx.example.com", serverName(t, m, "MX.example.com."))
+	require.Equal(t, "mx.example.org", serverName(t, m, "mx.example.org"))
+	require.Equal(t, "*.example.org", serverName(t, m, "smtp.example.org"))
+	require.Equal(t, "mx.example.net", serverName(t, m, "mx.example.net"))
+
+	// the first certificate is the default
+	require.Equal(t, "mx.example.com", serverName(t, m, "unknown.example"))
+	require.Equal(t, "mx.example.com", serverName(t, m, ""))
+
+	infos := m.Certificates()
+	require.Len(t, infos, 4)
+	require.Equal(t, "a", infos[0].Name)
+	require.Equal(t, []string{"mx.example.com"}, infos[0].DNSNames)
+	require.True(t, infos[0].NotAfter.After(time.Now()))
+	require.Equal(t, "d", infos[3].Name)
+}
+
+func TestManager_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := New(dir)
+	require.ErrorContains(t, err, "no certificate found")
+
+	_, err = New(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+
+	// the key is missing
+	writeCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.pem"), "mx.example.com")
+	_, err = New(dir)
+	require.ErrorContains(t, err, "certs: a:")
+}
+
+func TestManager_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "mx.crt"), filepath.Join(dir, "mx.key")
+	writeCert(t, certFile, keyFile, "mx.example.com")
+
+	m, err := New(dir)
+	require.NoError(t, err)
+
+	writeCert(t, certFile, keyFile, "mx.example.org")
+	require.NoError(t, m.Reload())
+	require.Equal(t, "mx.example.org", serverName(t, m, "mx.example.org"))
+
+	// a broken pair keeps the previous certificates
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0o600))
+	require.Error(t, m.Reload())
+	require.Equal(t, "mx.example.org", serverName(t, m, "mx.example.org"))
+}
+
+func TestManager_Watch(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "mx.example.com")
+
+	m, err := New(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Watch(ctx, 10*time.Millisecond)
+
+	writeCert(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "mx.example.org")
+	require.Eventually(t, func() bool {
+		return len(m.Certificates()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestManager_WatchSignal(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "mx.example.com")
+
+	m, err := New(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// SIGHUP mustn't terminate the test before the manager is notified
+	ignore := make(chan os.Signal, 1)
+	signal.Notify(ignore, syscall.SIGHUP)
+	defer signal.Stop(ignore)
+
+	// only the signal triggers a reload
+	go m.Watch(ctx, 0, syscall.SIGHUP)
+
+	writeCert(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "mx.example.org")
+	require.Eventually(t, func() bool {
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+		return len(m.Certificates()) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestManager_Server(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "mx.example.com")
+	writeCert(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "mx.example.org")
+
+	m, err := New(dir)
+	require.NoError(t, err)
+
+	for _, implicit := range []bool{true, false} {
+		srv := tester.Standard(server.WithTLSConfig(m.TLSConfig(nil)), server.WithImplicitTLS(implicit))
+
+		l, err := srv.Listen()
+		require.NoError(t, err)
+
+		go func() {
+			_ = srv.Serve(context.Background(), l)
+		}()
+
+		for _, name := range []string{"mx.example.com", "mx.example.org"} {
+			// nolint: gosec
+			config := &tls.Config{ServerName: name, InsecureSkipVerify: true}
+
+			c := client.New()
+			if implicit {
+				require.NoError(t, c.DialTLS(context.Background(), config, l.Addr().String()))
+			} else {
+				require.NoError(t, c.Dial(context.Background(), l.Addr().String()))
+				require.NoError(t, c.StartTLS(config, ""))
+			}
+
+			state, ok := c.TLSConnectionState()
+			require.True(t, ok)
+			require.Equal(t, []string{name}, state.PeerCertificates[0].DNSNames)
+			require.NoError(t, c.Quit())
+		}
+
+		require.NoError(t, srv.Close())
+	}
+}
@@ -33,6 +33,9 @@ import (
 	"github.com/uponusolutions/go-smtp/internal/textsmtp"
 )
 
+// ErrLineTooLong is returned while writing a message if a line exceeds the message line limit.
+var ErrLineTooLong = textsmtp.ErrLineTooLong
+
 // Client implements a SMTP Client with .
 type Client struct {
 	cfg Config
@@ -43,6 +46,8 @@ type Client struct {
 
 	ext map[string]string // supported extensions of the server after ehlo
 
+	utf8 bool // SMTPUTF8 is used for the current transaction
+
 	// keep a reference to the connection so it can be used to create a TLS
 	// connection later
 	conn        net.Conn
@@ -404,7 +409,8 @@ func (c *Client) Auth(saslClient sasl.Client) error {
 			// the last message isn't base64 because it isn't a challenge
 			msg = []byte(msg64)
 		default:
-			err = toSMTPErr(&textproto.Error{Code: code, Msg: msg64})
+			// the server finished the authentication, there is nothing to abort
+			return toSMTPErr(&textproto.Error{Code: code, Msg: msg64})
 		}
 		if err == nil {
 			if code == 334 {
@@ -431,6 +437,8 @@ func (c *Client) Auth(saslClient sasl.Client) error {
 // Mail issues a MAIL command to the server using the provided email address.
 // If the server supports the 8BITMIME extension, Mail adds the BODY=8BITMIME
 // parameter.
+// If SMTPUTF8 isn't used, the domain of an internationalized address is converted
+// to A-labels and a non-ASCII local part is rejected with 553 5.6.7 (RFC 6531 3.2).
 // This initiates a mail transaction and is followed by one or more Rcpt calls.
 //
 // If opts is not nil, MAIL arguments provided in the structure will be added
@@ -442,6 +450,23 @@ func (c *Client) Mail(from string, opts *MailOptions) error {
 		return err
 	}
 
+	// By default utf8 is preferred
+	c.utf8 = false
+	if opts == nil || opts.UTF8 != UTF8Disabled {
+		if _, ok := c.ext["SMTPUTF8"]; ok {
+			c.utf8 = true
+		} else if opts != nil && opts.UTF8 == UTF8Force {
+			return errors.New("smtp: server does not support SMTPUTF8")
+		}
+	}
+
+	if !c.utf8 {
+		var err error
+		if from, err = asciiAddress(from); err != nil {
+			return err
+		}
+	}
+
 	var sb strings.Builder
 	// A high enough power of 2 than 510+14+26+11+9+9+39+500
 	sb.Grow(2048)
@@ -458,13 +483,8 @@ func (c *Client) Mail(from string, opts *MailOptions) error {
 		}
 		sb.WriteString(" REQUIRETLS")
 	}
-	// By default utf8 is preferred
-	if opts == nil || opts.UTF8 != UTF8Disabled {
-		if _, ok := c.ext["SMTPUTF8"]; ok {
-			sb.WriteString(" SMTPUTF8")
-		} else if opts != nil && opts.UTF8 == UTF8Force {
-			return errors.New("smtp: server does not support SMTPUTF8")
-		}
+	if c.utf8 {
+		sb.WriteString(" SMTPUTF8")
 	}
 	if _, ok := c.ext["DSN"]; ok && opts != nil {
 		switch opts.Return {
@@ -509,8 +529,23 @@ func (c *Client) Mail(from string, opts *MailOptions) error {
 //
 // If server returns an error, it will be of type *smtp.
 func (c *Client) Rcpt(to string, opts *smtp.RcptOptions) error {
+	_, _, err := c.RcptWithResponse(to, opts)
+	return err
+}
+
+// RcptWithResponse issues a RCPT command like Rcpt and returns the code and msg of the server reply.
+// The address is converted like in Mail if the transaction doesn't use SMTPUTF8.
+//
+// If server returns an error, it will be of type *smtp.
+func (c *Client) RcptWithResponse(to string, opts *smtp.RcptOptions) (code int, msg string, err error) {
 	if err := validateLine(to); err != nil {
-		return err
+		return 0, "", err
+	}
+
+	if !c.utf8 {
+		if to, err = asciiAddress(to); err != nil {
+			return 0, "", err
+		}
 	}
 
 	var sb strings.Builder
@@ -521,7 +556,7 @@ func (c *Client) Rcpt(to string, opts *smtp.RcptOptions) error {
 		if len(opts.Notify) != 0 {
 			sb.WriteString(" NOTIFY=")
 			if err := textsmtp.CheckNotifySet(opts.Notify); err != nil {
-				return errors.New("smtp: Malformed NOTIFY parameter value")
+				return 0, "", errors.New("smtp: Malformed NOTIFY parameter value")
 			}
 			for i, v := range opts.Notify {
 				if i != 0 {
@@ -535,7 +570,7 @@ func (c *Client) Rcpt(to string, opts *smtp.RcptOptions) error {
 			switch opts.OriginalRecipientType {
 			case smtp.DSNAddressTypeRFC822:
 				if !textsmtp.IsPrintableASCII(opts.OriginalRecipient) {
-					return errors.New("smtp: Illegal address")
+					return 0, "", errors.New("smtp: Illegal address")
 				}
 				enc = encodeXtext(opts.OriginalRecipient)
 			case smtp.DSNAddressTypeUTF8:
@@ -545,15 +580,12 @@ func (c *Client) Rcpt(to string, opts *smtp.RcptOptions) error {
 					enc = encodeUTF8AddrXtext(opts.OriginalRecipient)
 				}
 			default:
-				return errors.New("smtp: Unknown address type")
+				return 0, "", errors.New("smtp: Unknown address type")
 			}
 			fmt.Fprintf(&sb, " ORCPT=%s;%s", string(opts.OriginalRecipientType), enc)
 		}
 	}
-	if _, _, err := c.cmd(25, "%s", sb.String()); err != nil {
-		return err
-	}
-	return nil
+	return c.cmd(25, "%s", sb.String())
 }
 
 // Content issues a DATA or BDAT (prefer BDAT if available) command to
@@ -581,7 +613,7 @@ func (c *Client) Data() (*DataCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DataCloser{c: c, writer: textsmtp.NewDotWriter(c.cfg.text.W)}, nil
+	return &DataCloser{c: c, writer: c.messageWriter(textsmtp.NewDotWriter(c.cfg.text.W))}, nil
 }
 
 // Bdat issues a BDAT command to the server and returns a writer that
@@ -598,6 +630,11 @@ func (c *Client) Bdat(size int) (*DataCloser, error) {
 		return nil, errors.New("smtp: server doesn't support chunking")
 	}
 
+	// the size of the message changes if line endings are translated
+	if c.cfg.normalizeLineEndings || c.cfg.finalCRLF {
+		size = 0
+	}
+
 	// if chunking max size is active but smaller than a typically []byte write call, the buffer is just overhead
 	if c.cfg.chunkingBufferEnabled && size == 0 && (c.cfg.chunkingMaxSize == 0 || c.cfg.chunkingMaxSize > 4096) {
 		// c.bdatBuffer is init on first use and always reuse it
@@ -609,16 +646,28 @@ func (c *Client) Bdat(size int) (*DataCloser, error) {
 			c.chunkingBuffer = make([]byte, bufferSize)
 		}
 
-		return &DataCloser{c: c, writer: textsmtp.NewBdatWriterBuffered(c.cfg.chunkingMaxSize, c.cfg.text.W, func() error {
-			_, _, err := c.cfg.text.ReadResponse(250)
-			return err
-		}, size, c.chunkingBuffer[:bufferSize])}, nil
+		return &DataCloser{c: c, writer: c.messageWriter(textsmtp.NewBdatWriterBuffered(
+			c.cfg.chunkingMaxSize, c.cfg.text.W, c.readBdatResponse, size, c.chunkingBuffer[:bufferSize],
+		))}, nil
 	}
 
-	return &DataCloser{c: c, writer: textsmtp.NewBdatWriter(c.cfg.chunkingMaxSize, c.cfg.text.W, func() error {
-		_, _, err := c.cfg.text.ReadResponse(250)
-		return err
-	}, size)}, nil
+	return &DataCloser{c: c, writer: c.messageWriter(textsmtp.NewBdatWriter(
+		c.cfg.chunkingMaxSize, c.cfg.text.W, c.readBdatResponse, size,
+	))}, nil
+}
+
+// readBdatResponse reads the response to a BDAT command.
+func (c *Client) readBdatResponse() error {
+	_, _, err := c.cfg.text.ReadResponse(250)
+	return err
+}
+
+// messageWriter applies the line ending options to the writer of a message.
+func (c *Client) messageWriter(w io.WriteCloser) io.WriteCloser {
+	if !c.cfg.normalizeLineEndings && c.cfg.messageLineLimit <= 0 && !c.cfg.finalCRLF {
+		return w
+	}
+	return textsmtp.NewLineEndingWriter(w, c.cfg.normalizeLineEndings, c.cfg.messageLineLimit, c.cfg.finalCRLF)
 }
 
 // Extension reports whether an extension is support by the server.
@@ -661,6 +710,44 @@ func (c *Client) MaxMessageSize() (size int, ok bool) {
 	return size, true
 }
 
+// Limits contains the limits advertised by the server with the LIMITS extension (RFC 9422).
+// A zero value means that the server doesn't advertise the limit.
+type Limits struct {
+	// Maximum number of transactions per connection.
+	MailMax int
+	// Maximum number of recipients per transaction.
+	RcptMax int
+	// Maximum number of recipient domains per transaction.
+	RcptDomainMax int
+}
+
+// Limits returns the limits advertised by the server (RFC 9422).
+// Unknown or malformed limits are ignored.
+func (c *Client) Limits() Limits {
+	limits := Limits{}
+
+	for param := range strings.FieldsSeq(c.ext["LIMITS"]) {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			continue
+		}
+		switch strings.ToUpper(name) {
+		case "MAILMAX":
+			limits.MailMax = limit
+		case "RCPTMAX":
+			limits.RcptMax = limit
+		case "RCPTDOMAINMAX":
+			limits.RcptDomainMax = limit
+		}
+	}
+
+	return limits
+}
+
 // Reset sends the RSET command to the server, aborting the current mail
 // transaction.
 func (c *Client) Reset() error {
@@ -33,7 +33,7 @@ func TestClientAuthTrimSpace(t *testing.T) {
 	c.setConn(fake)
 	require.Error(t, c.Auth(toServerNoRespAuth{}))
 	require.NoError(t, c.Close())
-	if got, want := wrote.String(), "AUTH FOOAUTH\r\n*\r\n"; got != want {
+	if got, want := wrote.String(), "AUTH FOOAUTH\r\n"; got != want {
 		t.Errorf("wrote %q; want %q", got, want)
 	}
 }
@@ -433,6 +433,24 @@ HELO localhost
 QUIT
 `
 
+func TestClientLimits(t *testing.T) {
+	server := "220 hello world\r\n" +
+		"250-mx.google.com at your service\r\n" +
+		"250-LIMITS MAILMAX=5 rcptmax=10 RCPTDOMAINMAX=x FOO=1\r\n" +
+		"250 8BITMIME\r\n"
+
+	c := New()
+	c.setConn(tester.NewFakeConn(server, &bytes.Buffer{}))
+	defer func() { _ = c.Close() }()
+
+	require.Equal(t, Limits{}, c.Limits())
+
+	require.NoError(t, c.greet())
+	require.NoError(t, c.Hello())
+
+	require.Equal(t, Limits{MailMax: 5, RcptMax: 10}, c.Limits())
+}
+
 func TestHello(t *testing.T) {
 	if len(helloServer) != len(helloClient) {
 		t.Fatal("Hello server and client size mismatch")
@@ -648,7 +666,6 @@ var authFailedServer = `220 hello world
 
 var authFailedClient = `EHLO localhost
 AUTH PLAIN AHVzZXIAcGFzcw==
-*
 `
 
 func TestTLSConnState(t *testing.T) {
@@ -885,6 +902,7 @@ func TestClientDSN(t *testing.T) {
 		Notify:                []smtp.DSNNotify{smtp.DSNNotifyFailure, smtp.DSNNotifyDelayed},
 	}))
 	c.ext["SMTPUTF8"] = ""
+	c.utf8 = true // the transaction uses SMTPUTF8, the address isn't converted
 	require.NoError(t, c.Rcpt(dsnEmailUTF8, &smtp.RcptOptions{
 		OriginalRecipientType: smtp.DSNAddressTypeUTF8,
 		OriginalRecipient:     dsnEmailUTF8,
@@ -895,6 +913,79 @@ func TestClientDSN(t *testing.T) {
 	}
 }
 
+func TestClientIDNA(t *testing.T) {
+	wrote := &bytes.Buffer{}
+	c := New()
+	c.setConn(tester.NewFakeConn("250 ok\r\n250 ok\r\n250 ok\r\n250 ok\r\n250 ok\r\n", wrote))
+
+	// without SMTPUTF8 the domain is converted
+	c.ext = map[string]string{}
+	require.NoError(t, c.Mail("alice@bücher.example", nil))
+	require.NoError(t, c.Rcpt("bob@münchen.de", nil))
+
+	// a non-ASCII local part fails without sending anything
+	err := c.Rcpt("jörg@example.com", nil)
+	status := &smtp.Status{}
+	require.ErrorAs(t, err, &status)
+	require.Equal(t, 553, status.Code)
+	require.Equal(t, smtp.EnhancedCode{5, 6, 7}, status.EnhancedCode)
+
+	// the address is kept if SMTPUTF8 is used
+	c.ext["SMTPUTF8"] = ""
+	require.NoError(t, c.Mail("jörg@bücher.example", nil))
+	require.NoError(t, c.Rcpt("bob@münchen.de", nil))
+
+	// but converted if SMTPUTF8 is disabled
+	require.NoError(t, c.Mail("alice@bücher.example", &MailOptions{UTF8: UTF8Disabled}))
+	require.ErrorAs(t, c.Mail("jörg@bücher.example", &MailOptions{UTF8: UTF8Disabled}), &status)
+
+	require.Equal(t, "MAIL FROM:<alice@xn--bcher-kva.example>\r\n"+
+		"RCPT TO:<bob@xn--mnchen-3ya.de>\r\n"+
+		"MAIL FROM:<jörg@bücher.example> SMTPUTF8\r\n"+
+		"RCPT TO:<bob@münchen.de>\r\n"+
+		"MAIL FROM:<alice@xn--bcher-kva.example>\r\n", wrote.String())
+}
+
 func (c *Client) Test() map[string]string {
 	return c.ext
 }
+
+func TestClientLineEndings(t *testing.T) {
+	server := "354 Go ahead\r\n250 ok\r\n250 ok\r\n250 ok\r\n250 ok\r\n"
+
+	wrote := &bytes.Buffer{}
+	c := New(WithNormalizeLineEndings(true), WithFinalCRLF(true), WithChunkingBuffer(false))
+	c.setConn(tester.NewFakeConn(server, wrote))
+
+	w, err := c.Data()
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "Subject: DATA\n\nb\r")
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "\n.c")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, "DATA\r\nSubject: DATA\r\n\r\nb\r\n..c\r\n.\r\n", wrote.String())
+
+	// the given size isn't used, as it changes
+	c.ext = map[string]string{"CHUNKING": ""}
+	wrote.Reset()
+
+	w, err = c.Bdat(15)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "Subject: BDAT\n\nb")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, "BDAT 18\r\nSubject: BDAT\r\n\r\nbBDAT 2\r\n\r\nBDAT 0 LAST\r\n", wrote.String())
+}
+
+func TestClientMessageLineLimit(t *testing.T) {
+	c := New(WithMessageLineLimit(3))
+	c.setConn(tester.NewFakeConn("354 Go ahead\r\n", &bytes.Buffer{}))
+
+	w, err := c.Data()
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "abc\r\nab")
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "cd\r\n")
+	require.ErrorIs(t, err, ErrLineTooLong)
+}
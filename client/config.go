@@ -107,6 +107,15 @@ type Config struct {
 	// If you guarantee that you reader has large enough chunks,
 	// you can disable the chunking buffer here.
 	chunkingBufferEnabled bool
+
+	// Translate bare CR and bare LF of messages into CRLF.
+	normalizeLineEndings bool
+
+	// Maximum line length of messages without CRLF, a zero value disables the limit.
+	messageLineLimit int
+
+	// Append CRLF if a message doesn't end with a line break.
+	finalCRLF bool
 }
 
 // Option defines a client option.
@@ -188,3 +197,29 @@ func WithWriterSize(writerSize int) Option {
 		c.writerSize = writerSize
 	}
 }
+
+// WithNormalizeLineEndings translates bare CR and bare LF of messages into CRLF while
+// they are written with DATA or BDAT. Strict receivers reject bare line endings.
+// As the size of the message may change, a known size isn't used for BDAT.
+func WithNormalizeLineEndings(normalize bool) Option {
+	return func(c *Config) {
+		c.normalizeLineEndings = normalize
+	}
+}
+
+// WithMessageLineLimit rejects messages with a line longer than limit octets (without CRLF)
+// with ErrLineTooLong while they are written. RFC 5322 limits lines to 998 octets.
+// A zero value disables the limit (default).
+func WithMessageLineLimit(limit int) Option {
+	return func(c *Config) {
+		c.messageLineLimit = limit
+	}
+}
+
+// WithFinalCRLF appends CRLF if a message doesn't end with a line break.
+// DATA always ends with CRLF, this is only relevant for BDAT.
+func WithFinalCRLF(finalCRLF bool) Option {
+	return func(c *Config) {
+		c.finalCRLF = finalCRLF
+	}
+}
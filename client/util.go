@@ -2,56 +2,43 @@ package client
 
 import (
 	"errors"
+	"fmt"
 	"net/textproto"
 	"strconv"
 	"strings"
 
 	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/internal/idna"
 )
 
-func parseEnhancedCode(s string) (smtp.EnhancedCode, error) {
-	parts := strings.Split(s, ".")
-	if len(parts) != 3 {
-		return smtp.EnhancedCode{}, errors.New("wrong amount of enhanced code parts")
-	}
-
-	code := smtp.EnhancedCode{}
-	for i, part := range parts {
-		num, err := strconv.Atoi(part)
-		if err != nil {
-			return code, err
-		}
-		code[i] = num
-	}
-	return code, nil
-}
-
 // toSMTPErr converts textproto.Error into smtp, parsing
 // enhanced status code if it is present.
 func toSMTPErr(protoErr *textproto.Error) *smtp.Status {
-	smtpErr := &smtp.Status{
-		Code:    protoErr.Code,
-		Message: protoErr.Msg,
+	return smtp.ParseStatus(protoErr.Code, protoErr.Msg)
+}
+
+// asciiAddress converts addr for a transaction without SMTPUTF8, the domain is converted
+// to A-labels (RFC 5891). A non-ASCII local part can't be converted (RFC 6531 3.2).
+func asciiAddress(addr string) (string, error) {
+	if idna.IsASCII(addr) {
+		return addr, nil
 	}
 
-	parts := strings.SplitN(protoErr.Msg, " ", 2)
-	if len(parts) != 2 {
-		return smtpErr
+	at := strings.LastIndexByte(addr, '@')
+	if at < 0 || !idna.IsASCII(addr[:at]) {
+		return "", smtp.NewStatus(553, smtp.EnhancedCode{5, 6, 7},
+			fmt.Sprintf("Non-ASCII address %s requires SMTPUTF8", addr),
+		)
 	}
 
-	enchCode, err := parseEnhancedCode(parts[0])
+	domain, err := idna.ToASCII(addr[at+1:])
 	if err != nil {
-		return smtpErr
+		return "", smtp.NewStatus(553, smtp.EnhancedCode{5, 1, 3},
+			fmt.Sprintf("Invalid domain of address %s: %v", addr, err),
+		)
 	}
 
-	msg := parts[1]
-
-	// Per RFC 2034, enhanced code should be prepended to each line.
-	msg = strings.ReplaceAll(msg, "\n"+parts[0]+" ", "\n")
-
-	smtpErr.EnhancedCode = enchCode
-	smtpErr.Message = msg
-	return smtpErr
+	return addr[:at+1] + domain, nil
 }
 
 // validateLine checks to see if a line has CR or LF.
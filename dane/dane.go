@@ -0,0 +1,203 @@
+// Package dane implements the verification of SMTP servers with DANE TLSA records (RFC 7672).
+//
+// TLSA records are only trustworthy if they are authenticated by DNSSEC, therefore the lookup
+// requires a validating resolver. Only the usages DANE-TA and DANE-EE are usable for SMTP,
+// PKIX-TA and PKIX-EE records are ignored (RFC 7672 3.1.3).
+package dane
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Usage of a TLSA record (RFC 6698 2.1.1).
+type Usage uint8
+
+const (
+	// UsagePKIXTA constrains the trust anchor, unusable for SMTP.
+	UsagePKIXTA Usage = 0
+	// UsagePKIXEE constrains the end entity certificate, unusable for SMTP.
+	UsagePKIXEE Usage = 1
+	// UsageDANETA specifies a trust anchor which must issue the certificate of the server.
+	UsageDANETA Usage = 2
+	// UsageDANEEE specifies the certificate or public key of the server.
+	UsageDANEEE Usage = 3
+)
+
+// Selector defines which part of the certificate is matched (RFC 6698 2.1.2).
+type Selector uint8
+
+const (
+	// SelectorCert matches the full certificate.
+	SelectorCert Selector = 0
+	// SelectorSPKI matches the subject public key info.
+	SelectorSPKI Selector = 1
+)
+
+// MatchingType defines how the data is matched (RFC 6698 2.1.3).
+type MatchingType uint8
+
+const (
+	// MatchingFull matches the data as it is.
+	MatchingFull MatchingType = 0
+	// MatchingSHA256 matches the SHA-256 hash of the data.
+	MatchingSHA256 MatchingType = 1
+	// MatchingSHA512 matches the SHA-512 hash of the data.
+	MatchingSHA512 MatchingType = 2
+)
+
+// TLSA is a TLSA resource record.
+type TLSA struct {
+	Usage        Usage
+	Selector     Selector
+	MatchingType MatchingType
+	Data         []byte
+}
+
+// String returns the record data in presentation format, e.g. "3 1 1 2bb1...".
+func (r TLSA) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.Usage, r.Selector, r.MatchingType, hex.EncodeToString(r.Data))
+}
+
+// Resolver looks up TLSA records with a DNSSEC validating resolver.
+type Resolver interface {
+	// LookupTLSA returns the TLSA records of name. Authenticated must only be true if the answer
+	// was validated by DNSSEC (e.g. the AD bit of a trusted validating resolver).
+	// If name has no records, no records and no error are returned.
+	LookupTLSA(ctx context.Context, name string) (records []TLSA, authenticated bool, err error)
+}
+
+// Lookup returns the TLSA records of the SMTP server host, e.g. _25._tcp.mx.example.com.
+// It returns nil if there are no records or the answer isn't authenticated.
+func Lookup(ctx context.Context, resolver Resolver, host string, port string) ([]TLSA, error) {
+	records, authenticated, err := resolver.LookupTLSA(ctx, "_"+port+"._tcp."+host)
+	if err != nil {
+		dnsErr := &net.DNSError{}
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !authenticated {
+		return nil, nil
+	}
+	return records, nil
+}
+
+// Usable returns true if at least one record is usable for SMTP (DANE-TA or DANE-EE).
+// If TLSA records exist but none is usable, TLS is still mandatory but the certificate
+// isn't authenticated (RFC 7672 2.2).
+func Usable(records []TLSA) bool {
+	for _, record := range records {
+		if record.Usage == UsageDANETA || record.Usage == UsageDANEEE {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the certificate matches the record, the usage isn't checked.
+func (r TLSA) Match(cert *x509.Certificate) bool {
+	var data []byte
+
+	switch r.Selector {
+	case SelectorCert:
+		data = cert.Raw
+	case SelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch r.MatchingType {
+	case MatchingFull:
+	case MatchingSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case MatchingSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+
+	return bytes.Equal(data, r.Data)
+}
+
+// ErrNoMatch is returned if the certificate chain doesn't match any usable TLSA record.
+var ErrNoMatch = errors.New("dane: certificate doesn't match any TLSA record")
+
+// Verify verifies the certificate chain presented by the server with the records (RFC 7672 3.1):
+//   - DANE-EE records must match the certificate of the server, its names and validity aren't checked,
+//   - DANE-TA records must match a certificate of the chain which issued the certificate of the server,
+//     which must be valid for one of the hostnames (e.g. the MX host).
+//
+// If no record is usable, the chain isn't verified.
+func Verify(records []TLSA, certs []*x509.Certificate, hostnames ...string) error {
+	if !Usable(records) {
+		return nil
+	}
+
+	if len(certs) == 0 {
+		return errors.New("dane: no certificate presented")
+	}
+
+	for _, record := range records {
+		switch record.Usage {
+		case UsageDANEEE:
+			if record.Match(certs[0]) {
+				return nil
+			}
+		case UsageDANETA:
+			for i, cert := range certs {
+				if record.Match(cert) && verifyChain(certs[0], certs[1:max(i, 1)], cert, hostnames) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return ErrNoMatch
+}
+
+// verifyChain returns true if leaf is issued by the trust anchor and valid for one of the hostnames.
+func verifyChain(
+	leaf *x509.Certificate,
+	intermediates []*x509.Certificate,
+	anchor *x509.Certificate,
+	hostnames []string,
+) bool {
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	opts.Roots.AddCert(anchor)
+	for _, cert := range intermediates {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	for _, hostname := range hostnames {
+		opts.DNSName = hostname
+		if _, err := leaf.Verify(opts); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyConnection returns a function for tls.Config.VerifyConnection which verifies the
+// connection with the records. InsecureSkipVerify must be set to skip the PKIX verification.
+func VerifyConnection(records []TLSA, hostnames ...string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		return Verify(records, state.PeerCertificates, hostnames...)
+	}
+}
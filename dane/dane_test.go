@@ -0,0 +1,166 @@
+package dane
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+type fakeResolver struct {
+	records       []TLSA
+	authenticated bool
+	err           error
+	name          string
+}
+
+func (r *fakeResolver) LookupTLSA(_ context.Context, name string) ([]TLSA, bool, error) {
+	r.name = name
+	return r.records, r.authenticated, r.err
+}
+
+// genChain returns a certificate for host issued by a new CA and the CA.
+func genChain(t *testing.T, host string) (*x509.Certificate, *x509.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return leaf, ca
+}
+
+func selfSigned(t *testing.T, host string) *x509.Certificate {
+	cert, err := tester.GenX509KeyPair(host)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf
+}
+
+func TestTLSA_Match(t *testing.T) {
+	cert := selfSigned(t, "mx.example.com")
+
+	spki256 := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	cert512 := sha512.Sum512(cert.Raw)
+
+	require.True(t, TLSA{Selector: SelectorCert, MatchingType: MatchingFull, Data: cert.Raw}.Match(cert))
+	require.True(t, TLSA{Selector: SelectorSPKI, MatchingType: MatchingSHA256, Data: spki256[:]}.Match(cert))
+	require.True(t, TLSA{Selector: SelectorCert, MatchingType: MatchingSHA512, Data: cert512[:]}.Match(cert))
+
+	require.False(t, TLSA{Selector: SelectorCert, MatchingType: MatchingSHA256, Data: spki256[:]}.Match(cert))
+	require.False(t, TLSA{Selector: 2, MatchingType: MatchingFull, Data: cert.Raw}.Match(cert))
+	require.False(t, TLSA{Selector: SelectorCert, MatchingType: 3, Data: cert.Raw}.Match(cert))
+}
+
+func TestTLSA_String(t *testing.T) {
+	record := TLSA{Usage: UsageDANEEE, Selector: SelectorSPKI, MatchingType: MatchingSHA256, Data: []byte{0x2b, 0xb1}}
+	require.Equal(t, "3 1 1 2bb1", record.String())
+}
+
+func TestVerify_DANEEE(t *testing.T) {
+	cert := selfSigned(t, "mx.example.com")
+	other := selfSigned(t, "mx.example.com")
+
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	records := []TLSA{{Usage: UsageDANEEE, Selector: SelectorSPKI, MatchingType: MatchingSHA256, Data: spki[:]}}
+
+	// the name isn't checked
+	require.NoError(t, Verify(records, []*x509.Certificate{cert}, "other.example.com"))
+	require.Error(t, Verify(records, []*x509.Certificate{other}, "mx.example.com"))
+	require.Error(t, Verify(records, nil, "mx.example.com"))
+}
+
+func TestVerify_DANETA(t *testing.T) {
+	leaf, ca := genChain(t, "mx.example.com")
+	otherLeaf, _ := genChain(t, "mx.example.com")
+
+	sum := sha256.Sum256(ca.Raw)
+	records := []TLSA{{Usage: UsageDANETA, Selector: SelectorCert, MatchingType: MatchingSHA256, Data: sum[:]}}
+
+	require.NoError(t, Verify(records, []*x509.Certificate{leaf, ca}, "example.com", "mx.example.com"))
+
+	// the name must match
+	require.Error(t, Verify(records, []*x509.Certificate{leaf, ca}, "other.example.com"))
+	// the trust anchor must be part of the chain
+	require.Error(t, Verify(records, []*x509.Certificate{leaf}, "mx.example.com"))
+	// the trust anchor must have issued the certificate
+	require.Error(t, Verify(records, []*x509.Certificate{otherLeaf, ca}, "mx.example.com"))
+
+	// a self signed certificate can be its own trust anchor
+	cert := selfSigned(t, "mx.example.com")
+	sum = sha256.Sum256(cert.Raw)
+	records = []TLSA{{Usage: UsageDANETA, Selector: SelectorCert, MatchingType: MatchingSHA256, Data: sum[:]}}
+	require.NoError(t, Verify(records, []*x509.Certificate{cert}, "mx.example.com"))
+}
+
+func TestVerify_Unusable(t *testing.T) {
+	records := []TLSA{{Usage: UsagePKIXEE, Selector: SelectorCert, MatchingType: MatchingFull, Data: []byte{1}}}
+
+	require.False(t, Usable(records))
+	require.NoError(t, Verify(records, nil, "mx.example.com"))
+}
+
+func TestLookup(t *testing.T) {
+	records := []TLSA{{Usage: UsageDANEEE, Selector: SelectorSPKI, MatchingType: MatchingSHA256, Data: []byte{1}}}
+
+	r := &fakeResolver{records: records, authenticated: true}
+	res, err := Lookup(context.Background(), r, "mx.example.com", "25")
+	require.NoError(t, err)
+	require.Equal(t, records, res)
+	require.Equal(t, "_25._tcp.mx.example.com", r.name)
+
+	// records without DNSSEC are ignored
+	r = &fakeResolver{records: records}
+	res, err = Lookup(context.Background(), r, "mx.example.com", "25")
+	require.NoError(t, err)
+	require.Nil(t, res)
+
+	r = &fakeResolver{err: &net.DNSError{IsNotFound: true}}
+	res, err = Lookup(context.Background(), r, "mx.example.com", "25")
+	require.NoError(t, err)
+	require.Nil(t, res)
+
+	r = &fakeResolver{err: errors.New("servfail")}
+	_, err = Lookup(context.Background(), r, "mx.example.com", "25")
+	require.Error(t, err)
+}
@@ -0,0 +1,398 @@
+// Package dsn builds delivery status notifications (RFC 3464), e.g. bounces.
+//
+// A notification is a multipart/report message with a human readable explanation,
+// the machine readable delivery status of every recipient and the returned message
+// or its header, as requested by the RET parameter of the original message:
+//
+//	report := dsn.Report{
+//		ReportingMTA: "mx.example.com",
+//		To:           from,
+//		Return:       mailOptions.Return,
+//		EnvelopeID:   mailOptions.EnvelopeID,
+//		Recipients: []dsn.Recipient{{
+//			FinalRecipient: "user@example.org",
+//			Action:         dsn.ActionFailed,
+//			Status:         status,
+//		}},
+//		Message: original,
+//	}
+//	r, err := report.Reader()
+//
+// Reports containing UTF-8 addresses or returning a message sent with SMTPUTF8 use the
+// UTF-8 media types of RFC 6533 (message/global-delivery-status).
+package dsn
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// Report is a delivery status notification.
+type Report struct {
+	// ReportingMTA is the name of the server which creates the report, required.
+	ReportingMTA string
+
+	// From address of the report, MAILER-DAEMON@ReportingMTA if empty.
+	From string
+
+	// To is the envelope sender of the original message, the report is sent to it with a null sender.
+	To string
+
+	// Subject of the report, derived from the actions if empty.
+	Subject string
+
+	// Text is the human readable explanation, generated from the recipients if empty.
+	Text string
+
+	// EnvelopeID is the ENVID parameter of the original message (MailOptions), if any.
+	EnvelopeID string
+
+	// ArrivalDate is the time the original message was received, if known.
+	ArrivalDate time.Time
+
+	// Return is the RET parameter of the original message (MailOptions).
+	// The complete message is only returned for FULL, otherwise just its header.
+	Return smtp.DSNReturn
+
+	// UTF8 is set if the original message was sent with SMTPUTF8.
+	UTF8 bool
+
+	// Recipients are the delivery status of the reported recipients, at least one is required.
+	Recipients []Recipient
+
+	// Message is the original message, nothing is returned if nil.
+	// If only the header is returned, it is limited to 64 KiB.
+	Message io.Reader
+}
+
+// Wanted returns true if a notification about action is requested by the NOTIFY parameter of the
+// recipient (RFC 3461 4.1). Without NOTIFY failures and delays are reported.
+func Wanted(opts *smtp.RcptOptions, action Action) bool {
+	var notify []smtp.DSNNotify
+	if opts != nil {
+		notify = opts.Notify
+	}
+
+	if len(notify) == 0 {
+		return action == ActionFailed || action == ActionDelayed
+	}
+
+	switch action {
+	case ActionFailed:
+		return slices.Contains(notify, smtp.DSNNotifyFailure)
+	case ActionDelayed:
+		return slices.Contains(notify, smtp.DSNNotifyDelayed)
+	case ActionDelivered, ActionRelayed, ActionExpanded:
+		return slices.Contains(notify, smtp.DSNNotifySuccess)
+	default:
+		return false
+	}
+}
+
+// Reader returns the report as message which can be sent with the mailer.
+func (r *Report) Reader() (io.Reader, error) {
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// WriteTo writes the report as message to w.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	if err := r.valid(); err != nil {
+		return 0, err
+	}
+
+	global := r.global()
+	boundary := newBoundary()
+
+	var b bytes.Buffer
+	r.header(&b, boundary)
+
+	// human readable explanation
+	text := r.text()
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	field(&b, "Content-Type", "text/plain; charset=utf-8")
+	field(&b, "Content-Transfer-Encoding", encoding(text))
+	b.WriteString("\r\n")
+	b.WriteString(text)
+
+	// delivery status
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	if global {
+		field(&b, "Content-Type", "message/global-delivery-status")
+	} else {
+		field(&b, "Content-Type", "message/delivery-status")
+	}
+	b.WriteString("\r\n")
+	r.status(&b)
+
+	n, err := b.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+
+	// returned message
+	if r.Message != nil {
+		m, err := r.writeMessage(w, boundary, global)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	m, err := fmt.Fprintf(w, "\r\n--%s--\r\n", boundary)
+	return n + int64(m), err
+}
+
+// valid returns an error if the report is incomplete or contains line breaks in fields.
+func (r *Report) valid() error {
+	if r.ReportingMTA == "" {
+		return errors.New("dsn: missing reporting mta")
+	}
+	if len(r.Recipients) == 0 {
+		return errors.New("dsn: missing recipients")
+	}
+	if strings.ContainsAny(r.ReportingMTA+r.From+r.To+r.Subject+r.EnvelopeID, "\r\n") {
+		return errors.New("dsn: field contains a line break")
+	}
+	for i := range r.Recipients {
+		if err := r.Recipients[i].valid(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// global returns true if the UTF-8 report of RFC 6533 is required.
+func (r *Report) global() bool {
+	if r.UTF8 || !ascii(r.EnvelopeID) {
+		return true
+	}
+	return slices.ContainsFunc(r.Recipients, func(rcpt Recipient) bool {
+		return rcpt.utf8()
+	})
+}
+
+// header writes the header of the report message.
+func (r *Report) header(b *bytes.Buffer, boundary string) {
+	from := r.From
+	if from == "" {
+		from = "MAILER-DAEMON@" + r.ReportingMTA
+	}
+
+	field(b, "From", "Mail Delivery System <"+from+">")
+	if r.To != "" {
+		field(b, "To", "<"+r.To+">")
+	}
+	field(b, "Subject", mime.QEncoding.Encode("utf-8", r.subject()))
+	field(b, "Date", time.Now().Format(time.RFC1123Z))
+	field(b, "Message-ID", "<"+hex.EncodeToString(random(16))+"@"+r.ReportingMTA+">")
+	field(b, "Auto-Submitted", "auto-replied")
+	field(b, "MIME-Version", "1.0")
+	field(b, "Content-Type", mime.FormatMediaType("multipart/report", map[string]string{
+		"report-type": "delivery-status",
+		"boundary":    boundary,
+	}))
+	b.WriteString("\r\n")
+	b.WriteString("This is a MIME-encapsulated message.\r\n\r\n")
+}
+
+// subject returns the subject of the report.
+func (r *Report) subject() string {
+	if r.Subject != "" {
+		return r.Subject
+	}
+	switch {
+	case r.has(ActionFailed):
+		return "Undelivered Mail Returned to Sender"
+	case r.has(ActionDelayed):
+		return "Delayed Mail (still being retried)"
+	default:
+		return "Successful Mail Delivery Report"
+	}
+}
+
+// has returns true if a recipient has the given action.
+func (r *Report) has(action Action) bool {
+	return slices.ContainsFunc(r.Recipients, func(rcpt Recipient) bool {
+		return rcpt.Action == action
+	})
+}
+
+// text returns the human readable explanation.
+func (r *Report) text() string {
+	if r.Text != "" {
+		return strings.ReplaceAll(strings.ReplaceAll(r.Text, "\r\n", "\n"), "\n", "\r\n")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "This is the mail system at host %s.\r\n", r.ReportingMTA)
+
+	sections := []struct {
+		actions []Action
+		text    string
+	}{
+		{[]Action{ActionFailed}, "Your message couldn't be delivered to the following recipients:"},
+		{[]Action{ActionDelayed}, "The delivery to the following recipients is delayed, it is still retried:"},
+		{[]Action{ActionDelivered, ActionRelayed, ActionExpanded}, "Your message was delivered to the following recipients:"},
+	}
+
+	for _, section := range sections {
+		first := true
+		for i := range r.Recipients {
+			rcpt := &r.Recipients[i]
+			if !slices.Contains(section.actions, rcpt.Action) {
+				continue
+			}
+			if first {
+				fmt.Fprintf(&b, "\r\n%s\r\n\r\n", section.text)
+				first = false
+			}
+			fmt.Fprintf(&b, "<%s>", rcpt.FinalRecipient)
+			if diagnostic := rcpt.diagnostic(); diagnostic != "" {
+				fmt.Fprintf(&b, ": %s", diagnostic)
+			} else if rcpt.Status != nil && rcpt.Status.Message != "" {
+				fmt.Fprintf(&b, ": %s", strings.Join(strings.Fields(rcpt.Status.Message), " "))
+			}
+			b.WriteString("\r\n")
+		}
+	}
+
+	return b.String()
+}
+
+// status writes the per-message and the per-recipient fields.
+func (r *Report) status(b *bytes.Buffer) {
+	field(b, "Reporting-MTA", "dns; "+r.ReportingMTA)
+	if r.EnvelopeID != "" {
+		field(b, "Original-Envelope-Id", r.EnvelopeID)
+	}
+	if !r.ArrivalDate.IsZero() {
+		field(b, "Arrival-Date", r.ArrivalDate.Format(time.RFC1123Z))
+	}
+
+	for i := range r.Recipients {
+		b.WriteString("\r\n")
+		r.Recipients[i].fields(b)
+	}
+}
+
+// writeMessage writes the part with the returned message or its header.
+func (r *Report) writeMessage(w io.Writer, boundary string, global bool) (int64, error) {
+	full := r.Return == smtp.DSNReturnFull
+
+	var contentType string
+	switch {
+	case full && global:
+		contentType = "message/global"
+	case full:
+		contentType = "message/rfc822"
+	case global:
+		contentType = "message/global-headers"
+	default:
+		contentType = "text/rfc822-headers"
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	field(&b, "Content-Type", contentType)
+	if full {
+		field(&b, "Content-Transfer-Encoding", "8bit")
+	}
+	b.WriteString("\r\n")
+
+	n, err := b.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+
+	if full {
+		m, err := io.Copy(w, r.Message)
+		return n + m, err
+	}
+
+	m, err := copyHeader(w, r.Message)
+	return n + m, err
+}
+
+// maxHeaderBytes limits the returned header of the original message.
+const maxHeaderBytes = 64 * 1024
+
+// copyHeader copies the header of the message read from r to w, the header is cut
+// after the last complete line within maxHeaderBytes.
+func copyHeader(w io.Writer, r io.Reader) (int64, error) {
+	var b bytes.Buffer
+	lr := &io.LimitedReader{R: r, N: maxHeaderBytes}
+	br := bufio.NewReader(lr)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return 0, err
+			}
+			if lr.N > 0 {
+				// the message ends without a body
+				b.Write(line)
+				b.WriteString("\r\n")
+			}
+			break
+		}
+		b.Write(line)
+	}
+	return b.WriteTo(w)
+}
+
+// field writes a header or status field.
+func field(b *bytes.Buffer, key string, value string) {
+	b.WriteString(key)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteString("\r\n")
+}
+
+// encoding returns the content transfer encoding of text.
+func encoding(text string) string {
+	if ascii(text) {
+		return "7bit"
+	}
+	return "8bit"
+}
+
+// ascii returns true if s contains only ASCII characters.
+func ascii(s string) bool {
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// random returns n random bytes.
+func random(n int) []byte {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return buf
+}
+
+// newBoundary returns a random multipart boundary.
+func newBoundary() string {
+	return "=_" + hex.EncodeToString(random(16))
+}
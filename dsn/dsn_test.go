@@ -0,0 +1,209 @@
+package dsn_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/dsn"
+)
+
+const original = "From: sender@example.org\r\nSubject: Hello\r\n\r\nBody\r\n"
+
+// part is a parsed part of a report.
+type part struct {
+	contentType string
+	body        string
+}
+
+// parse parses the report and returns its header and parts.
+func parse(t *testing.T, report dsn.Report) (mail.Header, []part) {
+	r, err := report.Reader()
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(r)
+	require.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/report", mediaType)
+	require.Equal(t, "delivery-status", params["report-type"])
+
+	parts := []part{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(p)
+		require.NoError(t, err)
+		parts = append(parts, part{contentType: p.Header.Get("Content-Type"), body: string(body)})
+	}
+
+	return msg.Header, parts
+}
+
+func TestReport(t *testing.T) {
+	arrival := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	header, parts := parse(t, dsn.Report{
+		ReportingMTA: "mx.example.com",
+		To:           "sender@example.org",
+		EnvelopeID:   "QQ314159",
+		ArrivalDate:  arrival,
+		Return:       smtp.DSNReturnHeaders,
+		Recipients: []dsn.Recipient{
+			{
+				FinalRecipient:        "unknown@example.com",
+				OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+				OriginalRecipient:     "alias@example.com",
+				Action:                dsn.ActionFailed,
+				Status:                smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "No such\nuser"),
+				RemoteMTA:             "mx.example.net",
+			},
+			{
+				FinalRecipient: "later@example.com",
+				Action:         dsn.ActionDelayed,
+				WillRetryUntil: arrival.Add(24 * time.Hour),
+			},
+		},
+		Message: strings.NewReader(original),
+	})
+
+	require.Equal(t, "Mail Delivery System <MAILER-DAEMON@mx.example.com>", header.Get("From"))
+	require.Equal(t, "<sender@example.org>", header.Get("To"))
+	require.Equal(t, "Undelivered Mail Returned to Sender", header.Get("Subject"))
+	require.Equal(t, "auto-replied", header.Get("Auto-Submitted"))
+
+	require.Len(t, parts, 3)
+
+	require.Equal(t, "text/plain; charset=utf-8", parts[0].contentType)
+	require.Contains(t, parts[0].body, "<unknown@example.com>: 550 5.1.1 No such user\r\n")
+	require.Contains(t, parts[0].body, "is delayed")
+	require.Contains(t, parts[0].body, "<later@example.com>\r\n")
+
+	require.Equal(t, "message/delivery-status", parts[1].contentType)
+	require.Equal(t, "Reporting-MTA: dns; mx.example.com\r\n"+
+		"Original-Envelope-Id: QQ314159\r\n"+
+		"Arrival-Date: Thu, 02 Jan 2025 03:04:05 +0000\r\n"+
+		"\r\n"+
+		"Original-Recipient: rfc822; alias@example.com\r\n"+
+		"Final-Recipient: rfc822; unknown@example.com\r\n"+
+		"Action: failed\r\n"+
+		"Status: 5.1.1\r\n"+
+		"Remote-MTA: dns; mx.example.net\r\n"+
+		"Diagnostic-Code: smtp; 550 5.1.1 No such user\r\n"+
+		"\r\n"+
+		"Final-Recipient: rfc822; later@example.com\r\n"+
+		"Action: delayed\r\n"+
+		"Status: 4.0.0\r\n"+
+		"Will-Retry-Until: Fri, 03 Jan 2025 03:04:05 +0000\r\n", parts[1].body)
+
+	// only the header is returned
+	require.Equal(t, "text/rfc822-headers", parts[2].contentType)
+	require.Equal(t, "From: sender@example.org\r\nSubject: Hello\r\n", parts[2].body)
+}
+
+func TestReport_Full(t *testing.T) {
+	header, parts := parse(t, dsn.Report{
+		ReportingMTA: "mx.example.com",
+		Return:       smtp.DSNReturnFull,
+		Recipients: []dsn.Recipient{{
+			FinalRecipient: "a@example.com",
+			Action:         dsn.ActionDelivered,
+			Status:         smtp.NewStatus(250, smtp.NoEnhancedCode, "OK"),
+		}},
+		Message: strings.NewReader(original),
+	})
+
+	require.Equal(t, "Successful Mail Delivery Report", header.Get("Subject"))
+	require.Empty(t, header.Get("To"))
+
+	require.Len(t, parts, 3)
+	require.Contains(t, parts[1].body, "Status: 2.0.0\r\nDiagnostic-Code: smtp; 250 OK\r\n")
+	require.Equal(t, "message/rfc822", parts[2].contentType)
+	require.Equal(t, original, parts[2].body)
+}
+
+func TestReport_LongHeader(t *testing.T) {
+	// a message without a blank line is returned up to the limit
+	line := "X-Long: " + strings.Repeat("a", 990) + "\r\n"
+	_, parts := parse(t, dsn.Report{
+		ReportingMTA: "mx.example.com",
+		Recipients: []dsn.Recipient{{
+			FinalRecipient: "a@example.com",
+			Action:         dsn.ActionFailed,
+			Status:         smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "No such user"),
+		}},
+		Message: strings.NewReader(strings.Repeat(line, 100)),
+	})
+
+	require.Len(t, parts, 3)
+	require.Equal(t, strings.Repeat(line, 65), parts[2].body)
+}
+
+func TestReport_UTF8(t *testing.T) {
+	_, parts := parse(t, dsn.Report{
+		ReportingMTA: "mx.example.com",
+		Recipients: []dsn.Recipient{{
+			FinalRecipient: "用户@example.com",
+			Action:         dsn.ActionFailed,
+		}},
+		Message: strings.NewReader(original),
+	})
+
+	require.Len(t, parts, 3)
+	require.Contains(t, parts[0].body, "<用户@example.com>\r\n")
+	require.Equal(t, "message/global-delivery-status", parts[1].contentType)
+	require.Contains(t, parts[1].body, "Final-Recipient: utf-8; 用户@example.com\r\nAction: failed\r\nStatus: 5.0.0\r\n")
+	require.Equal(t, "message/global-headers", parts[2].contentType)
+
+	// a message sent with SMTPUTF8 is returned as message/global
+	_, parts = parse(t, dsn.Report{
+		ReportingMTA: "mx.example.com",
+		Return:       smtp.DSNReturnFull,
+		UTF8:         true,
+		Recipients:   []dsn.Recipient{{FinalRecipient: "a@example.com", Action: dsn.ActionFailed}},
+		Message:      strings.NewReader(original),
+	})
+
+	require.Len(t, parts, 3)
+	require.Equal(t, "message/global-delivery-status", parts[1].contentType)
+	require.Equal(t, "message/global", parts[2].contentType)
+}
+
+func TestReport_Invalid(t *testing.T) {
+	rcpts := []dsn.Recipient{{FinalRecipient: "a@example.com", Action: dsn.ActionFailed}}
+
+	for _, report := range []dsn.Report{
+		{Recipients: rcpts},
+		{ReportingMTA: "mx.example.com"},
+		{ReportingMTA: "mx.example.com", To: "a@example.com\r\nBcc: b@example.com", Recipients: rcpts},
+		{ReportingMTA: "mx.example.com", Recipients: []dsn.Recipient{{FinalRecipient: "a@example.com"}}},
+	} {
+		_, err := report.Reader()
+		require.Error(t, err)
+	}
+}
+
+func TestWanted(t *testing.T) {
+	require.True(t, dsn.Wanted(nil, dsn.ActionFailed))
+	require.True(t, dsn.Wanted(&smtp.RcptOptions{}, dsn.ActionDelayed))
+	require.False(t, dsn.Wanted(nil, dsn.ActionDelivered))
+
+	never := &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifyNever}}
+	require.False(t, dsn.Wanted(never, dsn.ActionFailed))
+
+	success := &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifySuccess, smtp.DSNNotifyFailure}}
+	require.True(t, dsn.Wanted(success, dsn.ActionDelivered))
+	require.True(t, dsn.Wanted(success, dsn.ActionFailed))
+	require.False(t, dsn.Wanted(success, dsn.ActionDelayed))
+}
@@ -0,0 +1,139 @@
+package dsn
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// Action is the action performed for a recipient (RFC 3464 2.3.3).
+type Action string
+
+const (
+	// ActionFailed means the message couldn't be delivered.
+	ActionFailed Action = "failed"
+	// ActionDelayed means the delivery is delayed, but it is still retried.
+	ActionDelayed Action = "delayed"
+	// ActionDelivered means the message was delivered to the recipient.
+	ActionDelivered Action = "delivered"
+	// ActionRelayed means the message was relayed to a server which doesn't support DSN.
+	ActionRelayed Action = "relayed"
+	// ActionExpanded means the message was delivered and forwarded to multiple recipients.
+	ActionExpanded Action = "expanded"
+)
+
+// Recipient contains the delivery status of a single recipient.
+type Recipient struct {
+	// FinalRecipient is the address of the recipient (RCPT TO), required.
+	FinalRecipient string
+
+	// OriginalRecipient and its type from the ORCPT parameter (RcptOptions), if any.
+	OriginalRecipientType smtp.DSNAddressType
+	OriginalRecipient     string
+
+	// Action performed for the recipient, required.
+	Action Action
+
+	// Status of the delivery. The code and the message are returned as Diagnostic-Code,
+	// the enhanced code as Status. It is derived from the action if nil.
+	Status *smtp.Status
+
+	// RemoteMTA is the name of the server which returned the status, if any.
+	RemoteMTA string
+
+	// LastAttempt is the time of the last delivery attempt, if known.
+	LastAttempt time.Time
+
+	// WillRetryUntil is the time until a delayed delivery is retried, if known.
+	WillRetryUntil time.Time
+}
+
+// code returns the enhanced status code of the recipient.
+func (r *Recipient) code() string {
+	code := smtp.EnhancedCodeNotSet
+	if r.Status != nil {
+		code = r.Status.EnhancedCode
+	}
+
+	if code == smtp.EnhancedCodeNotSet || code == smtp.NoEnhancedCode {
+		class := 5
+		switch {
+		case r.Status != nil && r.Status.Code >= 200 && r.Status.Code < 600:
+			class = r.Status.Code / 100
+		case r.Action == ActionDelayed:
+			class = 4
+		case r.Action == ActionDelivered || r.Action == ActionRelayed || r.Action == ActionExpanded:
+			class = 2
+		}
+		code = smtp.EnhancedCode{class, 0, 0}
+	}
+
+	return fmt.Sprintf("%d.%d.%d", code[0], code[1], code[2])
+}
+
+// diagnostic returns the reply of the remote server in a single line, empty if there was none.
+func (r *Recipient) diagnostic() string {
+	if r.Status == nil || r.Status.Code == 0 {
+		return ""
+	}
+
+	text := strings.Join(strings.Fields(r.Status.Message), " ")
+	code := r.Status.EnhancedCode
+	if code != smtp.EnhancedCodeNotSet && code != smtp.NoEnhancedCode {
+		text = fmt.Sprintf("%d.%d.%d %s", code[0], code[1], code[2], text)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%d %s", r.Status.Code, text))
+}
+
+// fields writes the per-recipient fields (RFC 3464 2.3).
+func (r *Recipient) fields(b *bytes.Buffer) {
+	if r.OriginalRecipient != "" {
+		field(b, "Original-Recipient", addressType(r.OriginalRecipientType, r.OriginalRecipient)+"; "+r.OriginalRecipient)
+	}
+	field(b, "Final-Recipient", addressType("", r.FinalRecipient)+"; "+r.FinalRecipient)
+	field(b, "Action", string(r.Action))
+	field(b, "Status", r.code())
+	if r.RemoteMTA != "" {
+		field(b, "Remote-MTA", "dns; "+r.RemoteMTA)
+	}
+	if diagnostic := r.diagnostic(); diagnostic != "" {
+		field(b, "Diagnostic-Code", "smtp; "+diagnostic)
+	}
+	if !r.LastAttempt.IsZero() {
+		field(b, "Last-Attempt-Date", r.LastAttempt.Format(time.RFC1123Z))
+	}
+	if !r.WillRetryUntil.IsZero() {
+		field(b, "Will-Retry-Until", r.WillRetryUntil.Format(time.RFC1123Z))
+	}
+}
+
+// valid returns an error if a required field is missing or a field contains a line break.
+func (r *Recipient) valid() error {
+	if r.FinalRecipient == "" || r.Action == "" {
+		return fmt.Errorf("dsn: recipient without final recipient or action")
+	}
+	if strings.ContainsAny(r.FinalRecipient+r.OriginalRecipient+r.RemoteMTA+string(r.Action), "\r\n") {
+		return fmt.Errorf("dsn: recipient %q contains a line break", r.FinalRecipient)
+	}
+	return nil
+}
+
+// utf8 returns true if a field of the recipient requires the UTF-8 report (RFC 6533).
+func (r *Recipient) utf8() bool {
+	return r.OriginalRecipientType == smtp.DSNAddressTypeUTF8 ||
+		!ascii(r.FinalRecipient) || !ascii(r.OriginalRecipient) || !ascii(r.diagnostic())
+}
+
+// addressType returns the address type of an address, utf-8 if it contains non-ASCII characters.
+func addressType(typ smtp.DSNAddressType, address string) string {
+	if typ == smtp.DSNAddressTypeUTF8 || !ascii(address) {
+		return "utf-8"
+	}
+	if typ != "" {
+		return strings.ToLower(string(typ))
+	}
+	return "rfc822"
+}
@@ -1,7 +1,10 @@
 package smtp
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // EnhancedCode is the SMTP enhanced code
@@ -37,6 +40,47 @@ func NewStatus(code int, enhCode EnhancedCode, msg string) *Status {
 	}
 }
 
+// ParseStatus creates a status from a server reply.
+// If msg starts with an enhanced code (RFC 2034), it is parsed and removed from every line.
+func ParseStatus(code int, msg string) *Status {
+	status := &Status{
+		Code:    code,
+		Message: msg,
+	}
+
+	parts := strings.SplitN(msg, " ", 2)
+	if len(parts) != 2 {
+		return status
+	}
+
+	enchCode, err := parseEnhancedCode(parts[0])
+	if err != nil {
+		return status
+	}
+
+	// Per RFC 2034, enhanced code should be prepended to each line.
+	status.EnhancedCode = enchCode
+	status.Message = strings.ReplaceAll(parts[1], "\n"+parts[0]+" ", "\n")
+	return status
+}
+
+func parseEnhancedCode(s string) (EnhancedCode, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return EnhancedCode{}, errors.New("wrong amount of enhanced code parts")
+	}
+
+	code := EnhancedCode{}
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return code, err
+		}
+		code[i] = num
+	}
+	return code, nil
+}
+
 // Error returns a error string.
 func (err *Status) Error() string {
 	s := fmt.Sprintf("SMTP error %03d", err.Code)
@@ -100,6 +144,18 @@ var (
 		EnhancedCode: EnhancedCode{5, 3, 4},
 		Message:      "Maximum message size exceeded",
 	}
+	// ErrBareLF is returned if a line ends with a bare LF and bare line endings are rejected.
+	ErrBareLF = &Status{
+		Code:         550,
+		EnhancedCode: EnhancedCode{5, 5, 2},
+		Message:      "bare LF not allowed",
+	}
+	// ErrBareCR is returned if a line contains a bare CR and bare line endings are rejected.
+	ErrBareCR = &Status{
+		Code:         550,
+		EnhancedCode: EnhancedCode{5, 5, 2},
+		Message:      "bare CR not allowed",
+	}
 	// ErrAuthFailed is returned if the authentication failed.
 	ErrAuthFailed = &Status{
 		Code:         535,
@@ -0,0 +1,24 @@
+package smtp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatus(t *testing.T) {
+	status := ParseStatus(250, "2.1.5 OK")
+	require.Equal(t, &Status{Code: 250, EnhancedCode: EnhancedCode{2, 1, 5}, Message: "OK"}, status)
+	require.True(t, status.Positive())
+
+	status = ParseStatus(550, "5.1.1 first line\n5.1.1 second line")
+	require.Equal(t, EnhancedCode{5, 1, 1}, status.EnhancedCode)
+	require.Equal(t, "first line\nsecond line", status.Message)
+
+	status = ParseStatus(250, "OK queued")
+	require.Equal(t, EnhancedCode{}, status.EnhancedCode)
+	require.Equal(t, "OK queued", status.Message)
+
+	status = ParseStatus(250, "OK")
+	require.Equal(t, "OK", status.Message)
+}
@@ -0,0 +1,176 @@
+// Package idna converts internationalized domain names to their ASCII form (RFC 5891).
+//
+// Only the conversion itself is implemented, labels are lower cased but neither normalized
+// nor validated against the full IDNA2008 rules.
+package idna
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Punycode parameters (RFC 3492 5).
+const (
+	base        int32 = 36
+	damp        int32 = 700
+	initialBias int32 = 72
+	initialN    int32 = 128
+	skew        int32 = 38
+	tMax        int32 = 26
+	tMin        int32 = 1
+)
+
+// acePrefix is the prefix of A-labels.
+const acePrefix = "xn--"
+
+// maxLabelLength is the maximum length of a label (RFC 1035 2.3.4).
+const maxLabelLength = 63
+
+var errOverflow = errors.New("idna: overflow")
+
+// IsASCII returns true if s contains only ASCII characters.
+func IsASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ToASCII converts all non-ASCII labels of domain to A-labels, e.g. bücher.example to xn--bcher-kva.example.
+// Ideographic full stops are treated as dots (RFC 3490 3.1).
+func ToASCII(domain string) (string, error) {
+	if IsASCII(domain) {
+		return domain, nil
+	}
+
+	if !utf8.ValidString(domain) {
+		return "", fmt.Errorf("idna: invalid utf-8 in domain %q", domain)
+	}
+
+	domain = strings.Map(func(r rune) rune {
+		switch r {
+		case '。', '．', '｡':
+			return '.'
+		default:
+			return r
+		}
+	}, domain)
+
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if IsASCII(label) {
+			continue
+		}
+
+		encoded, err := encode(acePrefix, strings.ToLower(label))
+		if err != nil {
+			return "", err
+		}
+		if len(encoded) > maxLabelLength {
+			return "", fmt.Errorf("idna: label %q is too long", label)
+		}
+		labels[i] = encoded
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// encode encodes s with punycode (RFC 3492 6.3) and prepends prefix.
+func encode(prefix string, s string) (string, error) {
+	output := make([]byte, len(prefix), len(prefix)+1+2*len(s))
+	copy(output, prefix)
+
+	delta, n, bias := int32(0), initialN, initialBias
+	b, remaining := int32(0), int32(0)
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b++
+			output = append(output, byte(r))
+		} else {
+			remaining++
+		}
+	}
+
+	h := b
+	if b > 0 {
+		output = append(output, '-')
+	}
+
+	for remaining != 0 {
+		m := int32(0x7fffffff)
+		for _, r := range s {
+			if m > r && r >= n {
+				m = r
+			}
+		}
+
+		delta += (m - n) * (h + 1)
+		if delta < 0 {
+			return "", errOverflow
+		}
+		n = m
+
+		for _, r := range s {
+			if r < n {
+				delta++
+				if delta < 0 {
+					return "", errOverflow
+				}
+				continue
+			}
+			if r > n {
+				continue
+			}
+
+			q := delta
+			for k := base; ; k += base {
+				t := min(max(k-bias, tMin), tMax)
+				if q < t {
+					break
+				}
+				output = append(output, encodeDigit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			output = append(output, encodeDigit(q))
+
+			bias = adapt(delta, h+1, h == b)
+			delta = 0
+			h++
+			remaining--
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output), nil
+}
+
+// encodeDigit returns the character of a punycode digit (0 - 35).
+func encodeDigit(digit int32) byte {
+	if digit < 26 {
+		return byte('a' + digit)
+	}
+	return byte('0' + digit - 26)
+}
+
+// adapt is the bias adaptation function (RFC 3492 6.1).
+func adapt(delta int32, numPoints int32, firstTime bool) int32 {
+	if firstTime {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := int32(0)
+	for delta > ((base-tMin)*tMax)/2 {
+		delta /= base - tMin
+		k += base
+	}
+	return k + (base-tMin+1)*delta/(delta+skew)
+}
@@ -0,0 +1,60 @@
+package idna
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToASCII(t *testing.T) {
+	testCases := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", "example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"München.DE", "xn--mnchen-3ya.DE"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"mail.例え。テスト", "mail.xn--r8jz45g.xn--zckzah"},
+		{"пример.рф", "xn--e1afmkfd.xn--p1ai"},
+		{"☃.net", "xn--n3h.net"},
+		{"[127.0.0.1]", "[127.0.0.1]"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			res, err := ToASCII(tc.domain)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, res)
+		})
+	}
+}
+
+func TestToASCII_Errors(t *testing.T) {
+	_, err := ToASCII("ü" + strings.Repeat("a", 63) + ".com")
+	require.ErrorContains(t, err, "too long")
+
+	_, err = ToASCII("\xffü.com")
+	require.ErrorContains(t, err, "invalid utf-8")
+}
+
+func TestEncode(t *testing.T) {
+	// samples of RFC 3492 7.1
+	testCases := []struct {
+		input string
+		want  string
+	}{
+		{"ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+		{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+		{"3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+		{"MajiでKoiする5秒前", "MajiKoi5-783gue6qz075azm5e"},
+	}
+
+	for _, tc := range testCases {
+		res, err := encode("", tc.input)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, res)
+	}
+}
@@ -0,0 +1,72 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// MailOptions decodes already validated ESMTP arguments of MAIL FROM, e.g. sent by a milter.
+// Unknown or malformed arguments are ignored.
+func MailOptions(args []string) *smtp.MailOptions {
+	opts := &smtp.MailOptions{}
+
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		switch strings.ToUpper(key) {
+		case "SIZE":
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+				opts.Size = size
+			}
+		case "BODY":
+			opts.Body = smtp.BodyType(strings.ToUpper(value))
+		case "SMTPUTF8":
+			opts.UTF8 = true
+		case "REQUIRETLS":
+			opts.RequireTLS = true
+		case "RET":
+			opts.Return = smtp.DSNReturn(strings.ToUpper(value))
+		case "ENVID":
+			if envid, err := Xtext(value); err == nil {
+				opts.EnvelopeID = envid
+			}
+		case "AUTH":
+			if auth, err := Xtext(value); err == nil {
+				if len(auth) >= 2 && auth[0] == '<' && auth[len(auth)-1] == '>' {
+					auth = auth[1 : len(auth)-1]
+				}
+				opts.Auth = &auth
+			}
+		case "XOORG":
+			if xoorg, err := Xtext(value); err == nil {
+				opts.XOORG = &xoorg
+			}
+		}
+	}
+
+	return opts
+}
+
+// RcptOptions decodes already validated ESMTP arguments of RCPT TO, e.g. sent by a milter.
+// Unknown or malformed arguments are ignored.
+func RcptOptions(args []string) *smtp.RcptOptions {
+	opts := &smtp.RcptOptions{}
+
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		switch strings.ToUpper(key) {
+		case "NOTIFY":
+			for v := range strings.SplitSeq(value, ",") {
+				opts.Notify = append(opts.Notify, smtp.DSNNotify(strings.ToUpper(v)))
+			}
+		case "ORCPT":
+			if aType, aAddr, err := TypedAddress(value); err == nil {
+				opts.OriginalRecipientType = aType
+				opts.OriginalRecipient = aAddr
+			}
+		}
+	}
+
+	return opts
+}
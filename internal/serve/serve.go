@@ -0,0 +1,163 @@
+// Package serve implements the lifecycle shared by the servers: accepting connections,
+// tracking listeners and connections, closing and shutting down.
+package serve
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Lifecycle tracks the listeners and connections of a server. It is safe for concurrent use.
+type Lifecycle struct {
+	// errClosed is returned if the server is already closed.
+	errClosed error
+
+	wg   sync.WaitGroup
+	done chan struct{}
+
+	locker    sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+}
+
+// New creates a new lifecycle, errClosed is returned by Close and Shutdown if the server is already closed.
+func New(errClosed error) *Lifecycle {
+	return &Lifecycle{
+		errClosed: errClosed,
+		done:      make(chan struct{}, 1),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts incoming connections on the Listener l and calls handle for each in a new goroutine.
+// Temporary accept errors are retried, Serve returns nil after Close or Shutdown.
+func (lc *Lifecycle) Serve(
+	ctx context.Context,
+	logger *slog.Logger,
+	l net.Listener,
+	handle func(ctx context.Context, conn net.Conn),
+) error {
+	lc.locker.Lock()
+	lc.listeners = append(lc.listeners, l)
+	lc.locker.Unlock()
+
+	var tempDelay time.Duration // how long to sleep on accept failure
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			select {
+			case <-lc.done:
+				// we called Close()
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if maxDelay := 1 * time.Second; tempDelay > maxDelay {
+					tempDelay = maxDelay
+				}
+				logger.ErrorContext(
+					ctx,
+					"accept error, retrying",
+					slog.Any("err", err),
+					slog.Any("temp_delay", tempDelay),
+				)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+
+		lc.wg.Add(1)
+		go lc.handle(ctx, c, handle)
+	}
+}
+
+// handle tracks the connection while it is handled.
+func (lc *Lifecycle) handle(ctx context.Context, conn net.Conn, handle func(ctx context.Context, conn net.Conn)) {
+	lc.locker.Lock()
+	lc.conns[conn] = struct{}{}
+	lc.locker.Unlock()
+
+	defer func() {
+		lc.locker.Lock()
+		delete(lc.conns, conn)
+		lc.locker.Unlock()
+
+		lc.wg.Done()
+	}()
+
+	handle(ctx, conn)
+}
+
+// Close immediately closes all active listeners and connections.
+//
+// Close returns any error returned from closing the server's underlying
+// listener(s).
+func (lc *Lifecycle) Close() error {
+	select {
+	case <-lc.done:
+		return lc.errClosed
+	default:
+		close(lc.done)
+	}
+
+	var err error
+	lc.locker.Lock()
+	for _, l := range lc.listeners {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+
+	for conn := range lc.conns {
+		// directly close underlying connection
+		_ = conn.Close()
+	}
+	lc.locker.Unlock()
+
+	return err
+}
+
+// Shutdown closes all open listeners and then waits indefinitely for the connections
+// to be closed. If the provided context expires before the shutdown is complete,
+// Shutdown returns the context's error, otherwise it returns any error returned from
+// closing the server's underlying listener(s).
+func (lc *Lifecycle) Shutdown(ctx context.Context) error {
+	select {
+	case <-lc.done:
+		return lc.errClosed
+	default:
+		close(lc.done)
+	}
+
+	var err error
+	lc.locker.Lock()
+	for _, l := range lc.listeners {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	lc.locker.Unlock()
+
+	connDone := make(chan struct{})
+	go func() {
+		defer close(connDone)
+		lc.wg.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-connDone:
+		return err
+	}
+}
@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errClosed = errors.New("closed")
+
+// start serves lc on a local listener, handle is called for every connection.
+func start(t *testing.T, lc *Lifecycle, handle func(ctx context.Context, conn net.Conn)) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- lc.Serve(context.Background(), slog.Default(), l, handle)
+	}()
+	return l.Addr().String(), served
+}
+
+// waitConns waits until n connections are tracked.
+func waitConns(t *testing.T, lc *Lifecycle, n int) {
+	require.Eventually(t, func() bool {
+		lc.locker.Lock()
+		defer lc.locker.Unlock()
+		return len(lc.conns) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestLifecycle_Close(t *testing.T) {
+	lc := New(errClosed)
+	handled := make(chan error, 1)
+	addr, served := start(t, lc, func(_ context.Context, conn net.Conn) {
+		_, err := conn.Read(make([]byte, 1))
+		handled <- err
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	waitConns(t, lc, 1)
+
+	require.NoError(t, lc.Close())
+	require.NoError(t, <-served)
+	require.Error(t, <-handled)
+
+	require.ErrorIs(t, lc.Close(), errClosed)
+	require.ErrorIs(t, lc.Shutdown(context.Background()), errClosed)
+}
+
+func TestLifecycle_Shutdown(t *testing.T) {
+	lc := New(errClosed)
+	addr, served := start(t, lc, func(_ context.Context, conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	waitConns(t, lc, 1)
+
+	// the open connection isn't interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, lc.Shutdown(ctx), context.DeadlineExceeded)
+	require.NoError(t, <-served)
+
+	require.NoError(t, conn.Close())
+	waitConns(t, lc, 0)
+}
@@ -60,6 +60,11 @@ const (
 // elide leading dots and detect End-of-Data
 // (<CR><LF>.<CR><LF>) line.
 func (r *dotReader) Read(b []byte) (int, error) {
+	// End-of-Data was already read, the next command mustn't be consumed
+	if r.state == stateEOF {
+		return 0, io.EOF
+	}
+
 	if r.limited {
 		if r.n <= 0 {
 			return 0, smtp.ErrDataTooLarge
@@ -0,0 +1,148 @@
+package textsmtp
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// LineEnding defines how bare CR and bare LF are handled, which are used for SMTP smuggling.
+type LineEnding int32
+
+const (
+	// LineEndingPermissive accepts bare CR and bare LF. Commands may end with a bare LF
+	// and bare line endings in messages are passed through unchanged.
+	LineEndingPermissive LineEnding = 0
+	// LineEndingStrict rejects bare CR and bare LF in commands and messages.
+	LineEndingStrict LineEnding = 1
+	// LineEndingNormalize converts bare CR and bare LF in messages to CRLF. Commands may end
+	// with a bare LF, but a bare CR inside a command is rejected.
+	LineEndingNormalize LineEnding = 2
+)
+
+// checkLine checks the line endings of a line including its final \n (if any) and returns it without them.
+func checkLine(line []byte, mode LineEnding) ([]byte, error) {
+	crlf := false
+	if l := len(line); l > 0 && line[l-1] == '\n' {
+		line = line[:l-1]
+		if l > 1 && line[l-2] == '\r' {
+			line = line[:l-2]
+			crlf = true
+		}
+
+		if !crlf && mode == LineEndingStrict {
+			return nil, smtp.ErrBareLF
+		}
+	}
+
+	if mode != LineEndingPermissive && bytes.IndexByte(line, '\r') != -1 {
+		return nil, smtp.ErrBareCR
+	}
+
+	return line, nil
+}
+
+// lineEndingReader applies a line ending mode to the message read by a dot or bdat reader.
+type lineEndingReader struct {
+	r       io.Reader
+	mode    LineEnding
+	cr      bool   // the last byte read was \r
+	pending []byte // normalized bytes which didn't fit into the last read
+	err     error
+
+	// reused buffers
+	in  []byte
+	out []byte
+}
+
+// NewLineEndingReader returns a reader which rejects (LineEndingStrict) or normalizes (LineEndingNormalize)
+// bare CR and bare LF read from r. Rejecting returns smtp.ErrBareLF or smtp.ErrBareCR.
+func NewLineEndingReader(r io.Reader, mode LineEnding) io.Reader {
+	if mode == LineEndingPermissive {
+		return r
+	}
+	return &lineEndingReader{r: r, mode: mode}
+}
+
+// Read reads in some more bytes.
+func (r *lineEndingReader) Read(b []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(b, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if cap(r.in) < len(b) {
+		r.in = make([]byte, len(b))
+	}
+	n, err := r.r.Read(r.in[:len(b)])
+
+	out, ferr := r.filter(r.out[:0], r.in[:n])
+	r.out = out
+	r.err = ferr
+
+	if r.err == nil && err != nil {
+		if err == io.EOF && r.cr {
+			// the message ended with a bare CR
+			out, r.err = r.bare(out, smtp.ErrBareCR)
+			r.cr = false
+		}
+		if r.err == nil {
+			r.err = err
+		}
+	}
+
+	i := copy(b, out)
+	r.pending = out[i:]
+
+	if len(r.pending) > 0 {
+		return i, nil
+	}
+	return i, r.err
+}
+
+// filter appends in to out and handles the bare line endings.
+func (r *lineEndingReader) filter(out []byte, in []byte) ([]byte, error) {
+	var err error
+
+	for _, ch := range in {
+		if r.cr {
+			r.cr = false
+			if ch == '\n' {
+				out = append(out, '\n')
+				continue
+			}
+			if out, err = r.bare(out, smtp.ErrBareCR); err != nil {
+				return out, err
+			}
+		}
+
+		switch ch {
+		case '\r':
+			out = append(out, '\r')
+			r.cr = true
+		case '\n':
+			out = append(out, '\r')
+			if out, err = r.bare(out, smtp.ErrBareLF); err != nil {
+				return out[:len(out)-1], err
+			}
+		default:
+			out = append(out, ch)
+		}
+	}
+
+	return out, nil
+}
+
+// bare completes a bare line ending to CRLF or returns err if bare line endings are rejected.
+func (r *lineEndingReader) bare(out []byte, err error) ([]byte, error) {
+	if r.mode == LineEndingStrict {
+		return out, err
+	}
+	return append(out, '\n'), nil
+}
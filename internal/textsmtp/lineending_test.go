@@ -0,0 +1,144 @@
+package textsmtp_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/internal/textsmtp"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// smuggling are the published end of data variants used for SMTP smuggling.
+var smuggling = []struct {
+	name    string
+	end     string
+	bare    error  // error of the strict mode
+	content string // smuggled end of data after the normalization
+}{
+	{"LF.LF", "\n.\n", smtp.ErrBareLF, "\r\n.\r\n"},
+	{"LF.CRLF", "\n.\r\n", smtp.ErrBareLF, "\r\n.\r\n"},
+	{"CRLF.LF", "\r\n.\n", smtp.ErrBareLF, "\r\n\r\n"},
+	{"CR.CRLF", "\r.\r\n", smtp.ErrBareCR, "\r\n.\r\n"},
+	{"CRLF.CR", "\r\n.\rX", smtp.ErrBareCR, "\r\n\r\nX"},
+	{"CR.CR", "\r.\rX", smtp.ErrBareCR, "\r\n.\r\nX"},
+	{"LF.CR", "\n.\rX", smtp.ErrBareLF, "\r\n.\r\nX"},
+}
+
+// smuggle returns a message which hides a second message behind end.
+func smuggle(end string) string {
+	return "Subject: first\r\n\r\nHello" + end + "MAIL FROM:<admin@example.com>\r\n" +
+		"RCPT TO:<victim@example.com>\r\nDATA\r\nSubject: smuggled\r\n\r\nHi\r\n.\r\n"
+}
+
+func readData(t *testing.T, in string, mode textsmtp.LineEnding, oneByte bool) (string, error) {
+	br := bufio.NewReader(strings.NewReader(in + "QUIT\r\n"))
+
+	var r io.Reader = textsmtp.NewLineEndingReader(textsmtp.NewDotReader(br, 0), mode)
+	if oneByte {
+		r = iotest.OneByteReader(r)
+	}
+
+	data, err := io.ReadAll(r)
+
+	// the dot reader never stops at a smuggled end of data
+	rest, _ := io.ReadAll(br)
+	if err == nil {
+		require.Equal(t, "QUIT\r\n", string(rest))
+	}
+
+	return string(data), err
+}
+
+func TestLineEndingReader_Smuggling(t *testing.T) {
+	for _, tc := range smuggling {
+		t.Run(tc.name, func(t *testing.T) {
+			in := smuggle(tc.end)
+			body := strings.TrimSuffix(in, ".\r\n")
+
+			data, err := readData(t, in, textsmtp.LineEndingPermissive, false)
+			require.NoError(t, err)
+			require.Contains(t, data, "MAIL FROM:<admin@example.com>")
+
+			_, err = readData(t, in, textsmtp.LineEndingStrict, false)
+			require.ErrorIs(t, err, tc.bare)
+
+			for _, oneByte := range []bool{false, true} {
+				data, err = readData(t, in, textsmtp.LineEndingNormalize, oneByte)
+				require.NoError(t, err)
+				require.Equal(t, strings.Replace(body, tc.end, tc.content, 1), data)
+			}
+		})
+	}
+}
+
+func TestLineEndingReader_Normalize(t *testing.T) {
+	in := "a\rb\nc\r\nd\r\r\ne\n\nf\r\n.\r\n"
+	want := "a\r\nb\r\nc\r\nd\r\n\r\ne\r\n\r\nf\r\n"
+
+	data, err := readData(t, in, textsmtp.LineEndingNormalize, false)
+	require.NoError(t, err)
+	require.Equal(t, want, data)
+
+	data, err = readData(t, in, textsmtp.LineEndingNormalize, true)
+	require.NoError(t, err)
+	require.Equal(t, want, data)
+
+	data, err = readData(t, "a\r\nb\r\n.\r\n", textsmtp.LineEndingStrict, true)
+	require.NoError(t, err)
+	require.Equal(t, "a\r\nb\r\n", data)
+}
+
+func TestReadLine_LineEnding(t *testing.T) {
+	testCases := []struct {
+		in   string
+		mode textsmtp.LineEnding
+		line string
+		err  error
+	}{
+		{"MAIL FROM:<a@example.com>\r\n", textsmtp.LineEndingStrict, "MAIL FROM:<a@example.com>", nil},
+		{"MAIL FROM:<a@example.com>\n", textsmtp.LineEndingPermissive, "MAIL FROM:<a@example.com>", nil},
+		{"MAIL FROM:<a@example.com>\n", textsmtp.LineEndingNormalize, "MAIL FROM:<a@example.com>", nil},
+		{"MAIL FROM:<a@example.com>\n", textsmtp.LineEndingStrict, "", smtp.ErrBareLF},
+		{"NOOP\rMAIL FROM:<a@example.com>\r\n", textsmtp.LineEndingPermissive, "NOOP\rMAIL FROM:<a@example.com>", nil},
+		{"NOOP\rMAIL FROM:<a@example.com>\r\n", textsmtp.LineEndingNormalize, "", smtp.ErrBareCR},
+		{"NOOP\rMAIL FROM:<a@example.com>\r\n", textsmtp.LineEndingStrict, "", smtp.ErrBareCR},
+		{"NOOP\r\r\n", textsmtp.LineEndingStrict, "", smtp.ErrBareCR},
+	}
+
+	for _, tc := range testCases {
+		r := reader(tc.in, &bytes.Buffer{})
+		r.LineEnding = tc.mode
+
+		line, err := r.ReadLine()
+		require.Equal(t, tc.err, err, "%q", tc.in)
+		require.Equal(t, tc.line, line, "%q", tc.in)
+	}
+}
+
+func TestReadLine_LongLine(t *testing.T) {
+	// the line ending isn't part of the line length
+	r := textsmtp.NewTextproto(tester.NewFakeConn("12345\r\n123456\r\n", &bytes.Buffer{}), 16, 16, 5)
+	r.LineEnding = textsmtp.LineEndingStrict
+
+	line, err := r.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "12345", line)
+
+	_, err = r.ReadLine()
+	require.ErrorIs(t, err, textsmtp.ErrTooLongLine)
+
+	// lines longer than the reader buffer
+	long := strings.Repeat("a", 40)
+	r = textsmtp.NewTextproto(tester.NewFakeConn(long+"\r\n", &bytes.Buffer{}), 16, 16, 0)
+	r.LineEnding = textsmtp.LineEndingStrict
+
+	line, err = r.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, long, line)
+}
@@ -0,0 +1,126 @@
+package textsmtp
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrLineTooLong is returned if a line of a message exceeds the maximum line length.
+var ErrLineTooLong = errors.New("smtp: message line exceeds the maximum line length")
+
+// NewLineEndingWriter returns a writer that writes a message to w (e.g. a dot or bdat writer).
+// If normalize is set, bare CR and bare LF are translated into \r\n, also across writes.
+// If maxLineLength > 0, a line longer than maxLineLength (without \r\n) fails with ErrLineTooLong.
+// If finalCRLF is set, closing appends \r\n if the message doesn't end with a line break.
+func NewLineEndingWriter(w io.WriteCloser, normalize bool, maxLineLength int, finalCRLF bool) io.WriteCloser {
+	return &lineEndingWriter{
+		w:             w,
+		normalize:     normalize,
+		maxLineLength: maxLineLength,
+		finalCRLF:     finalCRLF,
+		lineStart:     true,
+	}
+}
+
+type lineEndingWriter struct {
+	w             io.WriteCloser
+	normalize     bool
+	maxLineLength int
+	finalCRLF     bool
+
+	cr        bool // the last byte written was \r
+	lineStart bool // at the beginning of a line
+	length    int  // length of the current line
+	buf       []byte
+}
+
+// Write writes b to the underlying writer.
+func (d *lineEndingWriter) Write(b []byte) (int, error) {
+	out := d.buf[:0]
+
+	for i, ch := range b {
+		if d.cr {
+			d.cr = false
+			if ch == '\n' {
+				out = append(out, '\n')
+				d.newLine()
+				continue
+			}
+			if d.normalize {
+				// bare \r
+				out = append(out, '\n')
+				d.newLine()
+			} else if err := d.data(); err != nil {
+				return d.flush(out, i, err)
+			}
+		}
+
+		switch ch {
+		case '\r':
+			out = append(out, '\r')
+			d.cr = true
+			d.lineStart = false
+		case '\n':
+			// bare \n
+			if d.normalize {
+				out = append(out, '\r')
+			}
+			out = append(out, '\n')
+			d.newLine()
+		default:
+			out = append(out, ch)
+			if err := d.data(); err != nil {
+				return d.flush(out[:len(out)-1], i, err)
+			}
+		}
+	}
+
+	d.buf = out
+	return d.flush(out, len(b), nil)
+}
+
+// newLine starts a new line.
+func (d *lineEndingWriter) newLine() {
+	d.lineStart = true
+	d.length = 0
+}
+
+// data counts a data byte of the current line.
+func (d *lineEndingWriter) data() error {
+	d.lineStart = false
+	d.length++
+	if d.maxLineLength > 0 && d.length > d.maxLineLength {
+		return ErrLineTooLong
+	}
+	return nil
+}
+
+// flush writes out to the underlying writer, n bytes of the input are written.
+func (d *lineEndingWriter) flush(out []byte, n int, err error) (int, error) {
+	if len(out) > 0 {
+		if _, werr := d.w.Write(out); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+// Close completes the last line if needed and closes the underlying writer.
+func (d *lineEndingWriter) Close() error {
+	var end []byte
+	switch {
+	case d.cr && (d.normalize || d.finalCRLF):
+		// a final bare \r
+		end = []byte{'\n'}
+	case !d.lineStart && !d.cr && d.finalCRLF:
+		end = crlf
+	}
+
+	if len(end) > 0 {
+		if _, err := d.w.Write(end); err != nil {
+			return err
+		}
+	}
+
+	return d.w.Close()
+}
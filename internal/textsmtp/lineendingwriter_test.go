@@ -0,0 +1,121 @@
+package textsmtp_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/internal/textsmtp"
+)
+
+// writeChunks writes each chunk with a separate write call.
+func writeChunks(t *testing.T, normalize bool, maxLineLength int, finalCRLF bool, chunks ...string) (string, error) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	w := textsmtp.NewLineEndingWriter(textsmtp.NewBdatWriter(0, bw, func() error { return nil }, 0),
+		normalize, maxLineLength, finalCRLF)
+
+	for _, chunk := range chunks {
+		n, err := w.Write([]byte(chunk))
+		if err != nil {
+			return buf.String(), err
+		}
+		require.Equal(t, len(chunk), n)
+	}
+
+	require.NoError(t, w.Close())
+
+	// strip the bdat commands
+	var data strings.Builder
+	r := bufio.NewReader(&buf)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		var size int
+		if _, err := fmt.Sscanf(line, "BDAT %d", &size); err != nil || size == 0 {
+			continue
+		}
+		chunk := make([]byte, size)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		data.Write(chunk)
+	}
+
+	return data.String(), nil
+}
+
+func TestLineEndingWriter_Normalize(t *testing.T) {
+	testCases := []struct {
+		chunks []string
+		want   string
+	}{
+		{[]string{"a\r\nb\r\n"}, "a\r\nb\r\n"},
+		{[]string{"a\nb\n"}, "a\r\nb\r\n"},
+		{[]string{"a\rb\r"}, "a\r\nb\r\n"},
+		{[]string{"a\r\r\nb"}, "a\r\n\r\nb"},
+		// line endings across writes
+		{[]string{"a\r", "\nb\r", "c\r", "\r", "\n"}, "a\r\nb\r\nc\r\n\r\n"},
+		{[]string{"a", "\n", "\n"}, "a\r\n\r\n"},
+	}
+
+	for _, tc := range testCases {
+		data, err := writeChunks(t, true, 0, false, tc.chunks...)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, data, "%q", tc.chunks)
+	}
+}
+
+func TestLineEndingWriter_FinalCRLF(t *testing.T) {
+	data, err := writeChunks(t, false, 0, true, "a\r\nb")
+	require.NoError(t, err)
+	require.Equal(t, "a\r\nb\r\n", data)
+
+	data, err = writeChunks(t, false, 0, true, "a\r\n")
+	require.NoError(t, err)
+	require.Equal(t, "a\r\n", data)
+
+	data, err = writeChunks(t, false, 0, true, "a\r")
+	require.NoError(t, err)
+	require.Equal(t, "a\r\n", data)
+
+	data, err = writeChunks(t, false, 0, true)
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	// without normalization bare line endings are kept
+	data, err = writeChunks(t, false, 0, false, "a\nb\rc")
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\rc", data)
+}
+
+func TestLineEndingWriter_MaxLineLength(t *testing.T) {
+	data, err := writeChunks(t, true, 3, false, "abc\r\n", "ab", "c\n", "abc")
+	require.NoError(t, err)
+	require.Equal(t, "abc\r\nabc\r\nabc", data)
+
+	_, err = writeChunks(t, true, 3, false, "abc\r\n", "ab", "cd\r\n")
+	require.ErrorIs(t, err, textsmtp.ErrLineTooLong)
+
+	// a bare \r is part of the line if it isn't normalized
+	_, err = writeChunks(t, false, 3, false, "a\rbc\r\n")
+	require.ErrorIs(t, err, textsmtp.ErrLineTooLong)
+}
+
+func TestLineEndingWriter_DotWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := textsmtp.NewLineEndingWriter(textsmtp.NewDotWriter(bufio.NewWriter(&buf)), true, 998, false)
+
+	_, err := w.Write([]byte("a\r"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte(".b\n.\r"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.Equal(t, "a\r\n..b\r\n..\r\n.\r\n", buf.String())
+}
@@ -18,6 +18,9 @@ type Textproto struct {
 	maxLineLength      int
 	lineLengthExceeded bool
 	textproto.Pipeline
+
+	// LineEnding defines how bare CR and bare LF are handled in read lines.
+	LineEnding LineEnding
 }
 
 // NewTextproto creates a new connection wrapper.
@@ -205,25 +208,38 @@ func (t *Textproto) readLineSlice() ([]byte, error) {
 
 	var line []byte
 	for {
-		l, more, err := t.R.ReadLine()
-		if err != nil {
+		l, err := t.R.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && (err != io.EOF || len(l)+len(line) == 0) {
 			return nil, err
 		}
 
-		if t.maxLineLength > 0 && len(l)+len(line) > t.maxLineLength {
+		// the line ending isn't counted, an incomplete line may end with the \r of \r\n
+		if t.maxLineLength > 0 && len(l)+len(line) > t.maxLineLength+2 {
 			t.lineLengthExceeded = true
 			return nil, ErrTooLongLine
 		}
 
 		// Avoid the copy if the first call produced a full line.
-		if line == nil && !more {
-			return l, nil
+		if line == nil && err != bufio.ErrBufferFull {
+			line = l
+			break
 		}
 		line = append(line, l...)
-		if !more {
+		if err != bufio.ErrBufferFull {
 			break
 		}
 	}
+
+	line, err := checkLine(line, t.LineEnding)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.maxLineLength > 0 && len(line) > t.maxLineLength {
+		t.lineLengthExceeded = true
+		return nil, ErrTooLongLine
+	}
+
 	return line, nil
 }
 
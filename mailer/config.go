@@ -4,7 +4,12 @@ import (
 	"crypto/tls"
 
 	"github.com/uponusolutions/go-sasl"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/batv"
 	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/dane"
+	"github.com/uponusolutions/go-smtp/mtasts"
+	"github.com/uponusolutions/go-smtp/tlsrpt"
 )
 
 // DefaultConfig returns the default configuration of a mailer.
@@ -62,6 +67,16 @@ type additionalConfig struct {
 	security           Security    // Defines the connection is secured
 	abortOnRcptReject  bool        // Send a mail even if some recipients aren't accepted
 	tlsConfig          *tls.Config
+	selector           Selector    // order of addresses with the same preference
+	retry              RetryPolicy // retry of temporary failures
+	headerDowngrade    bool        // downgrade UTF-8 headers if SMTPUTF8 isn't used
+	oauth2             *oauth2Config
+	mtasts             *mtasts.Client // MTA-STS discovery of Send
+	stsPolicy          *mtasts.Policy // MTA-STS policy of the recipient domain
+	dane               dane.Resolver  // TLSA lookup of DANE
+	tlsrpt             *tlsrpt.Collector
+	domain             string       // recipient domain of the MX delivery
+	batv               *batv.Signer // tags the envelope sender
 }
 
 // Config contains a client config and the mailer config additions.
@@ -103,6 +118,21 @@ func WithServerAddressIndex(index int) Option {
 	}
 }
 
+// WithSelector sets the selector which orders addresses with the same preference.
+// If not set, the addresses are rotated by the server address index.
+func WithSelector(selector Selector) Option {
+	return func(c *Config) {
+		c.extra.selector = selector
+	}
+}
+
+// WithRetryPolicy sets the retry policy used by SendReport and Send.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Config) {
+		c.extra.retry = policy
+	}
+}
+
 // WithSASLClient sets the SASL client.
 func WithSASLClient(cl sasl.Client) Option {
 	return func(c *Config) {
@@ -110,6 +140,24 @@ func WithSASLClient(cl sasl.Client) Option {
 	}
 }
 
+// WithXOAuth2 authenticates with XOAUTH2 and bearer tokens from source, as required by Google and Microsoft.
+// If a token is rejected, a new one is requested and the authentication is retried once.
+// It replaces the sasl client set by WithSASLClient.
+func WithXOAuth2(username string, source TokenSource) Option {
+	return func(c *Config) {
+		c.extra.oauth2 = &oauth2Config{mech: smtp.XOAuth2, username: username, source: source}
+	}
+}
+
+// WithOAuthBearer authenticates with OAUTHBEARER (RFC 7628) and bearer tokens from source.
+// If a token is rejected, a new one is requested and the authentication is retried once.
+// It replaces the sasl client set by WithSASLClient.
+func WithOAuthBearer(username string, source TokenSource) Option {
+	return func(c *Config) {
+		c.extra.oauth2 = &oauth2Config{mech: smtp.OAuthBearer, username: username, source: source}
+	}
+}
+
 // WithSecurity sets the TLS config.
 func WithSecurity(security Security) Option {
 	return func(c *Config) {
@@ -124,6 +172,49 @@ func WithTLSConfig(cfg *tls.Config) Option {
 	}
 }
 
+// WithHeaderDowngrade converts UTF-8 header fields of the message (see message.Downgrade)
+// if the server doesn't support SMTPUTF8 or it is disabled by the mail options.
+// Domains of the envelope addresses are always converted to A-labels in this case.
+func WithHeaderDowngrade(headerDowngrade bool) Option {
+	return func(c *Config) {
+		c.extra.headerDowngrade = headerDowngrade
+	}
+}
+
+// WithMTASTS enables MTA-STS (RFC 8461) for the MX delivery of Send with policies discovered by client.
+// If the policy of a recipient domain is enforced, only MX hosts matching the policy are used and
+// STARTTLS with a valid certificate is required. The client caches the policies and should be reused.
+func WithMTASTS(client *mtasts.Client) Option {
+	return func(c *Config) {
+		c.extra.mtasts = client
+	}
+}
+
+// WithDANE enables DANE (RFC 7672) with TLSA records looked up by the DNSSEC validating resolver.
+// For servers with authenticated TLSA records STARTTLS is mandatory and the certificate is verified
+// with the records instead of the configured roots. DANE takes precedence over MTA-STS.
+func WithDANE(resolver dane.Resolver) Option {
+	return func(c *Config) {
+		c.extra.dane = resolver
+	}
+}
+
+// WithTLSReporting records the outcome of every TLS negotiation of the MX delivery of Send in collector,
+// grouped by the recipient domain and the applied policy (MTA-STS, DANE or none), for TLS-RPT (RFC 8460).
+func WithTLSReporting(collector *tlsrpt.Collector) Option {
+	return func(c *Config) {
+		c.extra.tlsrpt = collector
+	}
+}
+
+// WithBATV tags the envelope sender with signer (BATV), so that bounces to it can be validated.
+// The null sender and senders which are already tagged are sent unchanged.
+func WithBATV(signer *batv.Signer) Option {
+	return func(c *Config) {
+		c.extra.batv = signer
+	}
+}
+
 // WithAbortOnRcptReject aborts sending if at last one recipient is rejected by the server.
 func WithAbortOnRcptReject(abortOnRcptReject bool) Option {
 	return func(c *Config) {
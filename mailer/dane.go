@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/uponusolutions/go-smtp/dane"
+)
+
+// daneRecords returns the TLSA records of the server address if DANE is enabled.
+// The sender can disable DANE with the header field "TLS-Required: No" (RFC 8689 5).
+func (c *Mailer) daneRecords(ctx context.Context, addr string, policy tlsPolicy) ([]dane.TLSA, error) {
+	if c.cfg.dane == nil || policy == tlsPolicyRelaxed {
+		return nil, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return dane.Lookup(ctx, c.cfg.dane, host, port)
+}
+
+// daneTLSConfig returns a tls config which verifies the server with the TLSA records instead of PKIX.
+func daneTLSConfig(cfg *tls.Config, addr string, records []dane.TLSA) *tls.Config {
+	host, _, _ := net.SplitHostPort(addr)
+
+	res := &tls.Config{}
+	if cfg != nil {
+		res = cfg.Clone()
+	}
+
+	// SNI is required (RFC 7672 8.1)
+	res.ServerName = host
+	// nolint: gosec
+	res.InsecureSkipVerify = true // verified by VerifyConnection
+	res.VerifyConnection = dane.VerifyConnection(records, host)
+	return res
+}
+
+// DANE returns true if the certificate of the current connection was verified with DANE TLSA records.
+func (c *Mailer) DANE() bool {
+	return c.dane
+}
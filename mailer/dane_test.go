@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/dane"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+type daneResolver struct {
+	records       []dane.TLSA
+	authenticated bool
+}
+
+func (r daneResolver) LookupTLSA(_ context.Context, _ string) ([]dane.TLSA, bool, error) {
+	return r.records, r.authenticated, nil
+}
+
+// startDANEServer starts a server with a self signed certificate and returns a DANE-EE record for it.
+func startDANEServer(t *testing.T, be *tester.Backend, opts ...server.Option) (string, dane.TLSA) {
+	cert, err := tester.GenX509KeyPair("mx.example.com")
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	addr := startServer(t, be, append([]server.Option{
+		server.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	}, opts...)...)
+
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return addr, dane.TLSA{
+		Usage:        dane.UsageDANEEE,
+		Selector:     dane.SelectorSPKI,
+		MatchingType: dane.MatchingSHA256,
+		Data:         sum[:],
+	}
+}
+
+func sendDANE(c *Mailer, mailOptions *client.MailOptions) (Report, error) {
+	return c.SendReport(
+		context.Background(),
+		"alice@example.com",
+		mailOptions,
+		[]string{"bob@example.com"},
+		nil,
+		func() io.Reader { return strings.NewReader("Hello\r\n") },
+	)
+}
+
+func TestDANE(t *testing.T) {
+	be := tester.NewBackend()
+	addr, record := startDANEServer(t, be, server.WithEnableREQUIRETLS(true))
+
+	c := New(WithServerAddresses(addr), WithDANE(daneResolver{records: []dane.TLSA{record}, authenticated: true}))
+	defer func() { _ = c.Disconnect() }()
+
+	// the certificate isn't trusted by PKIX, but by DANE which also fulfills REQUIRETLS
+	res, err := sendDANE(c, &client.MailOptions{RequireTLS: true})
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+	require.True(t, res.Attempts[0].DANE)
+	require.True(t, c.DANE())
+
+	_, ok := be.Load("alice@example.com", []string{"bob@example.com"})
+	require.True(t, ok)
+}
+
+func TestDANE_Mismatch(t *testing.T) {
+	be := tester.NewBackend()
+	addr, record := startDANEServer(t, be)
+	record.Data = make([]byte, len(record.Data))
+
+	c := New(WithServerAddresses(addr), WithDANE(daneResolver{records: []dane.TLSA{record}, authenticated: true}))
+	defer func() { _ = c.Disconnect() }()
+
+	// no fallback to a plain connection
+	res, err := sendDANE(c, nil)
+	require.ErrorContains(t, err, "TLSA")
+	require.False(t, res.Attempts[0].DANE)
+
+	_, ok := be.Load("alice@example.com", []string{"bob@example.com"})
+	require.False(t, ok)
+}
+
+func TestDANE_StartTLSRequired(t *testing.T) {
+	addr := startServer(t, tester.NewBackend())
+	record := dane.TLSA{Usage: dane.UsagePKIXEE}
+
+	// STARTTLS is mandatory even if no record is usable
+	c := New(WithServerAddresses(addr), WithDANE(daneResolver{records: []dane.TLSA{record}, authenticated: true}))
+	defer func() { _ = c.Disconnect() }()
+
+	_, err := sendDANE(c, nil)
+	require.ErrorContains(t, err, "STARTTLS")
+}
+
+func TestDANE_Unauthenticated(t *testing.T) {
+	addr, record := startDANEServer(t, tester.NewBackend())
+	record.Data = make([]byte, len(record.Data))
+
+	// the records are ignored, the certificate error falls back to plain
+	c := New(WithServerAddresses(addr), WithDANE(daneResolver{records: []dane.TLSA{record}}))
+	defer func() { _ = c.Disconnect() }()
+
+	res, err := sendDANE(c, nil)
+	require.NoError(t, err)
+	require.False(t, res.Attempts[0].DANE)
+}
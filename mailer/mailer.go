@@ -7,17 +7,25 @@ import (
 	"errors"
 	"io"
 	"net"
-	"slices"
+	"strings"
+	"time"
 
 	"github.com/uponusolutions/go-smtp"
 	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/dane"
+	"github.com/uponusolutions/go-smtp/message"
 	"github.com/uponusolutions/go-smtp/resolve"
+	"github.com/uponusolutions/go-smtp/tlsrpt"
 )
 
 // Mailer implements a smtp client with .
 type Mailer struct {
 	client *client.Client
 	cfg    additionalConfig
+	// number of transactions on the current connection
+	mails int
+	// the current connection was verified with DANE
+	dane bool
 }
 
 // New returns a new smtp client.
@@ -51,32 +59,108 @@ func (c *Mailer) Client() *client.Client {
 // Security is enforced like configured (Plain, TLS, StartTLS or PreferStartTLS)
 // If an error occures, the connection is closed if open.
 func (c *Mailer) Connect(ctx context.Context) error {
+	_, err := c.connect(ctx, "", tlsPolicyDefault)
+	return err
+}
+
+// connect connects to one of the available smtp server and returns the last address tried.
+// The address avoid is only tried if all other addresses failed, it is used to move on to the next server on retries.
+// Servers which don't fulfill the TLS policy are skipped.
+func (c *Mailer) connect(ctx context.Context, avoid string, policy tlsPolicy) (string, error) {
 	var err error
 
-	for i := 0; i < len(c.cfg.serverAddresses); i++ {
-		for p := 0; p < len(c.cfg.serverAddresses[i]); p++ {
-			// use c.serverAddressIndex
-			address := c.cfg.serverAddresses[i][(p+c.cfg.serverAddressIndex)%len(c.cfg.serverAddresses[i])]
-			err = c.connectAddress(ctx, address)
-			if err == nil {
-				return nil
+	selector := c.cfg.selector
+	if selector == nil {
+		selector = staticSelector{index: c.cfg.serverAddressIndex}
+	}
+
+	try := func(address string) bool {
+		err = c.connectAddress(ctx, address, policy)
+		// neither a cancellation nor a failed authentication is caused by the address
+		if ctx.Err() == nil && !errors.As(err, new(*authError)) {
+			selector.Report(address, err)
+		}
+		return err == nil
+	}
+
+	attempted := ""
+	avoided := false
+	selected := false
+	tryGroups := func(order func(addresses []string) []string) bool {
+		for _, addresses := range c.cfg.serverAddresses {
+			for _, address := range order(addresses) {
+				selected = true
+				if !c.stsAllowed(address, policy) {
+					continue
+				}
+				if avoid != "" && address == avoid {
+					avoided = true
+					continue
+				}
+				attempted = address
+				if try(address) {
+					return true
+				}
 			}
 		}
+		return false
 	}
 
-	return err
+	if tryGroups(selector.Select) {
+		return attempted, nil
+	}
+
+	// the selector left out every address (e.g. all failed recently), try them anyway
+	if !selected && tryGroups(staticSelector{index: c.cfg.serverAddressIndex}.Select) {
+		return attempted, nil
+	}
+
+	if avoided {
+		attempted = avoid
+		if try(avoid) {
+			return avoid, nil
+		}
+	}
+
+	if attempted == "" {
+		if c.stsEnforced(policy) {
+			err = errSTSNoMatch()
+			c.reportTLSResult("", nil, tlsrpt.ValidationFailure, err)
+			return "", err
+		}
+		return "", errors.New("smtp: no server address available")
+	}
+
+	return attempted, err
 }
 
 // Connect connects to the SMTP server (addr).
 // When server supports auth and clients SaslClient is set, auth is called.
 // Security is enforced like configured (Plain, TLS, StartTLS or PreferStartTLS)
 // If an error occures, the connection is closed if open.
-func (c *Mailer) connectAddress(ctx context.Context, addr string) error {
+// A message with REQUIRETLS is only sent over a validated TLS connection to a server supporting REQUIRETLS.
+func (c *Mailer) connectAddress(ctx context.Context, addr string, policy tlsPolicy) error {
 	var err error
 
-	switch c.cfg.security {
+	c.mails = 0
+	c.dane = false
+
+	records, err := c.daneRecords(ctx, addr, policy)
+	if err != nil {
+		return err
+	}
+
+	security := c.security(policy, len(records) > 0)
+
+	tlsConfig := c.tlsConfig(policy)
+	if len(records) > 0 {
+		tlsConfig = daneTLSConfig(tlsConfig, addr, records)
+	}
+
+	switch security {
 	case SecurityTLS:
-		err = c.client.DialTLS(ctx, c.cfg.tlsConfig, addr)
+		err = c.client.DialTLS(ctx, tlsConfig, addr)
+		c.reportTLS(addr, records, err)
 	case SecurityPlain, SecurityStartTLS, SecurityPreferStartTLS:
 		fallthrough
 	default:
@@ -87,18 +171,20 @@ func (c *Mailer) connectAddress(ctx context.Context, addr string) error {
 		return err
 	}
 
-	if c.cfg.security == SecurityStartTLS || c.cfg.security == SecurityPreferStartTLS {
+	if security == SecurityStartTLS || security == SecurityPreferStartTLS {
 		if ok, _ := c.client.Extension("STARTTLS"); !ok {
-			if c.cfg.security == SecurityStartTLS {
+			c.reportTLS(addr, records, errNoSTARTTLS)
+			if security == SecurityStartTLS {
 				_ = c.client.Quit()
-				return errors.New("smtp: server doesn't support STARTTLS")
+				return errNoSTARTTLS
 			}
 		} else {
 			serverName, _, _ := net.SplitHostPort(addr)
 
-			err = c.client.StartTLS(c.cfg.tlsConfig, serverName)
+			err = c.client.StartTLS(tlsConfig, serverName)
+			c.reportTLS(addr, records, startTLSError(err))
 			if err != nil {
-				if c.cfg.security != SecurityPreferStartTLS {
+				if security != SecurityPreferStartTLS {
 					return err
 				}
 				// recover from failure if prefer start tls --- switch to plain
@@ -110,42 +196,106 @@ func (c *Mailer) connectAddress(ctx context.Context, addr string) error {
 		}
 	}
 
-	return c.auth()
-}
+	// TLS is mandatory with TLSA records, the connection was verified if any record is usable
+	c.dane = dane.Usable(records)
 
-func (c *Mailer) auth() error {
-	// Authenticate if authentication is possible and sasl client available.
-	if ok, _ := c.client.Extension("AUTH"); ok && c.cfg.saslClient != nil {
-		if err := c.client.Auth(c.cfg.saslClient); err != nil {
+	if policy == tlsPolicyRequire {
+		if err := c.checkRequireTLS(); err != nil {
 			_ = c.client.Quit()
 			return err
 		}
 	}
+
+	return c.auth(ctx)
+}
+
+func (c *Mailer) auth(ctx context.Context) error {
+	if ok, _ := c.client.Extension("AUTH"); !ok {
+		return nil
+	}
+
+	var err error
+
+	// Authenticate if authentication is possible and sasl client or token source available.
+	switch {
+	case c.cfg.oauth2 != nil:
+		err = c.authOAuth2(ctx)
+	case c.cfg.saslClient != nil:
+		err = c.client.Auth(c.cfg.saslClient)
+	}
+
+	if err != nil {
+		_ = c.client.Quit()
+		return &authError{err: err}
+	}
 	return nil
 }
 
+// authError is a failed authentication, it isn't reported to the selector as failure of the address.
+type authError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
 // Len defines the Len method existing in some structs to get the length of the internal []byte (e.g. bytes.Buffer)
 type Len interface {
 	Len() int
 }
 
+// ErrNoRecipientAccepted is returned if the server rejected all recipients.
+var ErrNoRecipientAccepted = errors.New("smtp: no recipient accepted")
+
+// errNoRecipients is returned if a mail is sent without recipients.
+var errNoRecipients = errors.New("no recipients")
+
+// connected ensures that the mailer is connected, the server accepts another transaction
+// and the connection fulfills the TLS policy.
+func (c *Mailer) connected(ctx context.Context, policy tlsPolicy) error {
+	// start a new connection if the server doesn't allow more transactions
+	if limit := c.client.Limits().MailMax; c.client.Connected() && limit > 0 && c.mails >= limit {
+		_ = c.client.Quit()
+	}
+
+	// the current connection can't be used for a message with REQUIRETLS
+	if c.client.Connected() && policy == tlsPolicyRequire && c.checkRequireTLS() != nil {
+		_ = c.client.Quit()
+	}
+
+	if !c.client.Connected() {
+		_, err := c.connect(ctx, "", policy)
+		return err
+	}
+	return nil
+}
+
 func (c *Mailer) prepare(
 	ctx context.Context,
 	from string,
 	mailOptions *client.MailOptions,
-	rcpt []string,
+	rcpts []string,
 	rcptsOptions []*smtp.RcptOptions,
 	size int,
-) (*client.DataCloser, []resolve.Failure, error) {
-	if !c.client.Connected() {
-		err := c.Connect(ctx)
-		if err != nil {
-			return nil, nil, err
-		}
+) (*client.DataCloser, []Recipient, error) {
+	recipients := make([]Recipient, len(rcpts))
+	for i, addr := range rcpts {
+		recipients[i].Address = addr
+	}
+
+	if len(rcpts) < 1 {
+		return nil, recipients, errNoRecipients
 	}
 
-	if len(rcpt) < 1 {
-		return nil, nil, errors.New("no recipients")
+	for i := range recipients {
+		recipients[i].Server = c.client.ServerAddress()
 	}
 
 	if mailOptions == nil && size > 0 {
@@ -154,41 +304,106 @@ func (c *Mailer) prepare(
 		}
 	}
 
+	if c.cfg.batv != nil {
+		from = c.cfg.batv.Sign(from)
+	}
+
 	// MAIL FROM:
+	c.mails++
 	if err := c.client.Mail(from, mailOptions); err != nil {
-		return nil, nil, err
+		return nil, recipients, err
 	}
 
-	failures := []resolve.Failure{}
-
 	// RCPT TO:
-	for i, addr := range rcpt {
+	sent, accepted, err := c.rcpt(rcpts, rcptsOptions, recipients)
+	if err != nil {
+		return nil, recipients, err
+	}
+
+	// the remaining recipients are left for the next transaction
+	recipients = recipients[:sent]
+
+	if accepted == 0 {
+		// abort the transaction, the connection can still be used
+		if err := c.client.Reset(); err != nil {
+			return nil, recipients, err
+		}
+		return nil, recipients, ErrNoRecipientAccepted
+	}
+
+	// DATA
+	w, err := c.client.Content(size)
+	if err != nil {
+		return nil, recipients, err
+	}
+	return w, recipients, nil
+}
+
+// rcpt sends RCPT TO for the recipients until all are sent or the limits of the server are reached.
+// It returns the number of recipients sent and accepted.
+func (c *Mailer) rcpt(
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	recipients []Recipient,
+) (sent int, accepted int, err error) {
+	limits := c.client.Limits()
+	domains := map[string]struct{}{}
+
+	for i, addr := range rcpts {
+		if limits.RcptMax > 0 && i >= limits.RcptMax {
+			return i, accepted, nil
+		}
+
+		if limits.RcptDomainMax > 0 {
+			domain := strings.ToLower(addr[strings.LastIndexByte(addr, '@')+1:])
+			if _, ok := domains[domain]; !ok {
+				if len(domains) >= limits.RcptDomainMax {
+					return i, accepted, nil
+				}
+				domains[domain] = struct{}{}
+			}
+		}
+
 		var rcptsOption *smtp.RcptOptions
 		if len(rcptsOptions) > i {
 			rcptsOption = rcptsOptions[i]
 		}
 
-		if err := c.client.Rcpt(addr, rcptsOption); err != nil {
+		code, msg, err := c.client.RcptWithResponse(addr, rcptsOption)
+		if err != nil {
 			smtpErr := &smtp.Status{}
+			if !errors.As(err, &smtpErr) {
+				return i, accepted, err
+			}
 
-			// continue sending if code is 550 Requested action not taken and abort on rcpt reject is disabled
-			if c.cfg.abortOnRcptReject || !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
-				return nil, nil, err
+			// the server doesn't accept more recipients in this transaction
+			if accepted > 0 && tooManyRecipients(smtpErr) {
+				return i, accepted, nil
 			}
 
-			failures = append(failures, resolve.Failure{
-				Rcpts: []string{addr},
-				Error: err,
-			})
+			// continue sending if the rejection only affects this recipient and abort on rcpt reject is disabled
+			if c.cfg.abortOnRcptReject || !rcptRejected(smtpErr.Code) {
+				return i, accepted, err
+			}
+
+			recipients[i].Rcpt = smtpErr
+			continue
 		}
+
+		recipients[i].Rcpt = smtp.ParseStatus(code, msg)
+		accepted++
 	}
 
-	// DATA
-	w, err := c.client.Content(size)
-	if err != nil {
-		return nil, nil, err
+	return len(rcpts), accepted, nil
+}
+
+// tooManyRecipients returns true if status is a 452 too many recipients reply (RFC 5321 4.5.3.1.10).
+func tooManyRecipients(status *smtp.Status) bool {
+	if status.Code != 452 {
+		return false
 	}
-	return w, failures, nil
+	return status.EnhancedCode == smtp.EnhancedCode{4, 5, 3} ||
+		strings.Contains(strings.ToLower(status.Message), "too many recipients")
 }
 
 // Send send an email from
@@ -218,6 +433,14 @@ func (c *Mailer) Send(ctx context.Context, from string, rcpt []string, in io.Rea
 // If rcptsOptions isn't set for some rcpts (e.g. len(rcpts) > len(rcptsOptions)),
 // default values are used for these recipients.
 //
+// If mailOptions.RequireTLS is set, the mail is only sent over a validated TLS connection to a server
+// supporting REQUIRETLS (RFC 8689), otherwise it fails with 5.7.10. A message with the header field
+// "TLS-Required: No" is sent even if the certificate can't be validated.
+//
+// If the limits of the server require more than one transaction (e.g. LIMITS RCPTMAX), in must
+// implement io.Seeker to be sent again. Otherwise the recipients of further transactions fail
+// with 452 4.5.3.
+//
 // The in parameter should be a stream of an RFC 822-style email with headers
 // first, a blank line, and then the message body. The lines of in
 // should be CRLF terminated. The in headers should usually include
@@ -232,33 +455,315 @@ func (c *Mailer) SendAdvanced(
 	rcptsOptions []*smtp.RcptOptions,
 	in io.Reader,
 ) (code int, msg string, failures []resolve.Failure, err error) {
+	policy, next := c.tlsPolicy(mailOptions, rewind(in))
+	responses, recipients, err := c.transactions(ctx, from, mailOptions, rcpts, rcptsOptions, policy, next)
+
+	for i := range recipients {
+		// recipients which couldn't be sent because of server limits are failures too
+		if (recipients[i].Rcpt != nil && !recipients[i].Rcpt.Positive()) || (recipients[i].Rcpt == nil && err == nil) {
+			failures = append(failures, resolve.Failure{
+				Rcpts: []string{recipients[i].Address},
+				Error: recipients[i].Err(),
+			})
+		}
+	}
+
+	code, msg = lastResponse(responses, err)
+	return code, msg, failures, err
+}
+
+// rewind returns a function which returns in for the first transaction.
+// For further transactions in is rewound if it implements io.Seeker, otherwise nil is returned.
+func rewind(in io.Reader) func() io.Reader {
+	first := true
+	start := int64(0)
+
+	return func() io.Reader {
+		seeker, ok := in.(io.Seeker)
+		if first {
+			first = false
+			if ok {
+				start, _ = seeker.Seek(0, io.SeekCurrent)
+			}
+			return in
+		}
+		if ok {
+			if _, err := seeker.Seek(start, io.SeekStart); err == nil {
+				return in
+			}
+		}
+		return nil
+	}
+}
+
+// lastResponse returns code and message of the last response, or of err if it is a smtp status.
+func lastResponse(responses []Response, err error) (int, string) {
+	status := &smtp.Status{}
+	if err != nil && errors.As(err, &status) {
+		return status.Code, status.Message
+	}
+	if err == nil && len(responses) > 0 {
+		return responses[len(responses)-1].Code, responses[len(responses)-1].Msg
+	}
+	return 0, ""
+}
+
+// transactions sends a mail in as many transactions as required by the limits of the server
+// (LIMITS extension RFC 9422, or 452 too many recipients) and returns the outcome for every recipient.
+// The in function is called for every transaction, if it returns nil the remaining recipients aren't sent.
+func (c *Mailer) transactions(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	policy tlsPolicy,
+	in func() io.Reader,
+) (responses []Response, recipients []Recipient, err error) {
+	if len(rcpts) == 0 {
+		return nil, nil, errNoRecipients
+	}
+
+	recipients = make([]Recipient, 0, len(rcpts))
+	var remaining error
+
+	for len(recipients) < len(rcpts) {
+		done := len(recipients)
+
+		r := in()
+		if r == nil {
+			remaining = smtp.NewStatus(452, smtp.EnhancedCode{4, 5, 3}, "Too many recipients")
+			break
+		}
+
+		var opts []*smtp.RcptOptions
+		if len(rcptsOptions) > done {
+			opts = rcptsOptions[done:]
+		}
+
+		var (
+			code int
+			msg  string
+			sent []Recipient
+		)
+
+		code, msg, sent, err = c.transaction(ctx, from, mailOptions, rcpts[done:], opts, policy, r)
+		recipients = append(recipients, sent...)
+
+		if delivered := deliveredRcpts(sent); len(delivered) > 0 {
+			responses = append(responses, Response{
+				Code:  code,
+				Msg:   msg,
+				Rcpts: delivered,
+			})
+		}
+
+		// all recipients of a transaction may be rejected, that doesn't affect the next one
+		if err != nil && !errors.Is(err, ErrNoRecipientAccepted) {
+			remaining = err
+			break
+		}
+	}
+
+	// recipients which weren't part of any transaction
+	for _, rcpt := range rcpts[len(recipients):] {
+		recipients = append(recipients, Recipient{Address: rcpt, Error: remaining})
+	}
+
+	if errors.Is(err, ErrNoRecipientAccepted) && len(responses) > 0 {
+		err = nil
+	}
+
+	return responses, recipients, err
+}
+
+// transaction sends a mail on the current connection (or a new one) and
+// returns the outcome for every recipient.
+func (c *Mailer) transaction(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	policy tlsPolicy,
+	in io.Reader,
+) (code int, msg string, recipients []Recipient, err error) {
+	// the connection is needed to know if the server supports SMTPUTF8
+	if err = c.connected(ctx, policy); err == nil && c.cfg.headerDowngrade && !c.smtputf8(mailOptions) {
+		in, err = message.Downgrade(in)
+	}
+	if err != nil {
+		return 0, "", failedRecipients(rcpts, err), err
+	}
+
 	size := 0
 	if wt, ok := in.(Len); ok {
 		size = wt.Len()
 	}
 
-	w, failures, err := c.prepare(ctx, from, mailOptions, rcpts, rcptsOptions, size)
+	w, recipients, err := c.prepare(ctx, from, mailOptions, rcpts, rcptsOptions, size)
 	if err != nil {
-		return 0, "", failures, err
+		abortRecipients(recipients, err)
+		return 0, "", recipients, err
 	}
 
 	_, err = io.Copy(w.Writer(), in)
+	if err == nil {
+		code, msg, err = w.CloseWithResponse()
+	}
+
 	if err != nil {
-		// if err isn't smtp.Status we are in an unknown state, close connection
-		if _, ok := err.(*smtp.Status); !ok {
+		smtpErr, ok := err.(*smtp.Status)
+		if !ok {
+			// if err isn't smtp.Status we are in an unknown state, close connection
 			err = errors.Join(err, c.client.Close())
+			abortRecipients(recipients, err)
+			return 0, "", recipients, err
 		}
-		return 0, "", failures, err
+		setData(recipients, smtpErr)
+		return code, msg, recipients, err
+	}
+
+	setData(recipients, smtp.ParseStatus(code, msg))
+	return code, msg, recipients, nil
+}
+
+// smtputf8 returns true if the next transaction uses SMTPUTF8.
+func (c *Mailer) smtputf8(mailOptions *client.MailOptions) bool {
+	if mailOptions != nil && mailOptions.UTF8 == client.UTF8Disabled {
+		return false
 	}
+	ok, _ := c.client.Extension("SMTPUTF8")
+	return ok
+}
 
-	code, msg, err = w.CloseWithResponse()
+// setData sets the final reply for all accepted recipients.
+func setData(recipients []Recipient, status *smtp.Status) {
+	for i := range recipients {
+		if recipients[i].Rcpt != nil && recipients[i].Rcpt.Positive() {
+			recipients[i].Data = status
+		}
+	}
+}
 
-	// if err isn't smtp.Status we are in an unknown state, close connection
-	if _, ok := err.(*smtp.Status); err != nil && !ok {
-		err = errors.Join(err, c.client.Close())
+// SendReport sends an email like SendAdvanced but retries temporary failures
+// as defined by the retry policy (see WithRetryPolicy).
+// Every retry uses a new connection and prefers another server address.
+// If only some recipients failed temporarily, just these are retried.
+//
+// The in function is called for every attempt and must return the complete message.
+// If the limits of the server require more than one transaction within an attempt, the returned
+// reader must implement io.Seeker, otherwise the remaining recipients fail with 452 4.5.3.
+// All attempts and the outcome for every recipient are recorded in the returned report.
+// The returned error is set if the last attempt failed for all recipients.
+func (c *Mailer) SendReport(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	in func() io.Reader,
+) (res Report, err error) {
+	if len(rcpts) == 0 {
+		return res, errNoRecipients
 	}
 
-	return code, msg, failures, err
+	res.Recipients = make([]Recipient, len(rcpts))
+
+	// indexes of the recipients of the current attempt
+	pending := make([]int, len(rcpts))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	avoid := ""
+	policy, in := c.tlsPolicy(mailOptions, in)
+
+	for attempt := 1; ; attempt++ {
+		attemptRcpts, attemptOptions := subset(pending, rcpts, rcptsOptions)
+
+		var (
+			responses  []Response
+			recipients []Recipient
+		)
+
+		start := time.Now()
+		address := c.client.ServerAddress()
+		err = nil
+
+		if !c.client.Connected() {
+			address, err = c.connect(ctx, avoid, policy)
+		}
+
+		if err == nil {
+			responses, recipients, err = c.transactions(ctx, from, mailOptions, attemptRcpts, attemptOptions, policy, in)
+		} else {
+			recipients = failedRecipients(attemptRcpts, err)
+		}
+
+		code, msg := lastResponse(responses, err)
+
+		res.Attempts = append(res.Attempts, Attempt{
+			Address: address,
+			Time:    start,
+			Rcpts:   attemptRcpts,
+			Code:    code,
+			Msg:     msg,
+			Error:   err,
+			DANE:    c.dane,
+		})
+
+		res.Responses = append(res.Responses, responses...)
+
+		// collect temporary failures for the next attempt
+		retry := []int{}
+		retryErr := err
+		for i, index := range pending {
+			res.Recipients[index] = recipients[i]
+			if recipients[i].Temporary() {
+				retry = append(retry, index)
+				if retryErr == nil {
+					retryErr = recipients[i].Err()
+				}
+			}
+		}
+
+		if len(retry) == 0 || !c.cfg.retry.retryable(attempt, retryErr) {
+			break
+		}
+
+		pending = retry
+
+		// start over with the next server
+		_ = c.client.Quit()
+		avoid = address
+
+		if waitErr := c.cfg.retry.wait(ctx, attempt); waitErr != nil {
+			err = errors.Join(waitErr, err)
+			break
+		}
+	}
+
+	res.Failures = recipientFailures(res.Recipients)
+
+	return res, err
+}
+
+// subset returns the recipients and options of the given indexes.
+func subset(indexes []int, rcpts []string, rcptsOptions []*smtp.RcptOptions) ([]string, []*smtp.RcptOptions) {
+	if len(indexes) == len(rcpts) {
+		return rcpts, rcptsOptions
+	}
+
+	resRcpts := make([]string, len(indexes))
+	resOptions := make([]*smtp.RcptOptions, len(indexes))
+	for i, index := range indexes {
+		resRcpts[i] = rcpts[index]
+		if len(rcptsOptions) > index {
+			resOptions[i] = rcptsOptions[index]
+		}
+	}
+	return resRcpts, resOptions
 }
 
 // Verify checks the validity of an email address on the server.
@@ -300,6 +805,10 @@ func (c *Mailer) ServerName() string {
 type Report struct {
 	Responses []Response
 	Failures  []resolve.Failure
+	// All delivery attempts in chronological order.
+	Attempts []Attempt
+	// Outcome of every recipient.
+	Recipients []Recipient
 }
 
 // Response contains the response of a smtp server for specific recipients.
@@ -310,8 +819,22 @@ type Response struct {
 }
 
 // Send just sends a mail.
-// in is called multiple times if there are recipients from different servers.
+// in is called multiple times if there are recipients from different servers or retries are necessary.
 func Send(ctx context.Context, from string, rcpts []string, in func() io.Reader, opts ...Option) (res Report, err error) {
+	return SendReport(ctx, from, nil, rcpts, nil, in, opts...)
+}
+
+// SendReport sends a mail like Send with the parameters of the MAIL and RCPT commands.
+// The options of a recipient have the same index in rcptsOptions as the recipient in rcpts.
+func SendReport(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	in func() io.Reader,
+	opts ...Option,
+) (res Report, err error) {
 	r := resolve.New(nil)
 
 	config := NewConfig(opts...)
@@ -336,44 +859,71 @@ func Send(ctx context.Context, from string, rcpts []string, in func() io.Reader,
 
 	res.Failures = mx.Failures
 
-	for _, server := range mx.Servers {
-		code, msg, failures, err := send(ctx, server, from, config, in())
-		if err != nil {
-			res.Failures = append(res.Failures, resolve.Failure{
-				Rcpts: server.Rcpts,
-				Error: err,
+	// resolve failures are permanent, e.g. no mx record found
+	for _, fail := range mx.Failures {
+		for _, rcpt := range fail.Rcpts {
+			res.Recipients = append(res.Recipients, Recipient{
+				Address: rcpt,
+				Error:   smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 2}, fail.Error.Error()),
 			})
-			continue
 		}
+	}
 
-		if len(failures) > 0 {
-			rcpts := []string{}
-
-		outer:
-			for _, rcpt := range server.Rcpts {
-				for _, fail := range failures {
-					if slices.Contains(fail.Rcpts, rcpt) {
-						continue outer
-					}
-				}
-				rcpts = append(rcpts, rcpt)
-			}
-			server.Rcpts = rcpts
-			res.Failures = append(res.Failures, failures...)
+	for _, server := range mx.Servers {
+		serverDeliveries := []delivery{{server: server}}
+		if (config.extra.mtasts != nil || config.extra.tlsrpt != nil) && len(config.extra.serverAddresses) == 0 {
+			serverDeliveries = deliveries(ctx, config.extra, server)
 		}
 
-		res.Responses = append(res.Responses, Response{
-			Code:  code,
-			Msg:   msg,
-			Rcpts: server.Rcpts,
-		})
+		for _, delivery := range serverDeliveries {
+			deliveryConfig := config
+			deliveryConfig.extra.domain = delivery.domain
+			deliveryConfig.extra.stsPolicy = delivery.policy
+
+			// failures are part of the report
+			serverOptions := RcptsOptions(rcpts, rcptsOptions, delivery.server.Rcpts)
+			report, _ := send(ctx, delivery.server, from, mailOptions, serverOptions, deliveryConfig, in)
+			res.Attempts = append(res.Attempts, report.Attempts...)
+			res.Failures = append(res.Failures, report.Failures...)
+			res.Responses = append(res.Responses, report.Responses...)
+			res.Recipients = append(res.Recipients, report.Recipients...)
+		}
 	}
 	return res, nil
 }
 
-func send(ctx context.Context, server resolve.Server, from string, config Config, in io.Reader) (code int, msg string, failures []resolve.Failure, err error) {
+func send(
+	ctx context.Context,
+	server resolve.Server,
+	from string,
+	mailOptions *client.MailOptions,
+	rcptsOptions []*smtp.RcptOptions,
+	config Config,
+	in func() io.Reader,
+) (Report, error) {
 	config.extra.serverAddresses = server.Addresses
 	client := NewFromConfig(config)
 	defer func() { _ = client.Disconnect() }()
-	return client.Send(ctx, from, server.Rcpts, in)
+	return client.SendReport(ctx, from, mailOptions, server.Rcpts, rcptsOptions, in)
+}
+
+// RcptsOptions returns the options of the recipients in subset (e.g. the recipients of a server),
+// the options of a recipient have the same index in rcptsOptions as the recipient in rcpts.
+func RcptsOptions(rcpts []string, rcptsOptions []*smtp.RcptOptions, subset []string) []*smtp.RcptOptions {
+	if len(rcptsOptions) == 0 {
+		return nil
+	}
+
+	options := map[string]*smtp.RcptOptions{}
+	for i, rcpt := range rcpts {
+		if _, ok := options[rcpt]; !ok && i < len(rcptsOptions) {
+			options[rcpt] = rcptsOptions[i]
+		}
+	}
+
+	res := make([]*smtp.RcptOptions, len(subset))
+	for i, rcpt := range subset {
+		res[i] = options[rcpt]
+	}
+	return res
 }
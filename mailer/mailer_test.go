@@ -7,7 +7,11 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"net"
+	"net/textproto"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/uponusolutions/go-smtp"
@@ -326,3 +330,230 @@ func TestClient_SendMicrosoft(t *testing.T) {
 	_, _, _, err = c.Send(context.Background(), from, recipients, bytes.NewBuffer([]byte(eml)))
 	require.NoError(t, err)
 }
+
+// startTooManyRcptsServer starts a server which rejects more than max recipients per transaction
+// with 452 without advertising the limit and returns its address and the number of transactions.
+func startTooManyRcptsServer(t *testing.T, maxRcpts int32) (string, *atomic.Int32) {
+	var (
+		mails atomic.Int32
+		rcpts atomic.Int32
+	)
+
+	return startServer(t, &tester.Backend{
+		Mail: func(_ context.Context, _ string, _ *smtp.MailOptions) error {
+			mails.Add(1)
+			rcpts.Store(0)
+			return nil
+		},
+		Rcpt: func(_ context.Context, _ string, _ *smtp.RcptOptions) error {
+			if rcpts.Add(1) > maxRcpts {
+				return smtp.NewStatus(452, smtp.EnhancedCode{4, 5, 3}, "Too many recipients")
+			}
+			return nil
+		},
+	}), &mails
+}
+
+func TestSendReport_SplitRcptMax(t *testing.T) {
+	var mails atomic.Int32
+
+	limitAddr := startServer(t, &tester.Backend{
+		Mail: func(_ context.Context, _ string, _ *smtp.MailOptions) error {
+			mails.Add(1)
+			return nil
+		},
+	}, server.WithMaxRecipients(2))
+
+	c := New(WithServerAddresses(limitAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	require.NoError(t, c.Connect(context.Background()))
+	require.Equal(t, 2, c.Client().Limits().RcptMax)
+
+	rcpts := []string{"a@external.com", "b@external.com", "c@external.com", "d@external.com", "e@external.com"}
+
+	res, err := c.SendReport(context.Background(), "alice@internal.com", nil, rcpts, nil, func() io.Reader {
+		return bytes.NewBufferString("Hello World!")
+	})
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+	require.Len(t, res.Attempts, 1)
+	require.Len(t, res.Responses, 3)
+	require.Equal(t, rcpts[:2], res.Responses[0].Rcpts)
+	require.Equal(t, rcpts[2:4], res.Responses[1].Rcpts)
+	require.Equal(t, rcpts[4:], res.Responses[2].Rcpts)
+	require.Equal(t, int32(3), mails.Load())
+
+	for _, r := range res.Recipients {
+		require.True(t, r.Delivered(), r.Address)
+	}
+}
+
+func TestSendReport_SplitTooManyRecipients(t *testing.T) {
+	splitAddr, mails := startTooManyRcptsServer(t, 2)
+
+	c := New(WithServerAddresses(splitAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	rcpts := []string{"a@external.com", "b@external.com", "c@external.com", "d@external.com", "e@external.com"}
+
+	res, err := c.SendReport(context.Background(), "alice@internal.com", nil, rcpts, nil, func() io.Reader {
+		return bytes.NewBufferString("Hello World!")
+	})
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+	require.Len(t, res.Responses, 3)
+	require.Equal(t, int32(3), mails.Load())
+}
+
+func TestSendAdvanced_SplitTooManyRecipients(t *testing.T) {
+	splitAddr, _ := startTooManyRcptsServer(t, 2)
+
+	c := New(WithServerAddresses(splitAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	rcpts := []string{"a@external.com", "b@external.com", "c@external.com"}
+
+	// a seekable message is sent again for the remaining recipients
+	code, _, failures, err := c.SendAdvanced(
+		context.Background(), "alice@internal.com", nil, rcpts, nil, strings.NewReader("Hello World!"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 250, code)
+	require.Empty(t, failures)
+
+	// otherwise the remaining recipients fail temporarily
+	code, _, failures, err = c.SendAdvanced(
+		context.Background(), "alice@internal.com", nil, rcpts, nil, bytes.NewBufferString("Hello World!"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 250, code)
+	require.Len(t, failures, 1)
+	require.Equal(t, []string{"c@external.com"}, failures[0].Rcpts)
+	require.ErrorContains(t, failures[0].Error, "452")
+}
+
+func TestSendAdvanced_NoRecipients(t *testing.T) {
+	splitAddr, mails := startTooManyRcptsServer(t, 2)
+
+	c := New(WithServerAddresses(splitAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	_, _, _, err := c.SendAdvanced(
+		context.Background(), "alice@internal.com", nil, nil, nil, strings.NewReader("Hello World!"),
+	)
+	require.ErrorContains(t, err, "no recipients")
+
+	_, err = c.SendReport(context.Background(), "alice@internal.com", nil, nil, nil, func() io.Reader {
+		return strings.NewReader("Hello World!")
+	})
+	require.ErrorContains(t, err, "no recipients")
+	require.Equal(t, int32(0), mails.Load())
+}
+
+// startLimitsServer starts a minimal smtp server advertising the given limits
+// and returns its address and the number of accepted connections.
+func startLimitsServer(t *testing.T, limits string) (string, *atomic.Int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	var connections atomic.Int32
+
+	handle := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost ESMTP")
+
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd, _, _ := strings.Cut(strings.ToUpper(line), " "); cmd {
+			case "EHLO":
+				_ = text.PrintfLine("250-localhost\r\n250 LIMITS %s", limits)
+			case "DATA":
+				_ = text.PrintfLine("354 Go ahead")
+				_, _ = text.ReadDotBytes()
+				_ = text.PrintfLine("250 2.0.0 OK")
+			case "QUIT":
+				_ = text.PrintfLine("221 Bye")
+				return
+			default:
+				_ = text.PrintfLine("250 OK")
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			go handle(conn)
+		}
+	}()
+
+	return l.Addr().String(), &connections
+}
+
+func TestSendReport_SplitMailMax(t *testing.T) {
+	limitAddr, connections := startLimitsServer(t, "MAILMAX=2 RCPTMAX=1")
+
+	c := New(WithServerAddresses(limitAddr), WithSecurity(SecurityPlain))
+	defer func() { _ = c.Disconnect() }()
+
+	rcpts := []string{"a@external.com", "b@external.com", "c@external.com", "d@external.com", "e@external.com"}
+
+	res, err := c.SendReport(context.Background(), "alice@internal.com", nil, rcpts, nil, func() io.Reader {
+		return bytes.NewBufferString("Hello World!")
+	})
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+	require.Len(t, res.Responses, 5)
+	require.Equal(t, int32(3), connections.Load())
+}
+
+func TestSendReport_HeaderDowngrade(t *testing.T) {
+	be := tester.NewBackend()
+	downgradeAddr := startServer(t, be, server.WithEnableSMTPUTF8(false))
+
+	c := New(WithServerAddresses(downgradeAddr), WithHeaderDowngrade(true))
+	defer func() { _ = c.Disconnect() }()
+
+	eml := "From: Jörg <joerg@bücher.example>\r\nSubject: Grüße\r\n\r\nHällo\r\n"
+
+	res, err := c.SendReport(
+		context.Background(),
+		"joerg@bücher.example",
+		nil,
+		[]string{"bob@münchen.de", "jörg@example.com"},
+		nil,
+		func() io.Reader { return strings.NewReader(eml) },
+	)
+	require.NoError(t, err)
+
+	// the non-ASCII local part can't be delivered
+	require.Len(t, res.Failures, 1)
+	require.Equal(t, []string{"jörg@example.com"}, res.Failures[0].Rcpts)
+	require.Equal(t, smtp.EnhancedCode{5, 6, 7}, res.Recipients[1].EnhancedCode())
+	require.True(t, res.Recipients[1].Permanent())
+
+	m, ok := be.Load("joerg@xn--bcher-kva.example", []string{"bob@xn--mnchen-3ya.de"})
+	require.True(t, ok)
+	require.Equal(t, "From: =?utf-8?q?J=C3=B6rg?= <joerg@xn--bcher-kva.example>\r\n"+
+		"Subject: =?utf-8?b?R3LDvMOfZQ==?=\r\n\r\nHällo\r\n", string(m.Data))
+}
+
+func TestRcptsOptions(t *testing.T) {
+	never := &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifyNever}}
+	rcpts := []string{"a@example.com", "b@example.org", "c@example.com"}
+
+	require.Nil(t, RcptsOptions(rcpts, nil, rcpts[1:]))
+	require.Equal(t, []*smtp.RcptOptions{never, nil},
+		RcptsOptions(rcpts, []*smtp.RcptOptions{nil, never, nil}, []string{"b@example.org", "c@example.com"}))
+}
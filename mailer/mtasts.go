@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/mtasts"
+	"github.com/uponusolutions/go-smtp/resolve"
+)
+
+// stsEnforced returns true if the MTA-STS policy of the recipient domain is enforced.
+func (c *Mailer) stsEnforced(policy tlsPolicy) bool {
+	return c.cfg.stsPolicy != nil && c.cfg.stsPolicy.Mode == mtasts.ModeEnforce && policy != tlsPolicyRelaxed
+}
+
+// stsAllowed returns true if the address may be used by the MTA-STS policy.
+func (c *Mailer) stsAllowed(address string, policy tlsPolicy) bool {
+	if !c.stsEnforced(policy) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	return err == nil && c.cfg.stsPolicy.Match(host)
+}
+
+// errSTSNoMatch is returned if no address matches the MTA-STS policy, the delivery is retried later (RFC 8461 5.1).
+func errSTSNoMatch() error {
+	return smtp.NewStatus(451, smtp.EnhancedCode{4, 7, 5}, "No MX host matches the MTA-STS policy")
+}
+
+// delivery contains recipients of one domain with the MTA-STS policy of the domain.
+type delivery struct {
+	server resolve.Server
+	domain string
+	policy *mtasts.Policy
+}
+
+// deliveries splits the recipients of a server by domain and fetches the MTA-STS policy for each domain
+// if MTA-STS is enabled. If the policy can't be discovered, the recipients are delivered without policy.
+func deliveries(ctx context.Context, cfg additionalConfig, server resolve.Server) []delivery {
+	res := []delivery{}
+	index := map[string]int{}
+
+	for _, rcpt := range server.Rcpts {
+		domain := strings.ToLower(rcpt[strings.LastIndexByte(rcpt, '@')+1:])
+
+		if i, ok := index[domain]; ok {
+			res[i].server.Rcpts = append(res[i].server.Rcpts, rcpt)
+			continue
+		}
+
+		var policy *mtasts.Policy
+		if cfg.mtasts != nil {
+			var err error
+			if policy, err = cfg.mtasts.Policy(ctx, domain); err != nil {
+				reportSTSError(cfg.tlsrpt, domain, err)
+			}
+		}
+
+		index[domain] = len(res)
+		res = append(res, delivery{
+			server: resolve.Server{Addresses: server.Addresses, Rcpts: []string{rcpt}},
+			domain: domain,
+			policy: policy,
+		})
+	}
+
+	return res
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/mtasts"
+	"github.com/uponusolutions/go-smtp/resolve"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// newSTSMailer returns a mailer for a recipient domain with the given MTA-STS policy.
+func newSTSMailer(policy *mtasts.Policy, opts ...Option) *Mailer {
+	cfg := NewConfig(opts...)
+	cfg.extra.stsPolicy = policy
+	return NewFromConfig(cfg)
+}
+
+func sendSTS(c *Mailer) error {
+	_, _, _, err := c.SendAdvanced(
+		context.Background(), "alice@example.com", nil, []string{"bob@example.com"}, nil, strings.NewReader("Hello\r\n"),
+	)
+	return err
+}
+
+func TestMTASTS_Enforce(t *testing.T) {
+	plainAddr := startServer(t, tester.NewBackend())
+	tlsAddr, tlsConfig := startTLSServer(t, tester.NewBackend())
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	localhost := net.JoinHostPort("localhost", tlsPort)
+
+	policy := &mtasts.Policy{Mode: mtasts.ModeEnforce, MX: []string{"localhost"}}
+	tlsConfig.ServerName = ""
+
+	// the plain server doesn't match the policy
+	c := newSTSMailer(policy, WithServerAddresses(plainAddr, localhost), WithTLSConfig(tlsConfig))
+	defer func() { _ = c.Disconnect() }()
+
+	require.NoError(t, sendSTS(c))
+	require.Equal(t, localhost, c.ServerAddress())
+
+	_, ok := c.Client().TLSConnectionState()
+	require.True(t, ok)
+}
+
+func TestMTASTS_EnforceFailures(t *testing.T) {
+	plainAddr := startServer(t, tester.NewBackend())
+	tlsAddr, _ := startTLSServer(t, tester.NewBackend())
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	_, plainPort, _ := net.SplitHostPort(plainAddr)
+
+	policy := &mtasts.Policy{Mode: mtasts.ModeEnforce, MX: []string{"localhost"}}
+
+	// no address matches the policy
+	c := newSTSMailer(policy, WithServerAddresses(plainAddr))
+	err := sendSTS(c)
+	status := &smtp.Status{}
+	require.ErrorAs(t, err, &status)
+	require.Equal(t, smtp.EnhancedCode{4, 7, 5}, status.EnhancedCode)
+
+	// STARTTLS is required
+	c = newSTSMailer(policy, WithServerAddresses(net.JoinHostPort("localhost", plainPort)))
+	require.ErrorContains(t, sendSTS(c), "STARTTLS")
+
+	// the certificate must be valid, even if verification is disabled
+	c = newSTSMailer(policy,
+		WithServerAddresses(net.JoinHostPort("localhost", tlsPort)),
+		WithTLSConfig(&tls.Config{InsecureSkipVerify: true}), // nolint: gosec
+	)
+	require.ErrorContains(t, sendSTS(c), "certificate")
+}
+
+func TestMTASTS_Testing(t *testing.T) {
+	plainAddr := startServer(t, tester.NewBackend())
+
+	c := newSTSMailer(&mtasts.Policy{Mode: mtasts.ModeTesting, MX: []string{"localhost"}}, WithServerAddresses(plainAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	require.NoError(t, sendSTS(c))
+}
+
+type stsResolver struct{}
+
+func (stsResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if name == "_mta-sts.example.com" {
+		return []string{"v=STSv1; id=1"}, nil
+	}
+	return nil, &net.DNSError{IsNotFound: true, Name: name}
+}
+
+type stsHTTP struct{}
+
+func (stsHTTP) Do(_ *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "text/plain")
+	_, _ = rec.WriteString("version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 60\n")
+	return rec.Result(), nil
+}
+
+func TestDeliveries(t *testing.T) {
+	client := mtasts.New(mtasts.WithResolver(stsResolver{}), mtasts.WithHTTPClient(stsHTTP{}))
+
+	server := resolve.Server{
+		Addresses: [][]string{{"mail.example.com:25"}},
+		Rcpts:     []string{"a@example.com", "b@example.org", "c@EXAMPLE.com"},
+	}
+
+	res := deliveries(context.Background(), additionalConfig{mtasts: client}, server)
+	require.Len(t, res, 2)
+
+	require.Equal(t, []string{"a@example.com", "c@EXAMPLE.com"}, res[0].server.Rcpts)
+	require.Equal(t, server.Addresses, res[0].server.Addresses)
+	require.Equal(t, "example.com", res[0].domain)
+	require.Equal(t, mtasts.ModeEnforce, res[0].policy.Mode)
+
+	require.Equal(t, []string{"b@example.org"}, res[1].server.Rcpts)
+	require.Equal(t, "example.org", res[1].domain)
+	require.Nil(t, res[1].policy)
+}
@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// TokenSource provides OAuth2 bearer tokens for XOAUTH2 and OAUTHBEARER authentication.
+type TokenSource interface {
+	// Token returns a valid bearer token.
+	// If refresh is true, the previous token was rejected and must not be returned again.
+	Token(ctx context.Context, refresh bool) (string, error)
+}
+
+// TokenSourceFunc is an adapter to use a function as TokenSource.
+type TokenSourceFunc func(ctx context.Context, refresh bool) (string, error)
+
+// Token implements TokenSource.
+func (f TokenSourceFunc) Token(ctx context.Context, refresh bool) (string, error) {
+	return f(ctx, refresh)
+}
+
+// oauth2Config contains the configuration of an OAuth2 authentication.
+type oauth2Config struct {
+	mech     string
+	username string
+	source   TokenSource
+}
+
+// oauth2Client implements sasl.Client for XOAUTH2 and OAUTHBEARER.
+type oauth2Client struct {
+	mech        string
+	credentials smtp.OAuth2Credentials
+	err         *smtp.OAuth2Error
+}
+
+func (c *oauth2Client) Start() (string, []byte, error) {
+	ir, err := smtp.EncodeOAuth2(c.mech, c.credentials)
+	return c.mech, ir, err
+}
+
+// Next receives the error of the server, the dummy response finishes the authentication.
+func (c *oauth2Client) Next(challenge []byte) ([]byte, error) {
+	c.err = &smtp.OAuth2Error{}
+	if err := json.Unmarshal(challenge, c.err); err != nil {
+		return nil, err
+	}
+
+	if c.mech == smtp.OAuthBearer {
+		return []byte{0x01}, nil
+	}
+	return []byte{}, nil
+}
+
+// authOAuth2 authenticates with a bearer token, if the token is rejected
+// it is refreshed and the authentication is retried once.
+func (c *Mailer) authOAuth2(ctx context.Context) error {
+	err := c.authToken(ctx, false)
+
+	oauthErr := &smtp.OAuth2Error{}
+	status := &smtp.Status{}
+	if errors.As(err, &oauthErr) || (errors.As(err, &status) && status.Code == 535) {
+		// the token is likely expired
+		err = c.authToken(ctx, true)
+	}
+
+	return err
+}
+
+// authToken authenticates with a token of the token source.
+func (c *Mailer) authToken(ctx context.Context, refresh bool) error {
+	token, err := c.cfg.oauth2.source.Token(ctx, refresh)
+	if err != nil {
+		return err
+	}
+
+	host, port, _ := net.SplitHostPort(c.client.ServerAddress())
+	portNumber, _ := strconv.Atoi(port)
+
+	saslClient := &oauth2Client{
+		mech: c.cfg.oauth2.mech,
+		credentials: smtp.OAuth2Credentials{
+			Username: c.cfg.oauth2.username,
+			Token:    token,
+			Host:     host,
+			Port:     portNumber,
+		},
+	}
+
+	if err := c.client.Auth(saslClient); err != nil {
+		if saslClient.err != nil {
+			return errors.Join(err, saslClient.err)
+		}
+		return err
+	}
+
+	return nil
+}
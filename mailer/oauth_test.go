@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-sasl"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// startOAuth2Server starts a server which accepts the given bearer token only.
+func startOAuth2Server(t *testing.T, valid string) string {
+	return startServer(t, &tester.Backend{
+		AuthMechanisms: []string{smtp.XOAuth2, smtp.OAuthBearer},
+		Auth: func(ctx context.Context, mech string) (sasl.Server, error) {
+			return server.NewOAuth2Server(ctx, mech, func(_ context.Context, credentials smtp.OAuth2Credentials) error {
+				if credentials.Username != "alice@internal.com" || credentials.Token != valid {
+					return errors.New("invalid token")
+				}
+				return nil
+			})
+		},
+	})
+}
+
+func TestOAuth2_Refresh(t *testing.T) {
+	oauthAddr := startOAuth2Server(t, "fresh")
+
+	for name, option := range map[string]func(string, TokenSource) Option{
+		smtp.XOAuth2:     WithXOAuth2,
+		smtp.OAuthBearer: WithOAuthBearer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			refreshes := []bool{}
+			source := TokenSourceFunc(func(_ context.Context, refresh bool) (string, error) {
+				refreshes = append(refreshes, refresh)
+				if refresh {
+					return "fresh", nil
+				}
+				return "expired", nil
+			})
+
+			c := New(WithServerAddresses(oauthAddr), option("alice@internal.com", source))
+			defer func() { _ = c.Disconnect() }()
+
+			_, _, _, err := c.Send(context.Background(), "alice@internal.com", []string{"bob@external.com"},
+				bytes.NewBufferString("Hello World!"))
+			require.NoError(t, err)
+			require.Equal(t, []bool{false, true}, refreshes)
+		})
+	}
+}
+
+func TestOAuth2_Rejected(t *testing.T) {
+	oauthAddr := startOAuth2Server(t, "fresh")
+
+	calls := 0
+	source := TokenSourceFunc(func(_ context.Context, _ bool) (string, error) {
+		calls++
+		return "revoked", nil
+	})
+
+	c := New(WithServerAddresses(oauthAddr), WithXOAuth2("alice@internal.com", source))
+
+	err := c.Connect(context.Background())
+	require.ErrorContains(t, err, "535")
+
+	oauthErr := &smtp.OAuth2Error{}
+	require.ErrorAs(t, err, &oauthErr)
+	require.Equal(t, "invalid_token", oauthErr.Status)
+
+	// the authentication is retried only once
+	require.Equal(t, 2, calls)
+	require.False(t, c.Connected())
+
+	// token source errors are returned
+	c = New(WithServerAddresses(oauthAddr), WithXOAuth2("alice@internal.com", TokenSourceFunc(
+		func(_ context.Context, _ bool) (string, error) { return "", io.ErrUnexpectedEOF },
+	)))
+	require.ErrorIs(t, c.Connect(context.Background()), io.ErrUnexpectedEOF)
+}
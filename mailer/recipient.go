@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"errors"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/resolve"
+)
+
+// Recipient contains the delivery outcome of a single recipient.
+type Recipient struct {
+	// Address of the recipient.
+	Address string
+
+	// Server address used for the delivery, empty if no server could be reached.
+	Server string
+
+	// Reply to RCPT TO, nil if the command wasn't sent.
+	Rcpt *smtp.Status
+
+	// Final reply after the message data, nil if the recipient was rejected
+	// or the transaction was aborted before.
+	Data *smtp.Status
+
+	// Error which aborted the transaction, e.g. a connection error.
+	Error error
+}
+
+// Err returns the error which prevented the delivery, nil if the message was delivered.
+func (r *Recipient) Err() error {
+	if r.Rcpt != nil && !r.Rcpt.Positive() {
+		return r.Rcpt
+	}
+	if r.Data != nil && !r.Data.Positive() {
+		return r.Data
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.Data == nil {
+		return errors.New("smtp: message not delivered")
+	}
+	return nil
+}
+
+// Status returns the final smtp status of the recipient.
+// It is nil if the delivery failed without any smtp status (e.g. a connection error).
+func (r *Recipient) Status() *smtp.Status {
+	err := r.Err()
+	if err == nil {
+		return r.Data
+	}
+	status := &smtp.Status{}
+	if errors.As(err, &status) {
+		return status
+	}
+	return nil
+}
+
+// EnhancedCode returns the enhanced code of the final status, smtp.EnhancedCodeNotSet if unknown.
+func (r *Recipient) EnhancedCode() smtp.EnhancedCode {
+	if status := r.Status(); status != nil {
+		return status.EnhancedCode
+	}
+	return smtp.EnhancedCodeNotSet
+}
+
+// Delivered returns true if the server accepted the message for the recipient.
+func (r *Recipient) Delivered() bool {
+	return r.Err() == nil
+}
+
+// Permanent returns true if the delivery failed permanently (5xx).
+func (r *Recipient) Permanent() bool {
+	status := r.Status()
+	return status != nil && status.Permanent()
+}
+
+// Temporary returns true if the delivery failed but could succeed later.
+// Failures without a smtp status (e.g. connection errors) are considered temporary.
+func (r *Recipient) Temporary() bool {
+	return !r.Delivered() && !r.Permanent()
+}
+
+// rcptRejected returns true if code is a rejection of a single recipient
+// which doesn't affect the rest of the transaction.
+func rcptRejected(code int) bool {
+	switch code {
+	case 450, 451, 452, 550, 551, 552, 553:
+		return true
+	default:
+		return false
+	}
+}
+
+// failedRecipients returns the recipients of a transaction which failed with err before it started.
+func failedRecipients(rcpts []string, err error) []Recipient {
+	recipients := make([]Recipient, len(rcpts))
+	for i, rcpt := range rcpts {
+		recipients[i] = Recipient{Address: rcpt, Error: err}
+	}
+	return recipients
+}
+
+// abortRecipients sets err on all recipients which weren't rejected before.
+func abortRecipients(recipients []Recipient, err error) {
+	for i := range recipients {
+		if recipients[i].Rcpt == nil || recipients[i].Rcpt.Positive() {
+			recipients[i].Error = err
+		}
+	}
+}
+
+// recipientFailures converts all failed recipients into failures.
+func recipientFailures(recipients []Recipient) []resolve.Failure {
+	failures := []resolve.Failure{}
+	for i := range recipients {
+		if err := recipients[i].Err(); err != nil {
+			failures = append(failures, resolve.Failure{
+				Rcpts: []string{recipients[i].Address},
+				Error: err,
+			})
+		}
+	}
+	return failures
+}
+
+// deliveredRcpts returns the addresses of all delivered recipients.
+func deliveredRcpts(recipients []Recipient) []string {
+	rcpts := []string{}
+	for i := range recipients {
+		if recipients[i].Delivered() {
+			rcpts = append(rcpts, recipients[i].Address)
+		}
+	}
+	return rcpts
+}
@@ -0,0 +1,167 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// rcptCodeBackend rejects recipients of the form rcptXXX@... with code XXX.
+// Temporary rejections are only returned the first time per recipient.
+func rcptCodeBackend() *tester.Backend {
+	var temporary sync.Map
+
+	return &tester.Backend{
+		Rcpt: func(_ context.Context, to string, _ *smtp.RcptOptions) error {
+			local, ok := strings.CutPrefix(to, "rcpt")
+			if !ok {
+				return nil
+			}
+			code, err := strconv.Atoi(local[:3])
+			if err != nil {
+				return err
+			}
+			if _, seen := temporary.LoadOrStore(to, true); code/100 == 4 && seen {
+				return nil
+			}
+			return smtp.NewStatus(code, smtp.EnhancedCode{code / 100, 1, 1}, "rejected")
+		},
+	}
+}
+
+func TestRecipient_Status(t *testing.T) {
+	ok := smtp.NewStatus(250, smtp.EnhancedCode{2, 0, 0}, "OK")
+	tempfail := smtp.NewStatus(450, smtp.EnhancedCode{4, 2, 1}, "busy")
+	permfail := smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "unknown")
+
+	r := Recipient{Rcpt: ok, Data: ok}
+	require.True(t, r.Delivered())
+	require.NoError(t, r.Err())
+	require.Equal(t, ok, r.Status())
+	require.False(t, r.Temporary())
+	require.False(t, r.Permanent())
+
+	r = Recipient{Rcpt: tempfail}
+	require.False(t, r.Delivered())
+	require.True(t, r.Temporary())
+	require.Equal(t, smtp.EnhancedCode{4, 2, 1}, r.EnhancedCode())
+
+	r = Recipient{Rcpt: ok, Data: permfail}
+	require.True(t, r.Permanent())
+	require.Equal(t, permfail, r.Err())
+
+	r = Recipient{Error: errors.New("connection reset")}
+	require.Nil(t, r.Status())
+	require.True(t, r.Temporary())
+	require.Equal(t, smtp.EnhancedCodeNotSet, r.EnhancedCode())
+
+	r = Recipient{}
+	require.ErrorContains(t, r.Err(), "not delivered")
+}
+
+func TestSendReport_Recipients(t *testing.T) {
+	rcptAddr := startServer(t, rcptCodeBackend())
+
+	rcpts := []string{
+		"bob@external.com",
+		"rcpt450@external.com",
+		"rcpt451@external.com",
+		"rcpt551@external.com",
+		"rcpt553@external.com",
+	}
+
+	c := New(WithServerAddresses(rcptAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	res, err := c.SendReport(
+		context.Background(),
+		"alice@internal.com",
+		nil,
+		rcpts,
+		nil,
+		func() io.Reader { return bytes.NewBufferString("Hello World!") },
+	)
+	require.NoError(t, err)
+	require.Len(t, res.Recipients, 5)
+
+	require.True(t, res.Recipients[0].Delivered())
+	require.Equal(t, rcptAddr, res.Recipients[0].Server)
+	require.Equal(t, 250, res.Recipients[0].Rcpt.Code)
+	require.Equal(t, 250, res.Recipients[0].Data.Code)
+
+	for _, r := range res.Recipients[1:3] {
+		require.True(t, r.Temporary(), r.Address)
+		require.Nil(t, r.Data)
+	}
+	for _, r := range res.Recipients[3:] {
+		require.True(t, r.Permanent(), r.Address)
+		require.Equal(t, smtp.EnhancedCode{5, 1, 1}, r.EnhancedCode())
+	}
+
+	require.Len(t, res.Failures, 4)
+	require.Len(t, res.Responses, 1)
+	require.Equal(t, []string{"bob@external.com"}, res.Responses[0].Rcpts)
+}
+
+func TestSendReport_RecipientsRetry(t *testing.T) {
+	rcptAddr := startServer(t, rcptCodeBackend())
+
+	c := New(
+		WithServerAddresses(rcptAddr),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+	)
+	defer func() { _ = c.Disconnect() }()
+
+	res, err := c.SendReport(
+		context.Background(),
+		"alice@internal.com",
+		nil,
+		[]string{"bob@external.com", "rcpt452@external.com", "rcpt550@external.com"},
+		nil,
+		func() io.Reader { return bytes.NewBufferString("Hello World!") },
+	)
+	require.NoError(t, err)
+
+	// only the temporary failure is retried
+	require.Len(t, res.Attempts, 2)
+	require.Equal(t, []string{"rcpt452@external.com"}, res.Attempts[1].Rcpts)
+
+	require.True(t, res.Recipients[0].Delivered())
+	require.True(t, res.Recipients[1].Delivered())
+	require.True(t, res.Recipients[2].Permanent())
+	require.Len(t, res.Responses, 2)
+}
+
+func TestSendReport_NoRecipientAccepted(t *testing.T) {
+	rcptAddr := startServer(t, rcptCodeBackend())
+
+	c := New(WithServerAddresses(rcptAddr))
+	defer func() { _ = c.Disconnect() }()
+
+	res, err := c.SendReport(
+		context.Background(),
+		"alice@internal.com",
+		nil,
+		[]string{"rcpt550@external.com", "rcpt553@external.com"},
+		nil,
+		func() io.Reader { return bytes.NewBufferString("Hello World!") },
+	)
+	require.ErrorIs(t, err, ErrNoRecipientAccepted)
+	require.Len(t, res.Failures, 2)
+	require.Empty(t, res.Responses)
+	require.True(t, res.Recipients[0].Permanent())
+	require.True(t, res.Recipients[1].Permanent())
+
+	// the connection is still usable
+	require.NoError(t, c.Client().Noop())
+}
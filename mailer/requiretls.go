@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+)
+
+// tlsPolicy describes the TLS requirements of a message (RFC 8689).
+type tlsPolicy int
+
+const (
+	// tlsPolicyDefault uses the configured security.
+	tlsPolicyDefault tlsPolicy = iota
+	// tlsPolicyRequire requires a validated TLS connection to a server supporting REQUIRETLS.
+	tlsPolicyRequire
+	// tlsPolicyRelaxed ignores certificate errors, it is requested by the header field "TLS-Required: No".
+	tlsPolicyRelaxed
+)
+
+// maxHeaderPeek is the maximum number of bytes searched for the TLS-Required header field.
+const maxHeaderPeek = 64 * 1024
+
+// tlsPolicy returns the TLS policy of a message. REQUIRETLS takes precedence over the
+// TLS-Required header field, which is only searched if certificate errors prevent the delivery.
+// The returned function must be used instead of in, it returns the peeked message on the first call.
+func (c *Mailer) tlsPolicy(mailOptions *client.MailOptions, in func() io.Reader) (tlsPolicy, func() io.Reader) {
+	if mailOptions != nil && mailOptions.RequireTLS {
+		return tlsPolicyRequire, in
+	}
+
+	if !c.strictTLS() {
+		return tlsPolicyDefault, in
+	}
+
+	first := in()
+	if first == nil {
+		return tlsPolicyDefault, in
+	}
+
+	first, relaxed := peekTLSRequired(first)
+
+	policy := tlsPolicyDefault
+	if relaxed {
+		policy = tlsPolicyRelaxed
+	}
+
+	used := false
+	return policy, func() io.Reader {
+		if !used {
+			used = true
+			return first
+		}
+		return in()
+	}
+}
+
+// strictTLS returns true if certificate errors prevent the delivery.
+func (c *Mailer) strictTLS() bool {
+	return c.cfg.security == SecurityTLS || c.cfg.security == SecurityStartTLS ||
+		c.stsEnforced(tlsPolicyDefault) || c.cfg.dane != nil
+}
+
+// security returns the security used for a connection with the given policy.
+// TLS is mandatory if the server has TLSA records (dane) or the MTA-STS policy is enforced.
+func (c *Mailer) security(policy tlsPolicy, dane bool) Security {
+	switch {
+	case policy == tlsPolicyRelaxed && c.cfg.security == SecurityStartTLS:
+		// the sender allows a plain connection
+		return SecurityPreferStartTLS
+	case (dane || c.stsEnforced(policy)) && c.cfg.security != SecurityTLS:
+		return SecurityStartTLS
+	}
+	return c.cfg.security
+}
+
+// tlsConfig returns the tls config used for a connection with the given policy.
+func (c *Mailer) tlsConfig(policy tlsPolicy) *tls.Config {
+	var skipVerify bool
+
+	switch {
+	case policy == tlsPolicyRelaxed:
+		// requested by the sender (RFC 8689 5)
+		skipVerify = true
+	case c.stsEnforced(policy) && c.cfg.tlsConfig != nil && c.cfg.tlsConfig.InsecureSkipVerify:
+		// the certificate must be valid (RFC 8461 4.2)
+		skipVerify = false
+	default:
+		return c.cfg.tlsConfig
+	}
+
+	cfg := &tls.Config{}
+	if c.cfg.tlsConfig != nil {
+		cfg = c.cfg.tlsConfig.Clone()
+	}
+	// nolint: gosec
+	cfg.InsecureSkipVerify = skipVerify
+	return cfg
+}
+
+// checkRequireTLS returns a 5.7.10 status if the current connection doesn't fulfill REQUIRETLS (RFC 8689 4.1).
+func (c *Mailer) checkRequireTLS() error {
+	state, ok := c.client.TLSConnectionState()
+	if !ok || (len(state.VerifiedChains) == 0 && !c.dane) {
+		return smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 10}, "REQUIRETLS needs a validated TLS connection")
+	}
+	if ok, _ := c.client.Extension("REQUIRETLS"); !ok {
+		return smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 10}, "REQUIRETLS not supported by server")
+	}
+	return nil
+}
+
+// peekTLSRequired returns true if the header of the message contains "TLS-Required: No" (RFC 8689 5).
+// The returned reader returns the complete message, in is rewound if it implements io.Seeker.
+func peekTLSRequired(in io.Reader) (io.Reader, bool) {
+	seeker, seekable := in.(io.Seeker)
+
+	start := int64(0)
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	br := bufio.NewReaderSize(in, maxHeaderPeek)
+	// read errors are returned by br when the message is sent
+	buf, _ := br.Peek(maxHeaderPeek)
+
+	relaxed := headerTLSRequiredNo(buf)
+
+	if seekable {
+		if _, err := seeker.Seek(start, io.SeekStart); err == nil {
+			return in, relaxed
+		}
+	}
+
+	if l, ok := in.(Len); ok {
+		return &lenReader{Reader: br, length: br.Buffered() + l.Len()}, relaxed
+	}
+	return br, relaxed
+}
+
+// headerTLSRequiredNo searches the header for the field "TLS-Required: No".
+func headerTLSRequiredNo(buf []byte) bool {
+	for line := range bytes.Lines(buf) {
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			// end of header
+			return false
+		}
+
+		key, value, ok := bytes.Cut(line, []byte{':'})
+		if ok && strings.EqualFold(string(bytes.TrimSpace(key)), "TLS-Required") {
+			return strings.EqualFold(string(bytes.TrimSpace(value)), "No")
+		}
+	}
+	return false
+}
+
+// lenReader is a reader with a known length.
+type lenReader struct {
+	io.Reader
+	length int
+}
+
+func (r *lenReader) Len() int {
+	return r.length
+}
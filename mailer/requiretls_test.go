@@ -0,0 +1,168 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// startTLSServer starts a server with a self signed certificate for localhost and
+// returns the address and a tls config trusting it.
+func startTLSServer(t *testing.T, be *tester.Backend, opts ...server.Option) (string, *tls.Config) {
+	cert, err := tester.GenX509KeyPair("localhost")
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	addr := startServer(t, be, append([]server.Option{
+		server.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	}, opts...)...)
+
+	return addr, &tls.Config{ServerName: "localhost", RootCAs: pool}
+}
+
+func sendRequireTLS(t *testing.T, c *Mailer, eml string) Report {
+	res, err := c.SendReport(
+		context.Background(),
+		"alice@example.com",
+		&client.MailOptions{RequireTLS: true},
+		[]string{"bob@example.com"},
+		nil,
+		func() io.Reader { return strings.NewReader(eml) },
+	)
+	if len(res.Failures) == 0 {
+		require.NoError(t, err)
+	}
+	return res
+}
+
+func TestRequireTLS(t *testing.T) {
+	be := tester.NewBackend()
+	tlsAddr, tlsConfig := startTLSServer(t, be, server.WithEnableREQUIRETLS(true))
+
+	c := New(WithServerAddresses(tlsAddr), WithTLSConfig(tlsConfig))
+	defer func() { _ = c.Disconnect() }()
+
+	res := sendRequireTLS(t, c, "Subject: secret\r\n\r\nHello\r\n")
+	require.Empty(t, res.Failures)
+
+	_, ok := be.Load("alice@example.com", []string{"bob@example.com"})
+	require.True(t, ok)
+}
+
+func TestRequireTLS_Failures(t *testing.T) {
+	plainAddr := startServer(t, tester.NewBackend(), server.WithEnableREQUIRETLS(true))
+	tlsAddr, tlsConfig := startTLSServer(t, tester.NewBackend())
+	requireTLSAddr, _ := startTLSServer(t, tester.NewBackend(), server.WithEnableREQUIRETLS(true))
+
+	testCases := []struct {
+		name string
+		opts []Option
+	}{
+		{"plain", []Option{WithServerAddresses(plainAddr)}},
+		{"unsupported", []Option{WithServerAddresses(tlsAddr), WithTLSConfig(tlsConfig)}},
+		{"unverified", []Option{
+			WithServerAddresses(requireTLSAddr),
+			WithTLSConfig(&tls.Config{InsecureSkipVerify: true}), // nolint: gosec
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(tc.opts...)
+			defer func() { _ = c.Disconnect() }()
+
+			// TLS-Required: No is ignored if REQUIRETLS is set
+			res := sendRequireTLS(t, c, "TLS-Required: No\r\n\r\nHello\r\n")
+			require.Len(t, res.Failures, 1)
+			require.Len(t, res.Attempts, 1)
+			require.True(t, res.Recipients[0].Permanent())
+			require.Equal(t, smtp.EnhancedCode{5, 7, 10}, res.Recipients[0].EnhancedCode())
+		})
+	}
+}
+
+func TestRequireTLS_NextServer(t *testing.T) {
+	be := tester.NewBackend()
+	plainAddr := startServer(t, tester.NewBackend())
+	tlsAddr, tlsConfig := startTLSServer(t, be, server.WithEnableREQUIRETLS(true))
+
+	c := New(WithServerAddresses(plainAddr, tlsAddr), WithTLSConfig(tlsConfig))
+	defer func() { _ = c.Disconnect() }()
+
+	// an existing connection without TLS isn't used
+	require.NoError(t, c.Connect(context.Background()))
+	require.Equal(t, plainAddr, c.ServerAddress())
+
+	_, _, failures, err := c.SendAdvanced(
+		context.Background(),
+		"alice@example.com",
+		&client.MailOptions{RequireTLS: true},
+		[]string{"bob@example.com"},
+		nil,
+		strings.NewReader("Hello\r\n"),
+	)
+	require.NoError(t, err)
+	require.Empty(t, failures)
+	require.Equal(t, tlsAddr, c.ServerAddress())
+
+	_, ok := be.Load("alice@example.com", []string{"bob@example.com"})
+	require.True(t, ok)
+}
+
+func TestTLSRequiredNo(t *testing.T) {
+	be := tester.NewBackend()
+	tlsAddr, _ := startTLSServer(t, be)
+
+	// the certificate isn't trusted
+	c := New(WithServerAddresses(tlsAddr), WithSecurity(SecurityStartTLS))
+	defer func() { _ = c.Disconnect() }()
+
+	send := func(eml string) error {
+		_, _, _, err := c.SendAdvanced(
+			context.Background(), "alice@example.com", nil, []string{"bob@example.com"}, nil, bytes.NewBufferString(eml),
+		)
+		return err
+	}
+
+	require.Error(t, send("Subject: strict\r\n\r\nHello\r\n"))
+	require.False(t, c.Connected())
+
+	require.NoError(t, send("Subject: relaxed\r\ntls-required:  no \r\n\r\nHello\r\n"))
+
+	m, ok := be.Load("alice@example.com", []string{"bob@example.com"})
+	require.True(t, ok)
+	require.Equal(t, "Subject: relaxed\r\ntls-required:  no \r\n\r\nHello\r\n", string(m.Data))
+}
+
+func TestHeaderTLSRequiredNo(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   bool
+	}{
+		{"TLS-Required: No\r\n\r\n", true},
+		{"Subject: test\nTLS-Required:no\n\n", true},
+		{"TLS-Required: Yes\r\n\r\n", false},
+		{"Subject: test\r\n\r\nTLS-Required: No\r\n", false},
+		{"Subject: TLS-Required: No\r\n\r\n", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, headerTLSRequiredNo([]byte(tc.header)), tc.header)
+	}
+}
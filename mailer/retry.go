@@ -0,0 +1,148 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// RetryPolicy defines if and how often a failed delivery is retried.
+// Every retry uses the next server address if more than one is available.
+type RetryPolicy struct {
+	// Maximum number of attempts including the first one.
+	// A value smaller than 2 disables retries.
+	MaxAttempts int
+
+	// Backoff before the first retry.
+	InitialBackoff time.Duration
+
+	// Upper limit of the backoff, zero means unlimited.
+	MaxBackoff time.Duration
+
+	// The backoff is multiplied by Multiplier after every retry.
+	// A value smaller than 1 keeps the backoff constant.
+	Multiplier float64
+
+	// Jitter randomly reduces the backoff by up to the given fraction (0 - 1).
+	Jitter float64
+
+	// Retryable decides if an error is retried.
+	// If nil, IsRetryable is used.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns a retry policy with 3 attempts and an exponential backoff starting at 1 second.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// IsRetryable returns true if err is a temporary failure, that is
+// a 4xx smtp status, a connection reset or refused, an aborted connection,
+// a failed tls handshake or a timeout.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	status := &smtp.Status{}
+	if errors.As(err, &status) {
+		return status.Temporary()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var (
+		recordErr tls.RecordHeaderError
+		alertErr  tls.AlertError
+		certErr   *tls.CertificateVerificationError
+	)
+
+	return errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &certErr)
+}
+
+func (p *RetryPolicy) retryable(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff returns the time to wait after the given attempt (starting with 1).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	if p.Multiplier > 1 {
+		for i := 1; i < attempt; i++ {
+			backoff *= p.Multiplier
+			if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+				break
+			}
+		}
+	}
+
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		// nolint: gosec
+		backoff -= backoff * min(p.Jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(backoff)
+}
+
+// wait blocks for the backoff of the given attempt or until ctx is done.
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Attempt describes a single delivery attempt.
+type Attempt struct {
+	// Address of the server, empty if no server could be tried.
+	Address string
+	// Start of the attempt.
+	Time time.Time
+	// Recipients of the attempt.
+	Rcpts []string
+	// Code and Msg of the final response, if any.
+	Code int
+	Msg  string
+	// Error of the attempt, nil on success.
+	Error error
+	// The certificate of the server was verified with DANE TLSA records.
+	DANE bool
+}
@@ -0,0 +1,186 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{smtp.NewStatus(451, smtp.EnhancedCodeNotSet, "try again"), true},
+		{fmt.Errorf("wrapped: %w", smtp.NewStatus(421, smtp.EnhancedCodeNotSet, "bye")), true},
+		{smtp.NewStatus(550, smtp.EnhancedCodeNotSet, "not found"), false},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{io.EOF, true},
+		{&net.DNSError{IsTimeout: true}, true},
+		{tls.AlertError(40), true},
+		{tls.RecordHeaderError{Msg: "bad"}, true},
+		{errors.New("smtp: server doesn't support STARTTLS"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.err), func(t *testing.T) {
+			require.Equal(t, tc.want, IsRetryable(tc.err))
+		})
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+	}
+
+	require.Equal(t, time.Second, p.backoff(1))
+	require.Equal(t, 2*time.Second, p.backoff(2))
+	require.Equal(t, 4*time.Second, p.backoff(3))
+	require.Equal(t, 8*time.Second, p.backoff(4))
+	require.Equal(t, 10*time.Second, p.backoff(5))
+	require.Equal(t, 10*time.Second, p.backoff(100))
+
+	p.Jitter = 0.5
+	for range 100 {
+		b := p.backoff(2)
+		require.LessOrEqual(t, b, 2*time.Second)
+		require.GreaterOrEqual(t, b, time.Second)
+	}
+
+	p = RetryPolicy{MaxAttempts: 2}
+	require.True(t, p.retryable(1, io.EOF))
+	require.False(t, p.retryable(2, io.EOF))
+
+	p.Retryable = func(error) bool { return false }
+	require.False(t, p.retryable(1, io.EOF))
+}
+
+func TestRetryPolicy_Wait(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, p.wait(ctx, 1), context.Canceled)
+}
+
+// startServer starts a test server with the given backend and returns its address.
+func startServer(t *testing.T, be *tester.Backend, opts ...server.Option) string {
+	srv := tester.Standard(append([]server.Option{server.WithBackend(be)}, opts...)...)
+
+	l, err := srv.Listen()
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return l.Addr().String()
+}
+
+// startRetryServer starts a server which rejects the first failures MAIL commands temporarily.
+func startRetryServer(t *testing.T, failures int32) string {
+	var count atomic.Int32
+
+	return startServer(t, &tester.Backend{
+		Mail: func(_ context.Context, _ string, _ *smtp.MailOptions) error {
+			if count.Add(1) <= failures {
+				return smtp.NewStatus(451, smtp.EnhancedCode{4, 3, 0}, "try again later")
+			}
+			return nil
+		},
+	})
+}
+
+func TestSendReport_Retry(t *testing.T) {
+	retryAddr := startRetryServer(t, 2)
+
+	c := New(
+		WithServerAddresses(retryAddr),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+	)
+	defer func() { _ = c.Disconnect() }()
+
+	res, err := c.SendReport(
+		context.Background(),
+		"alice@internal.com",
+		nil,
+		[]string{"bob@external.com"},
+		nil,
+		func() io.Reader { return bytes.NewBufferString("Hello World!") },
+	)
+	require.NoError(t, err)
+	require.Len(t, res.Attempts, 3)
+	require.ErrorContains(t, res.Attempts[0].Error, "451")
+	require.ErrorContains(t, res.Attempts[1].Error, "451")
+	require.NoError(t, res.Attempts[2].Error)
+	require.Equal(t, 250, res.Attempts[2].Code)
+	require.Equal(t, retryAddr, res.Attempts[2].Address)
+	require.Len(t, res.Responses, 1)
+	require.Equal(t, []string{"bob@external.com"}, res.Responses[0].Rcpts)
+}
+
+func TestSendReport_RetryExhausted(t *testing.T) {
+	retryAddr := startRetryServer(t, 5)
+
+	c := New(
+		WithServerAddresses(retryAddr),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+	)
+	defer func() { _ = c.Disconnect() }()
+
+	res, err := c.SendReport(
+		context.Background(),
+		"alice@internal.com",
+		nil,
+		[]string{"bob@external.com"},
+		nil,
+		func() io.Reader { return bytes.NewBufferString("Hello World!") },
+	)
+	require.ErrorContains(t, err, "451")
+	require.Len(t, res.Attempts, 2)
+	require.Empty(t, res.Responses)
+}
+
+func TestSendReport_RetryNextServer(t *testing.T) {
+	// the first server rejects temporarily, the retry must use the second one
+	retryAddr := startRetryServer(t, 1)
+
+	res, err := Send(
+		context.Background(),
+		"alice@internal.com",
+		[]string{"bob@external.com"},
+		func() io.Reader { return bytes.NewBufferString("Hello World!") },
+		WithServerAddresses(retryAddr, addr),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+	)
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+	require.Len(t, res.Attempts, 2)
+	require.Equal(t, retryAddr, res.Attempts[0].Address)
+	require.ErrorContains(t, res.Attempts[0].Error, "451")
+	require.Equal(t, addr, res.Attempts[1].Address)
+	require.NoError(t, res.Attempts[1].Error)
+}
@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Selector decides in which order the addresses of one preference group are tried.
+// RFC 5321 (Section 5.1) asks senders to randomize among hosts with the same preference.
+// A Selector can be shared between multiple mailers and must be safe for concurrent use.
+type Selector interface {
+	// Select returns the addresses of a preference group in the order they should be tried.
+	// Addresses which are left out are skipped, if all addresses of all groups are left out
+	// they are tried in the configured order.
+	// The given slice must not be modified.
+	Select(addresses []string) []string
+
+	// Report is called after a connection attempt to address, err is nil on success.
+	Report(address string, err error)
+}
+
+// staticSelector rotates the addresses by a fixed index, it is used if no selector is configured.
+type staticSelector struct {
+	index int
+}
+
+// Select implements the Selector interface.
+func (s staticSelector) Select(addresses []string) []string {
+	res := make([]string, len(addresses))
+	for i := range addresses {
+		res[i] = addresses[(i+s.index)%len(addresses)]
+	}
+	return res
+}
+
+// Report implements the Selector interface.
+func (staticSelector) Report(_ string, _ error) {}
+
+type randomSelector struct{}
+
+// NewRandomSelector returns a selector which shuffles addresses of the same preference.
+func NewRandomSelector() Selector {
+	return randomSelector{}
+}
+
+// Select implements the Selector interface.
+func (randomSelector) Select(addresses []string) []string {
+	res := slices.Clone(addresses)
+	// nolint: gosec
+	rand.Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	return res
+}
+
+// Report implements the Selector interface.
+func (randomSelector) Report(_ string, _ error) {}
+
+type roundRobinSelector struct {
+	locker sync.Mutex
+	next   int
+}
+
+// NewRoundRobinSelector returns a selector which starts with the next address on every use.
+func NewRoundRobinSelector() Selector {
+	return &roundRobinSelector{}
+}
+
+// Select implements the Selector interface.
+func (s *roundRobinSelector) Select(addresses []string) []string {
+	s.locker.Lock()
+	index := s.next
+	s.next++
+	s.locker.Unlock()
+
+	return staticSelector{index: index}.Select(addresses)
+}
+
+// Report implements the Selector interface.
+func (*roundRobinSelector) Report(_ string, _ error) {}
+
+type failureAwareSelector struct {
+	selector Selector
+	cooldown time.Duration
+	now      func() time.Time
+
+	locker sync.Mutex
+	failed map[string]time.Time // address => time of the last failure
+}
+
+// NewFailureAwareSelector returns a selector which remembers failed addresses and
+// skips them until cooldown has passed. Failed authentications and cancellations aren't
+// considered failures of the address. The order of the remaining addresses is
+// defined by selector, if selector is nil the order isn't changed.
+func NewFailureAwareSelector(selector Selector, cooldown time.Duration) Selector {
+	if selector == nil {
+		selector = staticSelector{}
+	}
+	return &failureAwareSelector{
+		selector: selector,
+		cooldown: cooldown,
+		now:      time.Now,
+		failed:   map[string]time.Time{},
+	}
+}
+
+// Select implements the Selector interface.
+func (s *failureAwareSelector) Select(addresses []string) []string {
+	ordered := s.selector.Select(addresses)
+	now := s.now()
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	res := make([]string, 0, len(ordered))
+	for _, address := range ordered {
+		if failed, ok := s.failed[address]; ok {
+			if now.Sub(failed) < s.cooldown {
+				continue
+			}
+			delete(s.failed, address)
+		}
+		res = append(res, address)
+	}
+	return res
+}
+
+// Report implements the Selector interface.
+func (s *failureAwareSelector) Report(address string, err error) {
+	s.selector.Report(address, err)
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if err != nil {
+		s.failed[address] = s.now()
+	} else {
+		delete(s.failed, address)
+	}
+}
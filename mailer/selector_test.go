@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector_Static(t *testing.T) {
+	addresses := []string{"a:25", "b:25", "c:25"}
+
+	require.Equal(t, addresses, staticSelector{}.Select(addresses))
+	require.Equal(t, []string{"b:25", "c:25", "a:25"}, staticSelector{index: 1}.Select(addresses))
+	require.Equal(t, []string{"c:25", "a:25", "b:25"}, staticSelector{index: 5}.Select(addresses))
+}
+
+func TestSelector_Random(t *testing.T) {
+	addresses := []string{"a:25", "b:25", "c:25"}
+	s := NewRandomSelector()
+
+	seen := map[string]bool{}
+	for range 100 {
+		res := s.Select(addresses)
+		require.ElementsMatch(t, addresses, res)
+		seen[res[0]] = true
+	}
+
+	// every address should be tried first at least once
+	require.Len(t, seen, 3)
+	require.Equal(t, []string{"a:25", "b:25", "c:25"}, addresses)
+}
+
+func TestSelector_RoundRobin(t *testing.T) {
+	addresses := []string{"a:25", "b:25", "c:25"}
+	s := NewRoundRobinSelector()
+
+	require.Equal(t, []string{"a:25", "b:25", "c:25"}, s.Select(addresses))
+	require.Equal(t, []string{"b:25", "c:25", "a:25"}, s.Select(addresses))
+	require.Equal(t, []string{"c:25", "a:25", "b:25"}, s.Select(addresses))
+	require.Equal(t, []string{"a:25", "b:25", "c:25"}, s.Select(addresses))
+}
+
+func TestSelector_FailureAware(t *testing.T) {
+	addresses := []string{"a:25", "b:25", "c:25"}
+
+	now := time.Now()
+	s := NewFailureAwareSelector(nil, time.Minute).(*failureAwareSelector)
+	s.now = func() time.Time { return now }
+
+	require.Equal(t, addresses, s.Select(addresses))
+
+	s.Report("b:25", errors.New("connection refused"))
+	require.Equal(t, []string{"a:25", "c:25"}, s.Select(addresses))
+
+	now = now.Add(30 * time.Second)
+	s.Report("a:25", errors.New("connection refused"))
+	require.Equal(t, []string{"c:25"}, s.Select(addresses))
+
+	// cooldown of b is over
+	now = now.Add(31 * time.Second)
+	require.Equal(t, []string{"b:25", "c:25"}, s.Select(addresses))
+
+	// success removes a from failures
+	s.Report("a:25", nil)
+	require.Equal(t, addresses, s.Select(addresses))
+}
+
+func TestSelector_FailureAwareConnect(t *testing.T) {
+	selector := NewFailureAwareSelector(NewRoundRobinSelector(), time.Hour)
+
+	c := New(WithServerAddresses("127.0.0.1:1", addr), WithSelector(selector))
+	require.NoError(t, c.Connect(context.Background()))
+	require.Equal(t, addr, c.ServerAddress())
+	require.NoError(t, c.Disconnect())
+
+	// the invalid address is skipped now
+	c = New(WithServerAddresses("127.0.0.1:1", addr), WithSelector(selector))
+	require.NoError(t, c.Connect(context.Background()))
+	require.Equal(t, addr, c.ServerAddress())
+	require.NoError(t, c.Disconnect())
+
+	// all addresses failed recently, they are tried anyway
+	c = New(WithServerAddresses("127.0.0.1:1"), WithSelector(selector))
+	require.ErrorContains(t, c.Connect(context.Background()), "connection refused")
+
+	// a cancellation isn't a failure of the address
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = New(WithServerAddresses(addr), WithSelector(selector))
+	require.Error(t, c.Connect(ctx))
+	require.Equal(t, []string{addr}, selector.Select([]string{addr}))
+}
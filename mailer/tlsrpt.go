@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/dane"
+	"github.com/uponusolutions/go-smtp/mtasts"
+	"github.com/uponusolutions/go-smtp/tlsrpt"
+)
+
+// errNoSTARTTLS is returned if STARTTLS is required but not supported by the server.
+var errNoSTARTTLS = errors.New("smtp: server doesn't support STARTTLS")
+
+// startTLSError marks a rejected STARTTLS command as not supported for TLS reporting.
+func startTLSError(err error) error {
+	status := &smtp.Status{}
+	if errors.As(err, &status) {
+		return errors.Join(errNoSTARTTLS, err)
+	}
+	return err
+}
+
+// tlsResult returns the result type of a failed TLS negotiation, empty if err isn't caused by TLS.
+func tlsResult(err error) tlsrpt.ResultType {
+	switch {
+	case errors.Is(err, errNoSTARTTLS):
+		return tlsrpt.StartTLSNotSupported
+	case errors.Is(err, dane.ErrNoMatch):
+		return tlsrpt.ValidationFailure
+	}
+	return tlsrpt.Classify(err)
+}
+
+// reportTLS records the outcome of a TLS negotiation with addr, err is nil on success.
+// Errors which aren't caused by TLS (e.g. connection errors) aren't recorded.
+func (c *Mailer) reportTLS(addr string, records []dane.TLSA, err error) {
+	if err == nil {
+		if c.cfg.tlsrpt != nil && c.cfg.domain != "" {
+			c.cfg.tlsrpt.Success(c.tlsrptPolicy(records))
+		}
+		return
+	}
+
+	if result := tlsResult(err); result != "" {
+		c.reportTLSResult(addr, records, result, err)
+	}
+}
+
+// reportTLSResult records a failed TLS negotiation with addr.
+func (c *Mailer) reportTLSResult(addr string, records []dane.TLSA, result tlsrpt.ResultType, err error) {
+	if c.cfg.tlsrpt == nil || c.cfg.domain == "" {
+		return
+	}
+
+	failure := tlsrpt.Failure{ResultType: result, FailureReasonCode: err.Error()}
+	if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil {
+		if net.ParseIP(host) != nil {
+			failure.ReceivingIP = host
+		} else {
+			failure.ReceivingMXHostname = host
+		}
+	}
+
+	c.cfg.tlsrpt.Failure(c.tlsrptPolicy(records), failure)
+}
+
+// tlsrptPolicy returns the policy applied to the connection, DANE takes precedence over MTA-STS.
+func (c *Mailer) tlsrptPolicy(records []dane.TLSA) tlsrpt.Policy {
+	policy := tlsrpt.Policy{Type: tlsrpt.PolicyTypeNoPolicy, Domain: c.cfg.domain}
+
+	switch {
+	case len(records) > 0:
+		policy.Type = tlsrpt.PolicyTypeTLSA
+		for _, record := range records {
+			policy.String = append(policy.String, record.String())
+		}
+	case c.cfg.stsPolicy != nil && c.cfg.stsPolicy.Mode != mtasts.ModeNone:
+		policy.Type = tlsrpt.PolicyTypeSTS
+		policy.String = strings.Split(strings.TrimSuffix(c.cfg.stsPolicy.String(), "\n"), "\n")
+		policy.MXHost = c.cfg.stsPolicy.MX
+	}
+
+	return policy
+}
+
+// reportSTSError records a failed discovery of the MTA-STS policy of domain.
+func reportSTSError(collector *tlsrpt.Collector, domain string, err error) {
+	if collector == nil {
+		return
+	}
+
+	result := tlsrpt.STSPolicyFetchError
+	switch {
+	case errors.Is(err, mtasts.ErrInvalidPolicy):
+		result = tlsrpt.STSPolicyInvalid
+	case tlsrpt.Classify(err) != "":
+		// the certificate of the policy host is invalid
+		result = tlsrpt.STSWebPKIInvalid
+	}
+
+	collector.Failure(
+		tlsrpt.Policy{Type: tlsrpt.PolicyTypeSTS, Domain: domain},
+		tlsrpt.Failure{ResultType: result, FailureReasonCode: err.Error()},
+	)
+}
@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/dane"
+	"github.com/uponusolutions/go-smtp/mtasts"
+	"github.com/uponusolutions/go-smtp/tester"
+	"github.com/uponusolutions/go-smtp/tlsrpt"
+)
+
+// newTLSRPTMailer returns a mailer for the recipient domain example.com which reports to collector.
+func newTLSRPTMailer(collector *tlsrpt.Collector, policy *mtasts.Policy, opts ...Option) *Mailer {
+	cfg := NewConfig(append([]Option{WithTLSReporting(collector)}, opts...)...)
+	cfg.extra.domain = "example.com"
+	cfg.extra.stsPolicy = policy
+	return NewFromConfig(cfg)
+}
+
+// policyReports returns the policy reports of example.com.
+func policyReports(t *testing.T, collector *tlsrpt.Collector) []tlsrpt.PolicyReport {
+	reports := collector.Reports()
+	require.Len(t, reports, 1)
+	require.Equal(t, "example.com", reports[0].Domain())
+	return reports[0].Policies
+}
+
+func TestTLSReporting_DANE(t *testing.T) {
+	collector := tlsrpt.New()
+
+	addr, record := startDANEServer(t, tester.NewBackend())
+	mismatch := record
+	mismatch.Data = make([]byte, len(record.Data))
+
+	c := newTLSRPTMailer(collector, nil,
+		WithServerAddresses(addr), WithDANE(daneResolver{records: []dane.TLSA{record}, authenticated: true}))
+	defer func() { _ = c.Disconnect() }()
+
+	_, err := sendDANE(c, nil)
+	require.NoError(t, err)
+
+	c2 := newTLSRPTMailer(collector, nil,
+		WithServerAddresses(addr), WithDANE(daneResolver{records: []dane.TLSA{mismatch}, authenticated: true}))
+	defer func() { _ = c2.Disconnect() }()
+
+	_, err = sendDANE(c2, nil)
+	require.Error(t, err)
+
+	policies := policyReports(t, collector)
+	require.Len(t, policies, 2)
+
+	require.Equal(t, tlsrpt.PolicyTypeTLSA, policies[0].Policy.Type)
+	require.Equal(t, []string{record.String()}, policies[0].Policy.String)
+	require.Equal(t, tlsrpt.Summary{Successful: 1}, policies[0].Summary)
+
+	require.Equal(t, []string{mismatch.String()}, policies[1].Policy.String)
+	require.Equal(t, tlsrpt.Summary{Failure: 1}, policies[1].Summary)
+	require.Len(t, policies[1].FailureDetails, 1)
+
+	failure := policies[1].FailureDetails[0]
+	require.Equal(t, tlsrpt.ValidationFailure, failure.ResultType)
+	host, _, _ := net.SplitHostPort(addr)
+	require.Equal(t, host, failure.ReceivingIP)
+	require.Contains(t, failure.FailureReasonCode, "TLSA")
+}
+
+func TestTLSReporting_StartTLS(t *testing.T) {
+	collector := tlsrpt.New()
+	policy := &mtasts.Policy{Mode: mtasts.ModeTesting, MX: []string{"localhost"}, MaxAge: 24 * time.Hour}
+
+	// the server doesn't offer STARTTLS
+	plainAddr := startServer(t, tester.NewBackend())
+	c := newTLSRPTMailer(collector, policy, WithServerAddresses(plainAddr))
+	defer func() { _ = c.Disconnect() }()
+	require.NoError(t, sendSTS(c))
+
+	// the certificate isn't trusted, the message is sent without TLS
+	tlsAddr, _ := startTLSServer(t, tester.NewBackend())
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	c2 := newTLSRPTMailer(collector, policy, WithServerAddresses(net.JoinHostPort("localhost", port)))
+	defer func() { _ = c2.Disconnect() }()
+	require.NoError(t, sendSTS(c2))
+
+	policies := policyReports(t, collector)
+	require.Len(t, policies, 1)
+
+	require.Equal(t, tlsrpt.PolicyTypeSTS, policies[0].Policy.Type)
+	require.Equal(t, []string{"version: STSv1", "mode: testing", "mx: localhost", "max_age: 86400"},
+		policies[0].Policy.String)
+	require.Equal(t, []string{"localhost"}, policies[0].Policy.MXHost)
+	require.Equal(t, tlsrpt.Summary{Failure: 2}, policies[0].Summary)
+	require.Len(t, policies[0].FailureDetails, 2)
+
+	require.Equal(t, tlsrpt.StartTLSNotSupported, policies[0].FailureDetails[0].ResultType)
+	host, _, _ := net.SplitHostPort(plainAddr)
+	require.Equal(t, host, policies[0].FailureDetails[0].ReceivingIP)
+	require.Equal(t, tlsrpt.CertificateNotTrusted, policies[0].FailureDetails[1].ResultType)
+	require.Equal(t, "localhost", policies[0].FailureDetails[1].ReceivingMXHostname)
+}
+
+func TestTLSReporting_NoDomain(t *testing.T) {
+	collector := tlsrpt.New()
+
+	addr := startServer(t, tester.NewBackend())
+	c := New(WithServerAddresses(addr), WithTLSReporting(collector))
+	defer func() { _ = c.Disconnect() }()
+
+	// the recipient domain is unknown if the server addresses are configured
+	require.NoError(t, sendSTS(c))
+	require.Empty(t, collector.Reports())
+}
+
+func TestReportSTSError(t *testing.T) {
+	collector := tlsrpt.New()
+
+	reportSTSError(collector, "example.com", context.DeadlineExceeded)
+	reportSTSError(collector, "example.com", mtasts.ErrInvalidPolicy)
+
+	policies := policyReports(t, collector)
+	require.Len(t, policies, 1)
+	require.Equal(t, tlsrpt.PolicyTypeSTS, policies[0].Policy.Type)
+	require.Equal(t, tlsrpt.STSPolicyFetchError, policies[0].FailureDetails[0].ResultType)
+	require.Equal(t, tlsrpt.STSPolicyInvalid, policies[0].FailureDetails[1].ResultType)
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+)
+
+// errNotSeekable is returned by SendVERP if the message can't be sent more than once.
+var errNotSeekable = errors.New("mailer: message must implement io.Seeker to be sent with VERP")
+
+// SendVERP sends an email like SendAdvanced but in one transaction per recipient, each with the
+// recipient encoded into the envelope sender (see smtp.VERP). A bounce to
+// list-bounces+user=example.com@list.example identifies the failed recipient user@example.com.
+//
+// All transactions use the current connection (a new one is only opened if required).
+// If there is more than one recipient, in must implement io.Seeker as it is sent multiple times.
+// The outcome for every recipient is returned, the error is set if no recipient was delivered.
+func (c *Mailer) SendVERP(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	in io.Reader,
+) ([]Recipient, error) {
+	if _, ok := in.(io.Seeker); !ok && len(rcpts) > 1 {
+		return failedRecipients(rcpts, errNotSeekable), errNotSeekable
+	}
+	if len(rcpts) == 0 {
+		return nil, errNoRecipients
+	}
+
+	policy, next := c.tlsPolicy(mailOptions, rewind(in))
+
+	recipients := make([]Recipient, 0, len(rcpts))
+	delivered := false
+	var err error
+
+	for i, rcpt := range rcpts {
+		var opts []*smtp.RcptOptions
+		if len(rcptsOptions) > i {
+			opts = rcptsOptions[i : i+1]
+		}
+
+		var sent []Recipient
+		_, sent, err = c.transactions(ctx, smtp.VERP(from, rcpt), mailOptions, []string{rcpt}, opts, policy, next)
+		recipients = append(recipients, sent...)
+		delivered = delivered || len(deliveredRcpts(sent)) > 0
+
+		// errors without smtp status (e.g. connection errors) abort the remaining recipients
+		status := &smtp.Status{}
+		if err != nil && !errors.Is(err, ErrNoRecipientAccepted) && !errors.As(err, &status) {
+			recipients = append(recipients, failedRecipients(rcpts[i+1:], err)...)
+			break
+		}
+	}
+
+	if delivered {
+		return recipients, nil
+	}
+	return recipients, err
+}
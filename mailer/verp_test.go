@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+func TestSendVERP(t *testing.T) {
+	be := &tester.Backend{
+		Rcpt: func(_ context.Context, to string, _ *smtp.RcptOptions) error {
+			if to == "notfound@external.com" {
+				return smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "not found")
+			}
+			return nil
+		},
+	}
+	c := New(WithServerAddresses(startServer(t, be)))
+	defer func() { _ = c.Disconnect() }()
+
+	rcpts := []string{"a@external.com", "notfound@external.com", "b+tag@external.com"}
+	recipients, err := c.SendVERP(context.Background(), "list-bounces@internal.com", nil, rcpts, nil,
+		strings.NewReader("Hello World!\r\n"))
+	require.NoError(t, err)
+	require.Len(t, recipients, 3)
+
+	require.True(t, recipients[0].Delivered())
+	require.True(t, recipients[1].Permanent())
+	require.True(t, recipients[2].Delivered())
+
+	mail, ok := be.Load("list-bounces+a=external.com@internal.com", []string{"a@external.com"})
+	require.True(t, ok)
+	require.Equal(t, "Hello World!\r\n", string(mail.Data))
+
+	_, ok = be.Load("list-bounces+b+tag=external.com@internal.com", []string{"b+tag@external.com"})
+	require.True(t, ok)
+
+	// nothing delivered
+	recipients, err = c.SendVERP(context.Background(), "list-bounces@internal.com", nil,
+		[]string{"notfound@external.com"}, nil, strings.NewReader("Hello World!\r\n"))
+	require.ErrorIs(t, err, ErrNoRecipientAccepted)
+	require.True(t, recipients[0].Permanent())
+
+	// a message sent multiple times must be seekable
+	_, err = c.SendVERP(context.Background(), "list-bounces@internal.com", nil, rcpts, nil, tester.NewBuffer(nil))
+	require.ErrorIs(t, err, errNotSeekable)
+}
+
+func TestSendVERP_Connection(t *testing.T) {
+	limitAddr, connections := startLimitsServer(t, "RCPTMAX=10")
+
+	c := New(WithServerAddresses(limitAddr), WithSecurity(SecurityPlain))
+	defer func() { _ = c.Disconnect() }()
+
+	rcpts := []string{"a@external.com", "b@external.com", "c@external.com"}
+	recipients, err := c.SendVERP(context.Background(), "list-bounces@internal.com", nil, rcpts, nil,
+		strings.NewReader("Hello World!\r\n"))
+	require.NoError(t, err)
+	for _, r := range recipients {
+		require.True(t, r.Delivered(), r.Address)
+	}
+
+	// all transactions use the same connection
+	require.Equal(t, int32(1), connections.Load())
+}
@@ -0,0 +1,139 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp/internal/idna"
+)
+
+// addressFields are the header fields containing address lists.
+var addressFields = map[string]bool{
+	"from":          true,
+	"sender":        true,
+	"reply-to":      true,
+	"to":            true,
+	"cc":            true,
+	"bcc":           true,
+	"resent-from":   true,
+	"resent-sender": true,
+	"resent-to":     true,
+	"resent-cc":     true,
+	"resent-bcc":    true,
+}
+
+// unstructuredFields are the header fields which can be encoded with RFC 2047 as a whole.
+var unstructuredFields = map[string]bool{
+	"subject":             true,
+	"comments":            true,
+	"keywords":            true,
+	"content-description": true,
+}
+
+// Downgrade converts the header of a message with UTF-8 header fields (RFC 6532) for servers
+// without SMTPUTF8, similar to RFC 6857:
+//   - unstructured fields and display names are encoded with RFC 2047,
+//   - domains of addresses are converted to A-labels,
+//   - addresses with a non-ASCII local part are replaced by an encoded-word group (e.g. "=?utf-8?b?...?= :;"),
+//   - all other fields with non-ASCII content are renamed to Downgraded-<name> and encoded with RFC 2047.
+//
+// The body including the header of MIME parts is kept as it is.
+// The header is read immediately, the returned reader implements Len if r does.
+func Downgrade(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	header := &bytes.Buffer{}
+	read := 0
+	field := ""
+
+	for {
+		line, err := br.ReadString('\n')
+		read += len(line)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		// continuation line of a folded field
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			field += line
+		} else {
+			downgradeField(header, field)
+			field = line
+		}
+
+		if err != nil || line == "\r\n" || line == "\n" {
+			break
+		}
+	}
+
+	// the last field or the empty line
+	downgradeField(header, field)
+
+	out := io.MultiReader(header, br)
+	if l, ok := r.(Len); ok {
+		return &lenReader{r: out, remaining: header.Len() + br.Buffered() + l.Len()}, nil
+	}
+	return out, nil
+}
+
+// downgradeField writes the downgraded field, fields without non-ASCII content are written unchanged.
+func downgradeField(b *bytes.Buffer, field string) {
+	key, value, ok := strings.Cut(field, ":")
+	if idna.IsASCII(field) || !ok {
+		b.WriteString(field)
+		return
+	}
+
+	key = strings.TrimSpace(key)
+
+	// unfold the value (RFC 5322 2.2.3)
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(strings.ReplaceAll(value, "\n", ""))
+
+	name := strings.ToLower(key)
+	switch {
+	case addressFields[name]:
+		if addresses, ok := downgradeAddresses(value); ok {
+			writeHeader(b, key, addresses)
+			return
+		}
+	case unstructuredFields[name]:
+		writeHeader(b, key, encodeText(value))
+		return
+	}
+
+	writeHeader(b, "Downgraded-"+key, encodeText(value))
+}
+
+// downgradeAddresses converts an address list, it returns false if the list can't be parsed.
+func downgradeAddresses(value string) (string, bool) {
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return "", false
+	}
+
+	res := make([]string, len(list))
+	for i, address := range list {
+		at := strings.LastIndexByte(address.Address, '@')
+		if at < 0 || !idna.IsASCII(address.Address[:at]) {
+			// the address can't be converted, it is kept readable as display name of an empty group (RFC 6857)
+			display := strings.TrimSpace(address.Name + " <" + address.Address + ">")
+			res[i] = mime.BEncoding.Encode("utf-8", display) + " :;"
+			continue
+		}
+
+		domain, err := idna.ToASCII(address.Address[at+1:])
+		if err != nil {
+			return "", false
+		}
+		address.Address = address.Address[:at+1] + domain
+		res[i] = address.String()
+	}
+
+	return strings.Join(res, ", "), true
+}
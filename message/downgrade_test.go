@@ -0,0 +1,85 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/internal/idna"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+func TestDowngrade(t *testing.T) {
+	in := "From: Jörg <joerg@bücher.example>\r\n" +
+		"To: bob@example.com,\r\n =?utf-8?q?Caf=C3=A9?= <café@example.com>\r\n" +
+		"Subject: Grüße\r\n" +
+		"Message-ID: <1@bücher.example>\r\n" +
+		"X-Custom: plain\r\n" +
+		"\r\n" +
+		"Body with ümlauts\r\n"
+
+	r, err := Downgrade(strings.NewReader(in))
+	require.NoError(t, err)
+
+	l, ok := r.(Len)
+	require.True(t, ok)
+	size := l.Len()
+
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, out, size)
+
+	header, body, _ := strings.Cut(string(out), "\r\n\r\n")
+	require.Equal(t, "Body with ümlauts\r\n", body)
+	require.True(t, idna.IsASCII(header), header)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(out))
+	require.NoError(t, err)
+
+	from, err := msg.Header.AddressList("From")
+	require.NoError(t, err)
+	require.Equal(t, "Jörg", from[0].Name)
+	require.Equal(t, "joerg@xn--bcher-kva.example", from[0].Address)
+
+	dec := &mime.WordDecoder{}
+
+	to, err := dec.DecodeHeader(msg.Header.Get("To"))
+	require.NoError(t, err)
+	require.Equal(t, "<bob@example.com>, Café <café@example.com> :;", to)
+
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "Grüße", subject)
+
+	require.Empty(t, msg.Header.Get("Message-ID"))
+	messageID, err := dec.DecodeHeader(msg.Header.Get("Downgraded-Message-ID"))
+	require.NoError(t, err)
+	require.Equal(t, "<1@bücher.example>", messageID)
+
+	require.Equal(t, "plain", msg.Header.Get("X-Custom"))
+}
+
+func TestDowngrade_Unchanged(t *testing.T) {
+	in := "Subject: folded\r\n\tsubject\r\nTo: bob@example.com\r\n\r\nBody"
+
+	// the plain reader doesn't implement Len
+	r, err := Downgrade(tester.NewBuffer([]byte(in)))
+	require.NoError(t, err)
+	_, ok := r.(Len)
+	require.False(t, ok)
+
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, in, string(out))
+
+	// header without body
+	r, err = Downgrade(strings.NewReader("Subject: Grüße"))
+	require.NoError(t, err)
+	out, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "Subject: =?utf-8?b?R3LDvMOfZQ==?=\r\n", string(out))
+}
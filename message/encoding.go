@@ -0,0 +1,160 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encoding is a content transfer encoding (RFC 2045 6).
+type Encoding string
+
+const (
+	// EncodingBase64 encodes the content with base64, used for attachments by default.
+	EncodingBase64 Encoding = "base64"
+	// EncodingQuotedPrintable encodes the content with quoted-printable, suitable for mostly ASCII text.
+	EncodingQuotedPrintable Encoding = "quoted-printable"
+	// Encoding7Bit is used for ASCII text without long lines, the content isn't encoded.
+	Encoding7Bit Encoding = "7bit"
+)
+
+// maxBodyLineLength is the maximum length of a line in the body without CRLF (RFC 5322 2.1.1).
+const maxBodyLineLength = 998
+
+// base64LineLength is the number of input bytes per line of base64 output (76 characters).
+const base64LineLength = 57
+
+// base64Size returns the size of the base64 output for n input bytes including line breaks.
+func base64Size(n int) int {
+	size := n / base64LineLength * (76 + 2)
+	if rest := n % base64LineLength; rest > 0 {
+		size += base64.StdEncoding.EncodedLen(rest) + 2
+	}
+	return size
+}
+
+// base64Reader encodes the content read from src with base64 and line breaks after 76 characters.
+type base64Reader struct {
+	src  io.Reader
+	in   [base64LineLength]byte
+	out  []byte
+	line [76 + 2]byte
+	done bool
+}
+
+func newBase64Reader(src io.Reader) *base64Reader {
+	return &base64Reader{src: src}
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	if len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(r.src, r.in[:])
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			r.done = true
+		default:
+			return 0, err
+		}
+
+		if n == 0 {
+			return 0, io.EOF
+		}
+
+		size := base64.StdEncoding.EncodedLen(n)
+		base64.StdEncoding.Encode(r.line[:size], r.in[:n])
+		r.line[size] = '\r'
+		r.line[size+1] = '\n'
+		r.out = r.line[:size+2]
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// quotedPrintableReader encodes the content read from src with quoted-printable.
+type quotedPrintableReader struct {
+	src  io.Reader
+	in   []byte
+	out  bytes.Buffer
+	w    *quotedprintable.Writer
+	done bool
+}
+
+func newQuotedPrintableReader(src io.Reader) *quotedPrintableReader {
+	r := &quotedPrintableReader{
+		src: src,
+		in:  make([]byte, 4096),
+	}
+	r.w = quotedprintable.NewWriter(&r.out)
+	return r
+}
+
+func (r *quotedPrintableReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 && !r.done {
+		n, err := r.src.Read(r.in)
+		if n > 0 {
+			// writing into a bytes.Buffer can't fail
+			_, _ = r.w.Write(r.in[:n])
+		}
+		if err == io.EOF {
+			_ = r.w.Close()
+			r.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	if r.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.out.Read(p)
+}
+
+// encodeBody encodes a text body, it is kept as it is (7bit) if it is ASCII only
+// without long lines, otherwise quoted-printable is used.
+// Line breaks are converted to CRLF.
+func encodeBody(text string) ([]byte, Encoding) {
+	if is7Bit(text) {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		return []byte(strings.ReplaceAll(text, "\n", "\r\n")), Encoding7Bit
+	}
+
+	b := &bytes.Buffer{}
+	w := quotedprintable.NewWriter(b)
+	// writing into a bytes.Buffer can't fail
+	_, _ = w.Write([]byte(text))
+	_ = w.Close()
+	return b.Bytes(), EncodingQuotedPrintable
+}
+
+// is7Bit returns true if text contains only ASCII without NUL, bare CR
+// and lines longer than 998 characters (RFC 2045 2.7).
+func is7Bit(text string) bool {
+	line := 0
+	for i := range len(text) {
+		c := text[i]
+		switch {
+		case c >= utf8.RuneSelf || c == 0:
+			return false
+		case c == '\r' && (i+1 >= len(text) || text[i+1] != '\n'):
+			return false
+		case c == '\n':
+			line = 0
+		case c != '\r':
+			line++
+			if line > maxBodyLineLength {
+				return false
+			}
+		}
+	}
+	return true
+}
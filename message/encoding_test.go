@@ -0,0 +1,65 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+func TestBase64Reader(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 56, 57, 58, 114, 1000} {
+		data := bytes.Repeat([]byte{0xff, 0x00, 'a'}, size)[:size]
+
+		// the plain reader returns small chunks
+		out, err := io.ReadAll(newBase64Reader(tester.NewBuffer(data)))
+		require.NoError(t, err)
+		require.Len(t, out, base64Size(size), "size %d", size)
+
+		lines := strings.Split(string(out), "\r\n")
+		require.Empty(t, lines[len(lines)-1])
+		for _, line := range lines {
+			require.LessOrEqual(t, len(line), 76)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(out), "\r\n", ""))
+		require.NoError(t, err)
+		require.Equal(t, data, decoded)
+	}
+}
+
+func TestQuotedPrintableReader(t *testing.T) {
+	text := strings.Repeat("Grüße aus Köln = ", 100) + "\n"
+
+	out, err := io.ReadAll(newQuotedPrintableReader(strings.NewReader(text)))
+	require.NoError(t, err)
+
+	for line := range strings.SplitSeq(string(out), "\r\n") {
+		require.LessOrEqual(t, len(line), 76)
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(out)))
+	require.NoError(t, err)
+	require.Equal(t, strings.ReplaceAll(text, "\n", "\r\n"), string(decoded))
+}
+
+func TestEncodeBody(t *testing.T) {
+	body, encoding := encodeBody("Hello\nWorld\r\n")
+	require.Equal(t, Encoding7Bit, encoding)
+	require.Equal(t, "Hello\r\nWorld\r\n", string(body))
+
+	body, encoding = encodeBody("Grüße\n")
+	require.Equal(t, EncodingQuotedPrintable, encoding)
+	require.Equal(t, "Gr=C3=BC=C3=9Fe\r\n", string(body))
+
+	_, encoding = encodeBody(strings.Repeat("a", 999))
+	require.Equal(t, EncodingQuotedPrintable, encoding)
+
+	_, encoding = encodeBody("bare\rcr")
+	require.Equal(t, EncodingQuotedPrintable, encoding)
+}
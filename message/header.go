@@ -0,0 +1,87 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the recommended maximum line length without CRLF (RFC 5322 2.1.1).
+const maxLineLength = 78
+
+// Header is an additional header field of a message.
+type Header struct {
+	Key   string
+	Value string
+}
+
+// validHeader returns an error if key isn't a valid field name or value
+// contains line breaks, which would allow to inject additional header fields.
+func validHeader(key string, value string) error {
+	if key == "" {
+		return fmt.Errorf("message: empty header field name")
+	}
+	for i := range len(key) {
+		// printable US-ASCII except colon (RFC 5322 2.2)
+		if key[i] < 33 || key[i] > 126 || key[i] == ':' {
+			return fmt.Errorf("message: invalid header field name %q", key)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("message: header field %s contains a line break", key)
+	}
+	return nil
+}
+
+// encodeText encodes an unstructured header value with RFC 2047 encoded-words if it isn't plain ASCII.
+// Base64 is used if most of the characters are non-ASCII as it is shorter then.
+func encodeText(s string) string {
+	nonASCII := 0
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			nonASCII++
+		}
+	}
+	if nonASCII > len(s)/3 {
+		return mime.BEncoding.Encode("utf-8", s)
+	}
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// formatAddresses formats an address list, display names are encoded if necessary.
+func formatAddresses(addresses []*mail.Address) string {
+	list := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address != nil {
+			list = append(list, address.String())
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+// writeHeader writes a header field and folds it at spaces
+// to keep the lines shorter than 78 characters if possible (RFC 5322 2.2.3).
+// Words longer than the limit aren't split.
+func writeHeader(b *bytes.Buffer, key string, value string) {
+	b.WriteString(key)
+	b.WriteByte(':')
+
+	length := len(key) + 1
+	empty := true
+
+	for word := range strings.SplitSeq(value, " ") {
+		if !empty && length+1+len(word) > maxLineLength {
+			b.WriteString("\r\n")
+			length = 0
+		}
+		b.WriteByte(' ')
+		b.WriteString(word)
+		length += 1 + len(word)
+		empty = false
+	}
+
+	b.WriteString("\r\n")
+}
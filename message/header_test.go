@@ -0,0 +1,82 @@
+package message
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHeader_Fold(t *testing.T) {
+	b := &bytes.Buffer{}
+	writeHeader(b, "Subject", "short")
+	require.Equal(t, "Subject: short\r\n", b.String())
+
+	b.Reset()
+	value := strings.Repeat("word ", 40)
+	writeHeader(b, "Subject", value)
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	require.Greater(t, len(lines), 1)
+	for i, line := range lines {
+		require.LessOrEqual(t, len(line), maxLineLength)
+		if i > 0 {
+			require.True(t, strings.HasPrefix(line, " "))
+		}
+	}
+
+	// unfolding restores the value
+	require.Equal(t, "Subject: "+value, strings.ReplaceAll(b.String(), "\r\n", ""))
+
+	// a long word isn't split
+	b.Reset()
+	long := strings.Repeat("x", 100)
+	writeHeader(b, "X-Long", long)
+	require.Equal(t, "X-Long: "+long+"\r\n", b.String())
+}
+
+func TestEncodeText(t *testing.T) {
+	dec := &mime.WordDecoder{}
+
+	for _, s := range []string{
+		"Hello World",
+		"Grüße aus Köln am Rhein",
+		"こんにちは世界",
+		strings.Repeat("Ünïcödé ", 20),
+	} {
+		encoded := encodeText(s)
+		for i := range len(encoded) {
+			require.Less(t, encoded[i], byte(128))
+		}
+		decoded, err := dec.DecodeHeader(encoded)
+		require.NoError(t, err)
+		require.Equal(t, s, decoded)
+	}
+
+	require.Equal(t, "Hello World", encodeText("Hello World"))
+	require.True(t, strings.HasPrefix(encodeText("こんにちは世界"), "=?utf-8?b?"))
+	require.True(t, strings.HasPrefix(encodeText("Grüße aus Köln am Rhein"), "=?utf-8?q?"))
+}
+
+func TestValidHeader(t *testing.T) {
+	require.NoError(t, validHeader("X-Custom", "value"))
+	require.Error(t, validHeader("", "value"))
+	require.Error(t, validHeader("X Custom", "value"))
+	require.Error(t, validHeader("X:Custom", "value"))
+	require.Error(t, validHeader("X-Custom", "value\r\nBcc: eve@example.com"))
+}
+
+func TestFormatAddresses(t *testing.T) {
+	require.Equal(t, "=?utf-8?q?Bob_M=C3=BCller?= <bob@example.com>", formatAddresses([]*mail.Address{
+		{Name: "Bob Müller", Address: "bob@example.com"},
+	}))
+
+	require.Equal(t, "<a@example.com>, \"Al Ice\" <b@example.com>", formatAddresses([]*mail.Address{
+		{Address: "a@example.com"},
+		nil,
+		{Name: "Al Ice", Address: "b@example.com"},
+	}))
+}
@@ -0,0 +1,211 @@
+// Package message composes RFC 5322 messages with MIME bodies (RFC 2045 - 2049).
+// The result is a stream which can be sent with the mailer.
+package message
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Len defines the Len method which is implemented by the message reader if the size is known.
+// It is the same as mailer.Len.
+type Len interface {
+	Len() int
+}
+
+// Message is a mail message.
+//
+// The body consists of a text and/or html part (multipart/alternative), inline parts
+// referenced by the html part (multipart/related) and attachments (multipart/mixed).
+type Message struct {
+	// Author of the message, required.
+	From *mail.Address
+
+	// Sender of the message if it differs from the author.
+	Sender *mail.Address
+
+	ReplyTo []*mail.Address
+	To      []*mail.Address
+	Cc      []*mail.Address
+
+	// Bcc recipients aren't written to the header, see Rcpts.
+	Bcc []*mail.Address
+
+	// Subject is encoded with RFC 2047 if necessary.
+	Subject string
+
+	// Date of the message, the current time is used if zero.
+	Date time.Time
+
+	// Message-ID including angle brackets, generated if empty.
+	MessageID string
+
+	// Additional header fields, non-ASCII values are encoded with RFC 2047.
+	Headers []Header
+
+	// Plain text body.
+	Text string
+
+	// Html body.
+	HTML string
+
+	// Attachments, including inline parts.
+	Attachments []Attachment
+}
+
+// NewMessageID returns a new unique message id of the given domain including angle brackets.
+func NewMessageID(domain string) string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
+
+// Rcpts returns the addresses of all recipients (To, Cc and Bcc) to be used for RCPT TO.
+func (m *Message) Rcpts() []string {
+	rcpts := []string{}
+	for _, list := range [][]*mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, address := range list {
+			if address != nil {
+				rcpts = append(rcpts, address.Address)
+			}
+		}
+	}
+	return rcpts
+}
+
+// Reader returns the message as stream.
+// It implements Len (mailer.ReaderLen) if the size of all attachments is known.
+//
+// The content of the attachments is read while the stream is read, so Reader can only be
+// called again if the content of all attachments has been replaced.
+// Message-ID and Date are set on the message if they were empty.
+func (m *Message) Reader() (io.Reader, error) {
+	b := &builder{}
+
+	if err := m.writeHeader(b); err != nil {
+		return nil, err
+	}
+
+	root, err := m.tree()
+	if err != nil {
+		return nil, err
+	}
+
+	b.Write(root.header.Bytes())
+	b.WriteString("\r\n")
+	root.write(b)
+
+	return b.reader(), nil
+}
+
+// WriteTo writes the message to w.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	r, err := m.Reader()
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, r)
+}
+
+// writeHeader writes the header fields of the message except the content fields.
+func (m *Message) writeHeader(b *builder) error {
+	if m.From == nil {
+		return errors.New("message: missing from address")
+	}
+
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+
+	if m.MessageID == "" {
+		domain := m.From.Address[strings.LastIndexByte(m.From.Address, '@')+1:]
+		if domain == "" {
+			domain = "localhost"
+		}
+		m.MessageID = NewMessageID(domain)
+	}
+
+	headers := []Header{
+		{"Date", m.Date.Format(time.RFC1123Z)},
+		{"From", m.From.String()},
+	}
+	if m.Sender != nil {
+		headers = append(headers, Header{"Sender", m.Sender.String()})
+	}
+	for _, h := range []Header{
+		{"Reply-To", formatAddresses(m.ReplyTo)},
+		{"To", formatAddresses(m.To)},
+		{"Cc", formatAddresses(m.Cc)},
+	} {
+		if h.Value != "" {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers, Header{"Message-ID", m.MessageID})
+
+	// unstructured fields, encoded with RFC 2047 if necessary
+	text := []Header{}
+	if m.Subject != "" {
+		text = append(text, Header{"Subject", m.Subject})
+	}
+	for _, h := range append(text, m.Headers...) {
+		if err := validHeader(h.Key, h.Value); err != nil {
+			return err
+		}
+		headers = append(headers, Header{h.Key, encodeText(h.Value)})
+	}
+
+	headers = append(headers, Header{"MIME-Version", "1.0"})
+
+	for _, h := range headers {
+		if err := validHeader(h.Key, h.Value); err != nil {
+			return err
+		}
+		writeHeader(&b.Buffer, h.Key, h.Value)
+	}
+
+	return nil
+}
+
+// tree returns the mime tree of the body.
+func (m *Message) tree() (*node, error) {
+	var root *node
+
+	switch {
+	case m.Text != "" && m.HTML != "":
+		root = multipartNode("alternative", textNode("plain", m.Text), textNode("html", m.HTML))
+	case m.HTML != "":
+		root = textNode("html", m.HTML)
+	default:
+		root = textNode("plain", m.Text)
+	}
+
+	inline := []*node{}
+	attached := []*node{}
+
+	for i := range m.Attachments {
+		n, err := attachmentNode(&m.Attachments[i])
+		if err != nil {
+			return nil, err
+		}
+		if m.Attachments[i].ContentID != "" {
+			inline = append(inline, n)
+		} else {
+			attached = append(attached, n)
+		}
+	}
+
+	if len(inline) > 0 {
+		root = multipartNode("related", append([]*node{root}, inline...)...)
+	}
+	if len(attached) > 0 {
+		root = multipartNode("mixed", append([]*node{root}, attached...)...)
+	}
+
+	return root, nil
+}
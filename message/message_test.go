@@ -0,0 +1,210 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+// read reads the message and checks that Len matches the size if implemented.
+func read(t *testing.T, m *Message) (*mail.Message, bool) {
+	r, err := m.Reader()
+	require.NoError(t, err)
+
+	l, sized := r.(Len)
+	size := 0
+	if sized {
+		size = l.Len()
+	}
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	if sized {
+		require.Equal(t, len(buf), size)
+		require.Equal(t, 0, l.Len())
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(buf))
+	require.NoError(t, err)
+	return msg, sized
+}
+
+func TestMessage_Text(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m := &Message{
+		From:    &mail.Address{Name: "Alice Müller", Address: "alice@example.com"},
+		To:      []*mail.Address{{Address: "bob@example.com"}},
+		Cc:      []*mail.Address{{Name: "Carol", Address: "carol@example.com"}},
+		Bcc:     []*mail.Address{{Address: "dave@example.com"}},
+		Subject: "Grüße",
+		Date:    date,
+		Headers: []Header{{Key: "X-Mailer", Value: "go-smtp"}},
+		Text:    "Hello\nWorld\n",
+	}
+
+	msg, sized := read(t, m)
+	require.True(t, sized)
+
+	require.Equal(t, []string{"bob@example.com", "carol@example.com", "dave@example.com"}, m.Rcpts())
+	require.True(t, strings.HasSuffix(m.MessageID, "@example.com>"))
+	require.Equal(t, m.MessageID, msg.Header.Get("Message-ID"))
+	require.Empty(t, msg.Header.Get("Bcc"))
+	require.Equal(t, "go-smtp", msg.Header.Get("X-Mailer"))
+	require.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+
+	msgDate, err := msg.Header.Date()
+	require.NoError(t, err)
+	require.True(t, date.Equal(msgDate))
+
+	from, err := msg.Header.AddressList("From")
+	require.NoError(t, err)
+	require.Equal(t, "Alice Müller", from[0].Name)
+
+	subject, err := (&mime.WordDecoder{}).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "Grüße", subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", mediaType)
+	require.Equal(t, "utf-8", params["charset"])
+	require.Equal(t, "7bit", msg.Header.Get("Content-Transfer-Encoding"))
+
+	body, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello\r\nWorld\r\n", string(body))
+}
+
+func TestMessage_Multipart(t *testing.T) {
+	pdf := bytes.Repeat([]byte("%PDF"), 100)
+	png := []byte{0x89, 'P', 'N', 'G'}
+
+	m := &Message{
+		From: &mail.Address{Address: "alice@example.com"},
+		To:   []*mail.Address{{Address: "bob@example.com"}},
+		Text: "Hello World",
+		HTML: `<p>Hello <img src="cid:logo@example.com"> Wörld</p>`,
+		Attachments: []Attachment{
+			{Filename: "Rechnung März.pdf", Content: bytes.NewReader(pdf)},
+			{Filename: "logo.png", ContentID: "logo@example.com", Content: bytes.NewReader(png)},
+		},
+	}
+
+	msg, sized := read(t, m)
+	require.True(t, sized)
+
+	mixed := multipartReader(t, msg.Header.Get("Content-Type"), "multipart/mixed", msg.Body)
+
+	related := nextPart(t, mixed)
+	relatedReader := multipartReader(t, related.Header.Get("Content-Type"), "multipart/related", related)
+
+	alternative := nextPart(t, relatedReader)
+	alternativeReader := multipartReader(t, alternative.Header.Get("Content-Type"), "multipart/alternative", alternative)
+
+	text := nextPart(t, alternativeReader)
+	require.Equal(t, "text/plain; charset=utf-8", text.Header.Get("Content-Type"))
+	html := nextPart(t, alternativeReader)
+	require.Equal(t, "text/html; charset=utf-8", html.Header.Get("Content-Type"))
+	htmlBody, err := io.ReadAll(html)
+	require.NoError(t, err)
+	require.Contains(t, string(htmlBody), "Wörld")
+
+	logo := nextPart(t, relatedReader)
+	require.Equal(t, "<logo@example.com>", logo.Header.Get("Content-ID"))
+	require.Equal(t, "image/png", logo.Header.Get("Content-Type")[:9])
+	require.Equal(t, png, decodeBase64(t, logo))
+
+	attachment := nextPart(t, mixed)
+	require.Equal(t, "Rechnung März.pdf", attachment.FileName())
+	require.Equal(t, "attachment", attachment.Header.Get("Content-Disposition")[:10])
+	require.Equal(t, pdf, decodeBase64(t, attachment))
+
+	_, err = mixed.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestMessage_UnknownSize(t *testing.T) {
+	m := &Message{
+		From: &mail.Address{Address: "alice@example.com"},
+		Text: "Hello World",
+		Attachments: []Attachment{
+			{Filename: "a.txt", Content: tester.NewBuffer([]byte("Grüße\n")), Encoding: EncodingQuotedPrintable},
+		},
+	}
+
+	msg, sized := read(t, m)
+	require.False(t, sized)
+
+	mixed := multipartReader(t, msg.Header.Get("Content-Type"), "multipart/mixed", msg.Body)
+	_ = nextPart(t, mixed)
+
+	// multipart decodes quoted-printable
+	attachment := nextPart(t, mixed)
+	content, err := io.ReadAll(attachment)
+	require.NoError(t, err)
+	require.Equal(t, "Grüße\r\n", string(content))
+}
+
+func TestMessage_Errors(t *testing.T) {
+	_, err := (&Message{}).Reader()
+	require.ErrorContains(t, err, "missing from")
+
+	from := &mail.Address{Address: "alice@example.com"}
+
+	_, err = (&Message{From: from, Subject: "Hi\r\nBcc: eve@example.com"}).Reader()
+	require.ErrorContains(t, err, "line break")
+
+	_, err = (&Message{From: from, Headers: []Header{{Key: "Bad Key", Value: "x"}}}).Reader()
+	require.ErrorContains(t, err, "invalid header field name")
+
+	_, err = (&Message{From: from, Attachments: []Attachment{{ContentType: "/"}}}).Reader()
+	require.ErrorContains(t, err, "invalid content type")
+
+	_, err = (&Message{From: from, Attachments: []Attachment{{Encoding: "binary"}}}).Reader()
+	require.ErrorContains(t, err, "unsupported encoding")
+}
+
+func TestMessage_WriteTo(t *testing.T) {
+	m := &Message{
+		From:    &mail.Address{Address: "alice@example.com"},
+		Subject: "Hi",
+		HTML:    "<p>Hi</p>",
+	}
+
+	b := &bytes.Buffer{}
+	n, err := m.WriteTo(b)
+	require.NoError(t, err)
+	require.Equal(t, int64(b.Len()), n)
+	require.Contains(t, b.String(), "Content-Type: text/html; charset=utf-8\r\n")
+	require.True(t, strings.HasSuffix(b.String(), "\r\n\r\n<p>Hi</p>"))
+}
+
+func multipartReader(t *testing.T, contentType string, want string, r io.Reader) *multipart.Reader {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	require.Equal(t, want, mediaType)
+	return multipart.NewReader(r, params["boundary"])
+}
+
+func nextPart(t *testing.T, r *multipart.Reader) *multipart.Part {
+	p, err := r.NextPart()
+	require.NoError(t, err)
+	return p
+}
+
+func decodeBase64(t *testing.T, r io.Reader) []byte {
+	content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+	require.NoError(t, err)
+	return content
+}
@@ -0,0 +1,222 @@
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	// Name of the file, encoded with RFC 2231 if necessary.
+	Filename string
+
+	// Media type of the content, determined from the file name if empty.
+	ContentType string
+
+	// If set, the attachment is added inline and can be referenced in the html body by cid:ContentID.
+	ContentID string
+
+	// Content transfer encoding, base64 if empty.
+	Encoding Encoding
+
+	// Content of the attachment, it is read while the message is read.
+	// The size of the message is only known if it implements Len (e.g. bytes.Reader).
+	Content io.Reader
+}
+
+// node is a part of the mime tree of a message.
+type node struct {
+	header   bytes.Buffer
+	body     func(b *builder)
+	boundary string
+	children []*node
+}
+
+// newBoundary returns a random multipart boundary.
+// It starts with "=_" which can't occur in base64 or quoted-printable content.
+func newBoundary() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return "=_" + hex.EncodeToString(buf)
+}
+
+// multipartNode returns a multipart node with the given subtype and children.
+func multipartNode(subtype string, children ...*node) *node {
+	n := &node{
+		boundary: newBoundary(),
+		children: children,
+	}
+	writeHeader(&n.header, "Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{
+		"boundary": n.boundary,
+	}))
+	return n
+}
+
+// textNode returns a text node with the given subtype (plain or html).
+func textNode(subtype string, text string) *node {
+	body, encoding := encodeBody(text)
+
+	n := &node{
+		body: func(b *builder) {
+			b.Write(body)
+		},
+	}
+	writeHeader(&n.header, "Content-Type", mime.FormatMediaType("text/"+subtype, map[string]string{
+		"charset": "utf-8",
+	}))
+	writeHeader(&n.header, "Content-Transfer-Encoding", string(encoding))
+	return n
+}
+
+// attachmentNode returns a node for an attachment, either inline or as attachment.
+func attachmentNode(a *Attachment) (*node, error) {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(a.Filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("message: invalid content type of attachment %s: %w", a.Filename, err)
+	}
+
+	disposition := "attachment"
+	if a.ContentID != "" {
+		disposition = "inline"
+		if err := validHeader("Content-ID", a.ContentID); err != nil {
+			return nil, err
+		}
+	}
+
+	dispositionParams := map[string]string{}
+	if a.Filename != "" {
+		params["name"] = a.Filename
+		dispositionParams["filename"] = a.Filename
+	}
+
+	n := &node{}
+	writeHeader(&n.header, "Content-Type", mime.FormatMediaType(mediaType, params))
+	writeHeader(&n.header, "Content-Disposition", mime.FormatMediaType(disposition, dispositionParams))
+	if a.ContentID != "" {
+		writeHeader(&n.header, "Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+	}
+
+	content := a.Content
+	if content == nil {
+		content = strings.NewReader("")
+	}
+
+	switch a.Encoding {
+	case "", EncodingBase64:
+		writeHeader(&n.header, "Content-Transfer-Encoding", string(EncodingBase64))
+		n.body = func(b *builder) {
+			size := -1
+			if l, ok := content.(Len); ok {
+				size = base64Size(l.Len())
+			}
+			b.stream(newBase64Reader(content), size)
+		}
+	case EncodingQuotedPrintable:
+		writeHeader(&n.header, "Content-Transfer-Encoding", string(EncodingQuotedPrintable))
+		n.body = func(b *builder) {
+			// the size of quoted-printable content is unknown before encoding
+			b.stream(newQuotedPrintableReader(content), -1)
+		}
+	default:
+		return nil, fmt.Errorf("message: unsupported encoding %s of attachment %s", a.Encoding, a.Filename)
+	}
+
+	return n, nil
+}
+
+// write writes the body of the node, that is the encoded content or all children.
+func (n *node) write(b *builder) {
+	if n.body != nil {
+		n.body(b)
+		return
+	}
+
+	for _, child := range n.children {
+		b.WriteString("--" + n.boundary + "\r\n")
+		b.Write(child.header.Bytes())
+		b.WriteString("\r\n")
+		child.write(b)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--" + n.boundary + "--\r\n")
+}
+
+// segment is a part of the message output, size is negative if unknown.
+type segment struct {
+	r    io.Reader
+	size int
+}
+
+// builder collects the message output as static bytes and streams.
+type builder struct {
+	bytes.Buffer
+	segments []segment
+}
+
+// flush moves the buffered bytes into a segment.
+func (b *builder) flush() {
+	if b.Len() > 0 {
+		buf := bytes.Clone(b.Bytes())
+		b.segments = append(b.segments, segment{r: bytes.NewReader(buf), size: len(buf)})
+		b.Reset()
+	}
+}
+
+// stream adds a stream to the output.
+func (b *builder) stream(r io.Reader, size int) {
+	b.flush()
+	b.segments = append(b.segments, segment{r: r, size: size})
+}
+
+// reader returns the output, it implements Len if the size of all segments is known.
+func (b *builder) reader() io.Reader {
+	b.flush()
+
+	readers := make([]io.Reader, len(b.segments))
+	size := 0
+	for i, s := range b.segments {
+		readers[i] = s.r
+		if size >= 0 && s.size >= 0 {
+			size += s.size
+		} else {
+			size = -1
+		}
+	}
+
+	r := io.MultiReader(readers...)
+	if size < 0 {
+		return r
+	}
+	return &lenReader{r: r, remaining: size}
+}
+
+// lenReader is a reader with a known size.
+type lenReader struct {
+	r         io.Reader
+	remaining int
+}
+
+func (r *lenReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+// Len returns the number of unread bytes.
+func (r *lenReader) Len() int {
+	return r.remaining
+}
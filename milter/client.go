@@ -0,0 +1,457 @@
+package milter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// offered are the protocol steps the client can skip, rejected recipients are never sent.
+const offered = OptProtocol(1<<21-1) &^ OptRcptRejected
+
+// Stage is the command before which macros are sent.
+type Stage byte
+
+const (
+	// StageConnect are macros of the connection.
+	StageConnect = Stage(cmdConnect)
+	// StageHelo are macros of HELO.
+	StageHelo = Stage(cmdHelo)
+	// StageMail are macros of MAIL FROM.
+	StageMail = Stage(cmdMail)
+	// StageRcpt are macros of RCPT TO.
+	StageRcpt = Stage(cmdRcpt)
+	// StageData are macros of DATA.
+	StageData = Stage(cmdData)
+	// StageEOH are macros of the end of the header.
+	StageEOH = Stage(cmdEOH)
+	// StageEOM are macros of the end of the message.
+	StageEOM = Stage(cmdEOB)
+)
+
+// Client connects the MTA to a filter. It is safe for concurrent use.
+type Client struct {
+	network  string
+	address  string
+	timeout  time.Duration
+	actions  OptAction
+	failOpen bool
+}
+
+// Option defines a client option.
+type Option func(c *Client)
+
+// WithTimeout sets the timeout of the connect and of every command (default 30 seconds).
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithActions sets the modifications the filter is allowed to perform (default OptAllActions).
+func WithActions(actions OptAction) Option {
+	return func(c *Client) {
+		c.actions = actions
+	}
+}
+
+// WithFailOpen continues without the filter if it isn't available or fails.
+// By default messages are rejected temporarily in this case.
+func WithFailOpen(failOpen bool) Option {
+	return func(c *Client) {
+		c.failOpen = failOpen
+	}
+}
+
+// NewClient returns a client for the filter listening on address, e.g. NewClient("tcp", "127.0.0.1:11332")
+// or NewClient("unix", "/run/opendkim/opendkim.sock").
+func NewClient(network string, address string, opts ...Option) *Client {
+	c := &Client{
+		network: network,
+		address: address,
+		timeout: 30 * time.Second,
+		actions: OptAllActions,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// FailOpen returns true if the MTA should continue without the filter if it fails.
+func (c *Client) FailOpen() bool {
+	return c.failOpen
+}
+
+// Session connects to the filter and negotiates the options. A session is used for a single
+// SMTP connection and isn't safe for concurrent use.
+func (c *Client) Session(ctx context.Context) (*Session, error) {
+	d := net.Dialer{Timeout: c.timeout}
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{client: c, conn: conn, r: bufio.NewReader(conn)}
+	if err := s.negotiate(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Session is the connection to a filter for a single SMTP connection.
+type Session struct {
+	client   *Client
+	conn     net.Conn
+	r        *bufio.Reader
+	actions  OptAction
+	protocol OptProtocol
+}
+
+// Actions returns the modifications the filter requested to perform.
+func (s *Session) Actions() OptAction {
+	return s.actions
+}
+
+// Protocol returns the protocol steps the filter skips.
+func (s *Session) Protocol() OptProtocol {
+	return s.protocol
+}
+
+// negotiate sends the supported options and reads the options of the filter.
+func (s *Session) negotiate() error {
+	if err := s.write(cmdOptNeg, uint32s(version, uint32(s.client.actions), uint32(offered))); err != nil {
+		return err
+	}
+
+	p, err := s.read()
+	if err != nil {
+		return err
+	}
+	if p.code != cmdOptNeg || len(p.data) < 12 {
+		return fmt.Errorf("%w: unexpected option negotiation response %q", errProtocol, p.code)
+	}
+
+	v := binary.BigEndian.Uint32(p.data)
+	actions := OptAction(binary.BigEndian.Uint32(p.data[4:]))
+	protocol := OptProtocol(binary.BigEndian.Uint32(p.data[8:]))
+
+	switch {
+	case v < 2 || v > version:
+		return fmt.Errorf("milter: unsupported version %d", v)
+	case actions&^s.client.actions != 0:
+		return fmt.Errorf("milter: filter requests unsupported actions 0x%x", uint32(actions&^s.client.actions))
+	case protocol&^offered != 0:
+		return fmt.Errorf("milter: filter requests unsupported protocol options 0x%x", uint32(protocol&^offered))
+	}
+
+	s.actions = actions
+	s.protocol = protocol
+	return nil
+}
+
+// Macros sends macros (name, value pairs) which are valid from the command of the given stage on.
+func (s *Session) Macros(stage Stage, kv ...string) error {
+	if len(kv) == 0 {
+		return nil
+	}
+	return s.write(cmdMacro, []byte{byte(stage)}, cstrings(kv...))
+}
+
+// Connect sends the connection information of the client.
+func (s *Session) Connect(hostname string, addr net.Addr) (*Response, error) {
+	data := cstrings(hostname)
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		family := byte('4')
+		if a.IP.To4() == nil {
+			family = '6'
+		}
+		data = append(data, family)
+		data = binary.BigEndian.AppendUint16(data, uint16(a.Port)) // nolint: gosec
+		data = append(data, cstrings(a.IP.String())...)
+	case *net.UnixAddr:
+		data = append(data, 'L', 0, 0)
+		data = append(data, cstrings(a.Name)...)
+	default:
+		data = append(data, 'U')
+	}
+
+	return s.command(cmdConnect, OptNoConnect, OptNoConnReply, data)
+}
+
+// Helo sends the HELO or EHLO argument.
+func (s *Session) Helo(name string) (*Response, error) {
+	return s.command(cmdHelo, OptNoHelo, OptNoHeloReply, cstrings(name))
+}
+
+// Mail sends the envelope sender and the ESMTP arguments of MAIL FROM.
+func (s *Session) Mail(from string, args []string) (*Response, error) {
+	return s.command(cmdMail, OptNoMailFrom, OptNoMailReply, cstrings(append([]string{"<" + from + ">"}, args...)...))
+}
+
+// Rcpt sends a recipient and the ESMTP arguments of RCPT TO.
+func (s *Session) Rcpt(to string, args []string) (*Response, error) {
+	return s.command(cmdRcpt, OptNoRcptTo, OptNoRcptReply, cstrings(append([]string{"<" + to + ">"}, args...)...))
+}
+
+// Data sends the DATA command.
+func (s *Session) Data() (*Response, error) {
+	return s.command(cmdData, OptNoData, OptNoDataReply, nil)
+}
+
+// Header sends a header field, value is the unfolded value following the colon.
+func (s *Session) Header(name string, value string) (*Response, error) {
+	if s.protocol&OptHeaderLeadingSpace == 0 {
+		value = strings.TrimLeft(value, " \t")
+	}
+	return s.command(cmdHeader, OptNoHeaders, OptNoHeaderReply, cstrings(name, value))
+}
+
+// EndOfHeaders sends the end of the header.
+func (s *Session) EndOfHeaders() (*Response, error) {
+	return s.command(cmdEOH, OptNoEOH, OptNoEOHReply, nil)
+}
+
+// Body sends the body in chunks. It stops if the filter doesn't want more chunks (ActionSkip)
+// or returns another action than ActionContinue.
+func (s *Session) Body(body []byte) (*Response, error) {
+	for len(body) > 0 {
+		chunk := body[:min(len(body), maxChunk)]
+		body = body[len(chunk):]
+
+		res, err := s.command(cmdBody, OptNoBody, OptNoBodyReply, chunk)
+		if err != nil || res.Action != ActionContinue {
+			return res, err
+		}
+	}
+	return &Response{Action: ActionContinue}, nil
+}
+
+// EndOfMessage sends the end of the message and returns the requested modifications and the final response.
+// ActionContinue is returned if the message is accepted.
+func (s *Session) EndOfMessage() ([]Modification, *Response, error) {
+	if err := s.write(cmdEOB, nil); err != nil {
+		return nil, nil, err
+	}
+
+	mods := []Modification{}
+	for {
+		p, err := s.read()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if res, ok := response(p); ok {
+			if res.Action == ActionSkip {
+				return nil, nil, fmt.Errorf("%w: unexpected skip", errProtocol)
+			}
+			return mods, res, nil
+		}
+
+		mod, err := s.modification(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if mod.Action == ModifyReplaceBody && len(mods) > 0 && mods[len(mods)-1].Action == ModifyReplaceBody {
+			mods[len(mods)-1].Body = append(mods[len(mods)-1].Body, mod.Body...)
+			continue
+		}
+		mods = append(mods, mod)
+	}
+}
+
+// Abort aborts the current message, the session can be used for the next message.
+func (s *Session) Abort() error {
+	return s.write(cmdAbort, nil)
+}
+
+// Close ends the session and closes the connection.
+func (s *Session) Close() error {
+	err := s.write(cmdQuit, nil)
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// command sends a command unless the filter skips it and reads the response if the filter replies.
+func (s *Session) command(code byte, skip OptProtocol, noReply OptProtocol, data []byte) (*Response, error) {
+	if s.protocol&skip != 0 {
+		return &Response{Action: ActionContinue}, nil
+	}
+	if err := s.write(code, data); err != nil {
+		return nil, err
+	}
+	if s.protocol&noReply != 0 {
+		return &Response{Action: ActionContinue}, nil
+	}
+
+	p, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	res, ok := response(p)
+	if !ok || (res.Action == ActionSkip && code != cmdBody) {
+		return nil, fmt.Errorf("%w: unexpected response %q", errProtocol, p.code)
+	}
+	return res, nil
+}
+
+// write writes a packet with the timeout of the client.
+func (s *Session) write(code byte, data ...[]byte) error {
+	_ = s.conn.SetDeadline(time.Now().Add(s.client.timeout))
+	return writePacket(s.conn, code, data...)
+}
+
+// read reads the next packet, progress responses extend the timeout.
+func (s *Session) read() (*packet, error) {
+	for {
+		_ = s.conn.SetDeadline(time.Now().Add(s.client.timeout))
+		p, err := readPacket(s.r)
+		if err != nil || p.code != respProgress {
+			return p, err
+		}
+	}
+}
+
+// modification decodes a modification and checks that it was negotiated.
+func (s *Session) modification(p *packet) (Modification, error) {
+	var (
+		mod    Modification
+		action OptAction
+	)
+
+	switch p.code {
+	case respAddHeader:
+		mod, action = Modification{Action: ModifyAddHeader}, OptAddHeader
+	case respInsHeader:
+		mod, action = Modification{Action: ModifyInsertHeader}, OptAddHeader
+	case respChgHeader:
+		mod, action = Modification{Action: ModifyChangeHeader}, OptChangeHeader
+	case respChgFrom:
+		mod, action = Modification{Action: ModifyChangeFrom}, OptChangeFrom
+	case respAddRcpt:
+		mod, action = Modification{Action: ModifyAddRcpt}, OptAddRcpt
+	case respAddRcptPar:
+		mod, action = Modification{Action: ModifyAddRcpt}, OptAddRcptWithArgs
+	case respDelRcpt:
+		mod, action = Modification{Action: ModifyRemoveRcpt}, OptRemoveRcpt
+	case respReplBody:
+		return Modification{Action: ModifyReplaceBody, Body: p.data}, s.allowed(OptChangeBody)
+	case respQuarantine:
+		mod, action = Modification{Action: ModifyQuarantine}, OptQuarantine
+	default:
+		return mod, fmt.Errorf("%w: unexpected response %q", errProtocol, p.code)
+	}
+
+	if err := s.allowed(action); err != nil {
+		return mod, err
+	}
+
+	data := p.data
+	if p.code == respInsHeader || p.code == respChgHeader {
+		if len(data) < 4 {
+			return mod, fmt.Errorf("%w: invalid header modification", errProtocol)
+		}
+		mod.Index = int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+	}
+
+	fields := splitCStrings(data)
+	switch mod.Action {
+	case ModifyAddHeader, ModifyInsertHeader, ModifyChangeHeader:
+		if len(fields) < 1 || fields[0] == "" {
+			return mod, fmt.Errorf("%w: invalid header modification", errProtocol)
+		}
+		mod.Name = fields[0]
+		if len(fields) > 1 {
+			mod.Value = fields[1]
+		}
+	case ModifyChangeFrom, ModifyAddRcpt, ModifyRemoveRcpt:
+		if len(fields) < 1 || fields[0] == "" {
+			return mod, fmt.Errorf("%w: invalid address modification", errProtocol)
+		}
+		mod.Address = stripBrackets(fields[0])
+		if len(fields) > 1 {
+			mod.Args = strings.Fields(fields[1])
+		}
+	case ModifyQuarantine:
+		if len(fields) > 0 {
+			mod.Reason = fields[0]
+		}
+	case ModifyReplaceBody:
+		// handled above
+	}
+
+	return mod, nil
+}
+
+// allowed returns an error if the filter didn't negotiate action.
+func (s *Session) allowed(action OptAction) error {
+	if s.actions&action == 0 {
+		return fmt.Errorf("%w: modification without negotiated action 0x%x", errProtocol, uint32(action))
+	}
+	return nil
+}
+
+// response decodes a response which isn't a modification.
+func response(p *packet) (*Response, bool) {
+	switch p.code {
+	case respContinue:
+		return &Response{Action: ActionContinue}, true
+	case respAccept:
+		return &Response{Action: ActionAccept}, true
+	case respReject:
+		return &Response{Action: ActionReject}, true
+	case respTempFail:
+		return &Response{Action: ActionTempFail}, true
+	case respDiscard:
+		return &Response{Action: ActionDiscard}, true
+	case respSkip:
+		return &Response{Action: ActionSkip}, true
+	case respReplyCode:
+		return replyCode(strings.TrimRight(string(p.data), "\x00"))
+	default:
+		return nil, false
+	}
+}
+
+// replyCode decodes a custom reply, e.g. "550 5.7.1 Rejected" or a multiline reply
+// "550-5.7.1 First line\r\n550 5.7.1 Second line".
+func replyCode(reply string) (*Response, bool) {
+	if len(reply) < 3 {
+		return nil, false
+	}
+	code, err := strconv.Atoi(reply[:3])
+	if err != nil || (code/100 != 4 && code/100 != 5) {
+		return nil, false
+	}
+
+	lines := strings.Split(strings.ReplaceAll(reply, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if len(line) >= 4 && line[:3] == reply[:3] && (line[3] == ' ' || line[3] == '-') {
+			line = line[4:]
+		} else if len(line) == 3 && line == reply[:3] {
+			line = ""
+		}
+		lines[i] = line
+	}
+
+	res := &Response{Action: ActionReject, Status: smtp.ParseStatus(code, strings.Join(lines, "\n"))}
+	if code/100 == 4 {
+		res.Action = ActionTempFail
+	}
+	return res, true
+}
@@ -0,0 +1,139 @@
+package milter
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+)
+
+// pipeSession returns a negotiated session and the filter side of the connection.
+func pipeSession(t *testing.T, actions OptAction, protocol OptProtocol) (*Session, net.Conn) {
+	mta, filter := net.Pipe()
+	t.Cleanup(func() {
+		_ = mta.Close()
+		_ = filter.Close()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		p, err := readPacket(filter)
+		if err == nil && p.code != cmdOptNeg {
+			err = errProtocol
+		}
+		if err == nil {
+			err = writePacket(filter, cmdOptNeg, uint32s(version, uint32(actions), uint32(protocol)))
+		}
+		done <- err
+	}()
+
+	s := &Session{client: NewClient("tcp", ""), conn: mta, r: bufio.NewReader(mta)}
+	err := s.negotiate()
+	require.NoError(t, <-done)
+	require.NoError(t, err)
+	return s, filter
+}
+
+// reply reads a command and writes the responses.
+func reply(filter net.Conn, code byte, responses ...*packet) <-chan *packet {
+	c := make(chan *packet, 1)
+	go func() {
+		p, err := readPacket(filter)
+		if err != nil || p.code != code {
+			c <- nil
+			return
+		}
+		for _, r := range responses {
+			_ = writePacket(filter, r.code, r.data)
+		}
+		c <- p
+	}()
+	return c
+}
+
+func TestSession_Negotiate(t *testing.T) {
+	mta, filter := net.Pipe()
+	defer func() { _ = filter.Close() }()
+
+	go func() {
+		_, _ = readPacket(filter)
+		_ = writePacket(filter, cmdOptNeg, uint32s(version, uint32(OptChangeBody|OptQuarantine), 0))
+	}()
+
+	s := &Session{
+		client: NewClient("tcp", "", WithActions(OptAddHeader|OptChangeBody)),
+		conn:   mta,
+		r:      bufio.NewReader(mta),
+	}
+	require.ErrorContains(t, s.negotiate(), "unsupported actions")
+}
+
+func TestSession_Commands(t *testing.T) {
+	s, filter := pipeSession(t, OptAddHeader, OptNoHelo|OptNoMailReply)
+
+	// skipped
+	res, err := s.Helo("localhost")
+	require.NoError(t, err)
+	require.Equal(t, ActionContinue, res.Action)
+
+	// no reply
+	c := reply(filter, cmdMail)
+	res, err = s.Mail("", []string{"BODY=8BITMIME"})
+	require.NoError(t, err)
+	require.Equal(t, ActionContinue, res.Action)
+	require.Equal(t, []string{"<>", "BODY=8BITMIME"}, splitCStrings((<-c).data))
+
+	// progress and reply code
+	c = reply(filter, cmdRcpt, &packet{code: respProgress}, &packet{code: respReplyCode, data: cstrings("452 4.2.2 Full")})
+	res, err = s.Rcpt("full@example.org", nil)
+	require.NoError(t, err)
+	require.Equal(t, ActionTempFail, res.Action)
+	require.Equal(t, smtp.NewStatus(452, smtp.EnhancedCode{4, 2, 2}, "Full"), res.Status)
+	require.Equal(t, []string{"<full@example.org>"}, splitCStrings((<-c).data))
+
+	// leading space is removed
+	c = reply(filter, cmdHeader, &packet{code: respContinue})
+	_, err = s.Header("Subject", " Hello")
+	require.NoError(t, err)
+	require.Equal(t, []string{"Subject", "Hello"}, splitCStrings((<-c).data))
+
+	// the remaining chunks are skipped
+	c = reply(filter, cmdBody, &packet{code: respSkip})
+	res, err = s.Body(make([]byte, maxChunk+10))
+	require.NoError(t, err)
+	require.Equal(t, ActionSkip, res.Action)
+	require.Len(t, (<-c).data, maxChunk)
+
+	c = reply(filter, cmdEOB,
+		&packet{code: respAddHeader, data: cstrings("X-Spam", "yes")},
+		&packet{code: respAccept},
+	)
+	mods, res, err := s.EndOfMessage()
+	require.NoError(t, err)
+	require.Equal(t, ActionAccept, res.Action)
+	require.Equal(t, []Modification{{Action: ModifyAddHeader, Name: "X-Spam", Value: "yes"}}, mods)
+	<-c
+
+	// modifications must be negotiated
+	c = reply(filter, cmdEOB, &packet{code: respReplBody, data: []byte("body")})
+	_, _, err = s.EndOfMessage()
+	require.ErrorIs(t, err, errProtocol)
+	<-c
+}
+
+func TestReplyCode(t *testing.T) {
+	res, ok := replyCode("550-5.7.1 Virus found\r\n550 5.7.1 Eicar")
+	require.True(t, ok)
+	require.Equal(t, ActionReject, res.Action)
+	require.Equal(t, smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Virus found\nEicar"), res.Status)
+
+	res, ok = replyCode("421 Go away")
+	require.True(t, ok)
+	require.Equal(t, ActionTempFail, res.Action)
+	require.Equal(t, 421, res.Status.Code)
+
+	_, ok = replyCode("250 OK")
+	require.False(t, ok)
+}
@@ -0,0 +1,209 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// ErrModification is returned if a modification wasn't negotiated or is requested outside of EndOfMessage.
+var ErrModification = errors.New("milter: modification not allowed")
+
+// Backend creates a filter for every connection of the MTA.
+type Backend interface {
+	NewFilter(ctx context.Context) (context.Context, Filter, error)
+}
+
+// Filter is called for every stage of a SMTP connection. A nil response continues with the next stage.
+// An *smtp.Status error is sent as reply to the MTA, any other error closes the connection and the MTA
+// applies its default action.
+//
+// Stages skipped by the protocol options (see WithFilterProtocol) aren't called.
+type Filter interface {
+	// Connect is called with the connection information, addr is nil if the address is unknown.
+	Connect(ctx context.Context, m *Modifier, hostname string, addr net.Addr) (*Response, error)
+	// Helo is called with the HELO or EHLO argument.
+	Helo(ctx context.Context, m *Modifier, name string) (*Response, error)
+	// Mail is called with the envelope sender of a new message.
+	Mail(ctx context.Context, m *Modifier, from string, opts *smtp.MailOptions) (*Response, error)
+	// Rcpt is called for every recipient.
+	Rcpt(ctx context.Context, m *Modifier, to string, opts *smtp.RcptOptions) (*Response, error)
+	// Data is called when the client sends DATA.
+	Data(ctx context.Context, m *Modifier) (*Response, error)
+	// Header is called for every header field, folded lines of value are separated by LF.
+	Header(ctx context.Context, m *Modifier, name string, value string) (*Response, error)
+	// EndOfHeaders is called after the last header field.
+	EndOfHeaders(ctx context.Context, m *Modifier) (*Response, error)
+	// Body is called for every body chunk, ActionSkip skips the remaining chunks.
+	Body(ctx context.Context, m *Modifier, chunk []byte) (*Response, error)
+	// EndOfMessage is called at the end of the message, modifications are only allowed here.
+	// ActionContinue accepts the message.
+	EndOfMessage(ctx context.Context, m *Modifier) (*Response, error)
+	// Abort is called if the current message is aborted.
+	Abort(ctx context.Context, m *Modifier) error
+	// Close is called when the connection is closed.
+	Close(ctx context.Context)
+}
+
+// NoopFilter continues at every stage, it can be embedded to implement only the required stages.
+type NoopFilter struct{}
+
+// Connect implements Filter.
+func (NoopFilter) Connect(context.Context, *Modifier, string, net.Addr) (*Response, error) {
+	return nil, nil
+}
+
+// Helo implements Filter.
+func (NoopFilter) Helo(context.Context, *Modifier, string) (*Response, error) {
+	return nil, nil
+}
+
+// Mail implements Filter.
+func (NoopFilter) Mail(context.Context, *Modifier, string, *smtp.MailOptions) (*Response, error) {
+	return nil, nil
+}
+
+// Rcpt implements Filter.
+func (NoopFilter) Rcpt(context.Context, *Modifier, string, *smtp.RcptOptions) (*Response, error) {
+	return nil, nil
+}
+
+// Data implements Filter.
+func (NoopFilter) Data(context.Context, *Modifier) (*Response, error) {
+	return nil, nil
+}
+
+// Header implements Filter.
+func (NoopFilter) Header(context.Context, *Modifier, string, string) (*Response, error) {
+	return nil, nil
+}
+
+// EndOfHeaders implements Filter.
+func (NoopFilter) EndOfHeaders(context.Context, *Modifier) (*Response, error) {
+	return nil, nil
+}
+
+// Body implements Filter.
+func (NoopFilter) Body(context.Context, *Modifier, []byte) (*Response, error) {
+	return nil, nil
+}
+
+// EndOfMessage implements Filter.
+func (NoopFilter) EndOfMessage(context.Context, *Modifier) (*Response, error) {
+	return nil, nil
+}
+
+// Abort implements Filter.
+func (NoopFilter) Abort(context.Context, *Modifier) error {
+	return nil
+}
+
+// Close implements Filter.
+func (NoopFilter) Close(context.Context) {}
+
+// stages are the macro stages from the latest to the first.
+var stages = []Stage{StageEOM, StageEOH, StageData, StageRcpt, StageMail, StageHelo, StageConnect}
+
+// Modifier gives access to the macros and performs the modifications of a message.
+type Modifier struct {
+	conn *conn
+	eom  bool
+}
+
+// Macro returns the value of a macro sent by the MTA, the name can be given with or
+// without braces, e.g. "i", "{auth_authen}" or "auth_authen".
+func (m *Modifier) Macro(name string) string {
+	alt := "{" + name + "}"
+	if strings.HasPrefix(name, "{") {
+		alt = strings.Trim(name, "{}")
+	}
+
+	for _, stage := range stages {
+		macros := m.conn.macros[stage]
+		if v, ok := macros[name]; ok {
+			return v
+		}
+		if v, ok := macros[alt]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// Actions returns the negotiated modifications.
+func (m *Modifier) Actions() OptAction {
+	return m.conn.actions
+}
+
+// AddHeader appends a header field.
+func (m *Modifier) AddHeader(name string, value string) error {
+	return m.modify(OptAddHeader, respAddHeader, cstrings(name, value))
+}
+
+// InsertHeader inserts a header field at index (0 is the first field).
+func (m *Modifier) InsertHeader(index int, name string, value string) error {
+	return m.modify(OptAddHeader, respInsHeader, uint32s(uint32(max(index, 0))), cstrings(name, value)) // nolint: gosec
+}
+
+// ChangeHeader changes the index-th (starting at 1) header field name, an empty value removes it.
+func (m *Modifier) ChangeHeader(index int, name string, value string) error {
+	return m.modify(OptChangeHeader, respChgHeader, uint32s(uint32(max(index, 1))), cstrings(name, value)) // nolint: gosec
+}
+
+// ChangeFrom changes the envelope sender with optional ESMTP arguments.
+func (m *Modifier) ChangeFrom(from string, args ...string) error {
+	data := cstrings("<" + from + ">")
+	if len(args) > 0 {
+		data = append(data, cstrings(strings.Join(args, " "))...)
+	}
+	return m.modify(OptChangeFrom, respChgFrom, data)
+}
+
+// AddRcpt adds a recipient with optional ESMTP arguments.
+func (m *Modifier) AddRcpt(to string, args ...string) error {
+	if len(args) > 0 {
+		return m.modify(OptAddRcptWithArgs, respAddRcptPar, cstrings("<"+to+">", strings.Join(args, " ")))
+	}
+	return m.modify(OptAddRcpt, respAddRcpt, cstrings("<"+to+">"))
+}
+
+// RemoveRcpt removes a recipient.
+func (m *Modifier) RemoveRcpt(to string) error {
+	return m.modify(OptRemoveRcpt, respDelRcpt, cstrings("<"+to+">"))
+}
+
+// ReplaceBody replaces the body, it can be called multiple times to send the body in parts.
+func (m *Modifier) ReplaceBody(body []byte) error {
+	for len(body) > 0 {
+		chunk := body[:min(len(body), maxChunk)]
+		body = body[len(chunk):]
+		if err := m.modify(OptChangeBody, respReplBody, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Quarantine quarantines the message for reason.
+func (m *Modifier) Quarantine(reason string) error {
+	return m.modify(OptQuarantine, respQuarantine, cstrings(reason))
+}
+
+// Progress tells the MTA that the filter is still working, which resets its timeout.
+func (m *Modifier) Progress() error {
+	if !m.eom {
+		return ErrModification
+	}
+	return m.conn.write(respProgress)
+}
+
+// modify sends a modification if it was negotiated.
+func (m *Modifier) modify(action OptAction, code byte, data ...[]byte) error {
+	if !m.eom || m.conn.actions&action == 0 {
+		return ErrModification
+	}
+	return m.conn.write(code, data...)
+}
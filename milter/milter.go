@@ -0,0 +1,92 @@
+// Package milter implements the Sendmail milter protocol (version 6) used by MTAs to call external
+// content filters like rspamd, OpenDKIM or ClamAV.
+//
+// Client is the MTA side, it is used by the server (see server.WithMilters):
+//
+//	srv := server.New(
+//		server.WithBackend(backend),
+//		server.WithMilters(milter.NewClient("tcp", "127.0.0.1:11332")),
+//	)
+//
+// Server is the filter side, it calls a Filter for every connection of the MTA:
+//
+//	srv := milter.NewServer(
+//		milter.WithBackend(backend),
+//		milter.WithAddr("127.0.0.1:7357"),
+//		milter.WithFilterActions(milter.OptAddHeader),
+//		milter.WithFilterProtocol(milter.OptNoHelo|milter.OptNoBody),
+//	)
+//	err := srv.ListenAndServe(ctx)
+package milter
+
+import (
+	"github.com/uponusolutions/go-smtp"
+)
+
+// Action is the decision of a filter about a connection, a message or a recipient.
+type Action int
+
+const (
+	// ActionContinue continues with the next step.
+	ActionContinue Action = iota
+	// ActionAccept accepts the message (or the connection) without calling the filter again for it.
+	ActionAccept
+	// ActionReject rejects the command.
+	ActionReject
+	// ActionTempFail rejects the command temporarily.
+	ActionTempFail
+	// ActionDiscard accepts the message but silently discards it.
+	ActionDiscard
+	// ActionSkip skips the remaining body chunks.
+	ActionSkip
+)
+
+// Response is the response of a filter to a command.
+type Response struct {
+	Action Action
+	// Status is the reply for ActionReject and ActionTempFail, nil if the default reply should be used.
+	Status *smtp.Status
+}
+
+// ModifyAction is a modification of the message requested at the end of the message.
+type ModifyAction int
+
+const (
+	// ModifyAddHeader appends the header field Name: Value.
+	ModifyAddHeader ModifyAction = iota
+	// ModifyInsertHeader inserts the header field Name: Value at Index (0 is the first field).
+	ModifyInsertHeader
+	// ModifyChangeHeader changes the Index-th (starting at 1) header field Name to Value,
+	// it is removed if Value is empty.
+	ModifyChangeHeader
+	// ModifyChangeFrom changes the envelope sender to Address with the ESMTP arguments Args.
+	ModifyChangeFrom
+	// ModifyAddRcpt adds the recipient Address with the ESMTP arguments Args.
+	ModifyAddRcpt
+	// ModifyRemoveRcpt removes the recipient Address.
+	ModifyRemoveRcpt
+	// ModifyReplaceBody replaces the body with Body, the chunks of all actions are concatenated.
+	ModifyReplaceBody
+	// ModifyQuarantine quarantines the message for Reason.
+	ModifyQuarantine
+)
+
+// Modification is a modification of the message.
+type Modification struct {
+	Action  ModifyAction
+	Name    string
+	Value   string
+	Index   int
+	Address string
+	Args    []string
+	Body    []byte
+	Reason  string
+}
+
+// stripBrackets returns an address without angle brackets.
+func stripBrackets(addr string) string {
+	if len(addr) >= 2 && addr[0] == '<' && addr[len(addr)-1] == '>' {
+		return addr[1 : len(addr)-1]
+	}
+	return addr
+}
@@ -0,0 +1,207 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// version is the milter protocol version.
+const version = 6
+
+// maxPacket is the maximum size of a received packet.
+const maxPacket = 1 << 20
+
+// maxChunk is the maximum size of a body chunk.
+const maxChunk = 65535
+
+// command codes sent by the MTA.
+const (
+	cmdAbort   byte = 'A'
+	cmdBody    byte = 'B'
+	cmdConnect byte = 'C'
+	cmdMacro   byte = 'D'
+	cmdEOB     byte = 'E'
+	cmdHelo    byte = 'H'
+	cmdQuitNC  byte = 'K'
+	cmdHeader  byte = 'L'
+	cmdMail    byte = 'M'
+	cmdEOH     byte = 'N'
+	cmdOptNeg  byte = 'O'
+	cmdQuit    byte = 'Q'
+	cmdRcpt    byte = 'R'
+	cmdData    byte = 'T'
+	cmdUnknown byte = 'U'
+)
+
+// response codes sent by the filter.
+const (
+	respAddRcpt    byte = '+'
+	respDelRcpt    byte = '-'
+	respAddRcptPar byte = '2'
+	respAccept     byte = 'a'
+	respReplBody   byte = 'b'
+	respContinue   byte = 'c'
+	respDiscard    byte = 'd'
+	respChgFrom    byte = 'e'
+	respAddHeader  byte = 'h'
+	respInsHeader  byte = 'i'
+	respChgHeader  byte = 'm'
+	respProgress   byte = 'p'
+	respQuarantine byte = 'q'
+	respReject     byte = 'r'
+	respSkip       byte = 's'
+	respTempFail   byte = 't'
+	respReplyCode  byte = 'y'
+)
+
+// OptAction are the modifications a filter is allowed to perform (SMFIF_*).
+type OptAction uint32
+
+const (
+	// OptAddHeader allows to add and insert header fields.
+	OptAddHeader OptAction = 1 << iota
+	// OptChangeBody allows to replace the body.
+	OptChangeBody
+	// OptAddRcpt allows to add recipients.
+	OptAddRcpt
+	// OptRemoveRcpt allows to remove recipients.
+	OptRemoveRcpt
+	// OptChangeHeader allows to change and remove header fields.
+	OptChangeHeader
+	// OptQuarantine allows to quarantine the message.
+	OptQuarantine
+	// OptChangeFrom allows to change the envelope sender.
+	OptChangeFrom
+	// OptAddRcptWithArgs allows to add recipients with ESMTP arguments.
+	OptAddRcptWithArgs
+	// OptSetSymList allows to request the macros sent per stage.
+	OptSetSymList
+
+	// OptAllActions are all modifications.
+	OptAllActions = OptAddHeader | OptChangeBody | OptAddRcpt | OptRemoveRcpt | OptChangeHeader |
+		OptQuarantine | OptChangeFrom | OptAddRcptWithArgs | OptSetSymList
+)
+
+// OptProtocol are the steps a filter doesn't want to receive or doesn't reply to (SMFIP_*).
+type OptProtocol uint32
+
+const (
+	// OptNoConnect skips the connection information.
+	OptNoConnect OptProtocol = 1 << iota
+	// OptNoHelo skips HELO.
+	OptNoHelo
+	// OptNoMailFrom skips MAIL FROM.
+	OptNoMailFrom
+	// OptNoRcptTo skips RCPT TO.
+	OptNoRcptTo
+	// OptNoBody skips the body.
+	OptNoBody
+	// OptNoHeaders skips the header fields.
+	OptNoHeaders
+	// OptNoEOH skips the end of the header.
+	OptNoEOH
+	// OptNoHeaderReply means the filter doesn't reply to header fields.
+	OptNoHeaderReply
+	// OptNoUnknown skips unknown commands.
+	OptNoUnknown
+	// OptNoData skips DATA.
+	OptNoData
+	// OptSkip means the MTA understands the skip response to body chunks.
+	OptSkip
+	// OptRcptRejected means the filter receives rejected recipients too.
+	OptRcptRejected
+	// OptNoConnReply means the filter doesn't reply to the connection information.
+	OptNoConnReply
+	// OptNoHeloReply means the filter doesn't reply to HELO.
+	OptNoHeloReply
+	// OptNoMailReply means the filter doesn't reply to MAIL FROM.
+	OptNoMailReply
+	// OptNoRcptReply means the filter doesn't reply to RCPT TO.
+	OptNoRcptReply
+	// OptNoDataReply means the filter doesn't reply to DATA.
+	OptNoDataReply
+	// OptNoUnknownReply means the filter doesn't reply to unknown commands.
+	OptNoUnknownReply
+	// OptNoEOHReply means the filter doesn't reply to the end of the header.
+	OptNoEOHReply
+	// OptNoBodyReply means the filter doesn't reply to body chunks.
+	OptNoBodyReply
+	// OptHeaderLeadingSpace means header values are sent with their leading space.
+	OptHeaderLeadingSpace
+)
+
+// errProtocol is returned if a peer violates the protocol.
+var errProtocol = errors.New("milter: protocol error")
+
+// packet is a command or a response.
+type packet struct {
+	code byte
+	data []byte
+}
+
+// readPacket reads a packet: a 4 byte length, the code and the data.
+func readPacket(r io.Reader) (*packet, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 || length > maxPacket {
+		return nil, fmt.Errorf("%w: invalid packet length %d", errProtocol, length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &packet{code: buf[0], data: buf[1:]}, nil
+}
+
+// writePacket writes a packet.
+func writePacket(w io.Writer, code byte, data ...[]byte) error {
+	length := 1
+	for _, d := range data {
+		length += len(d)
+	}
+
+	buf := make([]byte, 5, 4+length)
+	binary.BigEndian.PutUint32(buf, uint32(length)) // nolint: gosec
+	buf[4] = code
+	for _, d := range data {
+		buf = append(buf, d...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// cstrings encodes strings as null terminated strings.
+func cstrings(s ...string) []byte {
+	var b bytes.Buffer
+	for _, v := range s {
+		b.WriteString(v)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// splitCStrings decodes null terminated strings.
+func splitCStrings(data []byte) []string {
+	s := strings.Split(string(data), "\x00")
+	if len(s) > 0 && s[len(s)-1] == "" {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// uint32s encodes values as big endian.
+func uint32s(values ...uint32) []byte {
+	buf := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		buf = binary.BigEndian.AppendUint32(buf, v)
+	}
+	return buf
+}
@@ -0,0 +1,564 @@
+package milter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/internal/parse"
+	"github.com/uponusolutions/go-smtp/internal/serve"
+)
+
+// ErrServerClosed occurs if a server is already closed.
+var ErrServerClosed = errors.New("milter: server already closed")
+
+// symLists are the indexes of the stages in the macro lists requested during negotiation.
+var symLists = map[Stage]uint32{
+	StageConnect: 0,
+	StageHelo:    1,
+	StageMail:    2,
+	StageRcpt:    3,
+	StageData:    4,
+	StageEOM:     5,
+	StageEOH:     6,
+}
+
+// Server implements the filter side of the milter protocol, e.g. for Postfix:
+//
+//	smtpd_milters = inet:127.0.0.1:7357
+type Server struct {
+	// The type of network, "tcp" or "unix".
+	network string
+	// TCP or Unix address to listen on.
+	addr string
+
+	// Modifications the filters perform.
+	actions OptAction
+	// Stages the filters don't need.
+	protocol OptProtocol
+	// Macros requested per stage.
+	macros map[Stage][]string
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// The server backend.
+	backend Backend
+
+	logger *slog.Logger
+
+	lifecycle *serve.Lifecycle
+}
+
+// ServerOption is an option for the server.
+type ServerOption func(*Server)
+
+// NewServer creates a new milter server.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		lifecycle: serve.New(ErrServerClosed),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+
+	return s
+}
+
+// WithBackend sets the backend.
+func WithBackend(backend Backend) ServerOption {
+	return func(s *Server) {
+		s.backend = backend
+	}
+}
+
+// WithNetwork sets the network, "tcp" (default) or "unix".
+func WithNetwork(network string) ServerOption {
+	return func(s *Server) {
+		s.network = network
+	}
+}
+
+// WithAddr sets the address to listen on.
+func WithAddr(addr string) ServerOption {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithReadTimeout sets the read timeout.
+func WithReadTimeout(readTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.readTimeout = readTimeout
+	}
+}
+
+// WithWriteTimeout sets the write timeout.
+func WithWriteTimeout(writeTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.writeTimeout = writeTimeout
+	}
+}
+
+// WithFilterActions sets the modifications the filters perform, the MTA must allow all of them.
+func WithFilterActions(actions OptAction) ServerOption {
+	return func(s *Server) {
+		s.actions = actions
+	}
+}
+
+// WithFilterProtocol sets the stages the filters don't need (e.g. OptNoHelo|OptNoBody) and
+// the stages they don't reply to. Skipped stages aren't called even if the MTA sends them,
+// because it doesn't support skipping them.
+func WithFilterProtocol(protocol OptProtocol) ServerOption {
+	return func(s *Server) {
+		s.protocol = protocol
+	}
+}
+
+// WithFilterMacros requests the macros sent by the MTA for a stage, e.g.
+// WithFilterMacros(StageMail, "{auth_authen}", "{mail_addr}"). It is ignored if the MTA doesn't support it.
+func WithFilterMacros(stage Stage, names ...string) ServerOption {
+	return func(s *Server) {
+		if s.macros == nil {
+			s.macros = map[Stage][]string{}
+		}
+		s.macros[stage] = names
+	}
+}
+
+// Backend returns the servers Backend.
+func (s *Server) Backend() Backend {
+	return s.backend
+}
+
+// Listen listens on the network address s.addr.
+func (s *Server) Listen() (net.Listener, error) {
+	network := s.network
+	if network == "" {
+		network = "tcp"
+	}
+	return net.Listen(network, s.addr)
+}
+
+// ListenAndServe listens on the network address s.addr and then calls Serve
+// to handle requests on incoming connections.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	l, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve accepts incoming connections on the Listener l.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	return s.lifecycle.Serve(ctx, s.logger, l, s.handleConn)
+}
+
+// Close immediately closes all active listeners and connections.
+//
+// Close returns any error returned from closing the server's underlying
+// listener(s).
+func (s *Server) Close() error {
+	return s.lifecycle.Close()
+}
+
+// Shutdown gracefully shuts down the server without interrupting any
+// active connections. Shutdown works by first closing all open
+// listeners and then waiting indefinitely for connections to be closed
+// by the MTA.
+// If the provided context expires before the shutdown is complete,
+// Shutdown returns the context's error, otherwise it returns any
+// error returned from closing the Server's underlying Listener(s).
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.lifecycle.Shutdown(ctx)
+}
+
+func (s *Server) handleConn(ctx context.Context, nc net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := &conn{
+		ctx:    ctx,
+		server: s,
+		conn:   nc,
+		r:      bufio.NewReader(nc),
+		macros: map[Stage]map[string]string{},
+	}
+	c.modifier = &Modifier{conn: c}
+
+	defer func() {
+		if err := recover(); err != nil {
+			s.logger.ErrorContext(
+				c.ctx,
+				"panic serving",
+				slog.Any("err", err),
+				slog.Any("stack", string(debug.Stack())),
+			)
+		}
+
+		c.close()
+
+		cancel()
+	}()
+
+	if err := c.run(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		s.logger.ErrorContext(c.ctx, "milter connection failed", slog.Any("err", err))
+	}
+}
+
+// conn is a connection of the MTA.
+type conn struct {
+	ctx    context.Context
+	server *Server
+	conn   net.Conn
+	r      *bufio.Reader
+
+	filter   Filter
+	modifier *Modifier
+	macros   map[Stage]map[string]string
+
+	actions  OptAction
+	protocol OptProtocol
+}
+
+// run handles commands until the MTA quits or an error occurs.
+func (c *conn) run() error {
+	if err := c.newFilter(); err != nil {
+		return err
+	}
+
+	for {
+		if c.server.readTimeout != 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.server.readTimeout))
+		}
+		p, err := readPacket(c.r)
+		if err != nil {
+			return err
+		}
+
+		switch p.code {
+		case cmdQuit:
+			return nil
+		case cmdQuitNC:
+			// the connection is reused for the next SMTP connection
+			c.filter.Close(c.ctx)
+			c.filter = nil
+			c.macros = map[Stage]map[string]string{}
+			if err := c.newFilter(); err != nil {
+				return err
+			}
+		default:
+			if err := c.handle(p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// newFilter creates the filter of the next SMTP connection.
+func (c *conn) newFilter() error {
+	if c.server.backend == nil {
+		return errors.New("milter: no backend")
+	}
+	ctx, filter, err := c.server.backend.NewFilter(c.ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't create filter: %w", err)
+	}
+	c.ctx = ctx
+	c.filter = filter
+	return nil
+}
+
+// close closes the filter and the connection.
+func (c *conn) close() {
+	if c.filter != nil {
+		c.filter.Close(c.ctx)
+		c.filter = nil
+	}
+	_ = c.conn.Close()
+}
+
+// handle dispatches a command to the filter.
+// nolint: revive
+func (c *conn) handle(p *packet) error {
+	var (
+		res *Response
+		err error
+	)
+
+	switch p.code {
+	case cmdOptNeg:
+		return c.negotiate(p.data)
+	case cmdMacro:
+		if len(p.data) > 0 {
+			c.setMacros(Stage(p.data[0]), splitCStrings(p.data[1:]))
+		}
+		return nil
+	case cmdAbort:
+		c.clearMacros()
+		return c.filter.Abort(c.ctx, c.modifier)
+	case cmdConnect:
+		if c.server.protocol&OptNoConnect != 0 {
+			break
+		}
+		hostname, addr, perr := connectInfo(p.data)
+		if perr != nil {
+			return perr
+		}
+		res, err = c.filter.Connect(c.ctx, c.modifier, hostname, addr)
+	case cmdHelo:
+		if c.server.protocol&OptNoHelo != 0 {
+			break
+		}
+		res, err = c.filter.Helo(c.ctx, c.modifier, strings.TrimRight(string(p.data), "\x00"))
+	case cmdMail:
+		if c.server.protocol&OptNoMailFrom != 0 {
+			break
+		}
+		args := splitCStrings(p.data)
+		if len(args) == 0 {
+			return fmt.Errorf("%w: MAIL FROM without sender", errProtocol)
+		}
+		res, err = c.filter.Mail(c.ctx, c.modifier, stripBrackets(args[0]), parse.MailOptions(args[1:]))
+	case cmdRcpt:
+		if c.server.protocol&OptNoRcptTo != 0 {
+			break
+		}
+		args := splitCStrings(p.data)
+		if len(args) == 0 {
+			return fmt.Errorf("%w: RCPT TO without recipient", errProtocol)
+		}
+		res, err = c.filter.Rcpt(c.ctx, c.modifier, stripBrackets(args[0]), parse.RcptOptions(args[1:]))
+	case cmdData:
+		if c.server.protocol&OptNoData != 0 {
+			break
+		}
+		res, err = c.filter.Data(c.ctx, c.modifier)
+	case cmdHeader:
+		if c.server.protocol&OptNoHeaders != 0 {
+			break
+		}
+		fields := splitCStrings(p.data)
+		if len(fields) != 2 {
+			return fmt.Errorf("%w: invalid header", errProtocol)
+		}
+		res, err = c.filter.Header(c.ctx, c.modifier, fields[0], fields[1])
+	case cmdEOH:
+		if c.server.protocol&OptNoEOH != 0 {
+			break
+		}
+		res, err = c.filter.EndOfHeaders(c.ctx, c.modifier)
+	case cmdBody:
+		if c.server.protocol&OptNoBody != 0 {
+			break
+		}
+		res, err = c.filter.Body(c.ctx, c.modifier, p.data)
+	case cmdEOB:
+		return c.endOfMessage(p.data)
+	case cmdUnknown:
+		// unknown SMTP commands are passed through
+	default:
+		return fmt.Errorf("%w: unknown command %q", errProtocol, p.code)
+	}
+
+	if noReply[p.code]&c.protocol != 0 {
+		return nil
+	}
+	return c.reply(p.code, res, err)
+}
+
+// noReply are the protocol options which suppress the reply to a command.
+var noReply = map[byte]OptProtocol{
+	cmdConnect: OptNoConnReply,
+	cmdHelo:    OptNoHeloReply,
+	cmdMail:    OptNoMailReply,
+	cmdRcpt:    OptNoRcptReply,
+	cmdData:    OptNoDataReply,
+	cmdHeader:  OptNoHeaderReply,
+	cmdEOH:     OptNoEOHReply,
+	cmdBody:    OptNoBodyReply,
+	cmdUnknown: OptNoUnknownReply,
+}
+
+// negotiate answers the option negotiation of the MTA.
+func (c *conn) negotiate(data []byte) error {
+	if len(data) < 12 {
+		return fmt.Errorf("%w: invalid option negotiation", errProtocol)
+	}
+	v := binary.BigEndian.Uint32(data)
+	actions := OptAction(binary.BigEndian.Uint32(data[4:]))
+	protocol := OptProtocol(binary.BigEndian.Uint32(data[8:]))
+
+	if v < 2 {
+		return fmt.Errorf("milter: unsupported version %d", v)
+	}
+	if c.server.actions&^actions != 0 {
+		return fmt.Errorf("milter: MTA doesn't allow actions 0x%x", uint32(c.server.actions&^actions))
+	}
+
+	c.actions = c.server.actions
+	c.protocol = c.server.protocol&protocol | protocol&OptSkip
+
+	var symlists []byte
+	if len(c.server.macros) > 0 && actions&OptSetSymList != 0 {
+		c.actions |= OptSetSymList
+		for stage, names := range c.server.macros {
+			symlists = append(symlists, uint32s(symLists[stage])...)
+			symlists = append(symlists, cstrings(strings.Join(names, " "))...)
+		}
+	}
+
+	return c.write(cmdOptNeg, uint32s(min(v, version), uint32(c.actions), uint32(c.protocol)), symlists)
+}
+
+// endOfMessage calls the filter at the end of the message, modifications are allowed until it returns.
+func (c *conn) endOfMessage(data []byte) error {
+	// the last body chunk can be sent with the end of the message
+	if len(data) > 0 && c.server.protocol&OptNoBody == 0 {
+		if _, err := c.filter.Body(c.ctx, c.modifier, data); err != nil {
+			return c.reply(cmdEOB, nil, err)
+		}
+	}
+
+	c.modifier.eom = true
+	res, err := c.filter.EndOfMessage(c.ctx, c.modifier)
+	c.modifier.eom = false
+
+	c.clearMacros()
+	return c.reply(cmdEOB, res, err)
+}
+
+// reply writes the response of the filter.
+func (c *conn) reply(code byte, res *Response, err error) error {
+	if err != nil {
+		status, ok := err.(*smtp.Status)
+		if !ok {
+			return err
+		}
+		res = &Response{Action: ActionReject, Status: status}
+		if status.Code/100 == 4 {
+			res.Action = ActionTempFail
+		}
+	}
+	if res == nil {
+		res = &Response{Action: ActionContinue}
+	}
+
+	switch res.Action {
+	case ActionAccept:
+		return c.write(respAccept)
+	case ActionReject, ActionTempFail:
+		if res.Status != nil {
+			return c.write(respReplyCode, cstrings(formatReply(res.Status)))
+		}
+		if res.Action == ActionTempFail {
+			return c.write(respTempFail)
+		}
+		return c.write(respReject)
+	case ActionDiscard:
+		return c.write(respDiscard)
+	case ActionSkip:
+		if code == cmdBody && c.protocol&OptSkip != 0 {
+			return c.write(respSkip)
+		}
+		return c.write(respContinue)
+	default:
+		return c.write(respContinue)
+	}
+}
+
+// write writes a response.
+func (c *conn) write(code byte, data ...[]byte) error {
+	if c.server.writeTimeout != 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.server.writeTimeout))
+	}
+	return writePacket(c.conn, code, data...)
+}
+
+// setMacros stores the macros of a stage.
+func (c *conn) setMacros(stage Stage, kv []string) {
+	macros := make(map[string]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		macros[kv[i]] = kv[i+1]
+	}
+	c.macros[stage] = macros
+}
+
+// clearMacros removes the macros of the current message.
+func (c *conn) clearMacros() {
+	for _, stage := range []Stage{StageMail, StageRcpt, StageData, StageEOH, StageEOM} {
+		delete(c.macros, stage)
+	}
+}
+
+// connectInfo decodes the connection information.
+func connectInfo(data []byte) (string, net.Addr, error) {
+	hostname, rest, ok := strings.Cut(string(data), "\x00")
+	if !ok || rest == "" {
+		return "", nil, fmt.Errorf("%w: invalid connection information", errProtocol)
+	}
+
+	family := rest[0]
+	if family == 'U' {
+		return hostname, nil, nil
+	}
+	if len(rest) < 3 {
+		return "", nil, fmt.Errorf("%w: invalid connection information", errProtocol)
+	}
+	port := int(binary.BigEndian.Uint16([]byte(rest[1:3])))
+	address := strings.TrimRight(rest[3:], "\x00")
+
+	switch family {
+	case '4', '6':
+		ip := net.ParseIP(strings.TrimPrefix(address, "IPv6:"))
+		if ip == nil {
+			return "", nil, fmt.Errorf("%w: invalid address %q", errProtocol, address)
+		}
+		return hostname, &net.TCPAddr{IP: ip, Port: port}, nil
+	case 'L':
+		return hostname, &net.UnixAddr{Name: address, Net: "unix"}, nil
+	default:
+		return "", nil, fmt.Errorf("%w: unknown family %q", errProtocol, family)
+	}
+}
+
+// formatReply formats a status as SMTP reply, e.g. "550 5.7.1 Rejected".
+func formatReply(status *smtp.Status) string {
+	enhanced := ""
+	if status.EnhancedCode != smtp.NoEnhancedCode && status.EnhancedCode != smtp.EnhancedCodeNotSet {
+		enhanced = fmt.Sprintf("%d.%d.%d ", status.EnhancedCode[0], status.EnhancedCode[1], status.EnhancedCode[2])
+	}
+
+	lines := strings.Split(status.Message, "\n")
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		lines[i] = strconv.Itoa(status.Code) + sep + enhanced + line
+	}
+	return strings.Join(lines, "\r\n")
+}
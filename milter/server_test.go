@@ -0,0 +1,239 @@
+package milter_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/milter"
+)
+
+type backend struct {
+	mu     sync.Mutex
+	events []any
+}
+
+func (b *backend) NewFilter(ctx context.Context) (context.Context, milter.Filter, error) {
+	return ctx, &filter{backend: b}, nil
+}
+
+func (b *backend) record(event ...any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, event...)
+}
+
+func (b *backend) Events() []any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.events
+}
+
+type filter struct {
+	milter.NoopFilter
+	backend *backend
+}
+
+func (f *filter) Connect(
+	_ context.Context, _ *milter.Modifier, hostname string, addr net.Addr,
+) (*milter.Response, error) {
+	f.backend.record(hostname, addr.String())
+	return nil, nil
+}
+
+func (f *filter) Helo(context.Context, *milter.Modifier, string) (*milter.Response, error) {
+	f.backend.record("helo")
+	return nil, nil
+}
+
+func (f *filter) Mail(
+	_ context.Context, m *milter.Modifier, from string, opts *smtp.MailOptions,
+) (*milter.Response, error) {
+	f.backend.record(from, opts, m.Macro("auth_authen"))
+	return nil, nil
+}
+
+func (f *filter) Rcpt(
+	_ context.Context, _ *milter.Modifier, to string, opts *smtp.RcptOptions,
+) (*milter.Response, error) {
+	if to == "spam@example.org" {
+		return nil, smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "No spam please")
+	}
+	f.backend.record(to, opts)
+	return nil, nil
+}
+
+func (f *filter) Header(_ context.Context, _ *milter.Modifier, name string, value string) (*milter.Response, error) {
+	f.backend.record(name + ":" + value)
+	return nil, nil
+}
+
+func (f *filter) Body(_ context.Context, _ *milter.Modifier, chunk []byte) (*milter.Response, error) {
+	f.backend.record(string(chunk))
+	return &milter.Response{Action: milter.ActionSkip}, nil
+}
+
+func (f *filter) EndOfMessage(_ context.Context, m *milter.Modifier) (*milter.Response, error) {
+	f.backend.record(m.RemoveRcpt("root@example.org"))
+
+	if err := m.AddHeader("X-Spam", "yes"); err != nil {
+		return nil, err
+	}
+	if err := m.ChangeFrom("bounce@example.org"); err != nil {
+		return nil, err
+	}
+	if err := m.AddRcpt("archive@example.org", "NOTIFY=NEVER"); err != nil {
+		return nil, err
+	}
+	if err := m.ReplaceBody([]byte("New body\r\n")); err != nil {
+		return nil, err
+	}
+	if err := m.Quarantine("suspicious"); err != nil {
+		return nil, err
+	}
+	return &milter.Response{Action: milter.ActionAccept}, nil
+}
+
+func startServer(t *testing.T, be milter.Backend, opts ...milter.ServerOption) (*milter.Server, string) {
+	opts = append([]milter.ServerOption{milter.WithBackend(be), milter.WithAddr("127.0.0.1:0")}, opts...)
+	srv := milter.NewServer(opts...)
+	l, err := srv.Listen()
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv, l.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	be := &backend{}
+	_, addr := startServer(t, be,
+		milter.WithFilterActions(milter.OptAddHeader|milter.OptChangeFrom|milter.OptAddRcptWithArgs|
+			milter.OptChangeBody|milter.OptQuarantine),
+		milter.WithFilterProtocol(milter.OptNoHelo|milter.OptNoEOH),
+	)
+
+	s, err := milter.NewClient("tcp", addr).Session(context.Background())
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+
+	require.Equal(t, milter.OptNoHelo|milter.OptNoEOH|milter.OptSkip, s.Protocol())
+
+	res, err := s.Connect("[192.0.2.1]", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2525})
+	require.NoError(t, err)
+	require.Equal(t, milter.ActionContinue, res.Action)
+
+	// skipped
+	_, err = s.Helo("localhost")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Macros(milter.StageMail, "{auth_authen}", "alice"))
+	_, err = s.Mail("alice@example.org", []string{"SIZE=100", "BODY=8BITMIME", "ENVID=abc+2Bdef"})
+	require.NoError(t, err)
+
+	res, err = s.Rcpt("spam@example.org", nil)
+	require.NoError(t, err)
+	require.Equal(t, milter.ActionReject, res.Action)
+	require.Equal(t, smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "No spam please"), res.Status)
+
+	_, err = s.Rcpt("root@example.org", []string{"NOTIFY=SUCCESS,FAILURE", "ORCPT=rfc822;root@example.net"})
+	require.NoError(t, err)
+
+	_, err = s.Data()
+	require.NoError(t, err)
+	_, err = s.Header("Subject", " Hello")
+	require.NoError(t, err)
+	_, err = s.EndOfHeaders()
+	require.NoError(t, err)
+	res, err = s.Body([]byte("Hey <3\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, milter.ActionSkip, res.Action)
+
+	mods, res, err := s.EndOfMessage()
+	require.NoError(t, err)
+	require.Equal(t, milter.ActionAccept, res.Action)
+	require.Equal(t, []milter.Modification{
+		{Action: milter.ModifyAddHeader, Name: "X-Spam", Value: "yes"},
+		{Action: milter.ModifyChangeFrom, Address: "bounce@example.org"},
+		{Action: milter.ModifyAddRcpt, Address: "archive@example.org", Args: []string{"NOTIFY=NEVER"}},
+		{Action: milter.ModifyReplaceBody, Body: []byte("New body\r\n")},
+		{Action: milter.ModifyQuarantine, Reason: "suspicious"},
+	}, mods)
+
+	require.Equal(t, []any{
+		"[192.0.2.1]", "192.0.2.1:2525",
+		"alice@example.org", &smtp.MailOptions{Size: 100, Body: smtp.Body8BitMIME, EnvelopeID: "abc+def"}, "alice",
+		"root@example.org", &smtp.RcptOptions{
+			Notify:                []smtp.DSNNotify{smtp.DSNNotifySuccess, smtp.DSNNotifyFailure},
+			OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+			OriginalRecipient:     "root@example.net",
+		},
+		"Subject:Hello",
+		"Hey <3\r\n",
+		milter.ErrModification,
+	}, be.Events())
+}
+
+func TestServer_SkipNotOffered(t *testing.T) {
+	be := &backend{}
+	_, addr := startServer(t, be, milter.WithFilterProtocol(milter.OptNoHelo))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	send := func(data ...byte) {
+		_, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(data))), data...))
+		require.NoError(t, err)
+	}
+	read := func() []byte {
+		var length uint32
+		require.NoError(t, binary.Read(conn, binary.BigEndian, &length))
+		data := make([]byte, length)
+		_, err := io.ReadFull(conn, data)
+		require.NoError(t, err)
+		return data
+	}
+
+	// the MTA doesn't support skipping HELO
+	send(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(
+		[]byte{'O'}, 6), uint32(milter.OptAllActions)), 0)...)
+	require.Equal(t, byte('O'), read()[0])
+
+	send(append([]byte{'H'}, "localhost\x00"...)...)
+	require.Equal(t, []byte{'c'}, read())
+	require.Empty(t, be.Events())
+}
+
+func TestServer_Actions(t *testing.T) {
+	_, addr := startServer(t, &backend{}, milter.WithFilterActions(milter.OptChangeBody))
+
+	// the MTA doesn't allow to replace the body
+	_, err := milter.NewClient("tcp", addr, milter.WithActions(milter.OptAddHeader)).Session(context.Background())
+	require.Error(t, err)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	srv, addr := startServer(t, &backend{})
+
+	s, err := milter.NewClient("tcp", addr).Session(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	require.ErrorIs(t, srv.Close(), milter.ErrServerClosed)
+
+	// the open connection is served until the MTA quits
+	res, err := s.Helo("localhost")
+	require.NoError(t, err)
+	require.Equal(t, milter.ActionContinue, res.Action)
+	require.NoError(t, s.Close())
+}
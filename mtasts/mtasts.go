@@ -0,0 +1,191 @@
+// Package mtasts implements the discovery of SMTP MTA Strict Transport Security policies (RFC 8461).
+//
+// A policy is announced by the TXT record _mta-sts.<domain> and fetched from
+// https://mta-sts.<domain>/.well-known/mta-sts.txt. Policies are cached until their max_age expires.
+package mtasts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPolicySize is the maximum size of a policy file.
+const maxPolicySize = 64 * 1024
+
+// LookupTXT describes the functions needed to discover policies, net.Resolver implements it.
+type LookupTXT interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// HTTPClient describes the functions needed to fetch policies, http.Client implements it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client discovers, fetches and caches policies. It is safe for concurrent use.
+type Client struct {
+	resolver LookupTXT
+	http     HTTPClient
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*cached
+}
+
+// cached is a policy in the cache.
+type cached struct {
+	policy  *Policy
+	expires time.Time
+}
+
+// Option defines a client option.
+type Option func(c *Client)
+
+// WithResolver sets the resolver used to look up the TXT records.
+func WithResolver(resolver LookupTXT) Option {
+	return func(c *Client) {
+		c.resolver = resolver
+	}
+}
+
+// WithHTTPClient sets the client used to fetch the policies. It must not follow redirects (RFC 8461 3.3).
+func WithHTTPClient(client HTTPClient) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// New returns a new client. By default net.DefaultResolver and a http client
+// with a timeout of one minute are used.
+func New(opts ...Option) *Client {
+	c := &Client{
+		resolver: net.DefaultResolver,
+		http: &http.Client{
+			Timeout: time.Minute,
+			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now:   time.Now,
+		cache: map[string]*cached{},
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// Policy returns the policy of domain. It returns nil if the domain has no policy.
+// A cached policy is used until it expires and as long as the id of the TXT record doesn't change.
+// If the discovery or the fetch fails, a cached policy is returned if available, otherwise the error.
+func (c *Client) Policy(ctx context.Context, domain string) (*Policy, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	current := c.cached(domain)
+
+	id, err := c.lookup(ctx, domain)
+	if current != nil && (err != nil || id == "" || id == current.ID) {
+		return current, nil
+	}
+	if err != nil || id == "" {
+		return nil, err
+	}
+
+	policy, err := c.fetch(ctx, domain)
+	if err != nil {
+		if current != nil {
+			return current, nil
+		}
+		return nil, err
+	}
+	policy.ID = id
+
+	c.mu.Lock()
+	c.cache[domain] = &cached{policy: policy, expires: c.now().Add(policy.MaxAge)}
+	c.mu.Unlock()
+
+	return policy, nil
+}
+
+// cached returns the cached policy of domain if it isn't expired.
+func (c *Client) cached(domain string) *Policy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[domain]
+	if !ok {
+		return nil
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.cache, domain)
+		return nil
+	}
+	return entry.policy
+}
+
+// lookup returns the id of the TXT record of domain, an empty id is returned if there is no valid record.
+func (c *Client) lookup(ctx context.Context, domain string) (string, error) {
+	records, err := c.resolver.LookupTXT(ctx, "_mta-sts."+domain)
+	if err != nil {
+		dnsErr := &net.DNSError{}
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	id := ""
+	found := 0
+	for _, record := range records {
+		recordID, ok, err := parseRecord(record)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		id = recordID
+		found++
+	}
+
+	// multiple records are treated as no record
+	if found != 1 {
+		return "", nil
+	}
+	return id, nil
+}
+
+// fetch fetches the policy of domain by https.
+func (c *Client) fetch(ctx context.Context, domain string) (*Policy, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"https://mta-sts."+domain+"/.well-known/mta-sts.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mtasts: unexpected status %s", res.Status)
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err != nil || mediaType != "text/plain" {
+		return nil, fmt.Errorf("mtasts: unexpected content type %q", res.Header.Get("Content-Type"))
+	}
+
+	return Parse(io.LimitReader(res.Body, maxPolicySize))
+}
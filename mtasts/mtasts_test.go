@@ -0,0 +1,161 @@
+package mtasts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{IsNotFound: true, Name: name}
+	}
+	if records == nil {
+		return nil, &net.DNSError{IsTemporary: true, Name: name}
+	}
+	return records, nil
+}
+
+// fakeHTTP sends all requests to the test server, it records the requested urls.
+type fakeHTTP struct {
+	server *httptest.Server
+	urls   []string
+}
+
+func (f *fakeHTTP) Do(req *http.Request) (*http.Response, error) {
+	f.urls = append(f.urls, req.URL.String())
+
+	redirected, err := http.NewRequestWithContext(req.Context(), req.Method, f.server.URL+req.URL.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.server.Client().Do(redirected)
+}
+
+const policyFile = "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 3600\n"
+
+func startPolicyServer(t *testing.T, policy *atomic.Value) *fakeHTTP {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/mta-sts.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(policy.Load().(string)))
+	}))
+	t.Cleanup(server.Close)
+	return &fakeHTTP{server: server}
+}
+
+func TestClient_Policy(t *testing.T) {
+	file := &atomic.Value{}
+	file.Store(policyFile)
+
+	fetcher := startPolicyServer(t, file)
+	resolver := fakeResolver{"_mta-sts.example.com": {"v=STSv1; id=1"}}
+	now := time.Now()
+
+	c := New(WithResolver(resolver), WithHTTPClient(fetcher))
+	c.now = func() time.Time { return now }
+
+	policy, err := c.Policy(context.Background(), "Example.com.")
+	require.NoError(t, err)
+	require.Equal(t, &Policy{ID: "1", Mode: ModeEnforce, MX: []string{"mail.example.com"}, MaxAge: time.Hour}, policy)
+	require.Equal(t, []string{"https://mta-sts.example.com/.well-known/mta-sts.txt"}, fetcher.urls)
+
+	// the id is unchanged, the cached policy is used
+	file.Store("version: STSv1\nmode: testing\nmx: mail.example.com\nmax_age: 3600\n")
+	policy, err = c.Policy(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Equal(t, ModeEnforce, policy.Mode)
+	require.Len(t, fetcher.urls, 1)
+
+	// the dns lookup fails, the cached policy is used
+	resolver["_mta-sts.example.com"] = nil
+	policy, err = c.Policy(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Equal(t, ModeEnforce, policy.Mode)
+
+	// the id changed, the policy is fetched again
+	resolver["_mta-sts.example.com"] = []string{"v=STSv1; id=2"}
+	policy, err = c.Policy(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Equal(t, ModeTesting, policy.Mode)
+	require.Len(t, fetcher.urls, 2)
+
+	// the cached policy expired
+	resolver["_mta-sts.example.com"] = nil
+	now = now.Add(2 * time.Hour)
+	policy, err = c.Policy(context.Background(), "example.com")
+	require.Error(t, err)
+	require.Nil(t, policy)
+}
+
+func TestClient_NoPolicy(t *testing.T) {
+	file := &atomic.Value{}
+	file.Store(policyFile)
+
+	fetcher := startPolicyServer(t, file)
+	c := New(WithResolver(fakeResolver{
+		"_mta-sts.multiple.com": {"v=STSv1; id=1", "v=STSv1; id=2"},
+		"_mta-sts.other.com":    {"v=spf1 -all"},
+	}), WithHTTPClient(fetcher))
+
+	for _, domain := range []string{"example.com", "multiple.com", "other.com"} {
+		policy, err := c.Policy(context.Background(), domain)
+		require.NoError(t, err)
+		require.Nil(t, policy)
+	}
+	require.Empty(t, fetcher.urls)
+}
+
+func TestClient_FetchErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		err     string
+	}{
+		{"status", http.NotFound, "unexpected status"},
+		{"content type", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(policyFile))
+		}, "unexpected content type"},
+		{"policy", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("version: STSv2\n"))
+		}, "unsupported version"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(tc.handler)
+			defer server.Close()
+
+			c := New(
+				WithResolver(fakeResolver{"_mta-sts.example.com": {"v=STSv1; id=1"}}),
+				WithHTTPClient(&fakeHTTP{server: server}),
+			)
+
+			_, err := c.Policy(context.Background(), "example.com")
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestClient_LookupError(t *testing.T) {
+	c := New(WithResolver(fakeResolver{"_mta-sts.example.com": nil}))
+
+	_, err := c.Policy(context.Background(), "example.com")
+	dnsErr := &net.DNSError{}
+	require.True(t, errors.As(err, &dnsErr))
+}
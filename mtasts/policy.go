@@ -0,0 +1,166 @@
+package mtasts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode defines how a sender applies the policy.
+type Mode string
+
+const (
+	// ModeEnforce doesn't deliver to MX hosts which don't match the policy or without a valid TLS connection.
+	ModeEnforce Mode = "enforce"
+	// ModeTesting delivers as without policy, failures are only reported (TLS-RPT).
+	ModeTesting Mode = "testing"
+	// ModeNone indicates that the domain has no active policy.
+	ModeNone Mode = "none"
+)
+
+// maxMaxAge is the maximum lifetime of a policy in seconds (RFC 8461 3.2).
+const maxMaxAge = 31557600
+
+// ErrInvalidPolicy is returned if a fetched policy can't be parsed.
+var ErrInvalidPolicy = errors.New("mtasts: invalid policy")
+
+// Policy is a MTA-STS policy of a domain (RFC 8461 3.2).
+type Policy struct {
+	// ID of the TXT record the policy was fetched for.
+	ID     string
+	Mode   Mode
+	MX     []string
+	MaxAge time.Duration
+}
+
+// Parse parses a policy file.
+func Parse(r io.Reader) (*Policy, error) {
+	policy := &Policy{}
+	version := ""
+	maxAge := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed line %q", ErrInvalidPolicy, line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "mode":
+			policy.Mode = Mode(value)
+		case "mx":
+			policy.MX = append(policy.MX, strings.ToLower(value))
+		case "max_age":
+			seconds, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid max_age %q", ErrInvalidPolicy, value)
+			}
+			policy.MaxAge = time.Duration(min(seconds, maxMaxAge)) * time.Second
+			maxAge = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if version != "STSv1" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidPolicy, version)
+	}
+	if !maxAge {
+		return nil, fmt.Errorf("%w: missing max_age", ErrInvalidPolicy)
+	}
+
+	switch policy.Mode {
+	case ModeEnforce, ModeTesting:
+		if len(policy.MX) == 0 {
+			return nil, fmt.Errorf("%w: missing mx", ErrInvalidPolicy)
+		}
+	case ModeNone:
+	default:
+		return nil, fmt.Errorf("%w: invalid mode %q", ErrInvalidPolicy, policy.Mode)
+	}
+
+	return policy, nil
+}
+
+// String returns the policy in the format of the policy file.
+func (p *Policy) String() string {
+	var b strings.Builder
+	b.WriteString("version: STSv1\nmode: " + string(p.Mode) + "\n")
+	for _, mx := range p.MX {
+		b.WriteString("mx: " + mx + "\n")
+	}
+	b.WriteString("max_age: " + strconv.FormatInt(int64(p.MaxAge/time.Second), 10) + "\n")
+	return b.String()
+}
+
+// Match returns true if the MX host matches one of the mx patterns of the policy.
+// A pattern with a wildcard (e.g. "*.example.com") matches exactly one additional label.
+func (p *Policy) Match(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, pattern := range p.MX {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, rest, found := strings.Cut(host, ".")
+			if found && label != "" && rest == suffix {
+				return true
+			}
+			continue
+		}
+
+		if host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRecord returns the id of a MTA-STS TXT record (RFC 8461 3.1),
+// false is returned if it isn't a MTA-STS record.
+func parseRecord(record string) (string, bool, error) {
+	fields := strings.Split(record, ";")
+
+	if strings.TrimSpace(fields[0]) != "v=STSv1" {
+		return "", false, nil
+	}
+
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		if key == "id" {
+			if !validID(value) {
+				return "", true, fmt.Errorf("mtasts: invalid id %q", value)
+			}
+			return value, true, nil
+		}
+	}
+
+	return "", true, errors.New("mtasts: missing id")
+}
+
+// validID returns true if id consists of 1 to 32 alphanumeric characters.
+func validID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package mtasts
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	policy, err := Parse(strings.NewReader("version: STSv1\r\nmode: enforce\r\n" +
+		"mx: mail.example.com\r\nmx: *.Example.net\r\nmx: backupmx.example.com\r\nmax_age: 604800\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, &Policy{
+		Mode:   ModeEnforce,
+		MX:     []string{"mail.example.com", "*.example.net", "backupmx.example.com"},
+		MaxAge: 604800 * time.Second,
+	}, policy)
+
+	policy, err = Parse(strings.NewReader(policy.String()))
+	require.NoError(t, err)
+	require.Equal(t, []string{"mail.example.com", "*.example.net", "backupmx.example.com"}, policy.MX)
+
+	policy, err = Parse(strings.NewReader("version: STSv1\nmode: none\nmax_age: 99999999999\nunknown: x\n"))
+	require.NoError(t, err)
+	require.Equal(t, ModeNone, policy.Mode)
+	require.Equal(t, maxMaxAge*time.Second, policy.MaxAge)
+}
+
+func TestParse_Errors(t *testing.T) {
+	testCases := []struct {
+		policy string
+		err    string
+	}{
+		{"mode: enforce\nmx: a.example\nmax_age: 1\n", "unsupported version"},
+		{"version: STSv1\nmode: enforce\nmx: a.example\n", "missing max_age"},
+		{"version: STSv1\nmode: enforce\nmx: a.example\nmax_age: -1\n", "invalid max_age"},
+		{"version: STSv1\nmode: enforce\nmax_age: 1\n", "missing mx"},
+		{"version: STSv1\nmode: strict\nmx: a.example\nmax_age: 1\n", "invalid mode"},
+		{"version: STSv1\nmode\n", "malformed line"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse(strings.NewReader(tc.policy))
+		require.ErrorIs(t, err, ErrInvalidPolicy)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestPolicy_Match(t *testing.T) {
+	policy := &Policy{MX: []string{"mail.example.com", "*.example.net"}}
+
+	require.True(t, policy.Match("mail.example.com"))
+	require.True(t, policy.Match("MAIL.example.com."))
+	require.True(t, policy.Match("mx1.example.net"))
+	require.False(t, policy.Match("example.net"))
+	require.False(t, policy.Match("a.mx1.example.net"))
+	require.False(t, policy.Match("mail.example.org"))
+}
+
+func TestParseRecord(t *testing.T) {
+	id, ok, err := parseRecord("v=STSv1; id=20160831085700Z;")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "20160831085700Z", id)
+
+	_, ok, _ = parseRecord("v=spf1 -all")
+	require.False(t, ok)
+
+	_, ok, err = parseRecord("v=STSv1; id=invalid-id")
+	require.True(t, ok)
+	require.ErrorContains(t, err, "invalid id")
+
+	_, _, err = parseRecord("v=STSv1;")
+	require.ErrorContains(t, err, "missing id")
+}
@@ -0,0 +1,154 @@
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OAuth2 sasl mechanisms.
+const (
+	// XOAuth2 is the mechanism used by Google and Microsoft.
+	XOAuth2 = "XOAUTH2"
+	// OAuthBearer is the mechanism defined in RFC 7628.
+	OAuthBearer = "OAUTHBEARER"
+)
+
+// OAuth2Error is sent by the server as challenge if a bearer token is rejected (RFC 7628 3.2.2).
+type OAuth2Error struct {
+	Status  string `json:"status"`
+	Schemes string `json:"schemes,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+// Error implements the error interface.
+func (err *OAuth2Error) Error() string {
+	return fmt.Sprintf("smtp: bearer token rejected (%s)", err.Status)
+}
+
+// OAuth2Credentials are the credentials of a XOAUTH2 or OAUTHBEARER authentication.
+type OAuth2Credentials struct {
+	// Username (XOAUTH2) or authorization identity (OAUTHBEARER).
+	Username string
+	// Bearer token.
+	Token string
+	// Host and Port the client connected to, only set by OAUTHBEARER.
+	Host string
+	Port int
+}
+
+// EncodeOAuth2 returns the client response for the given mechanism (XOAuth2 or OAuthBearer).
+func EncodeOAuth2(mech string, credentials OAuth2Credentials) ([]byte, error) {
+	switch mech {
+	case XOAuth2:
+		return []byte("user=" + credentials.Username + "\x01auth=Bearer " + credentials.Token + "\x01\x01"), nil
+	case OAuthBearer:
+		var b strings.Builder
+		b.WriteString("n,")
+		if credentials.Username != "" {
+			b.WriteString("a=" + saslNameEscaper.Replace(credentials.Username))
+		}
+		b.WriteString(",")
+		if credentials.Host != "" {
+			b.WriteString("\x01host=" + credentials.Host)
+		}
+		if credentials.Port != 0 {
+			b.WriteString("\x01port=" + strconv.Itoa(credentials.Port))
+		}
+		b.WriteString("\x01auth=Bearer " + credentials.Token + "\x01\x01")
+		return []byte(b.String()), nil
+	default:
+		return nil, fmt.Errorf("smtp: unknown OAuth2 mechanism %s", mech)
+	}
+}
+
+// DecodeOAuth2 parses the client response of the given mechanism (XOAuth2 or OAuthBearer).
+func DecodeOAuth2(mech string, response []byte) (OAuth2Credentials, error) {
+	credentials := OAuth2Credentials{}
+
+	switch mech {
+	case XOAuth2:
+	case OAuthBearer:
+		// gs2 header: n,a=username,
+		parts := bytes.SplitN(response, []byte{','}, 3)
+		if len(parts) != 3 || !bytes.Equal(parts[0], []byte{'n'}) {
+			return credentials, errors.New("smtp: invalid gs2 header")
+		}
+		if len(parts[1]) > 0 {
+			username, ok := bytes.CutPrefix(parts[1], []byte("a="))
+			if !ok {
+				return credentials, errors.New("smtp: invalid gs2 authzid")
+			}
+			name, err := unescapeSASLName(string(username))
+			if err != nil {
+				return credentials, err
+			}
+			credentials.Username = name
+		}
+		response = parts[2]
+	default:
+		return credentials, fmt.Errorf("smtp: unknown OAuth2 mechanism %s", mech)
+	}
+
+	for kv := range bytes.SplitSeq(response, []byte{0x01}) {
+		if len(kv) == 0 {
+			continue
+		}
+
+		key, value, ok := strings.Cut(string(kv), "=")
+		if !ok {
+			return credentials, errors.New("smtp: invalid key value pair")
+		}
+
+		switch key {
+		case "user":
+			credentials.Username = value
+		case "host":
+			credentials.Host = value
+		case "port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return credentials, errors.New("smtp: invalid port")
+			}
+			credentials.Port = int(port)
+		case "auth":
+			scheme, token, ok := strings.Cut(value, " ")
+			if !ok || !strings.EqualFold(scheme, "bearer") {
+				return credentials, errors.New("smtp: unsupported token type")
+			}
+			credentials.Token = token
+		}
+	}
+
+	if credentials.Token == "" {
+		return credentials, errors.New("smtp: missing bearer token")
+	}
+
+	return credentials, nil
+}
+
+// saslNameEscaper escapes the authzid of a gs2 header (RFC 5801 Section 4).
+var saslNameEscaper = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+// unescapeSASLName decodes the authzid of a gs2 header, "=" is only allowed in "=2C" and "=3D".
+func unescapeSASLName(name string) (string, error) {
+	var b strings.Builder
+	for {
+		before, after, found := strings.Cut(name, "=")
+		b.WriteString(before)
+		if !found {
+			return b.String(), nil
+		}
+		switch {
+		case strings.HasPrefix(after, "2C"):
+			b.WriteByte(',')
+		case strings.HasPrefix(after, "3D"):
+			b.WriteByte('=')
+		default:
+			return "", errors.New("smtp: invalid gs2 authzid")
+		}
+		name = after[2:]
+	}
+}
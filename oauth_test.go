@@ -0,0 +1,70 @@
+package smtp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuth2(t *testing.T) {
+	credentials := OAuth2Credentials{
+		Username: "user@example.com",
+		Token:    "ya29.token",
+		Host:     "smtp.example.com",
+		Port:     587,
+	}
+
+	response, err := EncodeOAuth2(XOAuth2, credentials)
+	require.NoError(t, err)
+	require.Equal(t, "user=user@example.com\x01auth=Bearer ya29.token\x01\x01", string(response))
+
+	decoded, err := DecodeOAuth2(XOAuth2, response)
+	require.NoError(t, err)
+	require.Equal(t, OAuth2Credentials{Username: "user@example.com", Token: "ya29.token"}, decoded)
+
+	response, err = EncodeOAuth2(OAuthBearer, credentials)
+	require.NoError(t, err)
+	require.Equal(t, "n,a=user@example.com,\x01host=smtp.example.com\x01port=587\x01auth=Bearer ya29.token\x01\x01",
+		string(response))
+
+	decoded, err = DecodeOAuth2(OAuthBearer, response)
+	require.NoError(t, err)
+	require.Equal(t, credentials, decoded)
+
+	// the authzid is escaped in the gs2 header
+	credentials.Username = "a,b=c"
+	response, err = EncodeOAuth2(OAuthBearer, credentials)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(response), "n,a=a=2Cb=3Dc,\x01"))
+
+	decoded, err = DecodeOAuth2(OAuthBearer, response)
+	require.NoError(t, err)
+	require.Equal(t, credentials, decoded)
+
+	_, err = EncodeOAuth2("PLAIN", credentials)
+	require.Error(t, err)
+}
+
+func TestDecodeOAuth2_Errors(t *testing.T) {
+	testCases := []struct {
+		mech     string
+		response string
+	}{
+		{"PLAIN", "user=a\x01auth=Bearer t\x01\x01"},
+		{XOAuth2, "user=a\x01\x01"},
+		{XOAuth2, "user=a\x01auth=Basic t\x01\x01"},
+		{XOAuth2, "user=a\x01invalid\x01\x01"},
+		{OAuthBearer, "y,,\x01auth=Bearer t\x01\x01"},
+		{OAuthBearer, "n,u=a,\x01auth=Bearer t\x01\x01"},
+		{OAuthBearer, "n,,\x01port=x\x01auth=Bearer t\x01\x01"},
+		{OAuthBearer, "\x01auth=Bearer t\x01\x01"},
+		{OAuthBearer, "n,a=a=b,\x01auth=Bearer t\x01\x01"},
+		{OAuthBearer, "n,a=a=2,\x01auth=Bearer t\x01\x01"},
+	}
+
+	for _, tc := range testCases {
+		_, err := DecodeOAuth2(tc.mech, []byte(tc.response))
+		require.Error(t, err, "%s %q", tc.mech, tc.response)
+	}
+}
@@ -0,0 +1,156 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client consults a policy service, connections are kept open for further requests.
+// It is safe for concurrent use.
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+	maxIdle int
+
+	mu   sync.Mutex
+	idle []*clientConn
+}
+
+// clientConn is a connection to the policy service.
+type clientConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Option defines a client option.
+type Option func(c *Client)
+
+// WithTimeout sets the timeout of the connect and of a request (default 10 seconds).
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithMaxIdle sets the maximum number of idle connections kept open (default 4).
+func WithMaxIdle(maxIdle int) Option {
+	return func(c *Client) {
+		c.maxIdle = maxIdle
+	}
+}
+
+// NewClient returns a client for the policy service listening on address,
+// e.g. NewClient("tcp", "127.0.0.1:10023") or NewClient("unix", "/run/postgrey.sock").
+func NewClient(network string, address string, opts ...Option) *Client {
+	c := &Client{
+		network: network,
+		address: address,
+		timeout: 10 * time.Second,
+		maxIdle: 4,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// Check sends a request and returns the response of the service. A request on an idle
+// connection which was closed by the service is retried on a new connection.
+func (c *Client) Check(ctx context.Context, req *Request) (*Response, error) {
+	cc, reused := c.get()
+	if cc != nil {
+		res, err := c.check(ctx, cc, req)
+		if err == nil || !reused {
+			return res, err
+		}
+	}
+
+	cc, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.check(ctx, cc, req)
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+
+	var err error
+	for _, cc := range idle {
+		if cerr := cc.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// check sends the request on a connection, the connection is returned to the pool on success.
+func (c *Client) check(ctx context.Context, cc *clientConn, req *Request) (*Response, error) {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = cc.conn.SetDeadline(deadline)
+
+	res, err := c.roundTrip(cc, req)
+	if err != nil {
+		_ = cc.conn.Close()
+		return nil, err
+	}
+
+	c.put(cc)
+	return res, nil
+}
+
+// roundTrip writes the request and reads the response.
+func (*Client) roundTrip(cc *clientConn, req *Request) (*Response, error) {
+	if _, err := req.WriteTo(cc.conn); err != nil {
+		return nil, err
+	}
+	return ReadResponse(cc.r)
+}
+
+// dial opens a new connection.
+func (c *Client) dial(ctx context.Context) (*clientConn, error) {
+	d := net.Dialer{Timeout: c.timeout}
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// get returns an idle connection, reused is false if there is none.
+func (c *Client) get() (cc *clientConn, reused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle) == 0 {
+		return nil, false
+	}
+	cc = c.idle[len(c.idle)-1]
+	c.idle = c.idle[:len(c.idle)-1]
+	return cc, true
+}
+
+// put returns a connection to the pool or closes it if the pool is full.
+func (c *Client) put(cc *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle) >= c.maxIdle {
+		_ = cc.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, cc)
+}
@@ -0,0 +1,447 @@
+// Package policy implements the Postfix SMTP access policy delegation protocol
+// (check_policy_service). A request is a list of name=value lines terminated by an empty line,
+// the service replies with an action=... line terminated by an empty line.
+//
+// Client consults a policy service, e.g. inside Session.Rcpt. NewRequest fills in the
+// connection and the envelope, which only the session knows, as well as the SASL login
+// (e.g. from its Auth callback):
+//
+//	req := policy.NewRequest(s.conn, policy.StateRcpt, &policy.Envelope{
+//		From:        s.from,
+//		MailOptions: s.mailOpts,
+//		To:          to,
+//		Username:    s.username,
+//	})
+//	res, err := s.policy.Check(ctx, req)
+//	if err != nil {
+//		return smtp.NewStatus(451, smtp.EnhancedCode{4, 3, 5}, "Server configuration problem")
+//	}
+//	if status := res.Status(); status != nil {
+//		return status
+//	}
+//
+// Server implements a policy service with a Handler.
+package policy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/server"
+)
+
+// maxLine is the maximum length of an attribute line.
+const maxLine = 4096
+
+// maxAttributes is the maximum number of attributes of a request.
+const maxAttributes = 1000
+
+// ErrProtocol is returned if a peer violates the protocol.
+var ErrProtocol = errors.New("policy: protocol error")
+
+// States of the SMTP protocol.
+const (
+	StateConnect      = "CONNECT"
+	StateHelo         = "EHLO"
+	StateMail         = "MAIL"
+	StateRcpt         = "RCPT"
+	StateData         = "DATA"
+	StateEndOfMessage = "END-OF-MESSAGE"
+	StateVrfy         = "VRFY"
+)
+
+// Request is a policy request. Attributes without a field are kept in Attributes.
+type Request struct {
+	Request        string // always smtpd_access_policy
+	ProtocolState  string // e.g. StateRcpt
+	ProtocolName   string // SMTP or ESMTP
+	HeloName       string
+	QueueID        string
+	Sender         string
+	Recipient      string
+	RecipientCount int
+	ClientAddress  string
+	ClientName     string
+	ClientPort     int
+	ServerAddress  string
+	ServerPort     int
+	Instance       string
+	SASLMethod     string
+	SASLUsername   string
+	SASLSender     string
+	Size           int64
+
+	CCertSubject       string
+	CCertIssuer        string
+	CCertFingerprint   string
+	EncryptionProtocol string
+	EncryptionCipher   string
+	EncryptionKeysize  int
+
+	Attributes map[string]string
+}
+
+// Envelope is the state of a session which isn't known by the connection.
+type Envelope struct {
+	// From and MailOptions of MAIL FROM, empty before MAIL FROM.
+	From        string
+	MailOptions *smtp.MailOptions
+
+	// To is the recipient of the current RCPT TO, empty in other states.
+	To string
+
+	// Username is the SASL login name of the client, empty if not authenticated.
+	Username string
+}
+
+// NewRequest returns a request for the given state filled in from the connection (client and
+// server address, HELO name, SASL method, recipient count and TLS) and env (sender, size,
+// recipient and SASL login), env may be nil before MAIL FROM.
+func NewRequest(c *server.Conn, state string, env *Envelope) *Request {
+	req := &Request{
+		Request:       "smtpd_access_policy",
+		ProtocolState: state,
+		ProtocolName:  "ESMTP",
+		HeloName:      c.Hostname(),
+		ClientName:    "unknown",
+		SASLMethod:    c.AuthMechanism(),
+	}
+
+	// like Postfix, the count is only set after all recipients are known
+	if state == StateData || state == StateEndOfMessage {
+		req.RecipientCount = c.Recipients()
+	}
+
+	if env != nil {
+		req.Sender = env.From
+		req.Recipient = env.To
+		req.SASLUsername = env.Username
+		if env.MailOptions != nil {
+			req.Size = env.MailOptions.Size
+			if env.MailOptions.Auth != nil {
+				req.SASLSender = *env.MailOptions.Auth
+			}
+		}
+	}
+
+	if addr, ok := c.Conn().RemoteAddr().(*net.TCPAddr); ok {
+		req.ClientAddress = addr.IP.String()
+		req.ClientPort = addr.Port
+	}
+	if addr, ok := c.Conn().LocalAddr().(*net.TCPAddr); ok {
+		req.ServerAddress = addr.IP.String()
+		req.ServerPort = addr.Port
+	}
+
+	if cs, ok := c.TLSConnectionState(); ok {
+		req.EncryptionProtocol = strings.Replace(tls.VersionName(cs.Version), "TLS ", "TLSv", 1)
+		req.EncryptionCipher = tls.CipherSuiteName(cs.CipherSuite)
+		req.EncryptionKeysize = keysize(req.EncryptionCipher)
+
+		if len(cs.PeerCertificates) > 0 {
+			cert := cs.PeerCertificates[0]
+			req.CCertSubject = cert.Subject.CommonName
+			req.CCertIssuer = cert.Issuer.CommonName
+			req.CCertFingerprint = fingerprint(cert.Raw)
+		}
+	}
+
+	return req
+}
+
+// fields returns the attributes in the order sent by Postfix.
+func (r *Request) fields() [][2]string {
+	return [][2]string{
+		{"request", r.Request},
+		{"protocol_state", r.ProtocolState},
+		{"protocol_name", r.ProtocolName},
+		{"helo_name", r.HeloName},
+		{"queue_id", r.QueueID},
+		{"sender", r.Sender},
+		{"recipient", r.Recipient},
+		{"recipient_count", strconv.Itoa(r.RecipientCount)},
+		{"client_address", r.ClientAddress},
+		{"client_name", r.ClientName},
+		{"client_port", itoa(r.ClientPort)},
+		{"server_address", r.ServerAddress},
+		{"server_port", itoa(r.ServerPort)},
+		{"instance", r.Instance},
+		{"sasl_method", r.SASLMethod},
+		{"sasl_username", r.SASLUsername},
+		{"sasl_sender", r.SASLSender},
+		{"size", strconv.FormatInt(r.Size, 10)},
+		{"ccert_subject", r.CCertSubject},
+		{"ccert_issuer", r.CCertIssuer},
+		{"ccert_fingerprint", r.CCertFingerprint},
+		{"encryption_protocol", r.EncryptionProtocol},
+		{"encryption_cipher", r.EncryptionCipher},
+		{"encryption_keysize", strconv.Itoa(r.EncryptionKeysize)},
+	}
+}
+
+// WriteTo writes the request including the terminating empty line.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, f := range r.fields() {
+		writeAttribute(&b, f[0], f[1])
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.Attributes)) {
+		writeAttribute(&b, name, r.Attributes[name])
+	}
+	b.WriteByte('\n')
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ReadRequest reads a request terminated by an empty line.
+func ReadRequest(r *bufio.Reader) (*Request, error) {
+	attrs, err := readAttributes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{Attributes: map[string]string{}}
+	set := map[string]func(string){
+		"request":             func(v string) { req.Request = v },
+		"protocol_state":      func(v string) { req.ProtocolState = v },
+		"protocol_name":       func(v string) { req.ProtocolName = v },
+		"helo_name":           func(v string) { req.HeloName = v },
+		"queue_id":            func(v string) { req.QueueID = v },
+		"sender":              func(v string) { req.Sender = v },
+		"recipient":           func(v string) { req.Recipient = v },
+		"recipient_count":     func(v string) { req.RecipientCount, _ = strconv.Atoi(v) },
+		"client_address":      func(v string) { req.ClientAddress = v },
+		"client_name":         func(v string) { req.ClientName = v },
+		"client_port":         func(v string) { req.ClientPort, _ = strconv.Atoi(v) },
+		"server_address":      func(v string) { req.ServerAddress = v },
+		"server_port":         func(v string) { req.ServerPort, _ = strconv.Atoi(v) },
+		"instance":            func(v string) { req.Instance = v },
+		"sasl_method":         func(v string) { req.SASLMethod = v },
+		"sasl_username":       func(v string) { req.SASLUsername = v },
+		"sasl_sender":         func(v string) { req.SASLSender = v },
+		"size":                func(v string) { req.Size, _ = strconv.ParseInt(v, 10, 64) },
+		"ccert_subject":       func(v string) { req.CCertSubject = v },
+		"ccert_issuer":        func(v string) { req.CCertIssuer = v },
+		"ccert_fingerprint":   func(v string) { req.CCertFingerprint = v },
+		"encryption_protocol": func(v string) { req.EncryptionProtocol = v },
+		"encryption_cipher":   func(v string) { req.EncryptionCipher = v },
+		"encryption_keysize":  func(v string) { req.EncryptionKeysize, _ = strconv.Atoi(v) },
+	}
+
+	for _, a := range attrs {
+		if f, ok := set[a[0]]; ok {
+			f(a[1])
+		} else {
+			req.Attributes[a[0]] = a[1]
+		}
+	}
+
+	return req, nil
+}
+
+// Response is the response of a policy service.
+type Response struct {
+	// Action is e.g. "DUNNO", "REJECT Go away", "450 4.7.1 Try again later" or "PREPEND X-Policy: checked".
+	Action string
+}
+
+// Dunno continues with the next restriction.
+func Dunno() *Response {
+	return &Response{Action: "DUNNO"}
+}
+
+// OK accepts the request.
+func OK() *Response {
+	return &Response{Action: "OK"}
+}
+
+// Reject rejects the request permanently with an optional text.
+func Reject(text string) *Response {
+	return &Response{Action: strings.TrimSpace("REJECT " + text)}
+}
+
+// Defer rejects the request temporarily with an optional text.
+func Defer(text string) *Response {
+	return &Response{Action: strings.TrimSpace("DEFER " + text)}
+}
+
+// Prepend adds a header field to the message, e.g. "X-Policy: checked".
+func Prepend(header string) *Response {
+	return &Response{Action: "PREPEND " + header}
+}
+
+// Reply rejects the request with a SMTP status.
+func Reply(status *smtp.Status) *Response {
+	text := strings.ReplaceAll(status.Message, "\n", " ")
+	if status.EnhancedCode != smtp.NoEnhancedCode && status.EnhancedCode != smtp.EnhancedCodeNotSet {
+		text = fmt.Sprintf("%d.%d.%d %s", status.EnhancedCode[0], status.EnhancedCode[1], status.EnhancedCode[2], text)
+	}
+	return &Response{Action: strconv.Itoa(status.Code) + " " + text}
+}
+
+// Verb returns the upper case action without text, e.g. "REJECT".
+func (r *Response) Verb() string {
+	verb, _, _ := strings.Cut(r.Action, " ")
+	return strings.ToUpper(verb)
+}
+
+// Text returns the text following the verb.
+func (r *Response) Text() string {
+	_, text, _ := strings.Cut(r.Action, " ")
+	return strings.TrimSpace(text)
+}
+
+// Status returns the SMTP status of a rejecting action: REJECT, DEFER, DEFER_IF_PERMIT or a
+// 4XX/5XX reply code. It returns nil for all other actions like OK, DUNNO or PREPEND.
+func (r *Response) Status() *smtp.Status {
+	verb, text := r.Verb(), r.Text()
+
+	switch verb {
+	case "REJECT":
+		return status(554, smtp.EnhancedCode{5, 7, 1}, text, "Access denied")
+	case "DEFER", "DEFER_IF_PERMIT":
+		return status(450, smtp.EnhancedCode{4, 7, 1}, text, "Service unavailable")
+	}
+
+	code, err := strconv.Atoi(verb)
+	if err != nil || len(verb) != 3 || (code/100 != 4 && code/100 != 5) {
+		return nil
+	}
+	return status(code, smtp.EnhancedCode{code / 100, 7, 1}, text, "Access denied")
+}
+
+// Header returns the header field of a PREPEND action.
+func (r *Response) Header() (string, bool) {
+	if r.Verb() != "PREPEND" || r.Text() == "" {
+		return "", false
+	}
+	return r.Text(), true
+}
+
+// WriteTo writes the response including the terminating empty line.
+func (r *Response) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	writeAttribute(&b, "action", r.Action)
+	b.WriteByte('\n')
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ReadResponse reads a response terminated by an empty line.
+func ReadResponse(r *bufio.Reader) (*Response, error) {
+	attrs, err := readAttributes(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		if a[0] == "action" {
+			return &Response{Action: a[1]}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: response without action", ErrProtocol)
+}
+
+// status returns a status with the enhanced code of the text or def.
+func status(code int, def smtp.EnhancedCode, text string, defText string) *smtp.Status {
+	if text == "" {
+		text = defText
+	}
+	s := smtp.ParseStatus(code, text)
+	if s.EnhancedCode == smtp.EnhancedCodeNotSet {
+		s.EnhancedCode = def
+	}
+	return s
+}
+
+// readAttributes reads name=value lines until an empty line.
+func readAttributes(r *bufio.Reader) ([][2]string, error) {
+	attrs := [][2]string{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return attrs, nil
+		}
+		if len(attrs) >= maxAttributes {
+			return nil, fmt.Errorf("%w: too many attributes", ErrProtocol)
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: invalid attribute %q", ErrProtocol, line)
+		}
+		attrs = append(attrs, [2]string{name, value})
+	}
+}
+
+// readLine reads a line without line ending.
+func readLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if b.Len()+len(chunk) > maxLine {
+			return "", fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		b.Write(chunk)
+		if !isPrefix {
+			return b.String(), nil
+		}
+	}
+}
+
+// writeAttribute writes a name=value line, line breaks of value are replaced by spaces.
+func writeAttribute(b *strings.Builder, name string, value string) {
+	b.WriteString(name)
+	b.WriteByte('=')
+	b.WriteString(strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value))
+	b.WriteByte('\n')
+}
+
+// itoa formats a port, 0 is formatted as an empty value.
+func itoa(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+// keysize returns the symmetric key size of a cipher suite.
+func keysize(cipher string) int {
+	switch {
+	case strings.Contains(cipher, "AES_256"), strings.Contains(cipher, "CHACHA20"):
+		return 256
+	case strings.Contains(cipher, "AES_128"):
+		return 128
+	case strings.Contains(cipher, "3DES"):
+		return 168
+	default:
+		return 0
+	}
+}
+
+// fingerprint returns the SHA-256 fingerprint of a certificate as colon separated hex.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
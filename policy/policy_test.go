@@ -0,0 +1,171 @@
+package policy_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-sasl"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/policy"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+func TestResponse_Status(t *testing.T) {
+	for action, expected := range map[string]*smtp.Status{
+		"DUNNO":                       nil,
+		"OK":                          nil,
+		"PREPEND X-Policy: checked":   nil,
+		"DEFER_IF_REJECT Maybe later": nil,
+		"REJECT":                      smtp.NewStatus(554, smtp.EnhancedCode{5, 7, 1}, "Access denied"),
+		"reject Go away":              smtp.NewStatus(554, smtp.EnhancedCode{5, 7, 1}, "Go away"),
+		"REJECT 5.7.2 Blocked":        smtp.NewStatus(554, smtp.EnhancedCode{5, 7, 2}, "Blocked"),
+		"DEFER Greylisted":            smtp.NewStatus(450, smtp.EnhancedCode{4, 7, 1}, "Greylisted"),
+		"DEFER_IF_PERMIT":             smtp.NewStatus(450, smtp.EnhancedCode{4, 7, 1}, "Service unavailable"),
+		"451 4.7.1 Try again":         smtp.NewStatus(451, smtp.EnhancedCode{4, 7, 1}, "Try again"),
+		"550 Unknown user":            smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Unknown user"),
+		"250 OK":                      nil,
+	} {
+		require.Equal(t, expected, (&policy.Response{Action: action}).Status(), action)
+	}
+
+	header, ok := policy.Prepend("X-Policy: checked").Header()
+	require.True(t, ok)
+	require.Equal(t, "X-Policy: checked", header)
+
+	_, ok = policy.Dunno().Header()
+	require.False(t, ok)
+
+	require.Equal(t, "550 5.1.1 Unknown user",
+		policy.Reply(smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "Unknown user")).Action)
+}
+
+func TestRequest(t *testing.T) {
+	req := &policy.Request{
+		Request:       "smtpd_access_policy",
+		ProtocolState: policy.StateRcpt,
+		Sender:        "",
+		Recipient:     "root@example.org",
+		ClientPort:    2525,
+		Size:          1024,
+		Attributes:    map[string]string{"policy_context": "submission\r\nsender=evil"},
+	}
+
+	var b bytes.Buffer
+	_, err := req.WriteTo(&b)
+	require.NoError(t, err)
+	require.Contains(t, b.String(), "protocol_state=RCPT\n")
+	require.Contains(t, b.String(), "sender=\n")
+	require.Contains(t, b.String(), "server_port=\n")
+	require.True(t, strings.HasSuffix(b.String(), "policy_context=submission sender=evil\n\n"))
+
+	read, err := policy.ReadRequest(bufio.NewReader(&b))
+	require.NoError(t, err)
+	req.Attributes["policy_context"] = "submission sender=evil"
+	require.Equal(t, req, read)
+
+	_, err = policy.ReadRequest(bufio.NewReader(strings.NewReader("invalid\n\n")))
+	require.ErrorIs(t, err, policy.ErrProtocol)
+
+	_, err = policy.ReadResponse(bufio.NewReader(strings.NewReader("foo=bar\n\n")))
+	require.ErrorIs(t, err, policy.ErrProtocol)
+}
+
+type connBackend struct {
+	*tester.Backend
+	conns chan *server.Conn
+}
+
+func (b connBackend) NewSession(ctx context.Context, c *server.Conn) (context.Context, server.Session, error) {
+	b.conns <- c
+	return b.Backend.NewSession(ctx, c)
+}
+
+func TestNewRequest(t *testing.T) {
+	cert, err := tester.GenX509KeyPair("localhost")
+	require.NoError(t, err)
+
+	backend := tester.NewBackend()
+	backend.AuthMechanisms = []string{sasl.Plain}
+	backend.Auth = func(context.Context, string) (sasl.Server, error) {
+		return sasl.NewPlainServer(func(string, string, string) error { return nil }), nil
+	}
+	be := connBackend{Backend: backend, conns: make(chan *server.Conn, 1)}
+	srv := tester.Standard(
+		server.WithBackend(be),
+		server.WithAddr("127.0.0.1:0"),
+		server.WithImplicitTLS(true),
+		server.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	)
+	l, err := srv.Listen()
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+	defer func() { _ = srv.Close() }()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true}) // nolint: gosec
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	_, err = r.ReadString('\n')
+	require.NoError(t, err)
+	c := <-be.conns
+
+	for _, cmd := range []string{
+		"EHLO client.example.org",
+		"AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00alice\x00secret")),
+		"MAIL FROM:<alice@example.org>",
+		"RCPT TO:<bob@example.com>",
+	} {
+		_, err = io.WriteString(conn, cmd+"\r\n")
+		require.NoError(t, err)
+		// multiline replies are read up to the last line
+		line := ""
+		for len(line) < 4 || line[3] == '-' {
+			line, err = r.ReadString('\n')
+			require.NoError(t, err)
+		}
+		require.Equal(t, byte('2'), line[0], line)
+	}
+
+	auth := "alice@example.org"
+	req := policy.NewRequest(c, policy.StateRcpt, &policy.Envelope{
+		From:        "alice@example.org",
+		MailOptions: &smtp.MailOptions{Size: 42, Auth: &auth},
+		To:          "bob@example.com",
+		Username:    "alice",
+	})
+
+	local := conn.LocalAddr().(*net.TCPAddr)
+	require.Equal(t, "smtpd_access_policy", req.Request)
+	require.Equal(t, policy.StateRcpt, req.ProtocolState)
+	require.Equal(t, "client.example.org", req.HeloName)
+	require.Equal(t, "127.0.0.1", req.ClientAddress)
+	require.Equal(t, local.Port, req.ClientPort)
+	require.Equal(t, "alice@example.org", req.Sender)
+	require.Equal(t, "alice@example.org", req.SASLSender)
+	require.Equal(t, int64(42), req.Size)
+	require.Equal(t, "bob@example.com", req.Recipient)
+	require.Zero(t, req.RecipientCount)
+	require.Equal(t, sasl.Plain, req.SASLMethod)
+	require.Equal(t, "alice", req.SASLUsername)
+	require.Equal(t, "TLSv1.3", req.EncryptionProtocol)
+	require.NotEmpty(t, req.EncryptionCipher)
+	require.NotZero(t, req.EncryptionKeysize)
+
+	// the recipients are counted at DATA
+	req = policy.NewRequest(c, policy.StateData, nil)
+	require.Equal(t, 1, req.RecipientCount)
+	require.Equal(t, sasl.Plain, req.SASLMethod)
+	require.Empty(t, req.Sender)
+}
@@ -0,0 +1,220 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/internal/serve"
+)
+
+// ErrServerClosed occurs if a server is already closed.
+var ErrServerClosed = errors.New("policy: server already closed")
+
+// Handler decides about policy requests, it must be safe for concurrent use.
+// A nil response is DUNNO, an *smtp.Status error is sent as reply code and any other error
+// defers the request if it would be permitted (DEFER_IF_PERMIT).
+type Handler interface {
+	Check(ctx context.Context, req *Request) (*Response, error)
+}
+
+// HandlerFunc is a function used as Handler.
+type HandlerFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// Check calls f.
+func (f HandlerFunc) Check(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
+
+// Server implements a policy service, e.g. for Postfix:
+//
+//	smtpd_recipient_restrictions = ..., check_policy_service inet:127.0.0.1:10023
+type Server struct {
+	// The type of network, "tcp" or "unix".
+	network string
+	// TCP or Unix address to listen on.
+	addr string
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	handler Handler
+
+	logger *slog.Logger
+
+	lifecycle *serve.Lifecycle
+}
+
+// ServerOption is an option for the server.
+type ServerOption func(*Server)
+
+// NewServer creates a new policy server.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		lifecycle: serve.New(ErrServerClosed),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+
+	return s
+}
+
+// WithHandler sets the handler.
+func WithHandler(handler Handler) ServerOption {
+	return func(s *Server) {
+		s.handler = handler
+	}
+}
+
+// WithNetwork sets the network, "tcp" (default) or "unix".
+func WithNetwork(network string) ServerOption {
+	return func(s *Server) {
+		s.network = network
+	}
+}
+
+// WithAddr sets the address to listen on.
+func WithAddr(addr string) ServerOption {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithReadTimeout sets the read timeout, e.g. to close idle connections.
+func WithReadTimeout(readTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.readTimeout = readTimeout
+	}
+}
+
+// WithWriteTimeout sets the write timeout.
+func WithWriteTimeout(writeTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.writeTimeout = writeTimeout
+	}
+}
+
+// Listen listens on the network address s.addr.
+func (s *Server) Listen() (net.Listener, error) {
+	network := s.network
+	if network == "" {
+		network = "tcp"
+	}
+	return net.Listen(network, s.addr)
+}
+
+// ListenAndServe listens on the network address s.addr and then calls Serve
+// to handle requests on incoming connections.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	l, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve accepts incoming connections on the Listener l.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	return s.lifecycle.Serve(ctx, s.logger, l, s.handleConn)
+}
+
+// Close immediately closes all active listeners and connections.
+//
+// Close returns any error returned from closing the server's underlying
+// listener(s).
+func (s *Server) Close() error {
+	return s.lifecycle.Close()
+}
+
+// Shutdown gracefully shuts down the server without interrupting any
+// active connections. Shutdown works by first closing all open
+// listeners and then waiting indefinitely for connections to be closed
+// by the clients.
+// If the provided context expires before the shutdown is complete,
+// Shutdown returns the context's error, otherwise it returns any
+// error returned from closing the Server's underlying Listener(s).
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.lifecycle.Shutdown(ctx)
+}
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer func() {
+		if err := recover(); err != nil {
+			s.logger.ErrorContext(
+				ctx,
+				"panic serving",
+				slog.Any("err", err),
+				slog.Any("stack", string(debug.Stack())),
+			)
+		}
+
+		_ = conn.Close()
+
+		cancel()
+	}()
+
+	err := s.serveConn(ctx, conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		// idle connection
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		s.logger.ErrorContext(ctx, "policy connection failed", slog.Any("err", err))
+	}
+}
+
+// serveConn answers requests until the client closes the connection.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	for {
+		if s.readTimeout != 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		}
+		req, err := ReadRequest(r)
+		if err != nil {
+			return err
+		}
+
+		res, err := s.handler.Check(ctx, req)
+		if err != nil {
+			if status, ok := err.(*smtp.Status); ok {
+				res = Reply(status)
+			} else {
+				s.logger.ErrorContext(ctx, "policy check failed", slog.Any("err", err))
+				res = &Response{Action: "DEFER_IF_PERMIT Service temporarily unavailable"}
+			}
+		}
+		if res == nil {
+			res = Dunno()
+		}
+
+		if s.writeTimeout != 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+		if _, err := res.WriteTo(conn); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/policy"
+)
+
+func startServer(t *testing.T, handler policy.HandlerFunc, opts ...policy.ServerOption) string {
+	opts = append([]policy.ServerOption{policy.WithHandler(handler), policy.WithAddr("127.0.0.1:0")}, opts...)
+	srv := policy.NewServer(opts...)
+	l, err := srv.Listen()
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+	t.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	addr := startServer(t, func(_ context.Context, req *policy.Request) (*policy.Response, error) {
+		switch req.Sender {
+		case "spam@example.org":
+			return policy.Reject("Go away"), nil
+		case "unknown@example.org":
+			return nil, smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 0}, "Unknown sender")
+		case "broken@example.org":
+			return nil, errors.New("database unavailable")
+		case "":
+			return policy.Prepend("X-Policy: " + req.Attributes["policy_context"]), nil
+		}
+		return nil, nil
+	})
+
+	c := policy.NewClient("tcp", addr)
+	defer func() { _ = c.Close() }()
+
+	check := func(sender string) *policy.Response {
+		req := &policy.Request{Request: "smtpd_access_policy", ProtocolState: policy.StateMail, Sender: sender}
+		req.Attributes = map[string]string{"policy_context": "bounce"}
+		res, err := c.Check(context.Background(), req)
+		require.NoError(t, err)
+		return res
+	}
+
+	require.Equal(t, "DUNNO", check("alice@example.org").Action)
+	require.Equal(t, smtp.NewStatus(554, smtp.EnhancedCode{5, 7, 1}, "Go away"), check("spam@example.org").Status())
+	require.Equal(t, smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 0}, "Unknown sender"),
+		check("unknown@example.org").Status())
+	require.Equal(t, "DEFER_IF_PERMIT", check("broken@example.org").Verb())
+
+	header, ok := check("").Header()
+	require.True(t, ok)
+	require.Equal(t, "X-Policy: bounce", header)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	addr := startServer(t, func(context.Context, *policy.Request) (*policy.Response, error) {
+		return policy.OK(), nil
+	}, policy.WithReadTimeout(50*time.Millisecond))
+
+	c := policy.NewClient("tcp", addr)
+	defer func() { _ = c.Close() }()
+
+	req := &policy.Request{Request: "smtpd_access_policy", ProtocolState: policy.StateRcpt}
+	res, err := c.Check(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "OK", res.Action)
+
+	// the idle connection is closed by the server
+	time.Sleep(100 * time.Millisecond)
+
+	res, err = c.Check(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "OK", res.Action)
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+// State is the delivery state of a recipient.
+type State string
+
+const (
+	// StatePending means the delivery is (re)tried at the next attempt time.
+	StatePending State = "pending"
+	// StateDelivered means the message was accepted for the recipient.
+	StateDelivered State = "delivered"
+	// StateFailed means the delivery failed permanently or the message expired.
+	StateFailed State = "failed"
+)
+
+// Recipient contains the delivery state of a single recipient.
+type Recipient struct {
+	Address string `json:"address"`
+	State   State  `json:"state"`
+	// Options are the parameters of the RCPT command, e.g. NOTIFY, nil if there weren't any.
+	Options *smtp.RcptOptions `json:"options,omitempty"`
+
+	// Number of delivery attempts.
+	Attempts int `json:"attempts,omitempty"`
+	// Time of the last attempt, zero if not tried yet.
+	LastAttempt time.Time `json:"last_attempt,omitzero"`
+	// Time of the next attempt if pending.
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+
+	// Server address used by the last attempt, if any.
+	Server string `json:"server,omitempty"`
+	// Status of the last attempt, nil if there wasn't any smtp status (e.g. a connection error).
+	Status *smtp.Status `json:"status,omitempty"`
+	// Error of the last attempt, empty on success.
+	Error string `json:"error,omitempty"`
+	// Expired is set if the message was given up because of its maximum lifetime.
+	Expired bool `json:"expired,omitempty"`
+}
+
+// Entry is a queued message with its envelope and delivery state.
+// The message itself is read with Queue.Open.
+type Entry struct {
+	// ID is the queue id returned by Enqueue.
+	ID string `json:"id"`
+	// From is the reverse path of the envelope.
+	From string `json:"from"`
+	// MailOptions are the parameters of the MAIL command, e.g. RET or REQUIRETLS, nil if there weren't any.
+	MailOptions *smtp.MailOptions `json:"mail_options,omitempty"`
+	Recipients  []Recipient       `json:"recipients"`
+	// Size of the message in bytes.
+	Size int64 `json:"size"`
+	// Created is the time the message was queued.
+	Created time.Time `json:"created"`
+	// Delayed is set after the delayed function was called (see WithDelayed).
+	Delayed bool `json:"delayed,omitempty"`
+}
+
+// Done returns true if no recipient is pending anymore.
+func (e *Entry) Done() bool {
+	for i := range e.Recipients {
+		if e.Recipients[i].State == StatePending {
+			return false
+		}
+	}
+	return true
+}
+
+// NextAttempt returns the earliest next attempt of all pending recipients, zero if done.
+func (e *Entry) NextAttempt() time.Time {
+	var next time.Time
+	found := false
+	for i := range e.Recipients {
+		r := &e.Recipients[i]
+		if r.State == StatePending && (!found || r.NextAttempt.Before(next)) {
+			next = r.NextAttempt
+			found = true
+		}
+	}
+	return next
+}
+
+// due returns the indexes of the pending recipients with a next attempt not after now.
+func (e *Entry) due(now time.Time) []int {
+	indexes := []int{}
+	for i := range e.Recipients {
+		r := &e.Recipients[i]
+		if r.State == StatePending && !r.NextAttempt.After(now) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// clone returns a deep copy of the entry.
+func (e *Entry) clone() Entry {
+	c := *e
+	c.Recipients = make([]Recipient, len(e.Recipients))
+	copy(c.Recipients, e.Recipients)
+	return c
+}
@@ -0,0 +1,284 @@
+// Package queue implements a persistent mail queue which stores accepted mail until it is delivered.
+//
+// Every message is written to the queue directory together with its envelope and the delivery
+// state of every recipient. Files are written atomically (write, fsync, rename), so the queue
+// is rebuilt from the directory after a crash. Deliveries interrupted by a crash are tried again.
+//
+// A server session queues the message and returns the queue id, while Run delivers the queue
+// in the background and retries temporary failures with an exponential backoff:
+//
+//	q, err := queue.New("/var/spool/smtp")
+//	if err != nil {
+//		return err
+//	}
+//	go q.Run(ctx, queue.Mailer())
+//
+//	func (s *session) Data(ctx context.Context, r func() io.Reader) (string, error) {
+//		return s.queue.EnqueueAdvanced(s.from, s.mailOpts, s.rcpts, s.rcptsOpts, r())
+//	}
+package queue
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+)
+
+var (
+	// ErrNotFound is returned if there is no entry with the given id.
+	ErrNotFound = errors.New("queue: entry not found")
+	// ErrBusy is returned if an entry can't be removed because it is being delivered.
+	ErrBusy = errors.New("queue: entry is being delivered")
+)
+
+// Queue is a persistent mail queue. It is safe for concurrent use.
+type Queue struct {
+	dir            string
+	logger         *slog.Logger
+	now            func() time.Time
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxLifetime    time.Duration
+	workers        int
+	completed      func(entry Entry)
+	delayAfter     time.Duration
+	delayed        func(entry Entry)
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	busy    map[string]bool
+	notify  chan struct{}
+}
+
+// Option defines a queue option.
+type Option func(q *Queue)
+
+// WithLogger sets the logger used to report delivery attempts and failures.
+func WithLogger(logger *slog.Logger) Option {
+	return func(q *Queue) {
+		q.logger = logger
+	}
+}
+
+// WithBackoff sets the backoff before the first retry of a recipient and its upper limit.
+// The backoff is doubled after every attempt (default: 5 minutes up to 4 hours).
+func WithBackoff(initial time.Duration, maximum time.Duration) Option {
+	return func(q *Queue) {
+		q.initialBackoff = initial
+		q.maxBackoff = maximum
+	}
+}
+
+// WithMaxLifetime sets how long temporary failures are retried before the message expires
+// and the remaining recipients fail (default: 5 days).
+func WithMaxLifetime(lifetime time.Duration) Option {
+	return func(q *Queue) {
+		q.maxLifetime = lifetime
+	}
+}
+
+// WithWorkers sets the number of concurrent deliveries of Run (default: 4).
+func WithWorkers(workers int) Option {
+	return func(q *Queue) {
+		q.workers = max(workers, 1)
+	}
+}
+
+// WithCompleted sets a function called after all recipients of an entry are delivered or failed,
+// right before the entry is removed, e.g. to send a delivery status notification.
+// The final state is saved before, so after a crash the function is called again by the next Run.
+func WithCompleted(completed func(entry Entry)) Option {
+	return func(q *Queue) {
+		q.completed = completed
+	}
+}
+
+// WithDelayed sets a function called once per entry if recipients are still pending after an attempt
+// and the message is queued for longer than after, e.g. to send a delay warning.
+func WithDelayed(after time.Duration, delayed func(entry Entry)) Option {
+	return func(q *Queue) {
+		q.delayAfter = after
+		q.delayed = delayed
+	}
+}
+
+// New returns the queue stored in dir, the directory is created if needed.
+// Existing entries are loaded, so a queue continues after a restart or a crash.
+func New(dir string, opts ...Option) (*Queue, error) {
+	q := &Queue{
+		dir:            dir,
+		logger:         slog.Default(),
+		now:            time.Now,
+		initialBackoff: 5 * time.Minute,
+		maxBackoff:     4 * time.Hour,
+		maxLifetime:    5 * 24 * time.Hour,
+		workers:        4,
+		busy:           map[string]bool{},
+		notify:         make(chan struct{}, 1),
+	}
+
+	for _, o := range opts {
+		o(q)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	entries, err := load(dir)
+	if err != nil {
+		return nil, err
+	}
+	q.entries = entries
+
+	return q, nil
+}
+
+// Enqueue stores the message read from r for the recipients and returns its queue id.
+// The message is stored persistently when Enqueue returns without error.
+func (q *Queue) Enqueue(from string, rcpts []string, r io.Reader) (string, error) {
+	return q.EnqueueAdvanced(from, nil, rcpts, nil, r)
+}
+
+// EnqueueAdvanced stores the message like Enqueue together with the parameters of the MAIL and
+// RCPT commands, which are used for the delivery and notifications (e.g. REQUIRETLS or NOTIFY).
+// The options of a recipient have the same index in rcptsOptions as the recipient in rcpts.
+func (q *Queue) EnqueueAdvanced(
+	from string,
+	mailOptions *smtp.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	r io.Reader,
+) (string, error) {
+	if len(rcpts) == 0 {
+		return "", errors.New("queue: no recipients")
+	}
+
+	now := q.time()
+	id := newID(now)
+
+	size, err := writeFile(q.dir, id+messageExt, r)
+	if err != nil {
+		return "", err
+	}
+
+	entry := &Entry{
+		ID:          id,
+		From:        from,
+		MailOptions: mailOptions,
+		Recipients:  make([]Recipient, len(rcpts)),
+		Size:        size,
+		Created:     now,
+	}
+	for i, rcpt := range rcpts {
+		entry.Recipients[i] = Recipient{Address: rcpt, State: StatePending, NextAttempt: now}
+		if i < len(rcptsOptions) {
+			entry.Recipients[i].Options = rcptsOptions[i]
+		}
+	}
+
+	if err := save(q.dir, entry); err != nil {
+		_ = os.Remove(filepath.Join(q.dir, id+messageExt))
+		return "", err
+	}
+
+	q.mu.Lock()
+	q.entries[id] = entry
+	q.mu.Unlock()
+
+	q.wake()
+
+	return id, nil
+}
+
+// Entries returns a copy of all entries ordered by creation.
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	entries := make([]Entry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		entries = append(entries, entry.clone())
+	}
+	q.mu.Unlock()
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		if a.ID < b.ID {
+			return -1
+		}
+		return 1
+	})
+
+	return entries
+}
+
+// Entry returns a copy of the entry with the given id.
+func (q *Queue) Entry(id string) (Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return entry.clone(), nil
+}
+
+// Open opens the message of the entry with the given id.
+func (q *Queue) Open(id string) (*os.File, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(filepath.Join(q.dir, id+messageExt)) // nolint: gosec
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Remove removes the entry with the given id without delivering it.
+func (q *Queue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.entries[id]; !ok {
+		return ErrNotFound
+	}
+	if q.busy[id] {
+		return ErrBusy
+	}
+
+	delete(q.entries, id)
+	return remove(q.dir, id)
+}
+
+// wake wakes up Run to check for due entries.
+func (q *Queue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// backoff returns the time to wait after the given attempt (starting with 1).
+func (q *Queue) backoff(attempt int) time.Duration {
+	backoff := q.initialBackoff
+	for i := 1; i < attempt && backoff < q.maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, q.maxBackoff)
+}
+
+// time returns the current time as it is stored (UTC without monotonic clock).
+func (q *Queue) time() time.Time {
+	return q.now().UTC().Round(0)
+}
@@ -0,0 +1,406 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/mailer"
+	"github.com/uponusolutions/go-smtp/queue"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+const message = "Subject: queued\r\n\r\nHello\r\n"
+
+// outcome returns the delivery result of rcpt with the given data status.
+func outcome(rcpt string, code int) mailer.Recipient {
+	return mailer.Recipient{
+		Address: rcpt,
+		Server:  "mx.example.com:25",
+		Rcpt:    smtp.NewStatus(250, smtp.EnhancedCode{2, 0, 0}, "OK"),
+		Data:    smtp.NewStatus(code, smtp.EnhancedCode{code / 100, 0, 0}, "result"),
+	}
+}
+
+// pending returns the addresses of the pending recipients.
+func pending(entry queue.Entry) []string {
+	rcpts := []string{}
+	for _, rcpt := range entry.Recipients {
+		if rcpt.State == queue.StatePending {
+			rcpts = append(rcpts, rcpt.Address)
+		}
+	}
+	return rcpts
+}
+
+// run runs q with deliver until an entry is completed.
+func run(t *testing.T, q *queue.Queue, completed <-chan queue.Entry, deliver queue.DeliverFunc) queue.Entry {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, deliver)
+		close(done)
+	}()
+
+	var entry queue.Entry
+	select {
+	case entry = <-completed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "entry not completed")
+	}
+
+	cancel()
+	<-done
+
+	return entry
+}
+
+func TestQueue_Enqueue(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := queue.New(dir)
+	require.NoError(t, err)
+
+	id, err := q.Enqueue("from@example.com", []string{"a@example.com", "b@example.com"}, strings.NewReader(message))
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	_, err = q.Enqueue("from@example.com", nil, strings.NewReader(message))
+	require.Error(t, err)
+
+	entry, err := q.Entry(id)
+	require.NoError(t, err)
+	require.Equal(t, "from@example.com", entry.From)
+	require.Equal(t, int64(len(message)), entry.Size)
+	require.Len(t, entry.Recipients, 2)
+	require.Equal(t, queue.StatePending, entry.Recipients[0].State)
+	require.False(t, entry.Done())
+
+	f, err := q.Open(id)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, message, string(data))
+
+	_, err = q.Open("../" + id)
+	require.ErrorIs(t, err, queue.ErrNotFound)
+
+	// leftovers of a crash
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tmp-123"), []byte("partial"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ABC.msg"), []byte(message), 0o600))
+
+	q, err = queue.New(dir)
+	require.NoError(t, err)
+
+	entries := q.Entries()
+	require.Len(t, entries, 1)
+	require.Equal(t, entry.ID, entries[0].ID)
+	require.Equal(t, entry.Recipients, entries[0].Recipients)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	require.NoError(t, q.Remove(id))
+	require.ErrorIs(t, q.Remove(id), queue.ErrNotFound)
+	require.Empty(t, q.Entries())
+
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestQueue_Run(t *testing.T) {
+	completed := make(chan queue.Entry, 1)
+
+	q, err := queue.New(t.TempDir(),
+		queue.WithBackoff(10*time.Millisecond, 20*time.Millisecond),
+		queue.WithCompleted(func(entry queue.Entry) { completed <- entry }),
+	)
+	require.NoError(t, err)
+
+	id, err := q.Enqueue("from@example.com",
+		[]string{"ok@example.com", "later@example.com", "rejected@example.com", "missing@example.com"},
+		strings.NewReader(message))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	attempts := [][]string{}
+
+	entry := run(t, q, completed,
+		func(
+			_ context.Context, from string, _ *client.MailOptions, rcpts []string, _ []*smtp.RcptOptions, in func() io.Reader,
+		) ([]mailer.Recipient, error) {
+			data, err := io.ReadAll(in())
+			require.NoError(t, err)
+			require.Equal(t, message, string(data))
+			require.Equal(t, "from@example.com", from)
+
+			mu.Lock()
+			defer mu.Unlock()
+			attempts = append(attempts, rcpts)
+
+			if len(attempts) == 1 {
+				return []mailer.Recipient{
+					outcome("ok@example.com", 250),
+					outcome("later@example.com", 451),
+					outcome("rejected@example.com", 550),
+				}, nil
+			}
+			return []mailer.Recipient{outcome("later@example.com", 250), outcome("missing@example.com", 250)}, nil
+		})
+
+	require.Equal(t, [][]string{
+		{"ok@example.com", "later@example.com", "rejected@example.com", "missing@example.com"},
+		{"later@example.com", "missing@example.com"},
+	}, attempts)
+
+	require.Equal(t, id, entry.ID)
+	require.True(t, entry.Done())
+
+	states := map[string]queue.State{}
+	for _, rcpt := range entry.Recipients {
+		states[rcpt.Address] = rcpt.State
+	}
+	require.Equal(t, map[string]queue.State{
+		"ok@example.com":       queue.StateDelivered,
+		"later@example.com":    queue.StateDelivered,
+		"rejected@example.com": queue.StateFailed,
+		"missing@example.com":  queue.StateDelivered,
+	}, states)
+
+	rejected := entry.Recipients[2]
+	require.Equal(t, 1, rejected.Attempts)
+	require.Equal(t, 550, rejected.Status.Code)
+	require.Equal(t, "mx.example.com:25", rejected.Server)
+	require.NotEmpty(t, rejected.Error)
+
+	require.Equal(t, 2, entry.Recipients[1].Attempts)
+	require.Empty(t, entry.Recipients[1].Error)
+
+	// completed entries are removed
+	require.Empty(t, q.Entries())
+	_, err = q.Entry(id)
+	require.ErrorIs(t, err, queue.ErrNotFound)
+}
+
+func TestQueue_Expire(t *testing.T) {
+	completed := make(chan queue.Entry, 1)
+
+	q, err := queue.New(t.TempDir(),
+		queue.WithBackoff(10*time.Millisecond, 10*time.Millisecond),
+		queue.WithMaxLifetime(50*time.Millisecond),
+		queue.WithCompleted(func(entry queue.Entry) { completed <- entry }),
+	)
+	require.NoError(t, err)
+
+	_, err = q.Enqueue("from@example.com", []string{"a@example.com"}, strings.NewReader(message))
+	require.NoError(t, err)
+
+	entry := run(t, q, completed,
+		func(
+			context.Context, string, *client.MailOptions, []string, []*smtp.RcptOptions, func() io.Reader,
+		) ([]mailer.Recipient, error) {
+			return nil, io.ErrUnexpectedEOF
+		})
+
+	rcpt := entry.Recipients[0]
+	require.Equal(t, queue.StateFailed, rcpt.State)
+	require.True(t, rcpt.Expired)
+	require.Greater(t, rcpt.Attempts, 1)
+	require.Nil(t, rcpt.Status)
+	require.Equal(t, io.ErrUnexpectedEOF.Error(), rcpt.Error)
+}
+
+func TestQueue_State(t *testing.T) {
+	dir := t.TempDir()
+
+	delayed := make(chan queue.Entry, 2)
+	q, err := queue.New(dir,
+		queue.WithBackoff(time.Hour, time.Hour),
+		queue.WithDelayed(0, func(entry queue.Entry) { delayed <- entry }),
+	)
+	require.NoError(t, err)
+
+	id, err := q.Enqueue("from@example.com", []string{"a@example.com", "b@example.com"}, strings.NewReader(message))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, func(
+			context.Context, string, *client.MailOptions, []string, []*smtp.RcptOptions, func() io.Reader,
+		) ([]mailer.Recipient, error) {
+			return []mailer.Recipient{outcome("a@example.com", 250), outcome("b@example.com", 421)}, nil
+		})
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		entry, err := q.Entry(id)
+		return err == nil && entry.Recipients[0].State == queue.StateDelivered
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	// the state is restored after a restart
+	q, err = queue.New(dir)
+	require.NoError(t, err)
+
+	entry, err := q.Entry(id)
+	require.NoError(t, err)
+	require.Equal(t, queue.StateDelivered, entry.Recipients[0].State)
+	require.Equal(t, queue.StatePending, entry.Recipients[1].State)
+	require.Equal(t, 421, entry.Recipients[1].Status.Code)
+	require.WithinDuration(t, time.Now().Add(time.Hour), entry.NextAttempt(), time.Minute)
+
+	// the delayed function is called once
+	require.True(t, entry.Delayed)
+	require.Len(t, delayed, 1)
+	require.Equal(t, []string{"b@example.com"}, pending(<-delayed))
+}
+
+func TestQueue_Shutdown(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := queue.New(dir)
+	require.NoError(t, err)
+
+	id, err := q.Enqueue("from@example.com",
+		[]string{"a@example.com", "b@example.com", "c@example.com"}, strings.NewReader(message))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Run(ctx, func(
+		context.Context, string, *client.MailOptions, []string, []*smtp.RcptOptions, func() io.Reader,
+	) ([]mailer.Recipient, error) {
+		// the queue is shut down during the delivery
+		cancel()
+		return []mailer.Recipient{outcome("a@example.com", 250), outcome("b@example.com", 550)}, context.Canceled
+	})
+
+	// the definitive results are kept
+	q, err = queue.New(dir)
+	require.NoError(t, err)
+
+	entry, err := q.Entry(id)
+	require.NoError(t, err)
+	require.Equal(t, queue.StateDelivered, entry.Recipients[0].State)
+	require.Equal(t, queue.StateFailed, entry.Recipients[1].State)
+	require.Equal(t, queue.StatePending, entry.Recipients[2].State)
+	require.Zero(t, entry.Recipients[2].Attempts)
+}
+
+func TestQueue_Recover(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := queue.New(dir)
+	require.NoError(t, err)
+
+	id, err := q.Enqueue("from@example.com", []string{"a@example.com"}, strings.NewReader(message))
+	require.NoError(t, err)
+
+	// a crash after the final state was saved
+	entry, err := q.Entry(id)
+	require.NoError(t, err)
+	entry.Recipients[0].State = queue.StateDelivered
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, id+".json"), data, 0o600))
+
+	completed := make(chan queue.Entry, 1)
+	q, err = queue.New(dir, queue.WithCompleted(func(entry queue.Entry) { completed <- entry }))
+	require.NoError(t, err)
+
+	entry = run(t, q, completed, func(
+		context.Context, string, *client.MailOptions, []string, []*smtp.RcptOptions, func() io.Reader,
+	) ([]mailer.Recipient, error) {
+		require.FailNow(t, "completed entry delivered again")
+		return nil, nil
+	})
+	require.Equal(t, id, entry.ID)
+	require.Empty(t, q.Entries())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestQueue_EnqueueAdvanced(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := queue.New(dir)
+	require.NoError(t, err)
+
+	rcptOptions := &smtp.RcptOptions{
+		Notify:                []smtp.DSNNotify{smtp.DSNNotifyNever},
+		OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+		OriginalRecipient:     "orig@example.com",
+	}
+	_, err = q.EnqueueAdvanced("from@example.com",
+		&smtp.MailOptions{RequireTLS: true, Return: smtp.DSNReturnHeaders, EnvelopeID: "envid"},
+		[]string{"a@example.com", "b@example.com"}, []*smtp.RcptOptions{nil, rcptOptions},
+		strings.NewReader(message))
+	require.NoError(t, err)
+
+	// the options are stored persistently
+	completed := make(chan queue.Entry, 1)
+	q, err = queue.New(dir, queue.WithCompleted(func(entry queue.Entry) { completed <- entry }))
+	require.NoError(t, err)
+
+	entry := run(t, q, completed, func(
+		_ context.Context, _ string, mailOptions *client.MailOptions, rcpts []string, rcptsOptions []*smtp.RcptOptions,
+		_ func() io.Reader,
+	) ([]mailer.Recipient, error) {
+		require.Equal(t, &client.MailOptions{RequireTLS: true, Return: smtp.DSNReturnHeaders, EnvelopeID: "envid"},
+			mailOptions)
+		require.Equal(t, []*smtp.RcptOptions{nil, rcptOptions}, rcptsOptions)
+		return []mailer.Recipient{outcome(rcpts[0], 250), outcome(rcpts[1], 250)}, nil
+	})
+	require.True(t, entry.MailOptions.RequireTLS)
+	require.Nil(t, entry.Recipients[0].Options)
+	require.Equal(t, rcptOptions, entry.Recipients[1].Options)
+}
+
+func TestQueue_Mailer(t *testing.T) {
+	be := tester.NewBackend()
+	srv := tester.Standard(server.WithBackend(be))
+
+	l, err := srv.Listen()
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	completed := make(chan queue.Entry, 1)
+
+	q, err := queue.New(t.TempDir(), queue.WithCompleted(func(entry queue.Entry) { completed <- entry }))
+	require.NoError(t, err)
+
+	rcpts := []string{"a@example.com", "b@example.com"}
+	_, err = q.Enqueue("from@example.com", rcpts, strings.NewReader(message))
+	require.NoError(t, err)
+
+	entry := run(t, q, completed, queue.Mailer(mailer.WithServerAddresses(l.Addr().String())))
+	require.Equal(t, queue.StateDelivered, entry.Recipients[0].State)
+	require.Equal(t, queue.StateDelivered, entry.Recipients[1].State)
+
+	mail, ok := be.Load("from@example.com", rcpts)
+	require.True(t, ok)
+	require.Equal(t, message, string(mail.Data))
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// messageExt is the extension of the message files.
+	messageExt = ".msg"
+	// entryExt is the extension of the envelope and state files.
+	entryExt = ".json"
+	// tmpPrefix is the prefix of files which are written.
+	tmpPrefix = "tmp-"
+)
+
+// newID returns a new queue id, the ids are ordered by time.
+func newID(now time.Time) string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return strings.ToUpper(strconv.FormatInt(now.UnixMicro(), 16) + hex.EncodeToString(random))
+}
+
+// validID returns true if id could be returned by newID, so it can't leave the queue directory.
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, ch := range id {
+		if (ch < '0' || ch > '9') && (ch < 'A' || ch > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFile writes r to name in dir atomically: a temporary file is written, synced and renamed.
+func writeFile(dir string, name string, r io.Reader) (int64, error) {
+	f, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// syncDir syncs dir, so that created, renamed and removed files are persistent.
+func syncDir(dir string) error {
+	d, err := os.Open(dir) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// save writes the envelope and state of entry.
+func save(dir string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := writeFile(dir, entry.ID+entryExt, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// remove removes the files of an entry. The state is removed first, because a message
+// without state is removed by load.
+func remove(dir string, id string) error {
+	err := os.Remove(filepath.Join(dir, id+entryExt))
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		err = os.Remove(filepath.Join(dir, id+messageExt))
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// load reads all entries of dir. Temporary files of interrupted writes and messages
+// without state (interrupted enqueue or removal) are removed.
+func load(dir string) (map[string]*Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*Entry{}
+	messages := map[string]bool{}
+
+	for _, file := range files {
+		name := file.Name()
+		switch {
+		case file.IsDir():
+		case strings.HasPrefix(name, tmpPrefix):
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(name, messageExt):
+			messages[strings.TrimSuffix(name, messageExt)] = true
+		case strings.HasSuffix(name, entryExt):
+			entry, err := loadEntry(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			entries[entry.ID] = entry
+		}
+	}
+
+	for id := range messages {
+		if _, ok := entries[id]; !ok {
+			if err := os.Remove(filepath.Join(dir, id+messageExt)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for id := range entries {
+		if !messages[id] {
+			return nil, fmt.Errorf("queue: message of %s is missing", id)
+		}
+	}
+
+	return entries, syncDir(dir)
+}
+
+// loadEntry reads the envelope and state file path.
+func loadEntry(path string) (*Entry, error) {
+	data, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("queue: invalid entry %s: %w", path, err)
+	}
+
+	if !validID(entry.ID) || filepath.Base(path) != entry.ID+entryExt {
+		return nil, fmt.Errorf("queue: invalid entry %s: id %q doesn't match", path, entry.ID)
+	}
+
+	return entry, nil
+}
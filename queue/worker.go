@@ -0,0 +1,307 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/mailer"
+)
+
+// DeliverFunc delivers the message to rcpts and returns the outcome of every recipient.
+// The options of a recipient have the same index in rcptsOptions as the recipient in rcpts.
+// The in function returns the complete message on every call. Recipients missing in the
+// result are retried, just like temporary failures.
+type DeliverFunc func(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	in func() io.Reader,
+) ([]mailer.Recipient, error)
+
+// Mailer returns a DeliverFunc which delivers with mailer.SendReport, that is to the MX servers
+// of the recipients unless mailer.WithServerAddresses is set.
+func Mailer(opts ...mailer.Option) DeliverFunc {
+	return func(
+		ctx context.Context,
+		from string,
+		mailOptions *client.MailOptions,
+		rcpts []string,
+		rcptsOptions []*smtp.RcptOptions,
+		in func() io.Reader,
+	) ([]mailer.Recipient, error) {
+		report, err := mailer.SendReport(ctx, from, mailOptions, rcpts, rcptsOptions, in, opts...)
+		return report.Recipients, err
+	}
+}
+
+// mailOptions returns the client options to deliver a message received with opts.
+// SMTPUTF8 isn't forced, the mailer uses it if the message requires it and the server supports it.
+func mailOptions(opts *smtp.MailOptions) *client.MailOptions {
+	if opts == nil {
+		return nil
+	}
+	return &client.MailOptions{
+		RequireTLS: opts.RequireTLS,
+		Return:     opts.Return,
+		EnvelopeID: opts.EnvelopeID,
+		XOORG:      opts.XOORG,
+		Auth:       opts.Auth,
+	}
+}
+
+// messageReader reads a queued message, Len allows the mailer to announce the size.
+type messageReader struct {
+	*io.SectionReader
+}
+
+// Len returns the number of unread bytes.
+func (r messageReader) Len() int {
+	offset, _ := r.Seek(0, io.SeekCurrent)
+	return int(r.Size() - offset)
+}
+
+// Run delivers due entries with deliver until ctx is done, then it waits for running deliveries.
+// Temporary failures are retried with an exponential backoff until the message expires.
+// Run must not be called more than once at the same time.
+func (q *Queue) Run(ctx context.Context, deliver DeliverFunc) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	workers := make(chan struct{}, q.workers)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		for _, id := range q.due() {
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				q.release(id)
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.deliver(ctx, id, deliver)
+				<-workers
+				q.wake()
+			}()
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		timer.Reset(q.wait())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-q.notify:
+		}
+	}
+}
+
+// due returns the ids of all due or done entries which aren't delivered currently and marks them as busy.
+func (q *Queue) due() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	ids := []string{}
+	for id, entry := range q.entries {
+		if !q.busy[id] && (entry.Done() || len(entry.due(now)) > 0) {
+			q.busy[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// release marks the entry as not busy anymore.
+func (q *Queue) release(id string) {
+	q.mu.Lock()
+	delete(q.busy, id)
+	q.mu.Unlock()
+}
+
+// wait returns the time until the next entry which isn't delivered currently is due.
+func (q *Queue) wait() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next time.Time
+	for id, entry := range q.entries {
+		if at := entry.NextAttempt(); !q.busy[id] && !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+
+	if next.IsZero() {
+		// nothing to do until the next enqueue
+		return 24 * time.Hour
+	}
+	return max(next.Sub(q.now()), 0)
+}
+
+// deliver delivers the due recipients of an entry and saves the new state.
+func (q *Queue) deliver(ctx context.Context, id string, deliver DeliverFunc) {
+	defer q.release(id)
+
+	q.mu.Lock()
+	entry := q.entries[id].clone()
+	q.mu.Unlock()
+
+	if entry.Done() {
+		// the completion was interrupted by a crash
+		q.complete(ctx, entry)
+		return
+	}
+
+	now := q.time()
+	indexes := entry.due(now)
+
+	rcpts := make([]string, len(indexes))
+	rcptsOptions := make([]*smtp.RcptOptions, len(indexes))
+	for i, index := range indexes {
+		rcpts[i] = entry.Recipients[index].Address
+		rcptsOptions[i] = entry.Recipients[index].Options
+	}
+
+	var results []mailer.Recipient
+
+	f, err := os.Open(filepath.Join(q.dir, id+messageExt)) // nolint: gosec
+	if err == nil {
+		results, err = deliver(ctx, entry.From, mailOptions(entry.MailOptions), rcpts, rcptsOptions, func() io.Reader {
+			return messageReader{io.NewSectionReader(f, 0, entry.Size)}
+		})
+		_ = f.Close()
+	}
+
+	if ctx.Err() != nil {
+		// the delivery was aborted, only definitive results are kept and the rest is tried again
+		indexes = definitive(&entry, indexes, results)
+		if len(indexes) == 0 {
+			return
+		}
+	}
+
+	q.update(&entry, indexes, results, err, now)
+
+	q.logger.InfoContext(ctx, "queue delivery attempt",
+		slog.String("id", id), slog.Int("rcpts", len(indexes)), slog.Bool("done", entry.Done()), slog.Any("err", err))
+
+	if q.delayed != nil && !entry.Done() && !entry.Delayed && now.Sub(entry.Created) >= q.delayAfter {
+		entry.Delayed = true
+		q.delayed(entry.clone())
+	}
+
+	// the state is saved before a completion, so a crash doesn't cause a second delivery
+	if err := save(q.dir, &entry); err != nil {
+		q.logger.ErrorContext(ctx, "queue state not saved", slog.String("id", id), slog.Any("err", err))
+	}
+
+	if entry.Done() {
+		q.complete(ctx, entry)
+		return
+	}
+
+	q.mu.Lock()
+	q.entries[id] = &entry
+	q.mu.Unlock()
+}
+
+// definitive returns the indexes of the recipients which were delivered or failed permanently.
+func definitive(entry *Entry, indexes []int, results []mailer.Recipient) []int {
+	outcome := map[string]bool{}
+	for i := range results {
+		if results[i].Delivered() || results[i].Permanent() {
+			outcome[results[i].Address] = true
+		}
+	}
+
+	res := []int{}
+	for _, index := range indexes {
+		if outcome[entry.Recipients[index].Address] {
+			res = append(res, index)
+		}
+	}
+	return res
+}
+
+// update sets the state of the recipients of an attempt at now.
+func (q *Queue) update(entry *Entry, indexes []int, results []mailer.Recipient, err error, now time.Time) {
+	outcome := map[string]*mailer.Recipient{}
+	for i := range results {
+		if _, ok := outcome[results[i].Address]; !ok {
+			outcome[results[i].Address] = &results[i]
+		}
+	}
+
+	if err == nil {
+		err = errors.New("queue: no delivery result")
+	}
+
+	for _, index := range indexes {
+		r := &entry.Recipients[index]
+		r.Attempts++
+		r.LastAttempt = now
+		r.Server = ""
+		r.Status = nil
+		r.Error = err.Error()
+
+		result, ok := outcome[r.Address]
+		if ok {
+			r.Server = result.Server
+			r.Status = result.Status()
+			r.Error = ""
+			if rcptErr := result.Err(); rcptErr != nil {
+				r.Error = rcptErr.Error()
+			}
+		}
+
+		switch {
+		case ok && result.Delivered():
+			r.State = StateDelivered
+			r.NextAttempt = time.Time{}
+		case ok && result.Permanent():
+			r.State = StateFailed
+			r.NextAttempt = time.Time{}
+		default:
+			r.NextAttempt = now.Add(q.backoff(r.Attempts))
+			if r.NextAttempt.After(entry.Created.Add(q.maxLifetime)) {
+				r.State = StateFailed
+				r.NextAttempt = time.Time{}
+				r.Expired = true
+			}
+		}
+	}
+}
+
+// complete removes an entry after all recipients are delivered or failed.
+func (q *Queue) complete(ctx context.Context, entry Entry) {
+	if q.completed != nil {
+		q.completed(entry)
+	}
+
+	q.mu.Lock()
+	delete(q.entries, entry.ID)
+	q.mu.Unlock()
+
+	if err := remove(q.dir, entry.ID); err != nil {
+		q.logger.ErrorContext(ctx, "queue entry not removed", slog.String("id", entry.ID), slog.Any("err", err))
+	}
+}
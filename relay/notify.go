@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/dsn"
+	"github.com/uponusolutions/go-smtp/queue"
+)
+
+// bounce notifies the sender of an entry about permanently failed recipients.
+func (r *Relay) bounce(entry queue.Entry) {
+	r.notify(entry, queue.StateFailed, dsn.ActionFailed)
+}
+
+// warn notifies the sender of an entry about a delayed delivery.
+func (r *Relay) warn(entry queue.Entry) {
+	r.notify(entry, queue.StatePending, dsn.ActionDelayed)
+}
+
+// notify queues a delivery status notification about the recipients of entry with the given state.
+// Messages with a null sender (e.g. bounces) never cause notifications, recipients are only reported
+// if the notification is requested by their NOTIFY parameter.
+func (r *Relay) notify(entry queue.Entry, state queue.State, action dsn.Action) {
+	if entry.From == "" {
+		return
+	}
+
+	report := dsn.Report{
+		ReportingMTA: r.hostname,
+		To:           entry.From,
+		ArrivalDate:  entry.Created,
+	}
+	if opts := entry.MailOptions; opts != nil {
+		report.Return = opts.Return
+		report.EnvelopeID = opts.EnvelopeID
+		report.UTF8 = opts.UTF8
+	}
+
+	for _, rcpt := range entry.Recipients {
+		if rcpt.State == state && dsn.Wanted(rcpt.Options, action) {
+			report.Recipients = append(report.Recipients, recipient(rcpt, action))
+		}
+	}
+
+	if len(report.Recipients) == 0 {
+		return
+	}
+
+	// the header of the original message is returned
+	if f, err := r.queue.Open(entry.ID); err == nil {
+		defer func() { _ = f.Close() }()
+		report.Message = f
+	}
+
+	var b bytes.Buffer
+	_, err := report.WriteTo(&b)
+	if err == nil {
+		_, err = r.queue.Enqueue("", []string{entry.From}, &b)
+	}
+	if err != nil {
+		r.logger.ErrorContext(context.Background(), "relay notification not queued",
+			slog.String("id", entry.ID), slog.String("action", string(action)), slog.Any("err", err))
+	}
+}
+
+// recipient returns the delivery status of a queued recipient.
+func recipient(rcpt queue.Recipient, action dsn.Action) dsn.Recipient {
+	res := dsn.Recipient{
+		FinalRecipient: rcpt.Address,
+		Action:         action,
+		Status:         rcpt.Status,
+		LastAttempt:    rcpt.LastAttempt,
+	}
+
+	if rcpt.Options != nil {
+		res.OriginalRecipientType = rcpt.Options.OriginalRecipientType
+		res.OriginalRecipient = rcpt.Options.OriginalRecipient
+	}
+
+	if host, _, err := net.SplitHostPort(rcpt.Server); err == nil {
+		res.RemoteMTA = host
+	}
+
+	switch {
+	case rcpt.Expired:
+		res.Status = smtp.NewStatus(0, smtp.EnhancedCode{4, 4, 7}, "Message expired: "+rcpt.Error)
+	case res.Status == nil && rcpt.Error != "":
+		// e.g. a connection error
+		res.Status = smtp.NewStatus(0, smtp.EnhancedCodeNotSet, rcpt.Error)
+	}
+
+	return res
+}
@@ -0,0 +1,215 @@
+// Package relay implements a store-and-forward relay MTA built from server, queue and mailer.
+//
+// The relay is a server.Backend. It accepts mail for its own domains from everyone and mail for any
+// domain from authenticated users, so it can't be abused as an open relay. Accepted mail is queued
+// and delivered to the MX servers of the recipients. The sender receives a bounce if the delivery
+// failed permanently and a warning if the delivery is delayed:
+//
+//	r, err := relay.New("/var/spool/relay",
+//		relay.WithDomains("example.com"),
+//		relay.WithAuthenticator(authenticate),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	go r.Run(ctx)
+//
+//	s := server.New(server.WithBackend(r))
+package relay
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/mailer"
+	"github.com/uponusolutions/go-smtp/queue"
+	"github.com/uponusolutions/go-smtp/resolve"
+	"github.com/uponusolutions/go-smtp/server"
+)
+
+// Resolver resolves the servers of recipients, e.g. *resolve.Resolver.
+type Resolver interface {
+	Recipients(ctx context.Context, rcpts []string) (resolve.Result, error)
+}
+
+// Relay is a server.Backend which queues accepted mail and delivers it.
+type Relay struct {
+	queue        *queue.Queue
+	hostname     string
+	domains      []string
+	authenticate func(ctx context.Context, username string, password string) error
+	resolver     Resolver
+	mailer       []mailer.Option
+	queueOpts    []queue.Option
+	delayWarning time.Duration
+	logger       *slog.Logger
+}
+
+// Option defines a relay option.
+type Option func(r *Relay)
+
+// WithHostname sets the name used in Received headers and as sender of bounces (default: os.Hostname).
+func WithHostname(hostname string) Option {
+	return func(r *Relay) {
+		r.hostname = hostname
+	}
+}
+
+// WithDomains sets the recipient domains accepted from unauthenticated clients.
+func WithDomains(domains ...string) Option {
+	return func(r *Relay) {
+		r.domains = domains
+	}
+}
+
+// WithAuthenticator enables the authentication with PLAIN and LOGIN.
+// Authenticated users may send mail to any domain.
+func WithAuthenticator(authenticate func(ctx context.Context, username string, password string) error) Option {
+	return func(r *Relay) {
+		r.authenticate = authenticate
+	}
+}
+
+// WithResolver sets the resolver of the recipient servers (default: MX records via resolve.New(nil)).
+func WithResolver(resolver Resolver) Option {
+	return func(r *Relay) {
+		r.resolver = resolver
+	}
+}
+
+// WithMailer sets the mailer options used for the delivery, e.g. mailer.WithDANE.
+// The server addresses are set by the resolver.
+func WithMailer(opts ...mailer.Option) Option {
+	return func(r *Relay) {
+		r.mailer = opts
+	}
+}
+
+// WithQueue sets the options of the queue, e.g. queue.WithBackoff.
+// queue.WithCompleted and queue.WithDelayed are replaced by the relay.
+func WithQueue(opts ...queue.Option) Option {
+	return func(r *Relay) {
+		r.queueOpts = opts
+	}
+}
+
+// WithDelayWarning sets after which time the sender is warned about a delayed delivery,
+// zero disables the warning (default: 4 hours).
+func WithDelayWarning(after time.Duration) Option {
+	return func(r *Relay) {
+		r.delayWarning = after
+	}
+}
+
+// WithLogger sets the logger of the relay and its queue.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Relay) {
+		r.logger = logger
+	}
+}
+
+// New returns a relay with its queue stored in dir.
+func New(dir string, opts ...Option) (*Relay, error) {
+	r := &Relay{
+		delayWarning: 4 * time.Hour,
+		logger:       slog.Default(),
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	if r.hostname == "" {
+		r.hostname, _ = os.Hostname()
+	}
+
+	if r.resolver == nil {
+		resolver := resolve.New(nil)
+		r.resolver = &resolver
+	}
+
+	queueOpts := append([]queue.Option{queue.WithLogger(r.logger)}, r.queueOpts...)
+	queueOpts = append(queueOpts, queue.WithCompleted(r.bounce))
+	if r.delayWarning > 0 {
+		queueOpts = append(queueOpts, queue.WithDelayed(r.delayWarning, r.warn))
+	}
+
+	q, err := queue.New(dir, queueOpts...)
+	if err != nil {
+		return nil, err
+	}
+	r.queue = q
+
+	return r, nil
+}
+
+// Queue returns the queue of the relay.
+func (r *Relay) Queue() *queue.Queue {
+	return r.queue
+}
+
+// Run delivers the queued mail until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	r.queue.Run(ctx, r.deliver)
+}
+
+// NewSession implements server.Backend.
+func (r *Relay) NewSession(ctx context.Context, c *server.Conn) (context.Context, server.Session, error) {
+	return ctx, &session{relay: r, conn: c}, nil
+}
+
+// local returns true if rcpt belongs to one of the domains of the relay.
+func (r *Relay) local(rcpt string) bool {
+	i := strings.LastIndexByte(rcpt, '@')
+	if i == -1 {
+		return false
+	}
+	domain := rcpt[i+1:]
+	return slices.ContainsFunc(r.domains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
+}
+
+// deliver delivers a queued message to the servers of the recipients.
+func (r *Relay) deliver(
+	ctx context.Context,
+	from string,
+	mailOptions *client.MailOptions,
+	rcpts []string,
+	rcptsOptions []*smtp.RcptOptions,
+	in func() io.Reader,
+) ([]mailer.Recipient, error) {
+	res, err := r.resolver.Recipients(ctx, rcpts)
+	if err != nil {
+		// e.g. the dns server isn't reachable, all recipients are retried
+		return nil, err
+	}
+
+	recipients := []mailer.Recipient{}
+
+	// resolve failures are permanent, e.g. no mx record found
+	for _, fail := range res.Failures {
+		for _, rcpt := range fail.Rcpts {
+			recipients = append(recipients, mailer.Recipient{
+				Address: rcpt,
+				Error:   smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 2}, fail.Error.Error()),
+			})
+		}
+	}
+
+	for _, srv := range res.Servers {
+		m := mailer.New(append(slices.Clone(r.mailer), mailer.WithServerAddressesPrio(srv.Addresses...))...)
+		report, _ := m.SendReport(ctx, from, mailOptions, srv.Rcpts, mailer.RcptsOptions(rcpts, rcptsOptions, srv.Rcpts), in)
+		_ = m.Disconnect()
+		recipients = append(recipients, report.Recipients...)
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,282 @@
+package relay_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-sasl"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/client"
+	"github.com/uponusolutions/go-smtp/mailer"
+	"github.com/uponusolutions/go-smtp/queue"
+	"github.com/uponusolutions/go-smtp/relay"
+	"github.com/uponusolutions/go-smtp/resolve"
+	"github.com/uponusolutions/go-smtp/server"
+	"github.com/uponusolutions/go-smtp/tester"
+)
+
+const message = "Subject: relayed\r\n\r\nHello\r\n"
+
+// staticResolver resolves the servers of the recipient domains from a map.
+type staticResolver map[string]string
+
+func (s staticResolver) Recipients(_ context.Context, rcpts []string) (resolve.Result, error) {
+	res := resolve.Result{}
+	for _, rcpt := range rcpts {
+		addr, ok := s[rcpt[strings.LastIndexByte(rcpt, '@')+1:]]
+		if !ok {
+			res.Failures = append(res.Failures, resolve.Failure{
+				Rcpts: []string{rcpt},
+				Error: errors.New("no mx record found"),
+			})
+			continue
+		}
+		i := slices.IndexFunc(res.Servers, func(srv resolve.Server) bool { return srv.Addresses[0][0] == addr })
+		if i == -1 {
+			res.Servers = append(res.Servers, resolve.Server{Addresses: [][]string{{addr}}})
+			i = len(res.Servers) - 1
+		}
+		res.Servers[i].Rcpts = append(res.Servers[i].Rcpts, rcpt)
+	}
+	return res, nil
+}
+
+func startServer(t *testing.T, be server.Backend, opts ...server.Option) string {
+	srv := tester.Standard(append([]server.Option{server.WithBackend(be)}, opts...)...)
+
+	l, err := srv.Listen()
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return l.Addr().String()
+}
+
+// startRelay starts a relay for example.com which delivers to the servers of resolver.
+func startRelay(t *testing.T, resolver staticResolver, opts ...relay.Option) string {
+	return startServer(t, runRelay(t, resolver, opts...))
+}
+
+// runRelay runs the delivery of a relay for example.com to the servers of resolver.
+func runRelay(t *testing.T, resolver staticResolver, opts ...relay.Option) *relay.Relay {
+	r, err := relay.New(t.TempDir(), append([]relay.Option{
+		relay.WithHostname("relay.example.com"),
+		relay.WithDomains("example.com"),
+		relay.WithResolver(resolver),
+		relay.WithAuthenticator(func(_ context.Context, username string, password string) error {
+			if username != "user" || password != "pass" {
+				return smtp.ErrAuthFailed
+			}
+			return nil
+		}),
+	}, opts...)...)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return r
+}
+
+// waitMail waits until be received a mail from from to rcpts.
+func waitMail(t *testing.T, be *tester.Backend, from string, rcpts ...string) string {
+	var mail *tester.Mail
+	require.Eventually(t, func() bool {
+		var ok bool
+		mail, ok = be.Load(from, rcpts)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	return string(mail.Data)
+}
+
+func TestRelay_Deliver(t *testing.T) {
+	dest := tester.NewBackend()
+	addr := startRelay(t, staticResolver{"example.com": startServer(t, dest)})
+
+	res, err := mailer.Send(context.Background(), "sender@example.org", []string{"a@example.com", "b@example.com"},
+		func() io.Reader { return strings.NewReader(message) }, mailer.WithServerAddresses(addr))
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+
+	data := waitMail(t, dest, "sender@example.org", "a@example.com", "b@example.com")
+	require.True(t, strings.HasPrefix(data, "Received: from localhost ("), data)
+	require.Contains(t, data, "by relay.example.com with ESMTP;")
+	require.True(t, strings.HasSuffix(data, message))
+}
+
+func TestRelay_OpenRelay(t *testing.T) {
+	dest := tester.NewBackend()
+	addr := startRelay(t, staticResolver{"example.com": startServer(t, dest), "example.net": startServer(t, dest)})
+
+	rcpts := []string{"a@example.com", "b@example.net"}
+	in := func() io.Reader { return strings.NewReader(message) }
+
+	// unauthenticated clients can only send to the domains of the relay
+	res, err := mailer.Send(context.Background(), "sender@example.org", rcpts, in, mailer.WithServerAddresses(addr))
+	require.NoError(t, err)
+	require.True(t, res.Recipients[0].Delivered())
+	require.True(t, res.Recipients[1].Permanent())
+	require.Equal(t, smtp.EnhancedCode{5, 7, 1}, res.Recipients[1].EnhancedCode())
+
+	// invalid credentials
+	res, err = mailer.Send(context.Background(), "sender@example.org", rcpts, in, mailer.WithServerAddresses(addr),
+		mailer.WithSASLClient(sasl.NewPlainClient("", "user", "wrong")))
+	require.NoError(t, err)
+	require.Len(t, res.Failures, 2)
+
+	// authenticated users can send to every domain
+	res, err = mailer.Send(context.Background(), "sender@example.org", rcpts, in, mailer.WithServerAddresses(addr),
+		mailer.WithSASLClient(sasl.NewLoginClient("user", "pass")))
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+
+	data := waitMail(t, dest, "sender@example.org", "b@example.net")
+	require.Contains(t, data, "by relay.example.com with ESMTPA;")
+}
+
+func TestRelay_TooLarge(t *testing.T) {
+	r, err := relay.New(t.TempDir(), relay.WithDomains("example.com"))
+	require.NoError(t, err)
+
+	srv := tester.Standard(server.WithBackend(r), server.WithMaxMessageBytes(10))
+	l, err := srv.Listen()
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(context.Background(), l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	// without a known size the message is rejected after DATA
+	res, err := mailer.Send(context.Background(), "sender@example.org", []string{"a@example.com"},
+		func() io.Reader { return io.MultiReader(strings.NewReader(message)) }, mailer.WithServerAddresses(l.Addr().String()))
+	require.NoError(t, err)
+	require.True(t, res.Recipients[0].Permanent())
+	require.Equal(t, 552, res.Recipients[0].Status().Code)
+	require.Empty(t, r.Queue().Entries())
+}
+
+func TestRelay_Bounce(t *testing.T) {
+	dest := tester.NewBackend()
+	dest.Rcpt = func(_ context.Context, to string, _ *smtp.RcptOptions) error {
+		if to == "unknown@example.com" {
+			return smtp.NewStatus(550, smtp.EnhancedCode{5, 1, 1}, "No such user")
+		}
+		return nil
+	}
+	sender := tester.NewBackend()
+
+	addr := startRelay(t, staticResolver{"example.com": startServer(t, dest), "example.org": startServer(t, sender)})
+
+	_, err := mailer.Send(context.Background(), "sender@example.org",
+		[]string{"a@example.com", "unknown@example.com", "a@unknown.example"},
+		func() io.Reader { return strings.NewReader(message) },
+		mailer.WithServerAddresses(addr), mailer.WithSASLClient(sasl.NewPlainClient("", "user", "pass")))
+	require.NoError(t, err)
+
+	waitMail(t, dest, "sender@example.org", "a@example.com")
+
+	bounce := waitMail(t, sender, "", "sender@example.org")
+	require.Contains(t, bounce, "From: Mail Delivery System <MAILER-DAEMON@relay.example.com>\r\n")
+	require.Contains(t, bounce, "Subject: Undelivered Mail Returned to Sender\r\n")
+	require.Contains(t, bounce, "Auto-Submitted: auto-replied\r\n")
+	require.Contains(t, bounce, "report-type=delivery-status")
+	require.Contains(t, bounce, "<unknown@example.com>: 550 5.1.1 No such user\r\n")
+	require.Contains(t, bounce, "Final-Recipient: rfc822; unknown@example.com\r\nAction: failed\r\nStatus: 5.1.1\r\n")
+	require.Contains(t, bounce, "Final-Recipient: rfc822; a@unknown.example\r\nAction: failed\r\nStatus: 5.1.2\r\n")
+	require.NotContains(t, bounce, "a@example.com")
+
+	// the header of the original message is returned
+	require.Contains(t, bounce, "Content-Type: text/rfc822-headers\r\n\r\nReceived: from ")
+	require.Contains(t, bounce, "Subject: relayed\r\n")
+	require.NotContains(t, bounce, "Hello")
+}
+
+func TestRelay_DelayWarning(t *testing.T) {
+	dest := tester.NewBackend()
+	dest.Rcpt = func(context.Context, string, *smtp.RcptOptions) error {
+		return smtp.NewStatus(451, smtp.EnhancedCode{4, 3, 0}, "Try again later")
+	}
+	sender := tester.NewBackend()
+
+	addr := startRelay(t, staticResolver{"example.com": startServer(t, dest), "example.org": startServer(t, sender)},
+		relay.WithDelayWarning(time.Nanosecond), relay.WithQueue(queue.WithBackoff(time.Hour, time.Hour)))
+
+	_, err := mailer.Send(context.Background(), "sender@example.org", []string{"a@example.com"},
+		func() io.Reader { return strings.NewReader(message) }, mailer.WithServerAddresses(addr))
+	require.NoError(t, err)
+
+	warning := waitMail(t, sender, "", "sender@example.org")
+	require.Contains(t, warning, "Subject: Delayed Mail (still being retried)\r\n")
+	require.Contains(t, warning, "<a@example.com>: 451 4.3.0 Try again later\r\n")
+	require.Contains(t, warning, "Action: delayed\r\nStatus: 4.3.0\r\n")
+}
+
+func TestRelay_RequireTLS(t *testing.T) {
+	dest := tester.NewBackend()
+	sender := tester.NewBackend()
+
+	r := runRelay(t, staticResolver{"example.com": startServer(t, dest), "example.org": startServer(t, sender)})
+
+	cert, err := tester.GenX509KeyPair("localhost")
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	addr := startServer(t, r, server.WithEnableREQUIRETLS(true), server.WithEnableDSN(true),
+		server.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	m := mailer.New(mailer.WithServerAddresses(addr),
+		mailer.WithTLSConfig(&tls.Config{ServerName: "localhost", RootCAs: pool}))
+	defer func() { _ = m.Disconnect() }()
+
+	res, err := m.SendReport(context.Background(), "sender@example.org",
+		&client.MailOptions{RequireTLS: true, Return: smtp.DSNReturnHeaders, EnvelopeID: "envid"},
+		[]string{"a@example.com", "b@example.com"},
+		[]*smtp.RcptOptions{
+			{OriginalRecipientType: smtp.DSNAddressTypeRFC822, OriginalRecipient: "orig@example.com"},
+			{Notify: []smtp.DSNNotify{smtp.DSNNotifyNever}},
+		},
+		func() io.Reader { return strings.NewReader(message) })
+	require.NoError(t, err)
+	require.Empty(t, res.Failures)
+
+	// the destination doesn't support REQUIRETLS, so the message is bounced instead of delivered
+	bounce := waitMail(t, sender, "", "sender@example.org")
+	require.Contains(t, bounce, "Original-Envelope-Id: envid\r\n")
+	require.Contains(t, bounce, "Original-Recipient: rfc822; orig@example.com\r\n"+
+		"Final-Recipient: rfc822; a@example.com\r\nAction: failed\r\nStatus: 5.7.10\r\n")
+	require.Contains(t, bounce, "Content-Type: text/rfc822-headers\r\n")
+
+	// b@example.com requested no notification
+	require.NotContains(t, bounce, "b@example.com")
+
+	_, ok := dest.Load("sender@example.org", []string{"a@example.com", "b@example.com"})
+	require.False(t, ok)
+}
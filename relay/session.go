@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/uponusolutions/go-sasl"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/server"
+)
+
+var (
+	// errRelayDenied is returned for recipients of other domains if the client isn't authenticated.
+	errRelayDenied = smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Relay access denied")
+	// errQueue is returned if the message couldn't be stored in the queue.
+	errQueue = smtp.NewStatus(451, smtp.EnhancedCode{4, 3, 0}, "Message couldn't be queued")
+	// errAuthFailed is returned by the sasl server if the credentials are rejected.
+	errAuthFailed = errors.New("relay: invalid credentials")
+)
+
+// session is a session of the relay.
+type session struct {
+	relay     *Relay
+	conn      *server.Conn
+	user      string
+	from      string
+	mailOpts  *smtp.MailOptions
+	rcpts     []string
+	rcptsOpts []*smtp.RcptOptions
+}
+
+// Reset implements server.Session.
+func (s *session) Reset(ctx context.Context, upgrade bool) (context.Context, error) {
+	s.from = ""
+	s.mailOpts = nil
+	s.rcpts = nil
+	s.rcptsOpts = nil
+	if upgrade {
+		// everything learned before STARTTLS is discarded
+		s.user = ""
+	}
+	return ctx, nil
+}
+
+// Close implements server.Session.
+func (*session) Close(context.Context, error) {}
+
+// Logger implements server.Session.
+func (s *session) Logger(context.Context) *slog.Logger {
+	return s.relay.logger
+}
+
+// Mail implements server.Session.
+func (s *session) Mail(_ context.Context, from string, opts *smtp.MailOptions) error {
+	s.from = from
+	s.mailOpts = opts
+	return nil
+}
+
+// Rcpt implements server.Session, recipients of other domains require an authenticated user.
+func (s *session) Rcpt(_ context.Context, to string, opts *smtp.RcptOptions) error {
+	if s.user == "" && !s.relay.local(to) {
+		return errRelayDenied
+	}
+	s.rcpts = append(s.rcpts, to)
+	s.rcptsOpts = append(s.rcptsOpts, opts)
+	return nil
+}
+
+// Verify implements server.Session, addresses aren't verified.
+func (*session) Verify(context.Context, string, *smtp.VrfyOptions) error {
+	return nil
+}
+
+// Data implements server.Session and queues the message.
+func (s *session) Data(ctx context.Context, r func() io.Reader) (string, error) {
+	data := r()
+
+	id, err := s.relay.queue.EnqueueAdvanced(s.from, s.mailOpts, s.rcpts, s.rcptsOpts,
+		io.MultiReader(strings.NewReader(s.received()), data))
+	if err != nil {
+		// errors of the message (e.g. too large) are returned unchanged, they aren't temporary
+		smtpErr := &smtp.Status{}
+		if errors.As(err, &smtpErr) {
+			return "", smtpErr
+		}
+		_, _ = io.Copy(io.Discard, data)
+		s.relay.logger.ErrorContext(ctx, "relay enqueue failed", slog.Any("err", err))
+		return "", errQueue
+	}
+
+	return id, nil
+}
+
+// received returns the Received header of the message.
+func (s *session) received() string {
+	remote := ""
+	if conn := s.conn.Conn(); conn != nil && conn.RemoteAddr() != nil {
+		remote = conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+	}
+
+	// protocol types of RFC 3848
+	protocol := "ESMTP"
+	if s.conn.IsTLS() {
+		protocol += "S"
+	}
+	if s.user != "" {
+		protocol += "A"
+	}
+
+	return fmt.Sprintf("Received: from %s (%s)\r\n\tby %s with %s; %s\r\n",
+		s.conn.Hostname(), remote, s.relay.hostname, protocol, time.Now().Format(time.RFC1123Z))
+}
+
+// AuthMechanisms implements server.Session.
+func (s *session) AuthMechanisms(context.Context) []string {
+	if s.relay.authenticate == nil {
+		return nil
+	}
+	return []string{sasl.Plain, sasl.Login}
+}
+
+// Auth implements server.Session.
+func (s *session) Auth(ctx context.Context, mech string) (sasl.Server, error) {
+	switch mech {
+	case sasl.Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			if identity != "" && identity != username {
+				return errAuthFailed
+			}
+			return s.login(ctx, username, password)
+		}), nil
+	case sasl.Login:
+		return sasl.NewLoginServer(func(username, password string) error {
+			return s.login(ctx, username, password)
+		}), nil
+	default:
+		return nil, errAuthFailed
+	}
+}
+
+// login checks the credentials and sets the user of the session.
+func (s *session) login(ctx context.Context, username string, password string) error {
+	if err := s.relay.authenticate(ctx, username, password); err != nil {
+		return errAuthFailed
+	}
+	s.user = username
+	return nil
+}
+
+// STARTTLS implements server.Session.
+func (*session) STARTTLS(_ context.Context, config *tls.Config) (*tls.Config, error) {
+	return config, nil
+}
@@ -2,6 +2,7 @@ package smtp
 
 import (
 	"regexp"
+	"strings"
 )
 
 /*
@@ -29,6 +30,7 @@ var (
 
 // ParseBATV parses src to extract a BATV address.
 // When BATV extration is not possible/needed src is returned.
+// prvs tags are created and validated by the batv package.
 func ParseBATV(src string) string {
 	res := compiledRegexpBATV.FindStringSubmatch(src)
 	if len(res) == 2 {
@@ -39,6 +41,7 @@ func ParseBATV(src string) string {
 
 // ParseSRS parses src to extract the forwarding sender from SRS (Exchange Online).
 // When SRS extration is not possible/needed src is returned.
+// SRS0 and SRS1 addresses are created and reversed by the srs package.
 func ParseSRS(src string) string {
 	res := compiledRegexpSRS.FindStringSubmatch(src)
 	if len(res) == 3 {
@@ -51,3 +54,35 @@ func ParseSRS(src string) string {
 func ParseSender(src string) string {
 	return ParseBATV(ParseSRS(src))
 }
+
+// VERP encodes rcpt into the envelope sender (Variable Envelope Return Path), e.g.
+// list-bounces@list.example and user@example.com result in list-bounces+user=example.com@list.example.
+// A bounce to this address identifies the failed recipient, see ParseVERP.
+// The local part of sender must not contain '+', otherwise the address can't be decoded.
+// The null sender and addresses without domain are returned unchanged.
+func VERP(sender string, rcpt string) string {
+	at := strings.LastIndexByte(sender, '@')
+	rcptAt := strings.LastIndexByte(rcpt, '@')
+	if at <= 0 || rcptAt <= 0 {
+		return sender
+	}
+	return sender[:at] + "+" + rcpt[:rcptAt] + "=" + rcpt[rcptAt+1:] + sender[at:]
+}
+
+// ParseVERP decodes an address created by VERP into the envelope sender and the recipient.
+// The ok result is false if src isn't a VERP address.
+func ParseVERP(src string) (sender string, rcpt string, ok bool) {
+	at := strings.LastIndexByte(src, '@')
+	if at <= 0 {
+		return "", "", false
+	}
+
+	local, domain := src[:at], src[at:]
+	plus := strings.IndexByte(local, '+')
+	eq := strings.LastIndexByte(local, '=')
+	if plus <= 0 || eq <= plus+1 || eq == len(local)-1 {
+		return "", "", false
+	}
+
+	return local[:plus] + domain, local[plus+1:eq] + "@" + local[eq+1:], true
+}
@@ -63,3 +63,46 @@ func TestParseSRS(t *testing.T) {
 		})
 	}
 }
+
+func TestVERP(t *testing.T) {
+	testCases := []struct {
+		sender string
+		rcpt   string
+		want   string
+	}{
+		{"list-bounces@list.example", "user@example.com", "list-bounces+user=example.com@list.example"},
+		{"list-bounces@list.example", "user+tag@example.com", "list-bounces+user+tag=example.com@list.example"},
+		{"list-bounces@list.example", "user", "list-bounces@list.example"},
+		{"", "user@example.com", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			got := VERP(tc.sender, tc.rcpt)
+			assert.Equal(t, tc.want, got)
+
+			sender, rcpt, ok := ParseVERP(got)
+			assert.Equal(t, got != tc.sender, ok)
+			if ok {
+				assert.Equal(t, tc.sender, sender)
+				assert.Equal(t, tc.rcpt, rcpt)
+			}
+		})
+	}
+}
+
+func TestParseVERP(t *testing.T) {
+	for _, src := range []string{
+		"tester@internal.com",
+		"tester+tag@internal.com",
+		"tester+=internal.com@internal.com",
+		"tester+user=@internal.com",
+		"+user=example.com@internal.com",
+		"tester+user=example.com",
+	} {
+		t.Run(src, func(t *testing.T) {
+			_, _, ok := ParseVERP(src)
+			assert.False(t, ok)
+		})
+	}
+}
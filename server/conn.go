@@ -30,6 +30,9 @@ const (
 	stateMail                    state = 5
 )
 
+// errSTARTTLSPipelining closes the connection if plaintext was pipelined after STARTTLS.
+var errSTARTTLSPipelining = errors.New("smtp: plaintext pipelined after STARTTLS")
+
 // Conn is a connection inside a smtp server.
 type Conn struct {
 	ctx context.Context
@@ -47,7 +50,10 @@ type Conn struct {
 	helo       string   // set in helo / ehlo
 	mechanisms []string // seh in helo / ehlo
 	recipients int      // count recipients
+	authMech   string   // set after a successful auth
 	didAuth    bool
+
+	milters *milters // nil without milters
 }
 
 // run loops until an error occurs (quit for example)
@@ -56,6 +62,10 @@ func (c *Conn) run() (err error) {
 		cmd string
 		arg string
 	)
+
+	if status := c.milterConnect(); status != nil {
+		return status
+	}
 	c.greet()
 
 	for {
@@ -254,6 +264,8 @@ func (c *Conn) Close(err error) {
 		c.logger().ErrorContext(c.ctx, "close error", slog.Any("err", err))
 	}
 
+	c.milterClose()
+
 	if c.session != nil {
 		c.session.Close(c.ctx, err)
 		c.session = nil
@@ -286,6 +298,16 @@ func (c *Conn) Mechanisms() []string {
 	return c.mechanisms
 }
 
+// AuthMechanism returns the mechanism of the successful authentication, empty if not authenticated.
+func (c *Conn) AuthMechanism() string {
+	return c.authMech
+}
+
+// Recipients returns the number of accepted recipients of the current transaction.
+func (c *Conn) Recipients() int {
+	return c.recipients
+}
+
 // Conn returns the connection.
 func (c *Conn) Conn() net.Conn {
 	return c.conn
@@ -320,6 +342,10 @@ func (c *Conn) handleGreet(enhanced bool, arg string) error {
 		}
 	}
 
+	if status := c.milterHelo(domain); status != nil {
+		return status
+	}
+
 	if c.server.enforceSecureConnection && !c.IsTLS() {
 		c.state = stateEnforceSecureConnection
 	} else if c.server.enforceAuthentication && !c.didAuth {
@@ -415,6 +441,12 @@ func (c *Conn) handleError(err error) {
 		return
 	}
 
+	if err == errSTARTTLSPipelining {
+		c.writeResponse(501, smtp.EnhancedCode{5, 5, 4}, "Commands pipelined after STARTTLS")
+		c.Close(err)
+		return
+	}
+
 	if err == textsmtp.ErrTooLongLine {
 		c.writeResponse(500, smtp.EnhancedCode{5, 4, 0}, "Too long line")
 		c.Close(errors.New("line too long"))
@@ -474,7 +506,7 @@ func (c *Conn) handleMail(arg string) error {
 
 			opts.Size = int64(size)
 		case "XOORG":
-			value, err := decodeXtext(value)
+			value, err := parse.Xtext(value)
 			if err != nil || value == "" {
 				return smtp.NewStatus(500, smtp.EnhancedCode{5, 5, 4}, "Malformed XOORG parameter value")
 			}
@@ -491,6 +523,10 @@ func (c *Conn) handleMail(arg string) error {
 			if !c.server.enableREQUIRETLS {
 				return smtp.NewStatus(504, smtp.EnhancedCode{5, 5, 4}, "REQUIRETLS is not implemented")
 			}
+			// REQUIRETLS is only advertised over TLS (RFC 8689 4.1)
+			if !c.IsTLS() {
+				return smtp.NewStatus(530, smtp.EnhancedCode{5, 7, 10}, "REQUIRETLS needs a TLS connection")
+			}
 			opts.RequireTLS = true
 		case "BODY":
 			value = strings.ToUpper(value)
@@ -522,13 +558,13 @@ func (c *Conn) handleMail(arg string) error {
 			if !c.server.enableDSN {
 				return smtp.NewStatus(504, smtp.EnhancedCode{5, 5, 4}, "ENVID is not implemented")
 			}
-			value, err := decodeXtext(value)
+			value, err := parse.Xtext(value)
 			if err != nil || value == "" || !textsmtp.IsPrintableASCII(value) {
 				return smtp.NewStatus(501, smtp.EnhancedCode{5, 5, 4}, "Malformed ENVID parameter value")
 			}
 			opts.EnvelopeID = value
 		case "AUTH":
-			value, err := decodeXtext(value)
+			value, err := parse.Xtext(value)
 			if err != nil || value == "" {
 				return smtp.NewStatus(500, smtp.EnhancedCode{5, 5, 4}, "Malformed AUTH parameter value")
 			}
@@ -547,14 +583,21 @@ func (c *Conn) handleMail(arg string) error {
 		}
 	}
 
+	if status := c.milterMail(from, strings.Fields(p.S), opts); status != nil {
+		return status
+	}
+
 	if err := c.session.Mail(c.ctx, from, opts); err != nil {
 		if smtpErr, ok := err.(*smtp.Status); ok {
 			// a positive response also counts as a success
 			if smtpErr.Positive() {
 				c.state = stateMail
+			} else {
+				c.milterAbort()
 			}
 			return smtpErr
 		}
+		c.milterAbort()
 		return c.newStatusError(451, smtp.EnhancedCode{4, 0, 0}, "Mail not accepted", err)
 	}
 
@@ -606,7 +649,7 @@ func (c *Conn) handleRcpt(arg string) error {
 			if !c.server.enableDSN {
 				return smtp.NewStatus(504, smtp.EnhancedCode{5, 5, 4}, "ORCPT is not implemented")
 			}
-			aType, aAddr, err := decodeTypedAddress(value)
+			aType, aAddr, err := parse.TypedAddress(value)
 			if err != nil || aAddr == "" {
 				return smtp.NewStatus(501, smtp.EnhancedCode{5, 5, 4}, "Malformed ORCPT parameter value")
 			}
@@ -617,11 +660,16 @@ func (c *Conn) handleRcpt(arg string) error {
 		}
 	}
 
+	if status := c.milterRcpt(recipient, strings.Fields(p.S)); status != nil {
+		return status
+	}
+
 	if err := c.session.Rcpt(c.ctx, recipient, opts); err != nil {
 		if smtpErr, ok := err.(*smtp.Status); ok {
 			// a positive response also counts as a success
 			if smtpErr.Positive() {
 				c.recipients++
+				c.milterAccepted(recipient, opts)
 			}
 			return smtpErr
 		}
@@ -629,6 +677,7 @@ func (c *Conn) handleRcpt(arg string) error {
 	}
 
 	c.recipients++
+	c.milterAccepted(recipient, opts)
 	return smtp.NewStatus(250, smtp.EnhancedCode{2, 0, 0}, fmt.Sprintf("I'll make sure <%v> gets this", recipient))
 }
 
@@ -732,6 +781,7 @@ func (c *Conn) handleAuth(arg string) error {
 	}
 
 	c.didAuth = true
+	c.authMech = mechanism
 	if c.state == stateEnforceAuthentication {
 		c.state = stateGreeted
 	}
@@ -757,7 +807,17 @@ func (c *Conn) handleStartTLS() error {
 		return smtp.NewStatus(451, smtp.EnhancedCode{4, 0, 0}, "TLS config retrieval nil returned")
 	}
 
+	// STARTTLS must be the last command of a pipelined group (RFC 3207 4.2), plaintext
+	// following it could have been injected and must not be processed inside the TLS session
+	if buffered := c.text.R.Buffered(); buffered > 0 {
+		if !c.server.discardSTARTTLSPipelining {
+			return errSTARTTLSPipelining
+		}
+		c.logger().WarnContext(c.ctx, "discarding plaintext pipelined after STARTTLS", slog.Int("bytes", buffered))
+	}
+
 	c.writeResponse(220, smtp.EnhancedCode{2, 0, 0}, "Ready to start TLS")
+	_ = c.text.W.Flush()
 
 	// Upgrade to TLS
 	tlsConn := tls.Server(c.conn, tlsConfig)
@@ -788,20 +848,21 @@ func (c *Conn) handleData(arg string) error {
 		return smtp.NewStatus(502, smtp.EnhancedCode{5, 5, 1}, "DATA not allowed for BINARYMIME messages")
 	}
 
-	var r io.Reader
+	var r, data io.Reader
 
 	rstart := func() io.Reader {
-		if r != nil {
-			return r
+		if data != nil {
+			return data
 		}
 		// We have recipients, go to accept data
 		c.writeResponse(354, smtp.NoEnhancedCode, "Go ahead. End your data with <CR><LF>.<CR><LF>")
 
-		r := textsmtp.NewDotReader(c.text.R, c.server.maxMessageBytes)
-		return r
+		r = textsmtp.NewDotReader(c.text.R, c.server.maxMessageBytes)
+		data = textsmtp.NewLineEndingReader(r, c.server.lineEnding)
+		return data
 	}
 
-	uuid, err := c.session.Data(c.ctx, rstart)
+	uuid, err := c.data(rstart)
 	if err != nil {
 		// an error which isn't a SMTPStatus error will always terminate the connection
 		// if it is an SMTPStatus then wi need to make sure the stream ist read to the end
@@ -811,9 +872,16 @@ func (c *Conn) handleData(arg string) error {
 		return err
 	}
 
-	// Make sure all the data has been consumed
-	if r != nil {
-		_, _ = io.Copy(io.Discard, r)
+	// Make sure all the data has been consumed. The session already accepted the message,
+	// a line ending rejected in the unread rest is only logged.
+	if data != nil {
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			if _, ok := err.(*smtp.Status); !ok {
+				return err
+			}
+			c.logger().WarnContext(c.ctx, "accepted message with invalid line ending", slog.Any("err", err))
+			_, _ = io.Copy(io.Discard, r)
+		}
 	}
 
 	if err = c.reset(); err != nil {
@@ -842,7 +910,7 @@ func (c *Conn) handleBdat(arg string) error {
 		return err
 	}
 
-	queueid, err := c.session.Data(c.ctx, func() io.Reader {
+	queueid, err := c.data(func() io.Reader {
 		return data
 	})
 	if err != nil {
@@ -966,12 +1034,14 @@ func (c *Conn) reset() error {
 	}
 
 	c.recipients = 0
+	c.milterAbort()
 
 	upgrade := c.state == stateUpgrade
 
 	// Authentication is only revoked if starttls is used.
 	if upgrade {
 		c.didAuth = false
+		c.authMech = ""
 	}
 	ctx, err := c.session.Reset(c.ctx, upgrade)
 	c.ctx = ctx
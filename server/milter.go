@@ -0,0 +1,459 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/internal/parse"
+	"github.com/uponusolutions/go-smtp/milter"
+)
+
+// milterConn is the connection to a milter.
+type milterConn struct {
+	client *milter.Client
+	// session is nil if the milter failed.
+	session *milter.Session
+	// accepted is set if the milter accepted the connection.
+	accepted bool
+	// message is set if the milter accepted the current message.
+	message bool
+}
+
+// milterRcpt is a recipient of the current message.
+type milterRcpt struct {
+	to   string
+	opts *smtp.RcptOptions
+}
+
+// milters are the milters of a connection and the envelope of the current message.
+type milters struct {
+	conns []*milterConn
+
+	active     bool // MAIL FROM was sent to the milters
+	changed    bool // the envelope was modified
+	discard    bool
+	from       string
+	mailOpts   *smtp.MailOptions
+	rcpts      []milterRcpt
+	quarantine string
+}
+
+// Quarantine returns the reason if a milter quarantined the current message.
+// It can be checked inside Session.Data.
+func (c *Conn) Quarantine() string {
+	if c.milters == nil {
+		return ""
+	}
+	return c.milters.quarantine
+}
+
+// milterConnect connects to the milters and sends the connection information.
+func (c *Conn) milterConnect() *smtp.Status {
+	if len(c.server.milters) == 0 {
+		return nil
+	}
+
+	c.milters = &milters{}
+	for _, client := range c.server.milters {
+		s, err := client.Session(c.ctx)
+		if err != nil {
+			c.logger().ErrorContext(c.ctx, "milter connect failed", slog.Any("err", err))
+		}
+		c.milters.conns = append(c.milters.conns, &milterConn{client: client, session: s})
+	}
+
+	addr := c.conn.RemoteAddr()
+	host := "unknown"
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		host = tcp.IP.String()
+	}
+
+	status := c.callMilters(milter.StageConnect,
+		[]string{"j", c.server.hostname, "{daemon_name}", c.server.hostname, "_", "[" + host + "]", "{client_addr}", host},
+		func(s *milter.Session) (*milter.Response, error) {
+			return s.Connect("["+host+"]", addr)
+		},
+	)
+	if status == nil {
+		return nil
+	}
+
+	// the connection is closed after the status
+	if status.Code/100 == 4 {
+		return smtp.NewStatus(421, status.EnhancedCode, status.Message)
+	}
+	return smtp.NewStatus(554, status.EnhancedCode, status.Message)
+}
+
+// milterHelo sends HELO to the milters.
+func (c *Conn) milterHelo(domain string) *smtp.Status {
+	if c.milters == nil {
+		return nil
+	}
+	return c.callMilters(milter.StageHelo, nil, func(s *milter.Session) (*milter.Response, error) {
+		return s.Helo(domain)
+	})
+}
+
+// milterMail sends MAIL FROM to the milters and starts a message.
+func (c *Conn) milterMail(from string, args []string, opts *smtp.MailOptions) *smtp.Status {
+	if c.milters == nil {
+		return nil
+	}
+
+	c.milters.active = true
+	c.milters.from = from
+	c.milters.mailOpts = opts
+
+	status := c.callMilters(milter.StageMail, []string{"{mail_addr}", from},
+		func(s *milter.Session) (*milter.Response, error) {
+			return s.Mail(from, args)
+		},
+	)
+	if status != nil {
+		c.milterAbort()
+	}
+	return status
+}
+
+// milterRcpt sends RCPT TO to the milters.
+func (c *Conn) milterRcpt(to string, args []string) *smtp.Status {
+	if c.milters == nil {
+		return nil
+	}
+	return c.callMilters(milter.StageRcpt, []string{"{rcpt_addr}", to}, func(s *milter.Session) (*milter.Response, error) {
+		return s.Rcpt(to, args)
+	})
+}
+
+// milterAccepted records a recipient accepted by the session.
+func (c *Conn) milterAccepted(to string, opts *smtp.RcptOptions) {
+	if c.milters != nil {
+		c.milters.rcpts = append(c.milters.rcpts, milterRcpt{to: to, opts: opts})
+	}
+}
+
+// milterAbort aborts the current message and clears its state.
+func (c *Conn) milterAbort() {
+	if c.milters == nil {
+		return
+	}
+
+	for _, m := range c.milters.conns {
+		m.message = false
+		if c.milters.active && m.session != nil && !m.accepted {
+			if err := m.session.Abort(); err != nil {
+				c.milterFailed(m, err)
+			}
+		}
+	}
+
+	conns := c.milters.conns
+	*c.milters = milters{conns: conns}
+}
+
+// milterClose closes the milter sessions.
+func (c *Conn) milterClose() {
+	if c.milters == nil {
+		return
+	}
+	for _, m := range c.milters.conns {
+		if m.session != nil {
+			_ = m.session.Close()
+			m.session = nil
+		}
+	}
+	c.milters = nil
+}
+
+// data passes the message through the milters before it is handed to Session.Data.
+func (c *Conn) data(read func() io.Reader) (string, error) {
+	if c.milters == nil {
+		return c.session.Data(c.ctx, read)
+	}
+
+	if status := c.callMilters(milter.StageData, nil, (*milter.Session).Data); status != nil {
+		c.milterAbort()
+		return "", status
+	}
+
+	buf, err := io.ReadAll(read())
+	if err != nil {
+		c.milterAbort()
+		return "", err
+	}
+
+	msg := parseMessage(buf)
+	if status := c.callMilters(milter.StageEOM, nil, func(s *milter.Session) (*milter.Response, error) {
+		return c.filter(s, msg)
+	}); status != nil {
+		c.milterAbort()
+		return "", status
+	}
+	c.milters.active = false
+
+	// discarded messages are accepted without delivery
+	if c.milters.discard || len(c.milters.rcpts) == 0 {
+		return "", nil
+	}
+
+	if c.milters.changed {
+		ctx, err := c.session.Reset(c.ctx, false)
+		c.ctx = ctx
+		if err != nil {
+			return "", err
+		}
+		if err := c.session.Mail(c.ctx, c.milters.from, c.milters.mailOpts); err != nil {
+			return "", c.newStatusError(451, smtp.EnhancedCode{4, 0, 0}, "Mail not accepted", err)
+		}
+		for _, rcpt := range c.milters.rcpts {
+			if err := c.session.Rcpt(c.ctx, rcpt.to, rcpt.opts); err != nil {
+				return "", c.newStatusError(451, smtp.EnhancedCode{4, 0, 0}, "Recipient not accepted", err)
+			}
+		}
+	}
+
+	return c.session.Data(c.ctx, func() io.Reader {
+		return bytes.NewReader(msg.bytes())
+	})
+}
+
+// filter sends the message to a milter and applies the modifications.
+func (c *Conn) filter(s *milter.Session, msg *message) (*milter.Response, error) {
+	for i := range msg.fields {
+		res, err := s.Header(msg.name(i), msg.value(i))
+		if err != nil || res.Action != milter.ActionContinue {
+			return res, err
+		}
+	}
+
+	res, err := s.EndOfHeaders()
+	if err != nil || res.Action != milter.ActionContinue {
+		return res, err
+	}
+
+	res, err = s.Body(msg.body)
+	if err != nil || (res.Action != milter.ActionContinue && res.Action != milter.ActionSkip) {
+		return res, err
+	}
+
+	mods, res, err := s.EndOfMessage()
+	if err != nil {
+		return nil, err
+	}
+	for _, mod := range mods {
+		c.modify(msg, mod)
+	}
+	return res, nil
+}
+
+// modify applies a modification to the message or the envelope.
+func (c *Conn) modify(msg *message, mod milter.Modification) {
+	switch mod.Action {
+	case milter.ModifyChangeFrom:
+		// the ESMTP arguments are replaced as well
+		c.milters.from = mod.Address
+		c.milters.mailOpts = parse.MailOptions(mod.Args)
+		c.milters.changed = true
+	case milter.ModifyAddRcpt:
+		c.milters.rcpts = append(c.milters.rcpts, milterRcpt{to: mod.Address, opts: parse.RcptOptions(mod.Args)})
+		c.milters.changed = true
+	case milter.ModifyRemoveRcpt:
+		c.milters.rcpts = slices.DeleteFunc(c.milters.rcpts, func(r milterRcpt) bool {
+			return strings.EqualFold(r.to, mod.Address)
+		})
+		c.milters.changed = true
+	case milter.ModifyQuarantine:
+		c.milters.quarantine = mod.Reason
+	case milter.ModifyAddHeader, milter.ModifyInsertHeader, milter.ModifyChangeHeader, milter.ModifyReplaceBody:
+		msg.apply(mod)
+	}
+}
+
+// callMilters calls fn for every milter which didn't accept the connection or the current message.
+func (c *Conn) callMilters(
+	stage milter.Stage,
+	macros []string,
+	fn func(*milter.Session) (*milter.Response, error),
+) *smtp.Status {
+	message := stage != milter.StageConnect && stage != milter.StageHelo
+
+	for _, m := range c.milters.conns {
+		if m.session == nil {
+			if m.client.FailOpen() {
+				continue
+			}
+			return smtp.NewStatus(451, smtp.EnhancedCode{4, 7, 1}, "Service unavailable - try again later")
+		}
+		if m.accepted || (message && (m.message || c.milters.discard)) {
+			continue
+		}
+
+		err := m.session.Macros(stage, macros...)
+		var res *milter.Response
+		if err == nil {
+			res, err = fn(m.session)
+		}
+		if err != nil {
+			if status := c.milterFailed(m, err); status != nil {
+				return status
+			}
+			continue
+		}
+
+		switch res.Action {
+		case milter.ActionAccept:
+			if message {
+				m.message = true
+			} else {
+				m.accepted = true
+			}
+		case milter.ActionDiscard:
+			c.milters.discard = true
+		case milter.ActionReject:
+			return milterStatus(res, smtp.NewStatus(550, smtp.EnhancedCode{5, 7, 1}, "Command rejected"))
+		case milter.ActionTempFail:
+			return milterStatus(res,
+				smtp.NewStatus(451, smtp.EnhancedCode{4, 7, 1}, "Service unavailable - try again later"))
+		case milter.ActionContinue, milter.ActionSkip:
+			// This space is intentionally left blank
+		}
+	}
+
+	return nil
+}
+
+// milterFailed closes a failed milter, the returned status is set unless the milter fails open.
+func (c *Conn) milterFailed(m *milterConn, err error) *smtp.Status {
+	c.logger().ErrorContext(c.ctx, "milter failed", slog.Any("err", err))
+
+	_ = m.session.Close()
+	m.session = nil
+
+	if m.client.FailOpen() {
+		return nil
+	}
+	return smtp.NewStatus(451, smtp.EnhancedCode{4, 7, 1}, "Service unavailable - try again later")
+}
+
+// milterStatus returns the reply of the milter or the default.
+func milterStatus(res *milter.Response, def *smtp.Status) *smtp.Status {
+	if res.Status != nil {
+		return res.Status
+	}
+	return def
+}
+
+// message is a message split into header fields and body.
+type message struct {
+	raw      []byte
+	fields   []string // without line ending, folded lines are separated by CRLF
+	body     []byte
+	modified bool
+}
+
+// parseMessage splits a message into header fields and body.
+func parseMessage(raw []byte) *message {
+	m := &message{raw: raw}
+
+	rest := raw
+	for len(rest) > 0 {
+		line, next, _ := bytes.Cut(rest, []byte{'\n'})
+		text := strings.TrimRight(string(line), "\r")
+
+		switch {
+		case text == "":
+			m.body = next
+			return m
+		case (text[0] == ' ' || text[0] == '\t') && len(m.fields) > 0:
+			m.fields[len(m.fields)-1] += "\r\n" + text
+		case !strings.Contains(text, ":"):
+			// no header field, everything else is the body
+			m.body = rest
+			return m
+		default:
+			m.fields = append(m.fields, text)
+		}
+
+		rest = next
+	}
+
+	return m
+}
+
+// name returns the name of the i-th header field.
+func (m *message) name(i int) string {
+	name, _, _ := strings.Cut(m.fields[i], ":")
+	return strings.TrimSpace(name)
+}
+
+// value returns the value of the i-th header field, folded lines are separated by LF.
+func (m *message) value(i int) string {
+	_, value, _ := strings.Cut(m.fields[i], ":")
+	return strings.ReplaceAll(value, "\r\n", "\n")
+}
+
+// apply applies a header or body modification.
+func (m *message) apply(mod milter.Modification) {
+	m.modified = true
+
+	switch mod.Action {
+	case milter.ModifyAddHeader:
+		m.fields = append(m.fields, field(mod.Name, mod.Value))
+	case milter.ModifyInsertHeader:
+		m.fields = slices.Insert(m.fields, min(max(mod.Index, 0), len(m.fields)), field(mod.Name, mod.Value))
+	case milter.ModifyChangeHeader:
+		n := 0
+		for i := range m.fields {
+			if !strings.EqualFold(m.name(i), mod.Name) {
+				continue
+			}
+			if n++; n < max(mod.Index, 1) {
+				continue
+			}
+			if mod.Value == "" {
+				m.fields = slices.Delete(m.fields, i, i+1)
+			} else {
+				m.fields[i] = field(mod.Name, mod.Value)
+			}
+			return
+		}
+		if mod.Value != "" {
+			m.fields = append(m.fields, field(mod.Name, mod.Value))
+		}
+	case milter.ModifyReplaceBody:
+		m.body = mod.Body
+	default:
+		// envelope modifications are applied by the connection
+	}
+}
+
+// bytes returns the message, the original if it wasn't modified.
+func (m *message) bytes() []byte {
+	if !m.modified {
+		return m.raw
+	}
+
+	var b bytes.Buffer
+	for _, f := range m.fields {
+		b.WriteString(f)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(m.body)
+	return b.Bytes()
+}
+
+// field formats a header field, folded lines of value are separated by LF.
+func field(name string, value string) string {
+	value = strings.ReplaceAll(strings.ReplaceAll(value, "\r\n", "\n"), "\n", "\r\n")
+	if value == "" || (value[0] != ' ' && value[0] != '\t') {
+		value = " " + value
+	}
+	return name + ":" + value
+}
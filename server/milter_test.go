@@ -0,0 +1,257 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/milter"
+	"github.com/uponusolutions/go-smtp/server"
+)
+
+// milterPacket encodes a response with null terminated strings.
+func milterPacket(code byte, s ...string) []byte {
+	p := []byte{code}
+	for _, v := range s {
+		p = append(append(p, v...), 0)
+	}
+	return p
+}
+
+// startMilter starts a local milter, respond returns the responses to a command.
+func startMilter(t *testing.T, respond func(code byte, data []byte) [][]byte) *milter.Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	var mu sync.Mutex
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				for {
+					var length uint32
+					if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+						return
+					}
+					buf := make([]byte, length)
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+
+					var out [][]byte
+					switch buf[0] {
+					case 'O':
+						out = [][]byte{binary.BigEndian.AppendUint32(
+							binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{'O'}, 6), 0x1ff), 0)}
+					case 'D', 'A':
+					case 'Q':
+						return
+					default:
+						mu.Lock()
+						out = respond(buf[0], buf[1:])
+						mu.Unlock()
+					}
+
+					for _, p := range out {
+						_, _ = conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(p))), p...))
+					}
+				}
+			}()
+		}
+	}()
+
+	return milter.NewClient("tcp", l.Addr().String())
+}
+
+func TestServerMilter(t *testing.T) {
+	headers := []string{}
+	client := startMilter(t, func(code byte, data []byte) [][]byte {
+		switch code {
+		case 'R':
+			if strings.HasPrefix(string(data), "<spam@example.org>") {
+				return [][]byte{milterPacket('y', "550 5.7.1 No spam please")}
+			}
+		case 'L':
+			headers = append(headers, strings.ReplaceAll(string(data), "\x00", "|"))
+		case 'E':
+			return [][]byte{
+				milterPacket('h', "X-Spam", "yes"),
+				append(binary.BigEndian.AppendUint32([]byte{'m'}, 1), milterPacket(0, "Subject", "Changed")[1:]...),
+				milterPacket('e', "<changed@example.org>", "RET=HDRS ENVID=abc+2Bd"),
+				milterPacket('2', "<added@example.org>", "NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;old@example.org"),
+				milterPacket('-', "<root@localhost>"),
+				[]byte("bNew body\r\n"),
+				milterPacket('q', "suspicious"),
+				{'a'},
+			}
+		}
+		return [][]byte{{'c'}}
+	})
+
+	be, s, c, scanner, _ := testServerEhlo(t, nil, server.WithMilters(client))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<root@nsa.gov>\r\n")
+	scanner.Scan()
+	require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+
+	_, _ = io.WriteString(c, "RCPT TO:<spam@example.org>\r\n")
+	scanner.Scan()
+	require.Equal(t, "550 5.7.1 No spam please", scanner.Text())
+
+	_, _ = io.WriteString(c, "RCPT TO:<root@localhost>\r\n")
+	scanner.Scan()
+	require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+
+	_, _ = io.WriteString(c, "DATA\r\n")
+	scanner.Scan()
+	require.True(t, strings.HasPrefix(scanner.Text(), "354 "), scanner.Text())
+
+	_, _ = io.WriteString(c, "Subject: Hello\r\nTo: root@localhost\r\n\r\nHey <3\r\n.\r\n")
+	scanner.Scan()
+	require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+
+	require.Equal(t, []string{"Subject|Hello|", "To|root@localhost|"}, headers)
+	require.Len(t, be.anonmsgs, 1)
+	msg := be.anonmsgs[0]
+	require.Equal(t, "changed@example.org", msg.From)
+	require.Equal(t, smtp.DSNReturnHeaders, msg.Opts.Return)
+	require.Equal(t, "abc+d", msg.Opts.EnvelopeID)
+	require.Equal(t, []string{"added@example.org"}, msg.To)
+	require.Equal(t, []smtp.DSNNotify{smtp.DSNNotifySuccess, smtp.DSNNotifyFailure}, msg.RcptOpts[0].Notify)
+	require.Equal(t, smtp.DSNAddressTypeRFC822, msg.RcptOpts[0].OriginalRecipientType)
+	require.Equal(t, "old@example.org", msg.RcptOpts[0].OriginalRecipient)
+	require.Equal(t, "suspicious", msg.Quarantine)
+	require.Equal(t, "Subject: Changed\r\nTo: root@localhost\r\nX-Spam: yes\r\n\r\nNew body\r\n", string(msg.Data))
+
+	// the next message isn't modified
+	_, _ = io.WriteString(c, "MAIL FROM:<root@nsa.gov>\r\nRCPT TO:<root@localhost>\r\nDATA\r\n")
+	for range 3 {
+		scanner.Scan()
+	}
+	require.True(t, strings.HasPrefix(scanner.Text(), "354 "), scanner.Text())
+}
+
+func TestServerMilter_Reject(t *testing.T) {
+	client := startMilter(t, func(code byte, _ []byte) [][]byte {
+		if code == 'M' {
+			return [][]byte{{'t'}}
+		}
+		return [][]byte{{'c'}}
+	})
+
+	be, s, c, scanner, _ := testServerEhlo(t, nil, server.WithMilters(client))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<root@nsa.gov>\r\n")
+	scanner.Scan()
+	require.Equal(t, "451 4.7.1 Service unavailable - try again later", scanner.Text())
+
+	_, _ = io.WriteString(c, "MAIL FROM:<>\r\n")
+	scanner.Scan()
+	require.Equal(t, "451 4.7.1 Service unavailable - try again later", scanner.Text())
+	require.Empty(t, be.anonmsgs)
+}
+
+func TestServerMilter_RejectMessage(t *testing.T) {
+	client := startMilter(t, func(code byte, _ []byte) [][]byte {
+		if code == 'E' {
+			return [][]byte{milterPacket('y', "550-5.7.1 Virus found\r\n550 5.7.1 Eicar")}
+		}
+		return [][]byte{{'c'}}
+	})
+
+	be, s, c, scanner, _ := testServerEhlo(t, nil, server.WithMilters(client))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<root@nsa.gov>\r\nRCPT TO:<root@localhost>\r\nDATA\r\n")
+	for range 3 {
+		scanner.Scan()
+	}
+	_, _ = io.WriteString(c, "Subject: Virus\r\n\r\nX5O!P%@AP\r\n.\r\n")
+	scanner.Scan()
+	require.Equal(t, "550-Virus found", scanner.Text())
+	scanner.Scan()
+	require.Equal(t, "550 5.7.1 Eicar", scanner.Text())
+	require.Empty(t, be.anonmsgs)
+}
+
+func TestServerMilter_Discard(t *testing.T) {
+	client := startMilter(t, func(code byte, _ []byte) [][]byte {
+		if code == 'N' {
+			return [][]byte{{'d'}}
+		}
+		return [][]byte{{'c'}}
+	})
+
+	be, s, c, scanner, _ := testServerEhlo(t, nil, server.WithMilters(client))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<root@nsa.gov>\r\nRCPT TO:<root@localhost>\r\nDATA\r\n")
+	for range 3 {
+		scanner.Scan()
+	}
+	_, _ = io.WriteString(c, "Subject: Hello\r\n\r\nHey <3\r\n.\r\n")
+	scanner.Scan()
+	require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+	require.Empty(t, be.anonmsgs)
+}
+
+func TestServerMilter_Connect(t *testing.T) {
+	client := startMilter(t, func(code byte, _ []byte) [][]byte {
+		if code == 'C' {
+			return [][]byte{{'r'}}
+		}
+		return [][]byte{{'c'}}
+	})
+
+	_, s, c, scanner := testServer(t, nil, server.WithMilters(client))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	scanner.Scan()
+	require.Equal(t, "554 5.7.1 Command rejected", scanner.Text())
+}
+
+func TestServerMilter_Unavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	_, s, c, scanner := testServer(t, nil, server.WithMilters(milter.NewClient("tcp", addr)))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	scanner.Scan()
+	require.Equal(t, "421 4.7.1 Service unavailable - try again later", scanner.Text())
+
+	// fail open
+	be, s2, c2, scanner2, _ := testServerEhlo(t, nil,
+		server.WithMilters(milter.NewClient("tcp", addr, milter.WithFailOpen(true))))
+	defer func() { _ = s2.Close() }()
+	defer func() { _ = c2.Close() }()
+
+	_, _ = io.WriteString(c2, "MAIL FROM:<root@nsa.gov>\r\nRCPT TO:<root@localhost>\r\nDATA\r\n")
+	for range 3 {
+		scanner2.Scan()
+	}
+	_, _ = io.WriteString(c2, "Subject: Hello\r\n\r\nHey <3\r\n.\r\n")
+	scanner2.Scan()
+	require.True(t, strings.HasPrefix(scanner2.Text(), "250 "), scanner2.Text())
+	require.Len(t, be.anonmsgs, 1)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/uponusolutions/go-sasl"
+	"github.com/uponusolutions/go-smtp"
+)
+
+// OAuth2Validator validates the credentials of a XOAUTH2 or OAUTHBEARER authentication.
+// A returned smtp.Status is sent to the client as it is, every other error rejects the token.
+type OAuth2Validator func(ctx context.Context, credentials smtp.OAuth2Credentials) error
+
+// oauth2Server implements sasl.Server for XOAUTH2 and OAUTHBEARER.
+type oauth2Server struct {
+	ctx      context.Context
+	mech     string
+	validate OAuth2Validator
+	failed   bool
+	done     bool
+}
+
+// NewOAuth2Server returns a sasl server for XOAUTH2 or OAUTHBEARER (RFC 7628) which
+// validates the bearer token with validate. It can be returned by Session.Auth.
+func NewOAuth2Server(ctx context.Context, mech string, validate OAuth2Validator) (sasl.Server, error) {
+	if mech != smtp.XOAuth2 && mech != smtp.OAuthBearer {
+		return nil, errors.New("smtp: unsupported OAuth2 mechanism " + mech)
+	}
+	return &oauth2Server{ctx: ctx, mech: mech, validate: validate}, nil
+}
+
+func (s *oauth2Server) Next(response []byte) (challenge []byte, done bool, err error) {
+	// the client has to respond to the error challenge before the authentication fails
+	if s.failed {
+		return nil, true, smtp.NewStatus(535, smtp.EnhancedCode{5, 7, 8}, "Authentication credentials invalid")
+	}
+
+	if s.done {
+		return nil, true, sasl.ErrUnexpectedClientResponse
+	}
+
+	// no initial response, ask for it
+	if response == nil {
+		return []byte{}, false, nil
+	}
+
+	s.done = true
+
+	credentials, err := smtp.DecodeOAuth2(s.mech, response)
+	if err != nil {
+		return nil, true, smtp.NewStatus(501, smtp.EnhancedCode{5, 5, 2}, "Invalid OAuth2 response")
+	}
+
+	if err := s.validate(s.ctx, credentials); err != nil {
+		status := &smtp.Status{}
+		if errors.As(err, &status) {
+			return nil, true, status
+		}
+
+		s.failed = true
+		challenge, err = json.Marshal(smtp.OAuth2Error{Status: "invalid_token", Schemes: "bearer"})
+		return challenge, false, err
+	}
+
+	return nil, true, nil
+}
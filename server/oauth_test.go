@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uponusolutions/go-smtp"
+	"github.com/uponusolutions/go-smtp/server"
+)
+
+func validateToken(_ context.Context, credentials smtp.OAuth2Credentials) error {
+	switch credentials.Token {
+	case "valid":
+		return nil
+	case "unavailable":
+		return smtp.NewStatus(454, smtp.EnhancedCode{4, 7, 0}, "Temporary authentication failure")
+	default:
+		return errors.New("invalid token")
+	}
+}
+
+func TestOAuth2Server(t *testing.T) {
+	_, err := server.NewOAuth2Server(context.Background(), "PLAIN", validateToken)
+	require.Error(t, err)
+
+	for _, mech := range []string{smtp.XOAuth2, smtp.OAuthBearer} {
+		t.Run(mech, func(t *testing.T) {
+			response := func(token string) []byte {
+				r, err := smtp.EncodeOAuth2(mech, smtp.OAuth2Credentials{Username: "user", Token: token})
+				require.NoError(t, err)
+				return r
+			}
+
+			// without initial response
+			s, err := server.NewOAuth2Server(context.Background(), mech, validateToken)
+			require.NoError(t, err)
+			challenge, done, err := s.Next(nil)
+			require.NoError(t, err)
+			require.False(t, done)
+			require.Empty(t, challenge)
+			_, done, err = s.Next(response("valid"))
+			require.NoError(t, err)
+			require.True(t, done)
+
+			// rejected token
+			s, err = server.NewOAuth2Server(context.Background(), mech, validateToken)
+			require.NoError(t, err)
+			challenge, done, err = s.Next(response("expired"))
+			require.NoError(t, err)
+			require.False(t, done)
+			oauthErr := smtp.OAuth2Error{}
+			require.NoError(t, json.Unmarshal(challenge, &oauthErr))
+			require.Equal(t, "invalid_token", oauthErr.Status)
+			_, done, err = s.Next([]byte{0x01})
+			require.True(t, done)
+			require.ErrorContains(t, err, "535")
+
+			// status of the validator
+			s, err = server.NewOAuth2Server(context.Background(), mech, validateToken)
+			require.NoError(t, err)
+			_, done, err = s.Next(response("unavailable"))
+			require.True(t, done)
+			require.ErrorContains(t, err, "454")
+
+			// malformed response
+			s, err = server.NewOAuth2Server(context.Background(), mech, validateToken)
+			require.NoError(t, err)
+			_, done, err = s.Next([]byte("invalid"))
+			require.True(t, done)
+			require.ErrorContains(t, err, "501")
+		})
+	}
+}
@@ -31,45 +31,7 @@ import (
 
 // Serve accepts incoming connections on the Listener l.
 func (s *Server) Serve(ctx context.Context, l net.Listener) error {
-	s.locker.Lock()
-	s.listeners = append(s.listeners, l)
-	s.locker.Unlock()
-
-	var tempDelay time.Duration // how long to sleep on accept failure
-
-	for {
-		c, err := l.Accept()
-		if err != nil {
-			select {
-			case <-s.done:
-				// we called Close()
-				return nil
-			default:
-			}
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				if tempDelay == 0 {
-					tempDelay = 5 * time.Millisecond
-				} else {
-					tempDelay *= 2
-				}
-				if maxDelay := 1 * time.Second; tempDelay > maxDelay {
-					tempDelay = maxDelay
-				}
-				s.logger.ErrorContext(
-					ctx,
-					"accept error, retrying",
-					slog.Any("err", err),
-					slog.Any("temp_delay", tempDelay),
-				)
-				time.Sleep(tempDelay)
-				continue
-			}
-			return err
-		}
-
-		s.wg.Add(1)
-		go s.handleConn(ctx, c)
-	}
+	return s.lifecycle.Serve(ctx, s.logger, l, s.handleConn)
 }
 
 func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
@@ -81,10 +43,7 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 		conn:   conn,
 		text:   textsmtp.NewTextproto(conn, s.readerSize, s.writerSize, s.maxLineLength),
 	}
-
-	s.locker.Lock()
-	s.conns[c] = struct{}{}
-	s.locker.Unlock()
+	c.text.LineEnding = s.lineEnding
 
 	var err error
 
@@ -101,12 +60,6 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 			c.Close(errors.New("recovered from panic inside handleConn"))
 		}
 
-		s.locker.Lock()
-		delete(s.conns, c)
-		s.locker.Unlock()
-
-		s.wg.Done()
-
 		cancel()
 	}()
 
@@ -207,28 +160,7 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 // Close returns any error returned from closing the server's underlying
 // listener(s).
 func (s *Server) Close() error {
-	select {
-	case <-s.done:
-		return ErrServerClosed
-	default:
-		close(s.done)
-	}
-
-	var err error
-	s.locker.Lock()
-	for _, l := range s.listeners {
-		if lerr := l.Close(); lerr != nil && err == nil {
-			err = lerr
-		}
-	}
-
-	for conn := range s.conns {
-		// directly close underlying connection
-		_ = conn.conn.Close()
-	}
-	s.locker.Unlock()
-
-	return err
+	return s.lifecycle.Close()
 }
 
 // Shutdown gracefully shuts down the server without interrupting any
@@ -239,32 +171,5 @@ func (s *Server) Close() error {
 // Shutdown returns the context's error, otherwise it returns any
 // error returned from closing the Server's underlying Listener(s).
 func (s *Server) Shutdown(ctx context.Context) error {
-	select {
-	case <-s.done:
-		return ErrServerClosed
-	default:
-		close(s.done)
-	}
-
-	var err error
-	s.locker.Lock()
-	for _, l := range s.listeners {
-		if lerr := l.Close(); lerr != nil && err == nil {
-			err = lerr
-		}
-	}
-	s.locker.Unlock()
-
-	connDone := make(chan struct{})
-	go func() {
-		defer close(connDone)
-		s.wg.Wait()
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-connDone:
-		return err
-	}
+	return s.lifecycle.Shutdown(ctx)
 }
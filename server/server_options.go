@@ -4,9 +4,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"log/slog"
-	"net"
-	"sync"
 	"time"
+
+	"github.com/uponusolutions/go-smtp/internal/serve"
+	"github.com/uponusolutions/go-smtp/internal/textsmtp"
+	"github.com/uponusolutions/go-smtp/milter"
 )
 
 // ErrServerClosed occurs if a server is already closed.
@@ -67,17 +69,21 @@ type Server struct {
 	// Should be used only if backend supports it.
 	enableXOORG bool
 
+	// Handling of bare CR and bare LF in commands and DATA.
+	lineEnding LineEnding
+
+	// Discards plaintext pipelined after STARTTLS instead of rejecting it and closing the connection.
+	discardSTARTTLSPipelining bool
+
 	// The server backend.
 	backend Backend
 
-	logger *slog.Logger
+	// Milters called for every connection.
+	milters []*milter.Client
 
-	wg   sync.WaitGroup
-	done chan struct{}
+	logger *slog.Logger
 
-	locker    sync.Mutex
-	listeners []net.Listener
-	conns     map[*Conn]struct{}
+	lifecycle *serve.Lifecycle
 }
 
 // Backend returns the servers Backend.
@@ -91,9 +97,8 @@ type Option func(*Server)
 // New creates a new SMTP server.
 func New(opts ...Option) *Server {
 	s := &Server{
-		done:     make(chan struct{}, 1),
-		conns:    make(map[*Conn]struct{}),
-		hostname: "localhost",
+		hostname:  "localhost",
+		lifecycle: serve.New(ErrServerClosed),
 	}
 
 	for _, o := range opts {
@@ -177,6 +182,41 @@ func WithEnableXOORG(enableXOORG bool) Option {
 	}
 }
 
+// LineEnding defines how bare CR and bare LF in commands and DATA are handled.
+// Inconsistent handling between servers allows SMTP smuggling, e.g. an attacker hides a second
+// message behind <LF>.<LF> which is taken as end of data by the next hop.
+// The end of data is always <CR><LF>.<CR><LF> only.
+type LineEnding = textsmtp.LineEnding
+
+const (
+	// LineEndingPermissive accepts bare CR and bare LF (default). Commands may end with a bare LF
+	// and bare line endings in messages are passed through unchanged.
+	LineEndingPermissive = textsmtp.LineEndingPermissive
+	// LineEndingStrict rejects commands and messages containing a bare CR or bare LF
+	// with 550 5.5.2 and closes the connection if a command is rejected.
+	LineEndingStrict = textsmtp.LineEndingStrict
+	// LineEndingNormalize converts bare CR and bare LF in messages to CRLF. Commands may end
+	// with a bare LF, but commands containing a bare CR are rejected.
+	LineEndingNormalize = textsmtp.LineEndingNormalize
+)
+
+// WithLineEnding sets how bare CR and bare LF in commands and DATA are handled.
+func WithLineEnding(lineEnding LineEnding) Option {
+	return func(s *Server) {
+		s.lineEnding = lineEnding
+	}
+}
+
+// WithDiscardSTARTTLSPipelining discards plaintext commands pipelined after STARTTLS.
+// By default, STARTTLS is rejected with 501 5.5.4 and the connection is closed, as the commands
+// could have been injected by an attacker (CVE-2011-0411). Commands are never processed inside
+// the TLS session.
+func WithDiscardSTARTTLSPipelining(discard bool) Option {
+	return func(s *Server) {
+		s.discardSTARTTLSPipelining = discard
+	}
+}
+
 // WithEnableBINARYMIME sets EnableBINARYMIME.
 func WithEnableBINARYMIME(enableBINARYMIME bool) Option {
 	return func(s *Server) {
@@ -260,3 +300,11 @@ func WithWriterSize(writerSize int) Option {
 		s.writerSize = writerSize
 	}
 }
+
+// WithMilters sets the milters (content filters) called for every connection in the given order.
+// Their decisions and modifications apply to the SMTP replies and the message passed to Session.Data.
+func WithMilters(milters ...*milter.Client) Option {
+	return func(s *Server) {
+		s.milters = milters
+	}
+}
@@ -26,6 +26,8 @@ type message struct {
 	RcptOpts []*smtp.RcptOptions
 	Data     []byte
 	Opts     *smtp.MailOptions
+
+	Quarantine string
 }
 
 type backend struct {
@@ -41,16 +43,20 @@ type backend struct {
 	// Error that will be returned by Data method.
 	dataErr error
 
+	// Number of bytes read by Data method, all if zero.
+	dataLimit int64
+
 	panicOnMail bool
 	userErr     error
 }
 
-func (be *backend) NewSession(ctx context.Context, _ *server.Conn) (context.Context, server.Session, error) {
-	return ctx, &session{backend: be, anonymous: true}, nil
+func (be *backend) NewSession(ctx context.Context, c *server.Conn) (context.Context, server.Session, error) {
+	return ctx, &session{backend: be, conn: c, anonymous: true}, nil
 }
 
 type session struct {
 	backend   *backend
+	conn      *server.Conn
 	anonymous bool
 
 	msg *message
@@ -126,7 +132,11 @@ func (s *session) Data(_ context.Context, r func() io.Reader) (string, error) {
 		}
 		return "", err
 	}
-	b, err := io.ReadAll(r())
+	data := r()
+	if s.backend.dataLimit > 0 {
+		data = io.LimitReader(data, s.backend.dataLimit)
+	}
+	b, err := io.ReadAll(data)
 	if err != nil {
 		if s.backend.dataErrors != nil {
 			s.backend.dataErrors <- err
@@ -134,6 +144,7 @@ func (s *session) Data(_ context.Context, r func() io.Reader) (string, error) {
 		return "", err
 	}
 	s.msg.Data = b
+	s.msg.Quarantine = s.conn.Quarantine()
 	if s.anonymous {
 		s.backend.anonmsgs = append(s.backend.anonmsgs, s.msg)
 	} else {
@@ -593,6 +604,16 @@ func TestServerSMTPUTF8_Disabled(t *testing.T) {
 	}
 }
 
+func TestServerREQUIRETLS_Plain(t *testing.T) {
+	_, s, c, scanner := testServerAuthenticated(t, nil, server.WithEnableREQUIRETLS(true))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<alice@wonderland.book> REQUIRETLS\r\n")
+	scanner.Scan()
+	require.Equal(t, "530 5.7.10 REQUIRETLS needs a TLS connection", scanner.Text())
+}
+
 func TestServer8BITMIME(t *testing.T) {
 	_, s, c, scanner := testServerAuthenticated(t, nil)
 	defer func() { _ = s.Close() }()
@@ -1832,3 +1853,78 @@ func TestServerXOORG(t *testing.T) {
 		t.Fatal("Invalid XOORG parameter value:", val)
 	}
 }
+
+func TestServerLineEndingStrict(t *testing.T) {
+	be, s, c, scanner := testServerAuthenticated(t, nil, server.WithLineEnding(server.LineEndingStrict))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<alice@example.com>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+	for _, code := range []string{"250 ", "250 ", "354 "} {
+		scanner.Scan()
+		require.True(t, strings.HasPrefix(scanner.Text(), code), scanner.Text())
+	}
+
+	// the smuggled message is part of the rejected message
+	_, _ = io.WriteString(c, "Subject: first\r\n\r\nHello\n.\n"+
+		"MAIL FROM:<admin@example.com>\r\nRCPT TO:<victim@example.com>\r\nDATA\r\n"+
+		"Subject: smuggled\r\n\r\nHi\r\n.\r\n")
+	scanner.Scan()
+	require.Equal(t, "550 5.5.2 bare LF not allowed", scanner.Text())
+
+	// the smuggled commands weren't processed
+	_, _ = io.WriteString(c, "RSET\r\nMAIL FROM:<alice@example.com>\r\n")
+	for range 2 {
+		scanner.Scan()
+		require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+	}
+	require.Empty(t, be.messages)
+
+	// a command with a bare LF closes the connection
+	_, _ = io.WriteString(c, "NOOP\n")
+	scanner.Scan()
+	require.Equal(t, "550 5.5.2 bare LF not allowed", scanner.Text())
+	require.False(t, scanner.Scan())
+}
+
+func TestServerLineEndingStrict_Accepted(t *testing.T) {
+	be, s, c, scanner := testServerAuthenticated(t, nil, server.WithLineEnding(server.LineEndingStrict))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	// the session accepts the message without reading the bare LF
+	be.dataLimit = 7
+
+	_, _ = io.WriteString(c, "MAIL FROM:<alice@example.com>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+	for _, code := range []string{"250 ", "250 ", "354 "} {
+		scanner.Scan()
+		require.True(t, strings.HasPrefix(scanner.Text(), code), scanner.Text())
+	}
+
+	_, _ = io.WriteString(c, "Subject: first\r\n\r\nHello\nWorld\r\n.\r\nNOOP\r\n")
+	for range 2 {
+		scanner.Scan()
+		require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+	}
+	require.Len(t, be.messages, 1)
+	require.Equal(t, "Subject", string(be.messages[0].Data))
+}
+
+func TestServerLineEndingNormalize(t *testing.T) {
+	be, s, c, scanner := testServerAuthenticated(t, nil, server.WithLineEnding(server.LineEndingNormalize))
+	defer func() { _ = s.Close() }()
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, "MAIL FROM:<alice@example.com>\nRCPT TO:<bob@example.com>\nDATA\n")
+	for _, code := range []string{"250 ", "250 ", "354 "} {
+		scanner.Scan()
+		require.True(t, strings.HasPrefix(scanner.Text(), code), scanner.Text())
+	}
+
+	_, _ = io.WriteString(c, "Subject: first\r\n\r\nHello\n.\nMAIL FROM:<admin@example.com>\r\n.\r\n")
+	scanner.Scan()
+	require.True(t, strings.HasPrefix(scanner.Text(), "250 "), scanner.Text())
+
+	require.Len(t, be.messages, 1)
+	require.Equal(t, "Subject: first\r\n\r\nHello\r\n.\r\nMAIL FROM:<admin@example.com>\r\n", string(be.messages[0].Data))
+}
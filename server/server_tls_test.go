@@ -158,3 +158,70 @@ func TestServerNoEnforceSecureConnectionStartTlsStarttls(t *testing.T) {
 		t.Fatal("Should succeed:", scanner.Text())
 	}
 }
+
+func TestServerSTARTTLSPipelining(t *testing.T) {
+	cert, err := tester.GenX509KeyPair("localhost")
+	require.NoError(t, err)
+
+	be, _, c, scanner, _ := testServerEhlo(
+		t,
+		nil,
+		server.WithTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}),
+	)
+
+	// the injected command must neither be processed in plaintext nor inside the TLS session
+	_, _ = io.WriteString(c, "STARTTLS\r\nMAIL FROM:<root@nsa.gov>\r\n")
+	scanner.Scan()
+	if !strings.HasPrefix(scanner.Text(), "501 5.5.4 ") {
+		t.Fatal("Pipelining after STARTTLS should be rejected:", scanner.Text())
+	}
+
+	if scanner.Scan() {
+		t.Fatal("Connection should be closed:", scanner.Text())
+	}
+
+	require.Empty(t, be.messages)
+}
+
+func TestServerSTARTTLSPipeliningDiscard(t *testing.T) {
+	cert, err := tester.GenX509KeyPair("localhost")
+	require.NoError(t, err)
+
+	_, _, c, _, _ := testServerEhlo(
+		t,
+		nil,
+		server.WithDiscardSTARTTLSPipelining(true),
+		server.WithTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}),
+	)
+
+	_, _ = io.WriteString(c, "STARTTLS\r\nMAIL FROM:<root@nsa.gov>\r\n")
+
+	buf := make([]byte, 30)
+	_, _ = c.Read(buf)
+
+	if string(buf) != "220 2.0.0 Ready to start TLS\r\n" {
+		t.Fatal("Ready to start expected:", string(buf))
+	}
+
+	// Upgrade to TLS
+	c = tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+
+	scanner := bufio.NewScanner(c)
+
+	_, _ = io.WriteString(c, "HELO localhost\r\n")
+	scanner.Scan()
+	if !strings.HasPrefix(scanner.Text(), "250 ") {
+		t.Fatal("hello expected:", scanner.Text())
+	}
+
+	// the pipelined MAIL command was discarded
+	_, _ = io.WriteString(c, "RCPT TO:<root@gchq.gov.uk>\r\n")
+	scanner.Scan()
+	if strings.HasPrefix(scanner.Text(), "250 ") {
+		t.Fatal("RCPT without MAIL should fail:", scanner.Text())
+	}
+}
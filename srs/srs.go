@@ -0,0 +1,279 @@
+// Package srs implements the Sender Rewriting Scheme for forwarding services.
+//
+// A forwarded message keeps passing SPF checks if its envelope sender is rewritten to an address
+// of the forwarding domain. The original sender is encoded in the address together with a
+// timestamp and a HMAC, so that bounces can be reversed and routed back to it:
+//
+//	sender@example.org -> SRS0=HHHH=TT=example.org=sender@forwarder.example.com
+//
+// Rewriting an address which is already rewritten by another forwarder results in a SRS1 address
+// pointing to the first forwarder. The format and the hash are compatible with libsrs2 and postsrsd.
+package srs
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// base32 is the alphabet of the timestamp.
+	base32 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	// precision is the resolution of the timestamp.
+	precision = 24 * time.Hour
+	// slots is the number of different timestamps, the timestamp wraps around after it.
+	slots = 1 << 10
+)
+
+var (
+	// ErrNotSRS is returned by Reverse if the address isn't an SRS address of the forwarding domain.
+	ErrNotSRS = errors.New("srs: not an srs address")
+	// ErrInvalid is returned by Reverse if the address is malformed.
+	ErrInvalid = errors.New("srs: invalid address")
+	// ErrHash is returned by Reverse if the hash doesn't match, e.g. the address is forged.
+	ErrHash = errors.New("srs: invalid hash")
+	// ErrExpired is returned by Reverse if the timestamp is older than the maximum age.
+	ErrExpired = errors.New("srs: timestamp expired")
+)
+
+// Rewriter rewrites and reverses addresses. It is safe for concurrent use.
+type Rewriter struct {
+	domain     string
+	secrets    [][]byte
+	separator  byte
+	hashLength int
+	maxAge     time.Duration
+	exclude    []string
+	now        func() time.Time
+}
+
+// Option defines a rewriter option.
+type Option func(r *Rewriter)
+
+// WithSeparator sets the separator following SRS0 and SRS1, one of '=' (default), '+' or '-'.
+func WithSeparator(separator byte) Option {
+	return func(r *Rewriter) {
+		r.separator = separator
+	}
+}
+
+// WithHashLength sets the number of characters of the hash (default 4).
+// Longer hashes are accepted by Reverse anyway.
+func WithHashLength(length int) Option {
+	return func(r *Rewriter) {
+		r.hashLength = length
+	}
+}
+
+// WithMaxAge sets how long rewritten addresses can be reversed (default 21 days).
+// The timestamp has a resolution of one day.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(r *Rewriter) {
+		r.maxAge = maxAge
+	}
+}
+
+// WithExcludeDomains sets domains whose addresses aren't rewritten, e.g. the local domains.
+func WithExcludeDomains(domains ...string) Option {
+	return func(r *Rewriter) {
+		for _, domain := range domains {
+			r.exclude = append(r.exclude, strings.ToLower(domain))
+		}
+	}
+}
+
+// New returns a rewriter for the forwarding domain. The first secret is used to create hashes,
+// all secrets are accepted by Reverse. This allows to rotate secrets: add a new secret in front
+// and remove the old one after the maximum age.
+func New(domain string, secrets []string, opts ...Option) (*Rewriter, error) {
+	r := &Rewriter{
+		domain:     strings.ToLower(domain),
+		separator:  '=',
+		hashLength: 4,
+		maxAge:     21 * precision,
+		now:        time.Now,
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	if r.domain == "" || strings.ContainsAny(r.domain, "@= \t\r\n") {
+		return nil, fmt.Errorf("srs: invalid domain %q", domain)
+	}
+	if len(secrets) == 0 {
+		return nil, errors.New("srs: missing secret")
+	}
+	for _, secret := range secrets {
+		if secret == "" {
+			return nil, errors.New("srs: empty secret")
+		}
+		r.secrets = append(r.secrets, []byte(secret))
+	}
+	if r.separator != '=' && r.separator != '+' && r.separator != '-' {
+		return nil, fmt.Errorf("srs: invalid separator %q", r.separator)
+	}
+	if r.hashLength < 1 || r.hashLength > base64.StdEncoding.EncodedLen(sha1.Size) {
+		return nil, fmt.Errorf("srs: invalid hash length %d", r.hashLength)
+	}
+
+	return r, nil
+}
+
+// Forward rewrites the sender of a forwarded message. The null sender, addresses without
+// domain and addresses of the forwarding or an excluded domain are returned unchanged.
+func (r *Rewriter) Forward(sender string) string {
+	at := strings.LastIndexByte(sender, '@')
+	if at <= 0 || at == len(sender)-1 {
+		return sender
+	}
+
+	local, host := sender[:at], sender[at+1:]
+	if lower := strings.ToLower(host); lower == r.domain || slices.Contains(r.exclude, lower) {
+		return sender
+	}
+
+	switch {
+	case prefix(local, "SRS0"):
+		// the sender is rewritten by another forwarder, keep the original address and point to it
+		return r.srs1(host, local[4:])
+	case prefix(local, "SRS1"):
+		// keep the first forwarder and the original address, just the hash is renewed
+		if parts := strings.SplitN(local[5:], "=", 3); len(parts) == 3 && parts[1] != "" && parts[2] != "" {
+			return r.srs1(parts[1], parts[2])
+		}
+	}
+
+	stamp := r.stamp()
+	return "SRS0" + string(r.separator) + hash(r.secrets[0], stamp, host, local)[:r.hashLength] +
+		"=" + stamp + "=" + host + "=" + local + "@" + r.domain
+}
+
+// Reverse returns the original address of a rewritten address, e.g. the recipient of a bounce.
+// A SRS1 address is reversed to the SRS0 address of the first forwarder.
+func (r *Rewriter) Reverse(address string) (string, error) {
+	at := strings.LastIndexByte(address, '@')
+	if at <= 0 || !strings.EqualFold(address[at+1:], r.domain) {
+		return "", ErrNotSRS
+	}
+
+	local := address[:at]
+	switch {
+	case prefix(local, "SRS0"):
+		parts := strings.SplitN(local[5:], "=", 4)
+		if len(parts) != 4 || slices.Contains(parts, "") {
+			return "", ErrInvalid
+		}
+		stamp, host, user := parts[1], parts[2], parts[3]
+		if !r.valid(parts[0], stamp, host, user) {
+			return "", ErrHash
+		}
+		if err := r.checkStamp(stamp); err != nil {
+			return "", err
+		}
+		return user + "@" + host, nil
+	case prefix(local, "SRS1"):
+		parts := strings.SplitN(local[5:], "=", 3)
+		if len(parts) != 3 || slices.Contains(parts, "") {
+			return "", ErrInvalid
+		}
+		host, user := parts[1], parts[2]
+		if !r.valid(parts[0], host, user) {
+			return "", ErrHash
+		}
+		return "SRS0" + user + "@" + host, nil
+	default:
+		return "", ErrNotSRS
+	}
+}
+
+// srs1 returns the SRS1 address pointing to the SRS0 address "SRS0" + user + "@" + host.
+func (r *Rewriter) srs1(host string, user string) string {
+	return "SRS1" + string(r.separator) + hash(r.secrets[0], host, user)[:r.hashLength] +
+		"=" + host + "=" + user + "@" + r.domain
+}
+
+// hash returns the base64 encoded HMAC-SHA1 of the lower case data.
+func hash(secret []byte, data ...string) string {
+	mac := hmac.New(sha1.New, secret)
+	for _, d := range data {
+		_, _ = mac.Write([]byte(lower(d)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// valid returns true if given matches the hash of data with one of the secrets. Hashes are
+// compared case insensitive as some servers change the case of addresses.
+func (r *Rewriter) valid(given string, data ...string) bool {
+	if len(given) < r.hashLength {
+		return false
+	}
+	for _, secret := range r.secrets {
+		expected := hash(secret, data...)
+		if len(given) > len(expected) {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(lower(given)), []byte(lower(expected[:len(given)]))) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// stamp returns the current timestamp, the days since the epoch modulo 1024 as two base32 characters.
+func (r *Rewriter) stamp() string {
+	day := r.day()
+	return string([]byte{base32[(day>>5)&31], base32[day&31]})
+}
+
+// checkStamp returns an error if stamp is invalid or older than the maximum age.
+func (r *Rewriter) checkStamp(stamp string) error {
+	if len(stamp) != 2 {
+		return ErrInvalid
+	}
+
+	then := 0
+	for _, c := range []byte(strings.ToUpper(stamp)) {
+		i := strings.IndexByte(base32, c)
+		if i < 0 {
+			return ErrInvalid
+		}
+		then = then<<5 | i
+	}
+
+	now := r.day()
+	for now < then {
+		now += slots
+	}
+	if time.Duration(now-then)*precision > r.maxAge {
+		return ErrExpired
+	}
+	return nil
+}
+
+// day returns the days since the epoch modulo the number of slots.
+func (r *Rewriter) day() int {
+	return int(r.now().Unix()/int64(precision/time.Second)) % slots
+}
+
+// prefix returns true if local starts with tag (case insensitive) followed by a separator.
+func prefix(local string, tag string) bool {
+	return len(local) > len(tag) && strings.EqualFold(local[:len(tag)], tag) &&
+		strings.IndexByte("=+-", local[len(tag)]) >= 0
+}
+
+// lower returns s with ASCII letters mapped to lower case, like the C function tolower.
+func lower(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'A' && c <= 'Z' {
+			return c + 'a' - 'A'
+		}
+		return c
+	}, s)
+}
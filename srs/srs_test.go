@@ -0,0 +1,137 @@
+package srs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newRewriter returns a rewriter for forward.example.com with a fixed clock.
+func newRewriter(t *testing.T, secrets []string, opts ...Option) *Rewriter {
+	r, err := New("forward.example.com", secrets, opts...)
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+	return r
+}
+
+func TestForward(t *testing.T) {
+	r := newRewriter(t, []string{"tops3cr3t"}, WithExcludeDomains("Local.example.com"))
+
+	for _, tc := range []struct {
+		sender string
+		want   string
+	}{
+		// same hashes as libsrs2 and postsrsd
+		{"Sender@example.org", "SRS0=m45A=T2=example.org=Sender@forward.example.com"},
+		{
+			"SRS0=eHSk=T2=example.org=sender@first.example.net",
+			"SRS1=UO9v=first.example.net==eHSk=T2=example.org=sender@forward.example.com",
+		},
+		{
+			"SRS1=xxxx=first.example.net==eHSk=T2=example.org=sender@second.example.net",
+			"SRS1=UO9v=first.example.net==eHSk=T2=example.org=sender@forward.example.com",
+		},
+		// not rewritten
+		{"", ""},
+		{"postmaster", "postmaster"},
+		{"user@local.example.com", "user@local.example.com"},
+		{"SRS0=m45A=T2=example.org=Sender@forward.example.com", "SRS0=m45A=T2=example.org=Sender@forward.example.com"},
+	} {
+		t.Run(tc.sender, func(t *testing.T) {
+			require.Equal(t, tc.want, r.Forward(tc.sender))
+		})
+	}
+
+	r = newRewriter(t, []string{"tops3cr3t"}, WithSeparator('+'), WithHashLength(6))
+	got := r.Forward("sender@example.org")
+	require.Regexp(t, `^SRS0\+[A-Za-z0-9+/]{6}=T2=example.org=sender@forward.example.com$`, got)
+
+	reversed, err := r.Reverse(got)
+	require.NoError(t, err)
+	require.Equal(t, "sender@example.org", reversed)
+}
+
+func TestReverse(t *testing.T) {
+	r := newRewriter(t, []string{"tops3cr3t"})
+
+	got, err := r.Reverse("SRS0=m45A=T2=example.org=Sender@forward.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "Sender@example.org", got)
+
+	// case of the hash and the tag is ignored
+	got, err = r.Reverse("srs0=M45a=t2=example.org=Sender@FORWARD.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "Sender@example.org", got)
+
+	got, err = r.Reverse("SRS1=UO9v=first.example.net==eHSk=T2=example.org=sender@forward.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "SRS0=eHSk=T2=example.org=sender@first.example.net", got)
+
+	for address, want := range map[string]error{
+		"sender@forward.example.com":                               ErrNotSRS,
+		"SRS0=m45A=T2=example.org=Sender@other.example.com":        ErrNotSRS,
+		"SRS0=m45A=T2=example.org@forward.example.com":             ErrInvalid,
+		"SRS0=m45B=T2=example.org=Sender@forward.example.com":      ErrHash,
+		"SRS0=m45=T2=example.org=Sender@forward.example.com":       ErrHash,
+		"SRS1=UO9v=first.example.net==eHSk=T2@forward.example.com": ErrHash,
+	} {
+		_, err := r.Reverse(address)
+		require.ErrorIs(t, err, want, address)
+	}
+}
+
+func TestReverse_Expired(t *testing.T) {
+	r := newRewriter(t, []string{"tops3cr3t"}, WithMaxAge(2*24*time.Hour))
+
+	address := r.Forward("sender@example.org")
+
+	r.now = func() time.Time { return now.Add(2 * 24 * time.Hour) }
+	_, err := r.Reverse(address)
+	require.NoError(t, err)
+
+	r.now = func() time.Time { return now.Add(3 * 24 * time.Hour) }
+	_, err = r.Reverse(address)
+	require.ErrorIs(t, err, ErrExpired)
+
+	// the timestamp wraps around after 1024 days
+	r.now = func() time.Time { return now.Add(1024 * 24 * time.Hour) }
+	_, err = r.Reverse(address)
+	require.NoError(t, err)
+}
+
+func TestRotation(t *testing.T) {
+	old := newRewriter(t, []string{"old"})
+	address := old.Forward("sender@example.org")
+
+	// addresses of the old secret are still accepted
+	r := newRewriter(t, []string{"new", "old"})
+	got, err := r.Reverse(address)
+	require.NoError(t, err)
+	require.Equal(t, "sender@example.org", got)
+
+	rotated := r.Forward("sender@example.org")
+	require.NotEqual(t, address, rotated)
+
+	_, err = old.Reverse(rotated)
+	require.ErrorIs(t, err, ErrHash)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("forward.example.com", nil)
+	require.Error(t, err)
+
+	_, err = New("forward.example.com", []string{""})
+	require.Error(t, err)
+
+	_, err = New("", []string{"secret"})
+	require.Error(t, err)
+
+	_, err = New("forward.example.com", []string{"secret"}, WithSeparator('#'))
+	require.Error(t, err)
+
+	_, err = New("forward.example.com", []string{"secret"}, WithHashLength(0))
+	require.Error(t, err)
+}
@@ -47,6 +47,10 @@ type Backend struct {
 	Mails sync.Map
 	Mail  func(ctx context.Context, from string, options *smtp.MailOptions) error
 	Rcpt  func(ctx context.Context, to string, options *smtp.RcptOptions) error
+
+	// Auth is called for every authentication with one of the AuthMechanisms.
+	AuthMechanisms []string
+	Auth           func(ctx context.Context, mech string) (sasl.Server, error)
 }
 
 // NewBackend returns a new Backend with an empty (not nil) Mails map.
@@ -168,12 +172,15 @@ func (s *Session) Data(_ context.Context, r func() io.Reader) (string, error) {
 }
 
 // AuthMechanisms implements the AuthMechanisms interface.
-func (Session) AuthMechanisms(_ context.Context) []string {
-	return nil
+func (s *Session) AuthMechanisms(_ context.Context) []string {
+	return s.backend.AuthMechanisms
 }
 
 // Auth implements the Auth interface.
-func (Session) Auth(_ context.Context, _ string) (sasl.Server, error) {
+func (s *Session) Auth(ctx context.Context, mech string) (sasl.Server, error) {
+	if s.backend.Auth != nil {
+		return s.backend.Auth(ctx, mech)
+	}
 	return nil, nil
 }
 
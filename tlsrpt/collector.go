@@ -0,0 +1,159 @@
+package tlsrpt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector collects the results of TLS negotiations per policy. It is safe for concurrent use.
+type Collector struct {
+	organization string
+	contact      string
+	now          func() time.Time
+
+	mu       sync.Mutex
+	start    time.Time
+	policies []*PolicyReport
+}
+
+// Option defines a collector option.
+type Option func(c *Collector)
+
+// WithOrganization sets the name of the organization responsible for the reports.
+func WithOrganization(organization string) Option {
+	return func(c *Collector) {
+		c.organization = organization
+	}
+}
+
+// WithContactInfo sets the contact information of the reports, e.g. an email address.
+func WithContactInfo(contact string) Option {
+	return func(c *Collector) {
+		c.contact = contact
+	}
+}
+
+// New returns a new collector, the time window of the first report starts now.
+func New(opts ...Option) *Collector {
+	c := &Collector{now: time.Now}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	c.start = c.now()
+	return c
+}
+
+// Success records a successful TLS negotiation.
+func (c *Collector) Success(policy Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policy(policy).Summary.Successful++
+}
+
+// Failure records a failed TLS negotiation, failures with equal details are aggregated.
+func (c *Collector) Failure(policy Policy, failure Failure) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := c.policy(policy)
+	report.Summary.Failure++
+
+	failure.FailedSessionCount = 0
+	for i := range report.FailureDetails {
+		existing := report.FailureDetails[i]
+		existing.FailedSessionCount = 0
+		if existing == failure {
+			report.FailureDetails[i].FailedSessionCount++
+			return
+		}
+	}
+
+	failure.FailedSessionCount = 1
+	report.FailureDetails = append(report.FailureDetails, failure)
+}
+
+// policy returns the report of the policy, c.mu must be held.
+func (c *Collector) policy(policy Policy) *PolicyReport {
+	for _, report := range c.policies {
+		if equalPolicy(report.Policy, policy) {
+			return report
+		}
+	}
+
+	report := &PolicyReport{Policy: policy}
+	c.policies = append(c.policies, report)
+	return report
+}
+
+// equalPolicy returns true if both policies are the same.
+func equalPolicy(a Policy, b Policy) bool {
+	return a.Type == b.Type && strings.EqualFold(a.Domain, b.Domain) &&
+		slices.Equal(a.String, b.String) && slices.Equal(a.MXHost, b.MXHost)
+}
+
+// Reports returns one report per policy domain with the results since the last call
+// and starts a new time window.
+func (c *Collector) Reports() []Report {
+	c.mu.Lock()
+	policies := c.policies
+	start := c.start
+	end := c.now()
+	c.policies = nil
+	c.start = end
+	c.mu.Unlock()
+
+	dateRange := DateRange{
+		Start: start.UTC().Truncate(time.Second),
+		End:   end.UTC().Truncate(time.Second),
+	}
+
+	reports := []Report{}
+	index := map[string]int{}
+
+	for _, policy := range policies {
+		domain := strings.ToLower(policy.Policy.Domain)
+
+		i, ok := index[domain]
+		if !ok {
+			i = len(reports)
+			index[domain] = i
+			reports = append(reports, Report{
+				OrganizationName: c.organization,
+				DateRange:        dateRange,
+				ContactInfo:      c.contact,
+				ReportID:         reportID(dateRange.Start, domain),
+			})
+		}
+
+		reports[i].Policies = append(reports[i].Policies, *policy)
+	}
+
+	return reports
+}
+
+// Flush passes the reports of the current time window to the sink.
+func (c *Collector) Flush(ctx context.Context, sink Sink) error {
+	var errs []error
+	for _, report := range c.Reports() {
+		if err := sink.Send(ctx, report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reportID returns a unique id of a report.
+func reportID(start time.Time, domain string) string {
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+	return start.Format("20060102T150405Z") + "_" + domain + "_" + hex.EncodeToString(random)
+}
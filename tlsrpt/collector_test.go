@@ -0,0 +1,144 @@
+package tlsrpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var stsPolicy = Policy{
+	Type:   PolicyTypeSTS,
+	String: []string{"version: STSv1", "mode: enforce", "mx: mx.example.com", "max_age: 86400"},
+	Domain: "example.com",
+	MXHost: []string{"mx.example.com"},
+}
+
+func TestCollector(t *testing.T) {
+	now := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	c := New(WithOrganization("Example Inc."), WithContactInfo("tlsrpt@example.net"))
+	c.now = func() time.Time { return now }
+	c.start = now
+
+	failure := Failure{ResultType: CertificateExpired, ReceivingMXHostname: "mx.example.com"}
+
+	c.Success(stsPolicy)
+	c.Success(stsPolicy)
+	c.Failure(stsPolicy, failure)
+	c.Failure(stsPolicy, failure)
+	c.Failure(stsPolicy, Failure{ResultType: StartTLSNotSupported, ReceivingMXHostname: "mx.example.com"})
+	c.Success(Policy{Type: PolicyTypeNoPolicy, Domain: "EXAMPLE.com"})
+	c.Success(Policy{Type: PolicyTypeNoPolicy, Domain: "example.org"})
+
+	now = now.Add(24 * time.Hour)
+	reports := c.Reports()
+	require.Len(t, reports, 2)
+
+	report := reports[0]
+	require.Equal(t, "Example Inc.", report.OrganizationName)
+	require.Equal(t, "tlsrpt@example.net", report.ContactInfo)
+	require.Equal(t, DateRange{Start: now.Add(-24 * time.Hour), End: now}, report.DateRange)
+	require.Equal(t, "example.com", report.Domain())
+	require.NotEmpty(t, report.ReportID)
+	require.Len(t, report.Policies, 2)
+
+	require.Equal(t, stsPolicy, report.Policies[0].Policy)
+	require.Equal(t, Summary{Successful: 2, Failure: 3}, report.Policies[0].Summary)
+	require.Equal(t, []Failure{
+		{ResultType: CertificateExpired, ReceivingMXHostname: "mx.example.com", FailedSessionCount: 2},
+		{ResultType: StartTLSNotSupported, ReceivingMXHostname: "mx.example.com", FailedSessionCount: 1},
+	}, report.Policies[0].FailureDetails)
+
+	require.Equal(t, Summary{Successful: 1}, report.Policies[1].Summary)
+	require.Equal(t, "example.org", reports[1].Domain())
+
+	// a new time window is started
+	require.Empty(t, c.Reports())
+}
+
+func TestReport_JSON(t *testing.T) {
+	start := time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC)
+	report := Report{
+		OrganizationName: "Company-X",
+		DateRange:        DateRange{Start: start, End: start.Add(24*time.Hour - time.Second)},
+		ContactInfo:      "sts-reporting@company-x.example",
+		ReportID:         "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+		Policies: []PolicyReport{{
+			Policy:  stsPolicy,
+			Summary: Summary{Successful: 5326, Failure: 303},
+			FailureDetails: []Failure{{
+				ResultType:          CertificateExpired,
+				SendingMTAIP:        "2001:db8:abcd:0012::1",
+				ReceivingMXHostname: "mx1.mail.company-y.example",
+				FailedSessionCount:  100,
+			}},
+		}},
+	}
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"organization-name": "Company-X",
+		"date-range": {"start-datetime": "2016-04-01T00:00:00Z", "end-datetime": "2016-04-01T23:59:59Z"},
+		"contact-info": "sts-reporting@company-x.example",
+		"report-id": "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+		"policies": [{
+			"policy": {
+				"policy-type": "sts",
+				"policy-string": ["version: STSv1", "mode: enforce", "mx: mx.example.com", "max_age: 86400"],
+				"policy-domain": "example.com",
+				"mx-host": ["mx.example.com"]
+			},
+			"summary": {"total-successful-session-count": 5326, "total-failure-session-count": 303},
+			"failure-details": [{
+				"result-type": "certificate-expired",
+				"sending-mta-ip": "2001:db8:abcd:0012::1",
+				"receiving-mx-hostname": "mx1.mail.company-y.example",
+				"failed-session-count": 100
+			}]
+		}]
+	}`, string(data))
+
+	require.Equal(t, "example.net!example.com!1459468800!1459555199.json", report.Filename("example.net"))
+}
+
+func TestCollector_Flush(t *testing.T) {
+	c := New()
+	c.Success(stsPolicy)
+	c.Success(Policy{Type: PolicyTypeNoPolicy, Domain: "example.org"})
+
+	domains := []string{}
+	err := c.Flush(context.Background(), SinkFunc(func(_ context.Context, report Report) error {
+		domains = append(domains, report.Domain())
+		if report.Domain() == "example.org" {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+	require.ErrorContains(t, err, "failed")
+	require.Equal(t, []string{"example.com", "example.org"}, domains)
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+
+	c := New()
+	c.Success(stsPolicy)
+
+	reports := c.Reports()
+	require.Len(t, reports, 1)
+	require.NoError(t, NewFileSink(dir, "example.net").Send(context.Background(), reports[0]))
+
+	data, err := os.ReadFile(filepath.Join(dir, reports[0].Filename("example.net")))
+	require.NoError(t, err)
+
+	res := Report{}
+	require.NoError(t, json.Unmarshal(data, &res))
+	require.Equal(t, reports[0].ReportID, res.ReportID)
+}
@@ -0,0 +1,89 @@
+// Package tlsrpt implements the collection of TLS negotiation results and
+// the generation of SMTP TLS aggregate reports (TLS-RPT, RFC 8460).
+//
+// The mailer records every TLS negotiation in a Collector. The collected results are
+// turned into one report per policy domain and passed to a Sink, e.g. to store or send them.
+package tlsrpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report is a TLS-RPT aggregate report of a policy domain (RFC 8460 4.4).
+type Report struct {
+	OrganizationName string         `json:"organization-name"`
+	DateRange        DateRange      `json:"date-range"`
+	ContactInfo      string         `json:"contact-info"`
+	ReportID         string         `json:"report-id"`
+	Policies         []PolicyReport `json:"policies"`
+}
+
+// DateRange is the time window of a report.
+type DateRange struct {
+	Start time.Time `json:"start-datetime"`
+	End   time.Time `json:"end-datetime"`
+}
+
+// PolicyReport contains the results of the sessions of a policy.
+type PolicyReport struct {
+	Policy         Policy    `json:"policy"`
+	Summary        Summary   `json:"summary"`
+	FailureDetails []Failure `json:"failure-details,omitempty"`
+}
+
+// Domain returns the policy domain of the report.
+func (r *Report) Domain() string {
+	if len(r.Policies) == 0 {
+		return ""
+	}
+	return r.Policies[0].Policy.Domain
+}
+
+// Filename returns the filename of the report (RFC 8460 5.3),
+// e.g. "example.com!example.net!1470013207!1470186007.json".
+func (r *Report) Filename(sender string) string {
+	return fmt.Sprintf("%s!%s!%d!%d.json", sender, r.Domain(), r.DateRange.Start.Unix(), r.DateRange.End.Unix())
+}
+
+// Sink receives the reports of a collector.
+type Sink interface {
+	Send(ctx context.Context, report Report) error
+}
+
+// SinkFunc is an adapter to use a function as Sink.
+type SinkFunc func(ctx context.Context, report Report) error
+
+// Send implements Sink.
+func (f SinkFunc) Send(ctx context.Context, report Report) error {
+	return f(ctx, report)
+}
+
+type fileSink struct {
+	dir    string
+	sender string
+}
+
+// NewFileSink returns a sink which writes every report as JSON file to dir.
+// The file is named as defined by RFC 8460 with the domain of the sender.
+func NewFileSink(dir string, sender string) Sink {
+	return fileSink{dir: dir, sender: sender}
+}
+
+// Send implements Sink.
+func (s fileSink) Send(_ context.Context, report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	// the domain is part of the filename, it must not contain a path
+	filename := strings.ReplaceAll(report.Filename(s.sender), string(filepath.Separator), "_")
+
+	return os.WriteFile(filepath.Join(s.dir, filename), data, 0o600)
+}
@@ -0,0 +1,105 @@
+package tlsrpt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// PolicyType is the type of the policy applied to a session (RFC 8460 4.3).
+type PolicyType string
+
+const (
+	// PolicyTypeSTS is a MTA-STS policy.
+	PolicyTypeSTS PolicyType = "sts"
+	// PolicyTypeTLSA is a DANE TLSA policy.
+	PolicyTypeTLSA PolicyType = "tlsa"
+	// PolicyTypeNoPolicy is used if no policy was found.
+	PolicyTypeNoPolicy PolicyType = "no-policy-found"
+)
+
+// ResultType is the type of a failure (RFC 8460 4.3).
+type ResultType string
+
+// Negotiation failures.
+const (
+	StartTLSNotSupported    ResultType = "starttls-not-supported"
+	CertificateHostMismatch ResultType = "certificate-host-mismatch"
+	CertificateExpired      ResultType = "certificate-expired"
+	CertificateNotTrusted   ResultType = "certificate-not-trusted"
+	ValidationFailure       ResultType = "validation-failure"
+)
+
+// Policy failures.
+const (
+	TLSAInvalid         ResultType = "tlsa-invalid"
+	DNSSECInvalid       ResultType = "dnssec-invalid"
+	DANERequired        ResultType = "dane-required"
+	STSPolicyFetchError ResultType = "sts-policy-fetch-error"
+	STSPolicyInvalid    ResultType = "sts-policy-invalid"
+	STSWebPKIInvalid    ResultType = "sts-webpki-invalid"
+)
+
+// Policy identifies the policy applied to a session.
+type Policy struct {
+	Type PolicyType `json:"policy-type"`
+	// Policy in its textual form, e.g. the lines of the MTA-STS policy or the TLSA records.
+	String []string `json:"policy-string,omitempty"`
+	// Domain the policy is defined for, the domain of the recipients.
+	Domain string `json:"policy-domain"`
+	// MX hosts of the policy, e.g. the mx patterns of the MTA-STS policy.
+	MXHost []string `json:"mx-host,omitempty"`
+}
+
+// Summary contains the number of sessions of a policy.
+type Summary struct {
+	Successful int `json:"total-successful-session-count"`
+	Failure    int `json:"total-failure-session-count"`
+}
+
+// Failure describes failed sessions of the same kind.
+type Failure struct {
+	ResultType            ResultType `json:"result-type"`
+	SendingMTAIP          string     `json:"sending-mta-ip,omitempty"`
+	ReceivingMXHostname   string     `json:"receiving-mx-hostname,omitempty"`
+	ReceivingMXHelo       string     `json:"receiving-mx-helo,omitempty"`
+	ReceivingIP           string     `json:"receiving-ip,omitempty"`
+	FailedSessionCount    int        `json:"failed-session-count"`
+	AdditionalInformation string     `json:"additional-information,omitempty"`
+	FailureReasonCode     string     `json:"failure-reason-code,omitempty"`
+}
+
+// Classify returns the result type of a failed TLS handshake, e.g. returned by client.StartTLS.
+// An empty result type is returned if err isn't a TLS error (e.g. a connection error).
+func Classify(err error) ResultType {
+	if err == nil {
+		return ""
+	}
+
+	hostnameErr := x509.HostnameError{}
+	if errors.As(err, &hostnameErr) {
+		return CertificateHostMismatch
+	}
+
+	invalidErr := x509.CertificateInvalidError{}
+	if errors.As(err, &invalidErr) {
+		if invalidErr.Reason == x509.Expired {
+			return CertificateExpired
+		}
+		return CertificateNotTrusted
+	}
+
+	authorityErr := x509.UnknownAuthorityError{}
+	if errors.As(err, &authorityErr) {
+		return CertificateNotTrusted
+	}
+
+	verificationErr := &tls.CertificateVerificationError{}
+	alertErr := tls.AlertError(0)
+	recordErr := tls.RecordHeaderError{}
+	if errors.As(err, &verificationErr) || errors.As(err, &alertErr) || errors.As(err, &recordErr) {
+		return ValidationFailure
+	}
+
+	return ""
+}
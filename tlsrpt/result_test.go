@@ -0,0 +1,34 @@
+package tlsrpt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want ResultType
+	}{
+		{nil, ""},
+		{&tls.CertificateVerificationError{Err: x509.HostnameError{Host: "mx.example.com"}}, CertificateHostMismatch},
+		{&tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}}, CertificateExpired},
+		{
+			&tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}},
+			CertificateNotTrusted,
+		},
+		{&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, CertificateNotTrusted},
+		{fmt.Errorf("handshake: %w", tls.AlertError(40)), ValidationFailure},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ""},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, Classify(tc.err), fmt.Sprint(tc.err))
+	}
+}